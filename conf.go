@@ -11,6 +11,7 @@ type conf struct {
 	IndexUrl     string `json:"index_url"`
 	Fcallback    string `json:"fcallback"`
 	AutoPayLimit string `json:"autoPayLimit"`
+	ExportPath   string `json:"export_path"`
 	Nats         struct {
 		Servers  []string `json:"servers"`
 		Username string   `json:"username"`
@@ -61,12 +62,17 @@ type conf struct {
 	Minio struct {
 		ImagesBucket    string `json:"images_bucket"`
 		JsonBucket      string `json:"json_bucket"`
+		ExportBucket    string `json:"export_bucket"`
 		Endpoint        string `json:"endpoint"`
 		AccessKeyID     string `json:"accessKeyID"`
 		SecretAccessKey string `json:"secretAccessKey"`
 		UseSSL          bool   `json:"useSSL"`
 		UploadUrl       string `json:"uploadUrl"`
 	} `json:"minio"`
+	Telegram struct {
+		Token  string `json:"token"`
+		ChatID int64  `json:"chat_id"`
+	} `json:"telegram"`
 	Es struct {
 		Host     []string `json:"host"`
 		Username string   `json:"username"`
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"strconv"
+
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	"github.com/shopspring/decimal"
+	"github.com/valyala/fasthttp"
+)
+
+type ApprovalController struct{}
+
+type approvalRuleParam struct {
+	Ty        string `rule:"none" name:"ty"`                                                                // 操作类型
+	Threshold string `rule:"float" min:"0" msg:"threshold error" name:"threshold"`                          // 阈值
+	Expire    int64  `rule:"digit" default:"86400" min:"300" max:"604800" msg:"expire error" name:"expire"` // 有效期(秒)
+	State     int    `rule:"digit" min:"0" max:"1" msg:"state error" name:"state"`                          // 0 关闭 1 开启
+}
+
+type approvalListParam struct {
+	Ty        string `rule:"none" name:"ty"`
+	State     string `rule:"none" name:"state"` // 为空查全部
+	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+type approvalReviewParam struct {
+	ID     string `rule:"digit" msg:"id error" name:"id"`
+	State  int    `rule:"digit" min:"1" max:"2" msg:"state error" name:"state"` // 1 通过 2 拒绝
+	Remark string `rule:"none" name:"remark"`
+}
+
+// RuleList 财务管理-操作复核-复核规则-列表
+func (that *ApprovalController) RuleList(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.ApprovalRuleList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// RuleUpdate 财务管理-操作复核-复核规则-修改
+func (that *ApprovalController) RuleUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := approvalRuleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !model.ApprovalValidTy(param.Ty) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	threshold, err := decimal.NewFromString(param.Threshold)
+	if err != nil || threshold.IsNegative() {
+		helper.Print(ctx, false, helper.AmountErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.ApprovalRuleUpdate(param.Ty, threshold.StringFixed(4), param.Expire, param.State, admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// List 财务管理-操作复核-复核列表
+func (that *ApprovalController) List(ctx *fasthttp.RequestCtx) {
+
+	param := approvalListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if param.Ty != "" && !model.ApprovalValidTy(param.Ty) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	state := -1
+	if param.State != "" {
+		if !validator.CheckIntScope(param.State, model.ApprovalPending, model.ApprovalExpired) {
+			helper.Print(ctx, false, helper.StateParamErr)
+			return
+		}
+
+		state, _ = strconv.Atoi(param.State)
+	}
+
+	data, err := model.ApprovalList(param.Ty, state, param.StartTime, param.EndTime, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Review 财务管理-操作复核-通过/拒绝
+func (that *ApprovalController) Review(ctx *fasthttp.RequestCtx) {
+
+	param := approvalReviewParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	// 拒绝时必须填写原因
+	if !validator.CheckStringLength(param.Remark, 0, 100) || (param.State == model.ApprovalRejected && param.Remark == "") {
+		helper.Print(ctx, false, helper.RemarkFMTErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.ApprovalReview(param.ID, param.State == model.ApprovalPassed, validator.FilterInjection(param.Remark), admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// 操作需要复核时返回复核单号, 修改在复核通过后才生效
+func approvalPending(ctx *fasthttp.RequestCtx, id string) {
+	helper.Print(ctx, true, map[string]string{"approval_id": id})
+}
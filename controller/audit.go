@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	"github.com/valyala/fasthttp"
+)
+
+type AuditController struct{}
+
+type auditListParam struct {
+	Entity    string `rule:"none" name:"entity"`    // 操作对象
+	EntityID  string `rule:"none" name:"entity_id"` // 操作对象id
+	Name      string `rule:"none" name:"name"`      // 操作人
+	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+// List 财务管理-操作日志-列表
+func (that *AuditController) List(ctx *fasthttp.RequestCtx) {
+
+	param := auditListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if param.StartTime == "" || param.EndTime == "" {
+		helper.Print(ctx, false, helper.DateTimeErr)
+		return
+	}
+
+	if !validator.CheckStringLength(param.Entity, 0, 30) ||
+		!validator.CheckStringLength(param.EntityID, 0, 50) ||
+		!validator.CheckStringLength(param.Name, 0, 30) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.AuditList(validator.FilterInjection(param.Entity), validator.FilterInjection(param.EntityID),
+		validator.FilterInjection(param.Name), param.StartTime, param.EndTime, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type BankTxnController struct{}
+
+type bankTxnListParam struct {
+	BankcardID string `rule:"digit" default:"0" msg:"bankcard_id error" name:"bankcard_id"` // 收款卡id
+	State      string `rule:"none" msg:"state error" name:"state"`                          // 0 待人工处理 1 自动入款 2 人工入款 3 已忽略
+	StartTime  string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime    string `rule:"none" msg:"end_time error" name:"end_time"`
+	Page       uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize   uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+type bankTxnMatchParam struct {
+	ID        string `rule:"digit" msg:"id error" name:"id"`                 // 流水id
+	DepositID string `rule:"digit" msg:"deposit_id error" name:"deposit_id"` // 存款订单号
+}
+
+// List 财务管理-线下转卡-银行流水-列表
+func (that *BankTxnController) List(ctx *fasthttp.RequestCtx) {
+
+	param := bankTxnListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ex := g.Ex{}
+	if param.BankcardID != "0" {
+		ex["bankcard_id"] = param.BankcardID
+	}
+	if param.State != "" {
+		if param.State != "0" && param.State != "1" && param.State != "2" && param.State != "3" {
+			helper.Print(ctx, false, helper.ParamErr)
+			return
+		}
+
+		ex["state"] = param.State
+	}
+
+	data, err := model.BankTxnList(ex, param.StartTime, param.EndTime, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Import 财务管理-线下转卡-银行流水-上传流水文件
+func (that *BankTxnController) Import(ctx *fasthttp.RequestCtx) {
+
+	cardID := string(ctx.FormValue("bankcard_id"))
+	colTime := strings.TrimSpace(string(ctx.FormValue("col_time")))
+	colAmount := strings.TrimSpace(string(ctx.FormValue("col_amount")))
+	colMemo := strings.TrimSpace(string(ctx.FormValue("col_memo")))
+
+	if !validator.CtypeDigit(cardID) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	if colTime == "" || colAmount == "" || colMemo == "" {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	fh, err := ctx.FormFile("file")
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(fh.Filename))
+	if (ext != ".csv" && ext != ".xlsx") || fh.Size == 0 || fh.Size > reconcileFileMaxSize {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+
+	data, err := model.BankTxnImport(cardID, filepath.Base(fh.Filename), content, colTime, colAmount, colMemo)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Sms 短信转发app推送收款卡的入账短信
+func (that *BankTxnController) Sms(ctx *fasthttp.RequestCtx) {
+
+	token := string(ctx.FormValue("token"))
+	content := strings.TrimSpace(string(ctx.FormValue("content")))
+	if token == "" || content == "" {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	// 短信到达时间, 秒或毫秒时间戳, 不传使用当前时间
+	var ts int64
+	if s := string(ctx.FormValue("ts")); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			helper.Print(ctx, false, helper.ParamErr)
+			return
+		}
+
+		if n > 1e12 {
+			n = n / 1000
+		}
+		ts = n
+	}
+
+	data, err := model.BankTxnSms(token, content, ts)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// SmsToken 财务管理-线下转卡-银行流水-生成短信转发token
+func (that *BankTxnController) SmsToken(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.PostArgs().Peek("bankcard_id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	token, err := model.BankSmsToken(id)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, token)
+}
+
+// Match 财务管理-线下转卡-银行流水-人工匹配订单入款
+func (that *BankTxnController) Match(ctx *fasthttp.RequestCtx) {
+
+	param := bankTxnMatchParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.BankTxnMatch(param.ID, param.DepositID, admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Ignore 财务管理-线下转卡-银行流水-忽略
+func (that *BankTxnController) Ignore(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.PostArgs().Peek("id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.BankTxnIgnore(id, admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
@@ -29,6 +29,9 @@ func (that *BankCardController) Insert(ctx *fasthttp.RequestCtx) {
 	flags := string(ctx.PostArgs().Peek("flags"))
 	code := string(ctx.PostArgs().Peek("code"))
 	remark := string(ctx.PostArgs().Peek("remark"))
+	weight := ctx.PostArgs().GetUintOrZero("weight")
+	levels := string(ctx.PostArgs().Peek("levels"))
+	bin := string(ctx.PostArgs().Peek("bin"))
 
 	//if !helper.CtypeDigit(bank_id) {
 	//	helper.Print(ctx, false, helper.ParamErr)
@@ -54,6 +57,24 @@ func (that *BankCardController) Insert(ctx *fasthttp.RequestCtx) {
 		flags = "1"
 	}
 
+	if weight < 1 {
+		weight = 1
+	}
+
+	if levels != "" && !validator.CheckStringCommaDigit(levels) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	// 不传时按银行名称查找
+	if bin == "" {
+		bin = model.VietQRBin(banklcard_name)
+	}
+	if bin != "" && (len(bin) != 6 || !helper.CtypeDigit(bin)) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	// 检查该卡号是否已经存在
 	_, err := model.BankCardByCol(banklcard_no)
 	if err == nil {
@@ -76,6 +97,44 @@ func (that *BankCardController) Insert(ctx *fasthttp.RequestCtx) {
 		TotalMaxAmount:    fmt.Sprintf("%d", total_max_amount),
 		TotalFinishAmount: "0",
 		Flags:             flags,
+		Weight:            fmt.Sprintf("%d", weight),
+		Levels:            levels,
+		Bin:               bin,
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	// 限额超过复核阈值时需要另一个管理员复核
+	after := map[string]string{
+		"banklcard_name":   bc.BanklcardName,
+		"banklcard_no":     bc.BanklcardNo,
+		"account_name":     bc.AccountName,
+		"remark":           bc.Remark,
+		"daily_max_amount": bc.DailyMaxAmount,
+		"total_max_amount": bc.TotalMaxAmount,
+		"flags":            bc.Flags,
+		"weight":           bc.Weight,
+		"levels":           bc.Levels,
+		"bin":              bc.Bin,
+	}
+	payload := map[string]string{"action": "insert"}
+	for k, v := range after {
+		payload[k] = v
+	}
+	approvalID, err := model.ApprovalSubmit(model.ApprovalBankcard, bc.Id, model.ApprovalAmountChange(nil, after),
+		nil, after, payload, string(ctx.PostArgs().Peek("reason")), admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	if approvalID != "" {
+		approvalPending(ctx, approvalID)
+		return
 	}
 
 	err = model.BankCardInsert(bc, code)
@@ -100,6 +159,10 @@ func (that *BankCardController) Delete(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	if !bankCardApproval(ctx, id, g.Record{}, "delete") {
+		return
+	}
+
 	err := model.BankCardDelete(id)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
@@ -171,6 +234,10 @@ func (that *BankCardController) Rest(ctx *fasthttp.RequestCtx) {
 		"total_finish_amount": "0",
 	}
 
+	if !bankCardApproval(ctx, id, rec, "update") {
+		return
+	}
+
 	err := model.BankCardUpdate(id, rec)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
@@ -191,6 +258,7 @@ func (that *BankCardController) Update(ctx *fasthttp.RequestCtx) {
 	//flags := string(ctx.PostArgs().Peek("flags"))
 	//code := string(ctx.PostArgs().Peek("code"))
 	remark := string(ctx.PostArgs().Peek("remark"))
+	weight := ctx.PostArgs().GetUintOrZero("weight")
 
 	if !helper.CtypeDigit(id) {
 		helper.Print(ctx, false, helper.ParamErr)
@@ -201,6 +269,27 @@ func (that *BankCardController) Update(ctx *fasthttp.RequestCtx) {
 		"state": state,
 	}
 
+	if weight > 0 {
+		rec["weight"] = fmt.Sprintf("%d", weight)
+	}
+	// levels 传空字符串时表示所有等级可用
+	if ctx.PostArgs().Has("levels") {
+		levels := string(ctx.PostArgs().Peek("levels"))
+		if levels != "" && !validator.CheckStringCommaDigit(levels) {
+			helper.Print(ctx, false, helper.ParamErr)
+			return
+		}
+		rec["levels"] = levels
+	}
+	if ctx.PostArgs().Has("bin") {
+		bin := string(ctx.PostArgs().Peek("bin"))
+		if bin != "" && (len(bin) != 6 || !helper.CtypeDigit(bin)) {
+			helper.Print(ctx, false, helper.ParamErr)
+			return
+		}
+		rec["bin"] = bin
+	}
+
 	if remark != "" {
 		rec["remark"] = validator.FilterInjection(remark)
 	}
@@ -211,6 +300,10 @@ func (that *BankCardController) Update(ctx *fasthttp.RequestCtx) {
 		rec["daily_max_amount"] = fmt.Sprintf("%f", dailyMaxAmount)
 	}
 
+	if !bankCardApproval(ctx, id, rec, "update") {
+		return
+	}
+
 	err := model.BankCardUpdate(id, rec)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
@@ -219,3 +312,62 @@ func (that *BankCardController) Update(ctx *fasthttp.RequestCtx) {
 
 	helper.Print(ctx, true, helper.Success)
 }
+
+// 修改银行卡前检查是否需要复核, 需要复核或出错时已输出结果, 返回false
+func bankCardApproval(ctx *fasthttp.RequestCtx, id string, rec g.Record, action string) bool {
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return false
+	}
+
+	bc, err := model.BankCardByID(id)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return false
+	}
+
+	current := map[string]string{
+		"state":               bc.State,
+		"remark":              bc.Remark,
+		"daily_max_amount":    bc.DailyMaxAmount,
+		"total_max_amount":    bc.TotalMaxAmount,
+		"total_finish_amount": bc.TotalFinishAmount,
+		"weight":              bc.Weight,
+		"levels":              bc.Levels,
+		"bin":                 bc.Bin,
+	}
+	before := map[string]string{}
+	after := map[string]string{}
+	payload := map[string]string{"action": action}
+	for k, v := range rec {
+		before[k] = current[k]
+		after[k] = fmt.Sprintf("%v", v)
+		payload[k] = after[k]
+	}
+
+	// 删除时按删除前的限额计算
+	amount := model.ApprovalAmountChange(before, after)
+	if action == "delete" {
+		before = current
+		amount = model.ApprovalAmountChange(nil, map[string]string{
+			"daily_max_amount": bc.DailyMaxAmount,
+			"total_max_amount": bc.TotalMaxAmount,
+		})
+	}
+
+	approvalID, err := model.ApprovalSubmit(model.ApprovalBankcard, id, amount, before, after, payload,
+		string(ctx.FormValue("reason")), admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return false
+	}
+
+	if approvalID != "" {
+		approvalPending(ctx, approvalID)
+		return false
+	}
+
+	return true
+}
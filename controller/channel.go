@@ -41,6 +41,9 @@ type updateChannelParam struct {
 	Comment     string `rule:"none" msg:"comment error" name:"comment"`             // 备注
 	Code        string `rule:"digit" msg:"code error" name:"code"`                  // 动态验证码
 	AmountList  string `rule:"none" msg:"amount_list error" name:"amount_list"`     // 固定金额列表
+	Weekdays    string `rule:"none" msg:"weekdays error" name:"weekdays"`           // 营业日 1-7 逗号分开, 为空表示每天
+	Quota       string `rule:"float" default:"0" msg:"quota error" name:"quota"`    // 每日限额, 0为不限制
+	Amount      string `rule:"float" default:"0" msg:"amount error" name:"amount"`  // 累计限额, 0为不限制
 }
 
 type channelListParam struct {
@@ -214,6 +217,11 @@ func (that *ChannelController) Update(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
+	if !model.PaymentCheckWeekdays(param.Weekdays) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	// 校验渠道id和通道id是否存在
 	payment, err := model.ChanExistsByID(param.ID)
 	if err != nil {
@@ -260,7 +268,8 @@ func (that *ChannelController) Update(ctx *fasthttp.RequestCtx) {
 
 	fields := map[string]string{
 		"id":           param.ID,
-		"quota":        "0",
+		"quota":        param.Quota,
+		"amount":       param.Amount,
 		"gateway":      "",
 		"payment_name": param.PaymentName,
 		"fmin":         param.FMin,
@@ -270,6 +279,7 @@ func (that *ChannelController) Update(ctx *fasthttp.RequestCtx) {
 		"sort":         param.Sort,
 		"comment":      param.Comment,
 		"amount_list":  param.AmountList,
+		"weekdays":     param.Weekdays,
 	}
 
 	if len(param.AmountList) > 0 {
@@ -350,6 +360,24 @@ func (that *ChannelController) Delete(ctx *fasthttp.RequestCtx) {
 	helper.Print(ctx, true, helper.Success)
 }
 
+// QuotaReset 财务管理-渠道管理-通道管理-清零累计已收金额
+func (that *ChannelController) QuotaReset(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.PostArgs().Peek("id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	err := model.PaymentQuotaReset(id)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
 // UpdateState 财务管理-渠道管理-通道管理-启用/停用
 func (that *ChannelController) UpdateState(ctx *fasthttp.RequestCtx) {
 
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type creditRuleParam struct {
+	CreditLevel   int64  `rule:"digit" min:"1" max:"100" msg:"credit_level error" name:"credit_level"`                   // 信用等级
+	DepositAmount string `rule:"float" default:"0" msg:"deposit_amount error" name:"deposit_amount"`                     // 累计成功存款金额
+	DepositCount  int64  `rule:"digit" default:"0" min:"0" max:"1000000" msg:"deposit_count error" name:"deposit_count"` // 累计成功存款次数
+	AccountDays   int64  `rule:"digit" default:"0" min:"0" max:"36500" msg:"account_days error" name:"account_days"`     // 注册天数
+	CancelDays    int64  `rule:"digit" default:"0" min:"0" max:"365" msg:"cancel_days error" name:"cancel_days"`         // 统计取消订单的天数
+	MaxCancel     int64  `rule:"digit" default:"0" min:"0" max:"10000" msg:"max_cancel error" name:"max_cancel"`         // 最多允许的取消订单数
+	State         int    `rule:"digit" default:"1" min:"0" max:"1" msg:"state error" name:"state"`                       // 0:关闭1:开启
+}
+
+type creditPinParam struct {
+	Username    string `rule:"uname" min:"5" max:"14" msg:"username error" name:"username"`                      // 会员名
+	CreditLevel int64  `rule:"digit" default:"0" min:"0" max:"100" msg:"credit_level error" name:"credit_level"` // 信用等级, 0为移出所有等级
+	Remark      string `rule:"none" msg:"remark error" name:"remark"`
+}
+
+type creditLogParam struct {
+	Username string `rule:"none" msg:"username error" name:"username"` // 会员名
+	Page     uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+// RuleList 财务管理-渠道管理-会员信用等级-自动分配规则列表
+func (that *CreditLevelController) RuleList(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.CreditRuleList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// RuleUpdate 财务管理-渠道管理-会员信用等级-按等级设置自动分配规则
+func (that *CreditLevelController) RuleUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := creditRuleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := g.Record{
+		"deposit_amount": param.DepositAmount,
+		"deposit_count":  param.DepositCount,
+		"account_days":   param.AccountDays,
+		"cancel_days":    param.CancelDays,
+		"max_cancel":     param.MaxCancel,
+		"state":          param.State,
+		"updated_at":     ctx.Time().Unix(),
+		"updated_uid":    admin["id"],
+		"updated_name":   admin["name"],
+	}
+	err = model.CreditRuleUpdate(param.CreditLevel, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// RuleDelete 财务管理-渠道管理-会员信用等级-删除自动分配规则
+func (that *CreditLevelController) RuleDelete(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.PostArgs().Peek("id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	err := model.CreditRuleDelete(id)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// MemberPin 财务管理-渠道管理-会员信用等级-手动固定会员等级
+func (that *CreditLevelController) MemberPin(ctx *fasthttp.RequestCtx) {
+
+	param := creditPinParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.CreditLevelPin(param.Username, param.CreditLevel, param.Remark, admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// MemberUnpin 财务管理-渠道管理-会员信用等级-取消固定, 恢复自动分配
+func (that *CreditLevelController) MemberUnpin(ctx *fasthttp.RequestCtx) {
+
+	param := creditPinParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.CreditLevelUnpin(param.Username, param.Remark, admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// MemberLog 财务管理-渠道管理-会员信用等级-等级变更记录
+func (that *CreditLevelController) MemberLog(ctx *fasthttp.RequestCtx) {
+
+	param := creditLogParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.CreditLogList(param.Username, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
@@ -75,6 +75,27 @@ type depositListParam struct {
 	IsAsc      int    `rule:"none" default:"0" name:"is_asc"`
 }
 
+// 存款历史记录导出
+type depositExportParam struct {
+	ID         string `rule:"none" name:"id"`
+	Username   string `rule:"none" msg:"username error" name:"username"`
+	ParentName string `rule:"none" msg:"parent_name error" name:"parent_name"`
+	GroupName  string `rule:"none" msg:"group_name error" name:"group_name"`
+	State      int    `rule:"none" default:"0" name:"state"`
+	OID        string `rule:"none" name:"oid"`
+	CID        string `rule:"none" json:"cid"`
+	ChannelID  string `rule:"none" name:"channel_id"`
+	Flag       int    `rule:"digit" name:"flag" min:"1" max:"2"`
+	TimeFlag   uint8  `rule:"digit" default:"1" min:"0" max:"1" name:"time_flag"`
+	StartTime  string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime    string `rule:"none" msg:"end_time error" name:"end_time"`
+	MinAmount  string `rule:"none" msg:"min_amount error" name:"min_amount"`
+	MaxAmount  string `rule:"none" msg:"max_amount error" name:"max_amount"`
+	Ty         int    `rule:"digit" min:"0" max:"4" default:"0" name:"ty"`
+	Dty        int    `rule:"none" default:"0" name:"dty"`
+	FileType   string `rule:"none" default:"csv" name:"file_type"` // csv xlsx
+}
+
 //Detail 会员列表-存款信息
 func (that *DepositController) Detail(ctx *fasthttp.RequestCtx) {
 
@@ -149,51 +170,109 @@ func (that *DepositController) History(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	msg := depositHistoryCheck(param)
+	if msg != "" {
+		helper.Print(ctx, false, msg)
+		return
+	}
+
+	data, err := model.DepositHistory(param.Username, param.ParentName, param.GroupName, param.ID, param.ChannelID, param.OID, strconv.Itoa(param.State),
+		param.MinAmount, param.MaxAmount, param.StartTime, param.EndTime, param.CID, param.SortField, param.TimeFlag, param.Flag, param.Page, param.PageSize, param.Ty, param.Dty, param.IsAsc)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Export 存款历史记录导出
+func (that *DepositController) Export(ctx *fasthttp.RequestCtx) {
+
+	param := depositExportParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if param.FileType != "csv" && param.FileType != "xlsx" {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	msg := depositHistoryCheck(depositListParam{
+		ID:        param.ID,
+		Username:  param.Username,
+		State:     param.State,
+		OID:       param.OID,
+		CID:       param.CID,
+		ChannelID: param.ChannelID,
+		StartTime: param.StartTime,
+		EndTime:   param.EndTime,
+	})
+	if msg != "" {
+		helper.Print(ctx, false, msg)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	id, err := model.ExportDepositInsert(admin, param.FileType, string(ctx.QueryArgs().QueryString()), param.Username, param.ParentName, param.GroupName, param.ID,
+		param.ChannelID, param.OID, strconv.Itoa(param.State), param.MinAmount, param.MaxAmount, param.StartTime, param.EndTime, param.CID, param.TimeFlag, param.Flag, param.Ty, param.Dty)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, id)
+}
+
+// 存款历史记录查询条件检查, 列表和导出共用
+func depositHistoryCheck(param depositListParam) string {
+
 	if param.Username != "" {
 		if !validator.CheckUName(param.Username, 5, 14) {
-			helper.Print(ctx, false, helper.UsernameErr)
-			return
+			return helper.UsernameErr
 		}
 	}
 
 	if param.ID != "" {
 		if !validator.CheckStringDigit(param.ID) {
-			helper.Print(ctx, false, helper.IDErr)
-			return
+			return helper.IDErr
 		}
 	}
 
 	if param.OID != "" {
 		if !validator.CheckStringAlnum(param.OID) {
-			helper.Print(ctx, false, helper.OIDErr)
-			return
+			return helper.OIDErr
 		}
 	}
 
 	if param.CID != "" {
 		if !validator.CheckStringAlnum(param.CID) {
-			helper.Print(ctx, false, helper.ParamErr)
-			return
+			return helper.ParamErr
 		}
 	}
 
 	if param.ChannelID != "" {
 		if !validator.CheckStringDigit(param.ChannelID) {
-			helper.Print(ctx, false, helper.ChannelIDErr)
-			return
+			return helper.ChannelIDErr
 		}
 	}
 
 	if param.State != 0 {
-		if param.State != model.DepositSuccess && param.State != model.DepositCancelled {
-			helper.Print(ctx, false, helper.StateParamErr)
-			return
+		if param.State != model.DepositSuccess && param.State != model.DepositCancelled && param.State != model.DepositReversed {
+			return helper.StateParamErr
 		}
 	}
 
 	if param.StartTime == "" || param.EndTime == "" {
-		helper.Print(ctx, false, helper.ParamErr)
-		return
+		return helper.ParamErr
 	}
 
 	if param.SortField != "" {
@@ -204,24 +283,15 @@ func (that *DepositController) History(ctx *fasthttp.RequestCtx) {
 		}
 
 		if _, ok := sortFields[param.SortField]; !ok {
-			helper.Print(ctx, false, helper.ParamErr)
-			return
+			return helper.ParamErr
 		}
 
 		if !validator.CheckIntScope(strconv.Itoa(param.IsAsc), 0, 1) {
-			helper.Print(ctx, false, helper.ParamErr)
-			return
+			return helper.ParamErr
 		}
 	}
 
-	data, err := model.DepositHistory(param.Username, param.ParentName, param.GroupName, param.ID, param.ChannelID, param.OID, strconv.Itoa(param.State),
-		param.MinAmount, param.MaxAmount, param.StartTime, param.EndTime, param.CID, param.SortField, param.TimeFlag, param.Flag, param.Page, param.PageSize, param.Ty, param.Dty, param.IsAsc)
-	if err != nil {
-		helper.Print(ctx, false, err.Error())
-		return
-	}
-
-	helper.Print(ctx, true, data)
+	return ""
 }
 
 // List 存款列表  三方存款
@@ -373,6 +443,8 @@ func (that *DepositController) USDTList(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	model.DepositReceiptAttach(&data.FDepositData)
+
 	helper.Print(ctx, true, data)
 }
 
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/shopspring/decimal"
+	"github.com/valyala/fasthttp"
+)
+
+type DepositMismatchController struct{}
+
+type depositMismatchConfParam struct {
+	ChannelID string `rule:"digit" msg:"channel_id error" name:"channel_id"`
+	Policy    int    `rule:"digit" default:"0" min:"0" max:"3" msg:"policy error" name:"policy"` // 0 拒绝 1 按实际金额上分 2 差额允许范围内上分 3 人工审核
+	Tolerance string `rule:"float" default:"0" msg:"tolerance error" name:"tolerance"`           // 允许的最大差额(KVND)
+}
+
+type depositMismatchListParam struct {
+	ID        string `rule:"none" default:"" msg:"id error" name:"id"`
+	Username  string `rule:"none" default:"" msg:"username error" name:"username"`
+	ChannelID string `rule:"none" default:"" msg:"channel_id error" name:"channel_id"`
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+type depositMismatchReviewParam struct {
+	ID     string `rule:"digit" msg:"id error" name:"id"`
+	State  int    `rule:"digit" msg:"state error" name:"state"`
+	Remark string `rule:"filter" default:"" min:"0" max:"200" msg:"remark error" name:"remark"`
+}
+
+// ConfList 财务管理-存款管理-金额不一致处理配置
+func (that *DepositMismatchController) ConfList(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.DepositMismatchConfList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// ConfUpdate 财务管理-存款管理-设置通道金额不一致处理方式
+func (that *DepositMismatchController) ConfUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := depositMismatchConfParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	tolerance, _ := decimal.NewFromString(param.Tolerance)
+	if tolerance.LessThan(decimal.Zero) {
+		helper.Print(ctx, false, helper.AmountErr)
+		return
+	}
+
+	conf := model.DepositMismatchConf{
+		ChannelID: param.ChannelID,
+		Policy:    param.Policy,
+		Tolerance: tolerance.String(),
+	}
+	err = model.DepositMismatchConfSet(conf)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// ConfDelete 财务管理-存款管理-删除通道金额不一致处理方式
+func (that *DepositMismatchController) ConfDelete(ctx *fasthttp.RequestCtx) {
+
+	channelID := string(ctx.PostArgs().Peek("channel_id"))
+	if !validator.CheckStringDigit(channelID) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	err := model.DepositMismatchConfDelete(channelID)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// List 财务管理-存款管理-金额不一致审核列表
+func (that *DepositMismatchController) List(ctx *fasthttp.RequestCtx) {
+
+	param := depositMismatchListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ex := g.Ex{}
+	if param.ID != "" {
+		if !validator.CheckStringDigit(param.ID) {
+			helper.Print(ctx, false, helper.IDErr)
+			return
+		}
+
+		ex["id"] = param.ID
+	}
+
+	if param.Username != "" {
+		if !validator.CheckUName(param.Username, 5, 14) {
+			helper.Print(ctx, false, helper.UsernameErr)
+			return
+		}
+
+		ex["username"] = param.Username
+	}
+
+	if param.ChannelID != "" {
+		if !validator.CheckStringDigit(param.ChannelID) {
+			helper.Print(ctx, false, helper.IDErr)
+			return
+		}
+
+		ex["channel_id"] = param.ChannelID
+	}
+
+	data, err := model.DepositMismatchList(ex, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Review 财务管理-存款管理-金额不一致审核, 通过时按实际支付金额上分
+func (that *DepositMismatchController) Review(ctx *fasthttp.RequestCtx) {
+
+	param := depositMismatchReviewParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if param.State != model.DepositSuccess && param.State != model.DepositCancelled {
+		helper.Print(ctx, false, helper.StateParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.DepositMismatchReview(param.ID, param.Remark, admin["name"], admin["id"], param.State)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type DepositPolicyController struct{}
+
+type depositPolicyParam struct {
+	Level          int    `rule:"digit" min:"0" max:"11" msg:"level error" name:"level"`
+	Window         int64  `rule:"digit" default:"0" min:"0" max:"2592000" msg:"window error" name:"window"`
+	Steps          string `rule:"none" msg:"steps error" name:"steps"`
+	ResetOnSuccess int    `rule:"digit" default:"1" min:"0" max:"1" msg:"reset_on_success error" name:"reset_on_success"`
+	ResetAfter     int64  `rule:"digit" default:"0" min:"0" max:"2592000" msg:"reset_after error" name:"reset_after"`
+	State          int    `rule:"digit" default:"1" min:"0" max:"1" msg:"state error" name:"state"`
+}
+
+type depositThrottleParam struct {
+	Username string `rule:"uname" min:"5" max:"14" msg:"username error" name:"username"` // 会员名
+}
+
+// List 财务管理-存款防刷策略-列表
+func (that *DepositPolicyController) List(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.DepositPolicyList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Update 财务管理-存款防刷策略-按会员等级设置
+func (that *DepositPolicyController) Update(ctx *fasthttp.RequestCtx) {
+
+	param := depositPolicyParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !model.DepositPolicyCheckSteps(param.Steps) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := g.Record{
+		"window":           param.Window,
+		"steps":            param.Steps,
+		"reset_on_success": param.ResetOnSuccess,
+		"reset_after":      param.ResetAfter,
+		"state":            param.State,
+		"updated_at":       ctx.Time().Unix(),
+		"updated_uid":      admin["id"],
+		"updated_name":     admin["name"],
+	}
+	err = model.DepositPolicyUpdate(param.Level, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Throttle 财务管理-存款防刷策略-查询会员当前限制状态
+func (that *DepositPolicyController) Throttle(ctx *fasthttp.RequestCtx) {
+
+	param := depositThrottleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.DepositThrottleFind(param.Username)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// ThrottleClear 财务管理-存款防刷策略-解除会员限制
+func (that *DepositPolicyController) ThrottleClear(ctx *fasthttp.RequestCtx) {
+
+	param := depositThrottleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	err = model.DepositThrottleClear(param.Username)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/shopspring/decimal"
+	"github.com/valyala/fasthttp"
+)
+
+type DepositPromoController struct{}
+
+type depositPromoRuleParam struct {
+	Ty            int    `rule:"digit" min:"1" max:"2" msg:"ty error" name:"ty"`                                       // 1 首存 2 二存
+	CateID        string `rule:"digit" default:"0" msg:"cate_id error" name:"cate_id"`                                 // 渠道id, 0为所有渠道
+	Rate          string `rule:"float" msg:"rate error" name:"rate"`                                                   // 优惠比例(%)
+	MaxBonus      string `rule:"float" default:"0" msg:"max_bonus error" name:"max_bonus"`                             // 单笔最高优惠, 0为不限制
+	MinAmount     string `rule:"float" default:"0" msg:"min_amount error" name:"min_amount"`                           // 最低存款金额
+	TurnoverMulti int    `rule:"digit" default:"1" min:"0" max:"100" msg:"turnover_multi error" name:"turnover_multi"` // 流水倍数
+	State         int    `rule:"digit" default:"1" min:"0" max:"1" msg:"state error" name:"state"`                     // 0:关闭1:开启
+}
+
+type depositPromoConfParam struct {
+	DayCap      string `rule:"float" default:"0" msg:"day_cap error" name:"day_cap"`                             // 每个会员每天最多获得的优惠, 0为不限制
+	TunnelMulti int    `rule:"digit" default:"1" min:"0" max:"100" msg:"tunnel_multi error" name:"tunnel_multi"` // 通道优惠的流水倍数
+}
+
+type depositPromoTurnoverParam struct {
+	Username string `rule:"uname" min:"5" max:"14" msg:"username error" name:"username"`
+}
+
+type depositPromoReportParam struct {
+	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
+}
+
+// RuleList 财务管理-存款优惠-首存二存规则列表
+func (that *DepositPromoController) RuleList(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.DepositPromoRuleList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// RuleUpdate 财务管理-存款优惠-按类型和渠道设置规则
+func (that *DepositPromoController) RuleUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := depositPromoRuleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	rate, err := decimal.NewFromString(param.Rate)
+	if err != nil || rate.LessThanOrEqual(decimal.Zero) || rate.GreaterThan(decimal.NewFromInt(100)) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := g.Record{
+		"rate":           param.Rate,
+		"max_bonus":      param.MaxBonus,
+		"min_amount":     param.MinAmount,
+		"turnover_multi": param.TurnoverMulti,
+		"state":          param.State,
+		"updated_at":     ctx.Time().Unix(),
+		"updated_uid":    admin["id"],
+		"updated_name":   admin["name"],
+	}
+	err = model.DepositPromoRuleUpdate(param.Ty, param.CateID, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// RuleDelete 财务管理-存款优惠-删除规则
+func (that *DepositPromoController) RuleDelete(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.PostArgs().Peek("id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	err := model.DepositPromoRuleDelete(id)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Conf 财务管理-存款优惠-每日上限和通道优惠流水配置
+func (that *DepositPromoController) Conf(ctx *fasthttp.RequestCtx) {
+
+	conf, err := model.DepositPromoConfGet()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, conf)
+}
+
+// ConfUpdate 财务管理-存款优惠-修改每日上限和通道优惠流水配置
+func (that *DepositPromoController) ConfUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := depositPromoConfParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	conf := model.DepositPromoConf{
+		DayCap:      param.DayCap,
+		TunnelMulti: param.TunnelMulti,
+	}
+	err = model.DepositPromoConfSet(conf)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Report 财务管理-存款优惠-按渠道统计优惠成本
+func (that *DepositPromoController) Report(ctx *fasthttp.RequestCtx) {
+
+	param := depositPromoReportParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.DepositPromoReportList(param.StartTime, param.EndTime)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// TurnoverList 财务管理-存款优惠-会员未完成流水要求的优惠
+func (that *DepositPromoController) TurnoverList(ctx *fasthttp.RequestCtx) {
+
+	param := depositPromoTurnoverParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.DepositPromoTurnoverList(param.Username)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
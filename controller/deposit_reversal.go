@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type DepositReversalController struct{}
+
+type depositReverseParam struct {
+	ID        string `rule:"digit" msg:"id error" name:"id"`
+	Reason    int    `rule:"digit" min:"1" max:"9" msg:"reason error" name:"reason"` // 1 三方渠道冲正 2 银行退回 3 欺诈转账 9 其他
+	Reference string `rule:"none" default:"" msg:"reference error" name:"reference"` // 三方或银行的冲正单号
+	Remark    string `rule:"filter" default:"" min:"0" max:"200" msg:"remark error" name:"remark"`
+	Lock      int    `rule:"digit" default:"0" min:"0" max:"1" msg:"lock error" name:"lock"` // 1 限制会员存提款
+}
+
+type depositReversalListParam struct {
+	Username  string `rule:"none" default:"" msg:"username error" name:"username"`
+	DepositID string `rule:"none" default:"" msg:"deposit_id error" name:"deposit_id"`
+	Reason    int    `rule:"digit" default:"0" min:"0" max:"9" msg:"reason error" name:"reason"`
+	StartTime string `rule:"none" default:"" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" default:"" msg:"end_time error" name:"end_time"`
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+type depositReversalConfParam struct {
+	Negative int `rule:"digit" default:"0" min:"0" max:"1" msg:"negative error" name:"negative"`   // 1 允许扣成负数 0 不足部分记为欠款
+	AutoLock int `rule:"digit" default:"0" min:"0" max:"1" msg:"auto_lock error" name:"auto_lock"` // 1 冲正后默认限制会员存提款
+}
+
+// Reverse 财务管理-存款管理-历史记录-冲正
+func (that *DepositReversalController) Reverse(ctx *fasthttp.RequestCtx) {
+
+	param := depositReverseParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil || len(param.Reference) > 64 {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.DepositReverse(param.ID, param.Reason, param.Reference, param.Remark, param.Lock == 1, admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// List 财务管理-存款管理-冲正记录
+func (that *DepositReversalController) List(ctx *fasthttp.RequestCtx) {
+
+	param := depositReversalListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ex := g.Ex{}
+	if param.Username != "" {
+		if !validator.CheckUName(param.Username, 5, 14) {
+			helper.Print(ctx, false, helper.UsernameErr)
+			return
+		}
+
+		ex["username"] = param.Username
+	}
+
+	if param.DepositID != "" {
+		if !validator.CheckStringDigit(param.DepositID) {
+			helper.Print(ctx, false, helper.IDErr)
+			return
+		}
+
+		ex["deposit_id"] = param.DepositID
+	}
+
+	if param.Reason > 0 {
+		ex["reason"] = param.Reason
+	}
+
+	data, err := model.DepositReversalList(ex, param.StartTime, param.EndTime, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Conf 财务管理-存款管理-冲正策略
+func (that *DepositReversalController) Conf(ctx *fasthttp.RequestCtx) {
+
+	conf, err := model.DepositReversalConfGet()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, conf)
+}
+
+// ConfUpdate 财务管理-存款管理-修改冲正策略
+func (that *DepositReversalController) ConfUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := depositReversalConfParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	conf := model.DepositReversalConf{
+		Negative: param.Negative,
+		AutoLock: param.AutoLock,
+	}
+	err = model.DepositReversalConfSet(conf)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	"github.com/valyala/fasthttp"
+)
+
+type ExportController struct{}
+
+type exportListParam struct {
+	Page     uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+// List 财务管理-导出记录-列表
+func (that *ExportController) List(ctx *fasthttp.RequestCtx) {
+
+	param := exportListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	data, err := model.ExportList(admin["id"], param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Download 财务管理-导出记录-下载
+func (that *ExportController) Download(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.QueryArgs().Peek("id"))
+	if !validator.CtypeDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	data, path, err := model.ExportDownload(id, admin["id"])
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	if data.Storage == model.ExportStorageMinio {
+		ctx.Redirect(path, fasthttp.StatusFound)
+		return
+	}
+
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, data.FileName))
+	ctx.SendFile(path)
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type HangUpReasonController struct{}
+
+type hangUpReasonParam struct {
+	ID            string `rule:"none" name:"id"`
+	Title         string `rule:"filter" min:"1" max:"50" msg:"title error" name:"title"`
+	ContentCn     string `rule:"filter" default:"" min:"0" max:"200" msg:"content_cn error" name:"content_cn"`
+	ContentEn     string `rule:"filter" default:"" min:"0" max:"200" msg:"content_en error" name:"content_en"`
+	ContentVn     string `rule:"filter" default:"" min:"0" max:"200" msg:"content_vn error" name:"content_vn"`
+	ResumeSeconds int64  `rule:"digit" default:"0" min:"0" max:"2592000" msg:"resume_seconds error" name:"resume_seconds"`
+	Sort          int    `rule:"digit" default:"0" min:"0" max:"9999" msg:"sort error" name:"sort"`
+	State         int    `rule:"digit" default:"1" min:"0" max:"1" msg:"state error" name:"state"`
+}
+
+type hangUpReportParam struct {
+	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
+}
+
+// List 风控管理-挂起原因-列表
+func (that *HangUpReasonController) List(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.HangUpReasonList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Insert 风控管理-挂起原因-新增
+func (that *HangUpReasonController) Insert(ctx *fasthttp.RequestCtx) {
+
+	param := hangUpReasonParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := hangUpReasonRecord(param, admin, ctx.Time().Unix())
+	record["id"] = helper.GenId()
+	err = model.HangUpReasonInsert(record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Update 风控管理-挂起原因-修改
+func (that *HangUpReasonController) Update(ctx *fasthttp.RequestCtx) {
+
+	param := hangUpReasonParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !validator.CheckStringDigit(param.ID) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := hangUpReasonRecord(param, admin, ctx.Time().Unix())
+	err = model.HangUpReasonUpdate(param.ID, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Report 风控管理-挂起原因-按原因和风控人员统计
+func (that *HangUpReasonController) Report(ctx *fasthttp.RequestCtx) {
+
+	param := hangUpReportParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.WithdrawHangUpReport(param.StartTime, param.EndTime)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+func hangUpReasonRecord(param hangUpReasonParam, admin map[string]string, ts int64) g.Record {
+
+	return g.Record{
+		"title":          param.Title,
+		"content_cn":     param.ContentCn,
+		"content_en":     param.ContentEn,
+		"content_vn":     param.ContentVn,
+		"resume_seconds": param.ResumeSeconds,
+		"sort":           param.Sort,
+		"state":          param.State,
+		"updated_at":     ts,
+		"updated_uid":    admin["id"],
+		"updated_name":   admin["name"],
+	}
+}
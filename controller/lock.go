@@ -7,14 +7,20 @@ import (
 	"finance/contrib/validator"
 	"finance/model"
 
+	g "github.com/doug-martin/goqu/v9"
 	"github.com/valyala/fasthttp"
 )
 
 type LockController struct{}
 
 type memberLockParam struct {
-	Username string `rule:"uname" min:"5" max:"14" msg:"username error" name:"username"` // 会员名
-	Comment  string `rule:"none" min:"0" max:"50" msg:"comment error" name:"comment"`    // 备注
+	Username  string `rule:"uname" min:"5" max:"14" msg:"username error" name:"username"` // 会员名
+	Comment   string `rule:"none" min:"0" max:"50" msg:"comment error" name:"comment"`    // 备注
+	Scope     int    `rule:"digit" default:"1" min:"1" max:"4" msg:"scope error" name:"scope"`
+	Target    string `rule:"none" msg:"target error" name:"target"` // 限制的渠道id
+	Reason    int    `rule:"digit" default:"9" min:"1" max:"9" msg:"reason error" name:"reason"`
+	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
 }
 
 type memberLockListParam struct {
@@ -22,6 +28,7 @@ type memberLockListParam struct {
 	LockName  string `rule:"none"  msg:"lock_name error" name:"lock_name"`      // 锁定操作人
 	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
 	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
+	Scope     int    `rule:"digit" default:"0" min:"0" max:"4" msg:"scope error" name:"scope"`
 	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
 	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
 }
@@ -46,6 +53,32 @@ func (that *LockController) MemberInsert(ctx *fasthttp.RequestCtx) {
 		param.Comment = validator.FilterInjection(param.Comment)
 	}
 
+	if _, ok := model.LockScopes[param.Scope]; !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if _, ok := model.LockReasons[param.Reason]; !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	// 指定渠道时必须传渠道id, 其他范围不需要
+	if param.Scope == model.LockScopeChannel && !validator.CheckStringDigit(param.Target) {
+		helper.Print(ctx, false, helper.ChannelIDErr)
+		return
+	}
+
+	if param.Scope != model.LockScopeChannel {
+		param.Target = ""
+	}
+
+	startAt, endAt, err := model.LockTimeRange(param.StartTime, param.EndTime, ctx.Time().Unix())
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
 	admin, err := model.AdminToken(ctx)
 	if err != nil || len(admin["id"]) < 1 {
 		helper.Print(ctx, false, helper.AccessTokenExpires)
@@ -58,23 +91,22 @@ func (that *LockController) MemberInsert(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	//
-
 	// 写入系统日志
 	//logMsg := fmt.Sprintf("锁定【会员账号: %s】", param.Username)
 	//defer model.SystemLogWrite(logMsg, ctx)
 
-	fields := map[string]string{
+	record := g.Record{
 		"id":           helper.GenId(),
-		"uid":          member.UID,
 		"username":     param.Username,
-		"state":        "1",
+		"reason":       param.Reason,
+		"start_at":     startAt,
+		"end_at":       endAt,
 		"comment":      param.Comment,
 		"created_uid":  admin["id"],
 		"created_name": admin["name"],
-		"created_at":   fmt.Sprintf("%d", ctx.Time().Unix()),
+		"created_at":   ctx.Time().Unix(),
 	}
-	err = model.LockInsert(fields)
+	err = model.LockInsert(member.UID, param.Scope, param.Target, record)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
 		return
@@ -107,7 +139,25 @@ func (that *LockController) MemberList(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
-	data, err := model.LockList(param.Username, param.LockName, param.StartTime, param.EndTime, param.Page, param.PageSize)
+	data, err := model.LockList(param.Username, param.LockName, param.StartTime, param.EndTime, param.Scope, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// History 财务管理-渠道管理-会员锁定-会员的全部限制记录
+func (that *LockController) History(ctx *fasthttp.RequestCtx) {
+
+	username := string(ctx.QueryArgs().Peek("username"))
+	if !validator.CheckUName(username, 5, 14) {
+		helper.Print(ctx, false, helper.UsernameErr)
+		return
+	}
+
+	data, err := model.LockHistory(username)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
 		return
@@ -137,7 +187,7 @@ func (that *LockController) UpdateState(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	if info.State == "0" {
+	if info.State != model.LockStateActive {
 		helper.Print(ctx, false, helper.NoDataUpdate)
 		return
 	}
@@ -147,7 +197,7 @@ func (that *LockController) UpdateState(ctx *fasthttp.RequestCtx) {
 
 	fields := map[string]string{
 		"id":           id,
-		"state":        "0",
+		"state":        model.LockStateReleased,
 		"updated_uid":  admin["id"],
 		"updated_name": admin["name"],
 		"updated_at":   fmt.Sprintf("%d", ctx.Time().Unix()),
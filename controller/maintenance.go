@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type MaintenanceController struct{}
+
+type maintenanceListParam struct {
+	CateID    string `rule:"digit" default:"0" msg:"cate_id error" name:"cate_id"`       // 渠道id
+	PaymentID string `rule:"digit" default:"0" msg:"payment_id error" name:"payment_id"` // 通道id
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+type maintenanceParam struct {
+	CateID    string `rule:"digit" msg:"cate_id error" name:"cate_id"`                   // 渠道id
+	PaymentID string `rule:"digit" default:"0" msg:"payment_id error" name:"payment_id"` // 通道id, 0为整个渠道
+	StartTime string `rule:"time" msg:"start_time error" name:"start_time"`              // 开始时间
+	EndTime   string `rule:"time" msg:"end_time error" name:"end_time"`                  // 结束时间
+	Message   string `rule:"none" msg:"message error" name:"message"`                    // 会员看到的维护提示
+}
+
+// List 财务管理-渠道管理-维护计划-列表
+func (that *MaintenanceController) List(ctx *fasthttp.RequestCtx) {
+
+	param := maintenanceListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ex := g.Ex{}
+	if param.CateID != "0" {
+		ex["cate_id"] = param.CateID
+	}
+	if param.PaymentID != "0" {
+		ex["payment_id"] = param.PaymentID
+	}
+
+	data, err := model.MaintenanceList(ex, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Insert 财务管理-渠道管理-维护计划-新增
+func (that *MaintenanceController) Insert(ctx *fasthttp.RequestCtx) {
+
+	param := maintenanceParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := g.Record{
+		"message":      param.Message,
+		"created_at":   ctx.Time().Unix(),
+		"created_uid":  admin["id"],
+		"created_name": admin["name"],
+	}
+	err = model.MaintenanceInsert(param.CateID, param.PaymentID, param.StartTime, param.EndTime, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Cancel 财务管理-渠道管理-维护计划-取消/提前结束
+func (that *MaintenanceController) Cancel(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.PostArgs().Peek("id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	err := model.MaintenanceCancel(id)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
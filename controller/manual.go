@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"io"
+
 	"finance/contrib/helper"
 	"finance/contrib/validator"
 	"finance/model"
@@ -118,6 +120,8 @@ func (that *ManualController) List(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	model.DepositReceiptAttach(&data)
+
 	helper.Print(ctx, true, data)
 }
 
@@ -248,3 +252,161 @@ func (that *ManualController) Review(ctx *fasthttp.RequestCtx) {
 
 	helper.Print(ctx, true, helper.Success)
 }
+
+// QrDetail 线下转卡订单的收款信息和VietQR二维码
+func (that *ManualController) QrDetail(ctx *fasthttp.RequestCtx) {
+
+	orderNo := string(ctx.PostArgs().Peek("order_no"))
+	if !helper.CtypeDigit(orderNo) {
+		helper.Print(ctx, false, helper.ParamNull)
+		return
+	}
+
+	user, err := model.MemberCache(ctx)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	res, err := model.ManualQRDetail(user.UID, orderNo)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, res)
+}
+
+// Receipt 会员为线下转卡或线下USDT订单上传转账截图
+func (that *ManualController) Receipt(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.FormValue("id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	user, err := model.MemberCache(ctx)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	fh, err := ctx.FormFile("file")
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if fh.Size == 0 || fh.Size > model.DepositReceiptMaxSize {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+
+	err = model.DepositReceiptUpload(user.UID, user.Username, id, content)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// ReceiptView 财务管理-存款管理-查看本地保存的转账截图
+func (that *ManualController) ReceiptView(ctx *fasthttp.RequestCtx) {
+
+	token := string(ctx.QueryArgs().Peek("token"))
+	if token == "" {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	rec, err := model.DepositReceiptView(token)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	ctx.SetContentType(rec.ContentType)
+	ctx.SendFile(rec.Path)
+}
+
+type manualCodeConfParam struct {
+	Window    int64 `rule:"digit" min:"3600" max:"2592000" msg:"window error" name:"window"`              // 同一张收款卡附言码不重复的时间(秒)
+	Alert     int   `rule:"digit" min:"1" max:"100" msg:"alert error" name:"alert"`                       // 已用占比超过多少(%)时告警
+	AmountTag int   `rule:"digit" default:"0" min:"0" max:"999" msg:"amount_tag error" name:"amount_tag"` // 金额尾数的最大值(VND), 0为不开启
+}
+
+type manualAmountTagParam struct {
+	BankcardID string `rule:"digit" msg:"bankcard_id error" name:"bankcard_id"`
+	AmountTag  int    `rule:"digit" default:"0" min:"0" max:"999" msg:"amount_tag error" name:"amount_tag"` // 金额尾数的最大值(VND), 0为该卡不开启
+	Inherit    int    `rule:"digit" default:"0" min:"0" max:"1" msg:"inherit error" name:"inherit"`         // 1 删除单独配置, 使用全局配置
+}
+
+// CodeConf 财务管理-线下转卡-附言码和金额尾数配置
+func (that *ManualController) CodeConf(ctx *fasthttp.RequestCtx) {
+
+	conf, err := model.ManualCodeConfGet()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, conf)
+}
+
+// CodeConfUpdate 财务管理-线下转卡-修改附言码和金额尾数配置
+func (that *ManualController) CodeConfUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := manualCodeConfParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	conf := model.ManualCodeConf{
+		Window:    param.Window,
+		Alert:     param.Alert,
+		AmountTag: param.AmountTag,
+	}
+	err = model.ManualCodeConfSet(conf)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// AmountTagUpdate 财务管理-线下转卡-设置收款卡的金额尾数
+func (that *ManualController) AmountTagUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := manualAmountTagParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	err = model.ManualAmountTagSet(param.BankcardID, param.AmountTag, param.Inherit == 1)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
@@ -136,6 +136,18 @@ func (that *PayController) Cate(ctx *fasthttp.RequestCtx) {
 	helper.PrintJson(ctx, true, data)
 }
 
+// Maintenance 当前正在维护的渠道和通道及提示信息
+func (that *PayController) Maintenance(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.MaintenanceActive(ctx.Time())
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
 func (that *PayController) PayQrDetail(ctx *fasthttp.RequestCtx) {
 
 	orderNo := string(ctx.PostArgs().Peek("order_no"))
@@ -0,0 +1,349 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	"github.com/valyala/fasthttp"
+)
+
+type ReconcileController struct{}
+
+// 三方账单最大10M
+const reconcileFileMaxSize = 10 << 20
+
+type reconcileMappingParam struct {
+	ID           string `rule:"none" name:"id"`
+	CateID       string `rule:"digit" msg:"cate_id error" name:"cate_id"`                                    // 渠道id
+	Ty           int    `rule:"digit" min:"1" max:"2" msg:"ty error" name:"ty"`                              // 1 代收 2 代付
+	HeaderRow    int    `rule:"digit" default:"1" min:"1" max:"50" msg:"header_row error" name:"header_row"` // 表头所在行
+	ColID        string `rule:"none" name:"col_id"`                                                          // 我方订单号列名
+	ColOID       string `rule:"none" name:"col_oid"`                                                         // 三方订单号列名
+	ColAmount    string `rule:"none" name:"col_amount"`                                                      // 金额列名
+	ColState     string `rule:"none" name:"col_state"`                                                       // 状态列名
+	SuccessValue string `rule:"none" name:"success_value"`                                                   // 成功状态值, 多个逗号分开
+	Cent         int64  `rule:"digit" default:"1000" min:"1" max:"1000000" msg:"cent error" name:"cent"`     // 账单金额单位, 越南盾账单为1000
+}
+
+type reconcileBatchListParam struct {
+	CateID    string `rule:"none" name:"cate_id"`
+	Ty        int    `rule:"digit" default:"0" min:"0" max:"2" msg:"ty error" name:"ty"`
+	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+type reconcileItemListParam struct {
+	BatchID  string `rule:"digit" msg:"batch_id error" name:"batch_id"`
+	Result   int    `rule:"digit" default:"0" min:"0" max:"5" msg:"result error" name:"result"` // 0 全部
+	State    string `rule:"none" name:"state"`                                                  // 为空查全部
+	Page     uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+type reconcileHandleParam struct {
+	ID     string `rule:"digit" msg:"id error" name:"id"`
+	Remark string `rule:"none" name:"remark"`
+}
+
+// MappingList 财务管理-对账管理-账单映射-列表
+func (that *ReconcileController) MappingList(ctx *fasthttp.RequestCtx) {
+
+	cateID := string(ctx.QueryArgs().Peek("cate_id"))
+	if cateID != "" && !validator.CtypeDigit(cateID) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ty := ctx.QueryArgs().GetUintOrZero("ty")
+	data, err := model.ReconcileMappingList(cateID, ty)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// MappingInsert 财务管理-对账管理-账单映射-新增
+func (that *ReconcileController) MappingInsert(ctx *fasthttp.RequestCtx) {
+
+	param := reconcileMappingParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	fields, ok := reconcileMappingFields(param)
+	if !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	fields["id"] = helper.GenId()
+	fields["cate_id"] = param.CateID
+	fields["ty"] = strconv.Itoa(param.Ty)
+	fields["created_at"] = fmt.Sprintf("%d", ctx.Time().Unix())
+	fields["updated_uid"] = admin["id"]
+	fields["updated_name"] = admin["name"]
+	err = model.ReconcileMappingInsert(fields)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// MappingUpdate 财务管理-对账管理-账单映射-修改
+func (that *ReconcileController) MappingUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := reconcileMappingParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !validator.CtypeDigit(param.ID) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	fields, ok := reconcileMappingFields(param)
+	if !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	fields["updated_at"] = fmt.Sprintf("%d", ctx.Time().Unix())
+	fields["updated_uid"] = admin["id"]
+	fields["updated_name"] = admin["name"]
+	err = model.ReconcileMappingUpdate(param.ID, fields)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Import 财务管理-对账管理-导入三方账单并对账
+func (that *ReconcileController) Import(ctx *fasthttp.RequestCtx) {
+
+	mappingID := string(ctx.FormValue("mapping_id"))
+	startTime := string(ctx.FormValue("start_time"))
+	endTime := string(ctx.FormValue("end_time"))
+
+	if !validator.CtypeDigit(mappingID) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	if startTime == "" || endTime == "" {
+		helper.Print(ctx, false, helper.DateTimeErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	fh, err := ctx.FormFile("file")
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(fh.Filename))
+	if (ext != ".csv" && ext != ".xlsx") || fh.Size == 0 || fh.Size > reconcileFileMaxSize {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		helper.Print(ctx, false, helper.FormatErr)
+		return
+	}
+
+	data, err := model.ReconcileImport(mappingID, filepath.Base(fh.Filename), content, startTime, endTime, admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// BatchList 财务管理-对账管理-对账记录
+func (that *ReconcileController) BatchList(ctx *fasthttp.RequestCtx) {
+
+	param := reconcileBatchListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if param.CateID != "" && !validator.CtypeDigit(param.CateID) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.ReconcileBatchList(param.CateID, param.Ty, param.StartTime, param.EndTime, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// ItemList 财务管理-对账管理-对账明细
+func (that *ReconcileController) ItemList(ctx *fasthttp.RequestCtx) {
+
+	param := reconcileItemListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	state := -1
+	if param.State != "" {
+		if !validator.CheckIntScope(param.State, 0, 2) {
+			helper.Print(ctx, false, helper.StateParamErr)
+			return
+		}
+
+		state, _ = strconv.Atoi(param.State)
+	}
+
+	data, err := model.ReconcileItemList(param.BatchID, param.Result, state, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Repair 财务管理-对账管理-对账明细-一键补单
+func (that *ReconcileController) Repair(ctx *fasthttp.RequestCtx) {
+
+	param := reconcileHandleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !validator.CheckStringLength(param.Remark, 0, 50) {
+		helper.Print(ctx, false, helper.RemarkFMTErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.ReconcileRepair(param.ID, validator.FilterInjection(param.Remark), admin["name"], admin["id"])
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Ignore 财务管理-对账管理-对账明细-标记已处理
+func (that *ReconcileController) Ignore(ctx *fasthttp.RequestCtx) {
+
+	param := reconcileHandleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if param.Remark == "" || !validator.CheckStringLength(param.Remark, 1, 50) {
+		helper.Print(ctx, false, helper.RemarkFMTErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	err = model.ReconcileIgnore(param.ID, validator.FilterInjection(param.Remark), admin["name"], admin["id"])
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+func reconcileMappingFields(param reconcileMappingParam) (map[string]string, bool) {
+
+	// 金额列必填, 我方订单号和三方订单号至少一个
+	if param.ColAmount == "" || (param.ColID == "" && param.ColOID == "") {
+		return nil, false
+	}
+
+	if param.ColState != "" && param.SuccessValue == "" {
+		return nil, false
+	}
+
+	fields := map[string]string{
+		"header_row":    strconv.Itoa(param.HeaderRow),
+		"col_id":        param.ColID,
+		"col_oid":       param.ColOID,
+		"col_amount":    param.ColAmount,
+		"col_state":     param.ColState,
+		"success_value": param.SuccessValue,
+		"cent":          strconv.FormatInt(param.Cent, 10),
+	}
+	for _, v := range fields {
+		if !validator.CheckStringLength(v, 0, 50) {
+			return nil, false
+		}
+	}
+
+	return fields, true
+}
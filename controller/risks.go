@@ -2,7 +2,12 @@ package controller
 
 import (
 	"finance/contrib/helper"
+	"finance/contrib/validator"
 	"finance/model"
+	"time"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/shopspring/decimal"
 	"github.com/valyala/fasthttp"
 )
 
@@ -134,3 +139,151 @@ func (that *RisksController) RegMax(ctx *fasthttp.RequestCtx) {
 
 	helper.Print(ctx, true, num)
 }
+
+type risksDispatchConfParam struct {
+	Mode           int    `rule:"digit" min:"1" max:"3" msg:"mode error" name:"mode"` // 1 轮询 2 最少未处理 3 权重
+	Timeout        int64  `rule:"digit" default:"0" min:"0" max:"86400" msg:"timeout error" name:"timeout"`
+	PriorityVip    int    `rule:"digit" default:"0" min:"0" max:"11" msg:"priority_vip error" name:"priority_vip"`
+	PriorityAmount string `rule:"none" default:"0" name:"priority_amount"`
+}
+
+type risksReviewerParam struct {
+	UID        string `rule:"digit" msg:"uid error" name:"uid"`
+	Weight     int    `rule:"digit" default:"1" min:"1" max:"100" msg:"weight error" name:"weight"`
+	Priority   int    `rule:"digit" default:"0" min:"0" max:"1" msg:"priority error" name:"priority"`
+	ShiftStart string `rule:"none" default:"" name:"shift_start"` // HH:MM
+	ShiftEnd   string `rule:"none" default:"" name:"shift_end"`   // HH:MM
+}
+
+type risksDispatchLogParam struct {
+	UID       string `rule:"none" name:"uid"`
+	OrderID   string `rule:"none" name:"order_id"`
+	StartTime string `rule:"none" name:"start_time"`
+	EndTime   string `rule:"none" name:"end_time"`
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+// 派单配置
+func (that *RisksController) DispatchConf(ctx *fasthttp.RequestCtx) {
+
+	conf, err := model.RisksDispatchConfGet()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, conf)
+}
+
+// 修改派单配置
+func (that *RisksController) DispatchConfUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := risksDispatchConfParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	amount, err := decimal.NewFromString(param.PriorityAmount)
+	if err != nil || amount.IsNegative() {
+		helper.Print(ctx, false, helper.AmountErr)
+		return
+	}
+
+	conf := model.RisksDispatchConf{
+		Mode:           param.Mode,
+		Timeout:        param.Timeout,
+		PriorityVip:    param.PriorityVip,
+		PriorityAmount: amount.String(),
+	}
+	err = model.RisksDispatchConfSet(conf)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// 设置风控人员的排班和派单权重
+func (that *RisksController) ReviewerUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := risksReviewerParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	// 班次开始和结束必须同时设置或同时为空
+	if (param.ShiftStart == "") != (param.ShiftEnd == "") {
+		helper.Print(ctx, false, helper.DateTimeErr)
+		return
+	}
+
+	if param.ShiftStart != "" {
+		_, err1 := time.Parse("15:04", param.ShiftStart)
+		_, err2 := time.Parse("15:04", param.ShiftEnd)
+		if err1 != nil || err2 != nil || param.ShiftStart == param.ShiftEnd {
+			helper.Print(ctx, false, helper.DateTimeErr)
+			return
+		}
+	}
+
+	name, err := model.AdminGetName(param.UID)
+	if err != nil || name == "" {
+		helper.Print(ctx, false, helper.AdminNameErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := g.Record{
+		"name":         name,
+		"weight":       param.Weight,
+		"priority":     param.Priority,
+		"shift_start":  param.ShiftStart,
+		"shift_end":    param.ShiftEnd,
+		"updated_at":   ctx.Time().Unix(),
+		"updated_uid":  admin["id"],
+		"updated_name": admin["name"],
+	}
+	err = model.RisksReviewerUpdate(param.UID, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// 派单记录
+func (that *RisksController) DispatchLog(ctx *fasthttp.RequestCtx) {
+
+	param := risksDispatchLogParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if (param.UID != "" && !validator.CheckStringDigit(param.UID)) ||
+		(param.OrderID != "" && !validator.CheckStringDigit(param.OrderID)) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	data, err := model.RisksDispatchLogList(param.UID, param.OrderID, param.StartTime, param.EndTime, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
@@ -40,6 +40,35 @@ func (that *UsdtController) Update(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	if field == "usdt_rate" {
+		admin, err := model.AdminToken(ctx)
+		if err != nil || len(admin["id"]) < 1 {
+			helper.Print(ctx, false, helper.AccessTokenExpires)
+			return
+		}
+
+		info, err := model.UsdtInfo()
+		if err != nil {
+			helper.Print(ctx, false, err.Error())
+			return
+		}
+
+		// 汇率变化超过复核阈值时需要另一个管理员复核
+		before := map[string]string{"usdt_rate": info["usdt_rate"]}
+		after := map[string]string{"usdt_rate": value}
+		approvalID, err := model.ApprovalSubmit(model.ApprovalUsdtRate, field, model.ApprovalAmountChange(before, after),
+			before, after, map[string]string{"value": value}, string(ctx.PostArgs().Peek("reason")), admin)
+		if err != nil {
+			helper.Print(ctx, false, err.Error())
+			return
+		}
+
+		if approvalID != "" {
+			approvalPending(ctx, approvalID)
+			return
+		}
+	}
+
 	err := model.UsdtUpdate(field, value)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
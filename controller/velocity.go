@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type VelocityController struct{}
+
+type velocityListParam struct {
+	Ty       int    `rule:"digit" default:"0" min:"0" max:"2" msg:"ty error" name:"ty"`
+	Username string `rule:"none" msg:"username error" name:"username"` // 会员名
+}
+
+type velocityUpdateParam struct {
+	Ty          int    `rule:"digit" min:"1" max:"2" msg:"ty error" name:"ty"`
+	Level       int    `rule:"digit" default:"0" min:"0" max:"11" msg:"level error" name:"level"`
+	Username    string `rule:"none" msg:"username error" name:"username"` // 会员名, 不为空时设置单个会员
+	SingleMax   string `rule:"float" default:"0" msg:"single_max error" name:"single_max"`
+	HourCount   int64  `rule:"digit" default:"0" min:"0" max:"100000" msg:"hour_count error" name:"hour_count"`
+	HourAmount  string `rule:"float" default:"0" msg:"hour_amount error" name:"hour_amount"`
+	DayCount    int64  `rule:"digit" default:"0" min:"0" max:"100000" msg:"day_count error" name:"day_count"`
+	DayAmount   string `rule:"float" default:"0" msg:"day_amount error" name:"day_amount"`
+	WeekCount   int64  `rule:"digit" default:"0" min:"0" max:"100000" msg:"week_count error" name:"week_count"`
+	WeekAmount  string `rule:"float" default:"0" msg:"week_amount error" name:"week_amount"`
+	MonthCount  int64  `rule:"digit" default:"0" min:"0" max:"100000" msg:"month_count error" name:"month_count"`
+	MonthAmount string `rule:"float" default:"0" msg:"month_amount error" name:"month_amount"`
+}
+
+type velocityQuotaParam struct {
+	Username string `rule:"uname" min:"5" max:"14" msg:"username error" name:"username"` // 会员名
+}
+
+// List 财务管理-存提款限额-列表
+func (that *VelocityController) List(ctx *fasthttp.RequestCtx) {
+
+	param := velocityListParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ex := g.Ex{}
+	if param.Ty > 0 {
+		ex["ty"] = param.Ty
+	}
+	if param.Username != "" {
+		ex["username"] = param.Username
+	}
+
+	data, err := model.VelocityList(ex)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Update 财务管理-存提款限额-按会员等级或单个会员设置
+func (that *VelocityController) Update(ctx *fasthttp.RequestCtx) {
+
+	param := velocityUpdateParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := g.Record{
+		"single_max":   param.SingleMax,
+		"hour_count":   param.HourCount,
+		"hour_amount":  param.HourAmount,
+		"day_count":    param.DayCount,
+		"day_amount":   param.DayAmount,
+		"week_count":   param.WeekCount,
+		"week_amount":  param.WeekAmount,
+		"month_count":  param.MonthCount,
+		"month_amount": param.MonthAmount,
+		"updated_at":   ctx.Time().Unix(),
+		"updated_uid":  admin["id"],
+		"updated_name": admin["name"],
+	}
+	err = model.VelocityUpdate(param.Ty, param.Level, param.Username, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Delete 财务管理-存提款限额-删除
+func (that *VelocityController) Delete(ctx *fasthttp.RequestCtx) {
+
+	id := string(ctx.PostArgs().Peek("id"))
+	if !validator.CheckStringDigit(id) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	err := model.VelocityDelete(id)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Quota 财务管理-存提款限额-查询会员剩余额度
+func (that *VelocityController) Quota(ctx *fasthttp.RequestCtx) {
+
+	param := velocityQuotaParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	data, err := model.VelocityQuotaFind(param.Username)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
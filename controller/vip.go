@@ -156,6 +156,39 @@ func (that *VipController) Update(ctx *fasthttp.RequestCtx) {
 				cateName, channelName, level-1, param.FMin, param.FMax)
 			defer model.SystemLogWrite(logMsg, ctx)
 	*/
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	// 限额变化超过复核阈值时需要另一个管理员复核
+	before := map[string]string{
+		"fmin": vip.Fmin,
+		"fmax": vip.Fmax,
+	}
+	after := map[string]string{
+		"fmin": param.FMin,
+		"fmax": param.FMax,
+	}
+	payload := map[string]string{
+		"payment_id": vip.PaymentID,
+		"vip":        param.Vip,
+		"fmin":       param.FMin,
+		"fmax":       param.FMax,
+	}
+	approvalID, err := model.ApprovalSubmit(model.ApprovalVipLimit, param.ID, model.ApprovalAmountChange(before, after),
+		before, after, payload, string(ctx.PostArgs().Peek("reason")), admin)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	if approvalID != "" {
+		approvalPending(ctx, approvalID)
+		return
+	}
+
 	fields := map[string]string{
 		"id":   param.ID,
 		"fmin": param.FMin,
@@ -47,6 +47,7 @@ type withdrawHangUp struct {
 	ID           string `name:"id" rule:"digit" msg:"id error"`
 	RemarkID     string `name:"remark_id" rule:"digit" msg:"remark_id error"`
 	HangUpRemark string `name:"hang_up_remark" rule:"filter" min:"1" max:"100" msg:"hang_up_remark error"`
+	ResumeAt     string `name:"resume_at" rule:"none" default:""` // 自动恢复时间, 为空时使用挂起原因的默认时长
 }
 
 type withdrawRecord struct {
@@ -79,6 +80,7 @@ func (that *WithdrawController) RiskReviewList(ctx *fasthttp.RequestCtx) {
 	id := string(ctx.FormValue("id"))                    // 订单号
 	realName := string(ctx.FormValue("real_name"))       // 真实姓名
 	confirmName := string(ctx.FormValue("confirm_name")) // 领取人
+	sortScore := string(ctx.FormValue("sort_score"))     // 风险评分排序 1 从高到低 2 从低到高
 	page, err := strconv.ParseUint(string(ctx.FormValue("page")), 10, 64)
 	if err != nil {
 		page = 1
@@ -114,9 +116,20 @@ func (that *WithdrawController) RiskReviewList(ctx *fasthttp.RequestCtx) {
 		ex = g.Ex{"id": id}
 	}
 
+	orders, ok := withdrawRiskOrder(sortScore)
+	if !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !withdrawWalletEx(ctx, ex) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	// 已派单
 	ex["state"] = model.WithdrawDispatched
-	data, err := model.WithdrawList(ex, 3, "", "", uint(page), uint(pageSize))
+	data, err := model.WithdrawList(ex, 3, "", "", uint(page), uint(pageSize), orders...)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
 		return
@@ -142,6 +155,7 @@ func (that *WithdrawController) RiskWaitConfirmList(ctx *fasthttp.RequestCtx) {
 	maxAmount := string(ctx.FormValue("max_amount"))
 	minAmount := string(ctx.FormValue("min_amount"))
 	vips := string(ctx.FormValue("vips"))
+	sortScore := string(ctx.FormValue("sort_score")) // 风险评分排序 1 从高到低 2 从低到高
 	page, err := strconv.ParseUint(string(ctx.FormValue("page")), 10, 64)
 	if err != nil {
 		page = 1
@@ -156,6 +170,12 @@ func (that *WithdrawController) RiskWaitConfirmList(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	orders, ok := withdrawRiskOrder(sortScore)
+	if !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	ex := g.Ex{}
 	if realName != "" {
 		if len([]rune(id)) > 30 {
@@ -210,10 +230,16 @@ func (that *WithdrawController) RiskWaitConfirmList(ctx *fasthttp.RequestCtx) {
 		}
 		ex = g.Ex{"id": id}
 	}
+
+	if !withdrawWalletEx(ctx, ex) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	// 待派单
 	ex["state"] = model.WithdrawReviewing
 
-	data, err := model.WithdrawList(ex, 1, startTime, endTime, uint(page), uint(pageSize))
+	data, err := model.WithdrawList(ex, 1, startTime, endTime, uint(page), uint(pageSize), orders...)
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
 		return
@@ -228,6 +254,36 @@ func (that *WithdrawController) RiskWaitConfirmList(ctx *fasthttp.RequestCtx) {
 	helper.Print(ctx, true, result)
 }
 
+// 风控列表按风险评分排序
+func withdrawRiskOrder(sortScore string) ([]exp.OrderedExpression, bool) {
+
+	switch sortScore {
+	case "":
+		return nil, true
+	case "1":
+		return []exp.OrderedExpression{g.C("risk_score").Desc()}, true
+	case "2":
+		return []exp.OrderedExpression{g.C("risk_score").Asc()}, true
+	}
+
+	return nil, false
+}
+
+// 按提款钱包筛选, 1 中心钱包 2 佣金钱包, 为空时不筛选
+func withdrawWalletEx(ctx *fasthttp.RequestCtx, ex g.Ex) bool {
+
+	walletFlag := string(ctx.FormValue("wallet_flag"))
+	switch walletFlag {
+	case "":
+		return true
+	case "1", "2":
+		ex["wallet_flag"] = walletFlag
+		return true
+	}
+
+	return false
+}
+
 // HangUpList 风控审核挂起列表
 func (that *WithdrawController) HangUpList(ctx *fasthttp.RequestCtx) {
 
@@ -323,6 +379,11 @@ func (that *WithdrawController) HangUpList(ctx *fasthttp.RequestCtx) {
 		ex = g.Ex{"id": id}
 	}
 
+	if !withdrawWalletEx(ctx, ex) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	// 挂起
 	ex["state"] = model.WithdrawHangup
 	data, err := model.WithdrawList(ex, 1, startTime, endTime, uint(page), uint(pageSize))
@@ -437,6 +498,11 @@ func (that *WithdrawController) RiskHistory(ctx *fasthttp.RequestCtx) {
 		model.WithdrawAbnormal,
 		model.WithdrawAutoPayFailed,
 	}
+	if !withdrawWalletEx(ctx, ex) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	ex["state"] = baseState
 
 	if state != "" {
@@ -484,13 +550,8 @@ func (that *WithdrawController) RiskHistory(ctx *fasthttp.RequestCtx) {
 // HistoryList 后台提款列表
 func (that *WithdrawController) HistoryList(ctx *fasthttp.RequestCtx) {
 
-	id := string(ctx.FormValue("id")) // 订单号
-	username := string(ctx.FormValue("username"))
 	startTime := string(ctx.FormValue("start_time"))
 	endTime := string(ctx.FormValue("end_time"))
-	maxAmount := string(ctx.FormValue("max_amount"))
-	minAmount := string(ctx.FormValue("min_amount"))
-	state := string(ctx.FormValue("state"))
 	ty := string(ctx.FormValue("ty"))
 
 	page, err := strconv.ParseUint(string(ctx.FormValue("page")), 10, 64)
@@ -502,23 +563,90 @@ func (that *WithdrawController) HistoryList(ctx *fasthttp.RequestCtx) {
 		pageSize = 15
 	}
 
-	if startTime == "" || endTime == "" {
-		helper.Print(ctx, false, helper.DateTimeErr)
+	ex, msg := withdrawHistoryEx(ctx)
+	if msg != "" {
+		helper.Print(ctx, false, msg)
 		return
 	}
 
+	data, err := model.WithdrawHistoryList(ex, ty, startTime, endTime, uint(page), uint(pageSize))
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	result, err := model.WithdrawDealListData(data)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, result)
+}
+
+// HistoryExport 后台提款历史记录导出
+func (that *WithdrawController) HistoryExport(ctx *fasthttp.RequestCtx) {
+
+	startTime := string(ctx.FormValue("start_time"))
+	endTime := string(ctx.FormValue("end_time"))
+	ty := string(ctx.FormValue("ty"))
+	fileType := string(ctx.FormValue("file_type"))
+
+	if fileType == "" {
+		fileType = "csv"
+	}
+
+	if fileType != "csv" && fileType != "xlsx" {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	ex, msg := withdrawHistoryEx(ctx)
+	if msg != "" {
+		helper.Print(ctx, false, msg)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	id, err := model.ExportWithdrawInsert(admin, fileType, ctx.PostArgs().String(), ex, ty, startTime, endTime)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, id)
+}
+
+// 提款历史记录查询条件, 列表和导出共用
+func withdrawHistoryEx(ctx *fasthttp.RequestCtx) (g.Ex, string) {
+
+	id := string(ctx.FormValue("id")) // 订单号
+	username := string(ctx.FormValue("username"))
+	startTime := string(ctx.FormValue("start_time"))
+	endTime := string(ctx.FormValue("end_time"))
+	maxAmount := string(ctx.FormValue("max_amount"))
+	minAmount := string(ctx.FormValue("min_amount"))
+	state := string(ctx.FormValue("state"))
+
+	if startTime == "" || endTime == "" {
+		return nil, helper.DateTimeErr
+	}
+
 	ex := g.Ex{}
 	if minAmount != "" && maxAmount != "" {
 		if !validator.CheckStringDigit(minAmount) || !validator.CheckStringDigit(maxAmount) {
-			helper.Print(ctx, false, helper.AmountErr)
-			return
+			return nil, helper.AmountErr
 		}
 
 		minAmountInt, _ := strconv.Atoi(minAmount)
 		maxAmountInt, _ := strconv.Atoi(maxAmount)
 		if minAmountInt > maxAmountInt {
-			helper.Print(ctx, false, helper.AmountErr)
-			return
+			return nil, helper.AmountErr
 		}
 
 		ex["amount"] = g.Op{"between": exp.NewRangeVal(minAmountInt, maxAmountInt)}
@@ -526,8 +654,7 @@ func (that *WithdrawController) HistoryList(ctx *fasthttp.RequestCtx) {
 
 	if username != "" {
 		if !validator.CheckUName(username, 5, 14) {
-			helper.Print(ctx, false, helper.UsernameErr)
-			return
+			return nil, helper.UsernameErr
 		}
 
 		ex["username"] = username
@@ -540,18 +667,20 @@ func (that *WithdrawController) HistoryList(ctx *fasthttp.RequestCtx) {
 		model.WithdrawReviewReject,
 		model.WithdrawDispatched,
 	}
+	if !withdrawWalletEx(ctx, ex) {
+		return nil, helper.ParamErr
+	}
+
 	ex["state"] = baseState
 
 	if state != "" {
 		stateInt, err := strconv.Atoi(state)
 		if err != nil {
-			helper.Print(ctx, false, helper.StateParamErr)
-			return
+			return nil, helper.StateParamErr
 		}
 
 		if stateInt < model.WithdrawSuccess && stateInt > model.WithdrawAbnormal {
-			helper.Print(ctx, false, helper.StateParamErr)
-			return
+			return nil, helper.StateParamErr
 		}
 
 		ex["state"] = state
@@ -559,8 +688,7 @@ func (that *WithdrawController) HistoryList(ctx *fasthttp.RequestCtx) {
 
 	if id != "" {
 		if !validator.CheckStringDigit(id) {
-			helper.Print(ctx, false, helper.IDErr)
-			return
+			return nil, helper.IDErr
 		}
 
 		ex = map[string]interface{}{
@@ -569,19 +697,7 @@ func (that *WithdrawController) HistoryList(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
-	data, err := model.WithdrawHistoryList(ex, ty, startTime, endTime, uint(page), uint(pageSize))
-	if err != nil {
-		helper.Print(ctx, false, err.Error())
-		return
-	}
-
-	result, err := model.WithdrawDealListData(data)
-	if err != nil {
-		helper.Print(ctx, false, err.Error())
-		return
-	}
-
-	helper.Print(ctx, true, result)
+	return ex, ""
 }
 
 // MemberWithdrawList 会员列表-提款信息
@@ -649,6 +765,11 @@ func (that *WithdrawController) MemberWithdrawList(ctx *fasthttp.RequestCtx) {
 		ex["state"] = state
 	}
 
+	if !withdrawWalletEx(ctx, ex) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	tyUint, err := strconv.ParseUint(ty, 10, 8)
 	if err != nil {
 		helper.Print(ctx, false, helper.TimeTypeErr)
@@ -743,6 +864,11 @@ func (that *WithdrawController) FinanceReviewList(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
+	if !withdrawWalletEx(ctx, ex) {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
 	ex["state"] = []int{
 		model.WithdrawDealing,
 		model.WithdrawAutoPayFailed,
@@ -808,11 +934,9 @@ func (that *WithdrawController) ReviewReject(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	/*
-		logMsg := fmt.Sprintf("拒绝【订单号:%s；会员账号:%s；订单金额:%.4f；申请时间:%s；完成时间:%s】",
-			withdraw.ID, withdraw.Username, withdraw.Amount, model.TimeFormat(withdraw.CreatedAt), model.TimeFormat(ctx.Time().Unix()))
-		defer model.SystemLogWrite(logMsg, ctx)
-	*/
+	logMsg := fmt.Sprintf("拒绝【订单号:%s；会员账号:%s；订单金额:%.4f；申请时间:%s；完成时间:%s】",
+		withdraw.ID, withdraw.Username, withdraw.Amount, model.TimeFormat(withdraw.CreatedAt), model.TimeFormat(ctx.Time().Unix()))
+	defer model.SystemLogWrite(logMsg, ctx)
 
 	record := g.Record{
 		"review_remark":   param.Remark,
@@ -903,65 +1027,49 @@ func (that *WithdrawController) Review(ctx *fasthttp.RequestCtx) {
 		//	return
 		//}
 
-		/*
-			logMsg := fmt.Sprintf("人工出款【订单号:%s；会员账号:%s；订单金额:%.4f；申请时间:%s；完成时间:%s】",
-				withdraw.ID, withdraw.Username, withdraw.Amount, model.TimeFormat(withdraw.CreatedAt), model.TimeFormat(ctx.Time().Unix()))
-			defer model.SystemLogWrite(logMsg, ctx)
-		*/
-
-		record["pid"] = "0"
-		//record["bid"] = param.BankId
-		record["automatic"] = "0"
-		record["state"] = model.WithdrawSuccess
-		record["bank_name"] = param.BankName
-		record["card_no"] = param.CardNo
-		record["real_name"] = param.RealName
-		if param.BankId != "" && param.BankId != "undefined" {
-			bk, err := model.BankCardByCol(param.CardNo)
-			if err != nil {
-				helper.Print(ctx, false, err.Error())
-				return
-			}
-
-			//提款超过当日最大提款限额
-			fishAmount, _ := decimal.NewFromString(bk.DailyFinishAmount)
-			maxAmount, _ := decimal.NewFromString(bk.DailyMaxAmount)
-			totalAmount, _ := decimal.NewFromString(bk.TotalFinishAmount)
-			totalMaxAmount, _ := decimal.NewFromString(bk.TotalMaxAmount)
+		// 超过复核阈值的人工出款需要另一个管理员复核后才出款
+		payload := map[string]string{
+			"remark":        param.Remark,
+			"withdraw_uid":  admin["id"],
+			"withdraw_name": admin["name"],
+			"bank_name":     param.BankName,
+			"card_no":       param.CardNo,
+			"real_name":     param.RealName,
+			"bank_id":       param.BankId,
+		}
+		after := map[string]string{
+			"state":     strconv.Itoa(model.WithdrawSuccess),
+			"bank_name": param.BankName,
+			"card_no":   param.CardNo,
+			"real_name": param.RealName,
+		}
+		before := map[string]string{
+			"state": strconv.Itoa(withdraw.State),
+		}
+		approvalID, err := model.ApprovalSubmit(model.ApprovalWithdrawManual, withdraw.ID, decimal.NewFromFloat(withdraw.Amount),
+			before, after, payload, param.Remark, admin)
+		if err != nil {
+			helper.Print(ctx, false, err.Error())
+			return
+		}
 
-			if fishAmount.Cmp(maxAmount) >= 0 || fishAmount.Add(decimal.NewFromFloat(withdraw.Amount)).GreaterThan(maxAmount) ||
-				totalAmount.Add(decimal.NewFromFloat(withdraw.Amount)).GreaterThan(totalMaxAmount) {
-				helper.Print(ctx, false, helper.DailyAmountLimitErr)
-				return
-			}
-			err = model.WithdrawHandSuccess(param.ID, withdraw.UID, withdraw.BID, record)
-			if err != nil {
-				helper.Print(ctx, false, err.Error())
-				return
-			}
-			record := g.Record{
-				"daily_finish_amount": fishAmount.Add(decimal.NewFromFloat(withdraw.Amount)).StringFixed(4),
-				"total_finish_amount": totalAmount.Add(decimal.NewFromFloat(withdraw.Amount)).StringFixed(4),
-			}
-			if fishAmount.Add(decimal.NewFromFloat(withdraw.Amount)).Cmp(maxAmount) >= 0 {
-				record["state"] = 0
-			}
-			if totalAmount.Add(decimal.NewFromFloat(withdraw.Amount)).Cmp(totalMaxAmount) >= 0 {
-				record["state"] = 0
-			}
-			model.BankCardUpdate(bk.Id, record)
-		} else {
-			err = model.WithdrawHandSuccess(param.ID, withdraw.UID, withdraw.BID, record)
-			if err != nil {
-				helper.Print(ctx, false, err.Error())
-				return
-			}
+		if approvalID != "" {
+			approvalPending(ctx, approvalID)
+			return
 		}
 
+		logMsg := fmt.Sprintf("人工出款【订单号:%s；会员账号:%s；订单金额:%.4f；申请时间:%s；完成时间:%s】",
+			withdraw.ID, withdraw.Username, withdraw.Amount, model.TimeFormat(withdraw.CreatedAt), model.TimeFormat(ctx.Time().Unix()))
+		defer model.SystemLogWrite(logMsg, ctx)
+
+		err = model.WithdrawManualPay(withdraw, record, param.BankName, param.CardNo, param.RealName, param.BankId)
+		if err != nil {
+			helper.Print(ctx, false, err.Error())
+			return
+		}
 	}
 
 	helper.Print(ctx, true, helper.Success)
-
 }
 
 // AutomaticFailed 代付失败
@@ -1198,14 +1306,28 @@ func (that *WithdrawController) HangUp(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	reason, err := model.HangUpReasonFind(param.RemarkID)
+	if err != nil || reason.State != 1 {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	now := ctx.Time().Unix()
+	resumeAt, err := model.HangUpResumeAt(reason, param.ResumeAt, now)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
 	record := g.Record{
 		"hang_up_uid":    admin["id"],
 		"hang_up_remark": param.HangUpRemark,
 		"hang_up_name":   admin["name"],
 		"remark_id":      param.RemarkID,
 		"state":          model.WithdrawHangup,
-		"hang_up_at":     ctx.Time().Unix(),
+		"hang_up_at":     now,
 		"receive_at":     "0",
+		"resume_at":      resumeAt,
 	}
 
 	err = model.WithdrawUpdateInfo(param.ID, record)
@@ -1215,6 +1337,7 @@ func (that *WithdrawController) HangUp(ctx *fasthttp.RequestCtx) {
 	}
 
 	_ = model.SetRisksOrder(withdraw.ConfirmUID, param.ID, -1)
+	model.WithdrawHangUpLog(param.ID, param.RemarkID, admin["id"], admin["name"], param.HangUpRemark, resumeAt, now)
 
 	helper.Print(ctx, true, helper.Success)
 }
@@ -1310,6 +1433,7 @@ func (that *WithdrawController) ConfirmName(ctx *fasthttp.RequestCtx) {
 		"confirm_name": admin["name"],
 		"state":        model.WithdrawDispatched,
 		"receive_at":   ctx.Time().Unix(),
+		"resume_at":    0,
 	}
 	err = model.WithdrawUpdateInfo(id, record)
 	if err != nil {
@@ -1355,3 +1479,45 @@ func (that *WithdrawController) BankCardWithdrawRecord(ctx *fasthttp.RequestCtx)
 
 	helper.Print(ctx, true, data)
 }
+
+type withdrawSlaParam struct {
+	State   int   `rule:"digit" min:"371" max:"379" msg:"state error" name:"state"`
+	Level   int   `rule:"digit" default:"0" min:"0" max:"11" msg:"level error" name:"level"`         // 0 全部会员等级
+	Seconds int64 `rule:"digit" default:"0" min:"0" max:"604800" msg:"seconds error" name:"seconds"` // 0 删除配置
+}
+
+// SlaList 风控管理-提款超时配置-列表
+func (that *WithdrawController) SlaList(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.WithdrawSlaList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// SlaUpdate 风控管理-提款超时配置-修改
+func (that *WithdrawController) SlaUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := withdrawSlaParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !model.WithdrawSlaValidState(param.State) {
+		helper.Print(ctx, false, helper.StateParamErr)
+		return
+	}
+
+	err = model.WithdrawSlaUpdate(param.State, param.Level, param.Seconds)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
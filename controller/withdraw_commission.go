@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	"github.com/shopspring/decimal"
+	"github.com/valyala/fasthttp"
+)
+
+type withdrawCommissionConfParam struct {
+	Fmin       string `rule:"float" default:"0" msg:"fmin error" name:"fmin"`                                 // 单笔最低金额
+	Fmax       string `rule:"float" default:"0" msg:"fmax error" name:"fmax"`                                 // 单笔最高金额, 0为不限制
+	DailyTimes int    `rule:"digit" default:"0" min:"0" max:"100" msg:"daily_times error" name:"daily_times"` // 每日提款次数, 0为不限制
+	DailyMax   string `rule:"float" default:"0" msg:"daily_max error" name:"daily_max"`                       // 每日提款总额, 0为不限制
+	Dispatch   int    `rule:"digit" default:"0" min:"0" max:"1" msg:"dispatch error" name:"dispatch"`         // 1 按风控派单规则派单 0 进入待领取列表
+}
+
+// Commission 代理申请佣金钱包提款
+func (that *WithdrawController) Commission(ctx *fasthttp.RequestCtx) {
+
+	bid := string(ctx.PostArgs().Peek("bid"))
+	amount := string(ctx.PostArgs().Peek("amount"))
+	if !validator.CheckStringDigit(bid) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	id, err := model.WithdrawCommissionInsert(amount, bid, ctx)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, id)
+}
+
+// CommissionConf 风控管理-佣金钱包提款配置
+func (that *WithdrawController) CommissionConf(ctx *fasthttp.RequestCtx) {
+
+	conf, err := model.CommissionWithdrawConfGet()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, conf)
+}
+
+// CommissionConfUpdate 风控管理-修改佣金钱包提款配置
+func (that *WithdrawController) CommissionConfUpdate(ctx *fasthttp.RequestCtx) {
+
+	param := withdrawCommissionConfParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	fmin, _ := decimal.NewFromString(param.Fmin)
+	fmax, _ := decimal.NewFromString(param.Fmax)
+	if fmax.GreaterThan(decimal.Zero) && fmax.LessThan(fmin) {
+		helper.Print(ctx, false, helper.AmountErr)
+		return
+	}
+
+	conf := model.CommissionWithdrawConf{
+		Fmin:       param.Fmin,
+		Fmax:       param.Fmax,
+		DailyTimes: param.DailyTimes,
+		DailyMax:   param.DailyMax,
+		Dispatch:   param.Dispatch,
+	}
+	err = model.CommissionWithdrawConfSet(conf)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+
+	"finance/contrib/helper"
+	"finance/contrib/validator"
+	"finance/model"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/valyala/fasthttp"
+)
+
+type WithdrawRuleController struct{}
+
+type withdrawRuleParam struct {
+	ID           string `rule:"none" name:"id"`
+	Name         string `rule:"none" name:"name"`
+	Sort         int    `rule:"digit" default:"0" min:"0" max:"9999" msg:"sort error" name:"sort"`
+	Action       int    `rule:"digit" min:"1" max:"3" msg:"action error" name:"action"` // 1 自动通过 2 自动挂起 3 人工审核
+	Conditions   string `rule:"none" name:"conditions"`                                 // 条件列表json
+	RemarkID     string `rule:"none" name:"remark_id"`                                  // 挂起原因ID
+	HangUpRemark string `rule:"none" name:"hang_up_remark"`                             // 挂起备注
+}
+
+type withdrawRuleStateParam struct {
+	ID    string `rule:"digit" msg:"id error" name:"id"`
+	State int    `rule:"digit" min:"0" max:"1" msg:"state error" name:"state"` // 0 关闭 1 开启
+}
+
+type withdrawRuleDryRunParam struct {
+	IDs       string `rule:"none" name:"ids"` // 规则id, 多个逗号分开, 为空时使用所有开启的规则
+	StartTime string `rule:"none" msg:"start_time error" name:"start_time"`
+	EndTime   string `rule:"none" msg:"end_time error" name:"end_time"`
+	Page      uint16 `rule:"digit" default:"1" min:"1" msg:"page error" name:"page"`
+	PageSize  uint16 `rule:"digit" default:"10" min:"10" max:"200" msg:"page_size error" name:"page_size"`
+}
+
+// List 风控管理-自动审核规则-列表
+func (that *WithdrawRuleController) List(ctx *fasthttp.RequestCtx) {
+
+	data, err := model.WithdrawRuleList()
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+// Insert 风控管理-自动审核规则-新增
+func (that *WithdrawRuleController) Insert(ctx *fasthttp.RequestCtx) {
+
+	param := withdrawRuleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	record, ok := withdrawRuleRecord(param)
+	if !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record["id"] = helper.GenId()
+	record["state"] = 0
+	record["updated_at"] = ctx.Time().Unix()
+	record["updated_uid"] = admin["id"]
+	record["updated_name"] = admin["name"]
+	err = model.WithdrawRuleInsert(record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// Update 风控管理-自动审核规则-修改
+func (that *WithdrawRuleController) Update(ctx *fasthttp.RequestCtx) {
+
+	param := withdrawRuleParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if !validator.CtypeDigit(param.ID) {
+		helper.Print(ctx, false, helper.IDErr)
+		return
+	}
+
+	record, ok := withdrawRuleRecord(param)
+	if !ok {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record["updated_at"] = ctx.Time().Unix()
+	record["updated_uid"] = admin["id"]
+	record["updated_name"] = admin["name"]
+	err = model.WithdrawRuleUpdate(param.ID, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// UpdateState 风控管理-自动审核规则-启用/停用
+func (that *WithdrawRuleController) UpdateState(ctx *fasthttp.RequestCtx) {
+
+	param := withdrawRuleStateParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	admin, err := model.AdminToken(ctx)
+	if err != nil || len(admin["id"]) < 1 {
+		helper.Print(ctx, false, helper.AccessTokenExpires)
+		return
+	}
+
+	record := g.Record{
+		"state":        param.State,
+		"updated_at":   ctx.Time().Unix(),
+		"updated_uid":  admin["id"],
+		"updated_name": admin["name"],
+	}
+	err = model.WithdrawRuleUpdate(param.ID, record)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, helper.Success)
+}
+
+// DryRun 风控管理-自动审核规则-试运行, 用规则计算历史订单的审核结果
+func (that *WithdrawRuleController) DryRun(ctx *fasthttp.RequestCtx) {
+
+	param := withdrawRuleDryRunParam{}
+	err := validator.Bind(ctx, &param)
+	if err != nil {
+		helper.Print(ctx, false, helper.ParamErr)
+		return
+	}
+
+	if param.StartTime == "" || param.EndTime == "" {
+		helper.Print(ctx, false, helper.DateTimeErr)
+		return
+	}
+
+	var ids []string
+	if param.IDs != "" {
+		for _, v := range strings.Split(param.IDs, ",") {
+			if !validator.CtypeDigit(v) {
+				helper.Print(ctx, false, helper.IDErr)
+				return
+			}
+
+			ids = append(ids, v)
+		}
+	}
+
+	data, err := model.WithdrawRuleDryRun(ids, param.StartTime, param.EndTime, param.Page, param.PageSize)
+	if err != nil {
+		helper.Print(ctx, false, err.Error())
+		return
+	}
+
+	helper.Print(ctx, true, data)
+}
+
+func withdrawRuleRecord(param withdrawRuleParam) (g.Record, bool) {
+
+	if param.Name == "" || !validator.CheckStringLength(param.Name, 1, 50) {
+		return nil, false
+	}
+
+	if !model.WithdrawRuleCheckConditions(param.Conditions) {
+		return nil, false
+	}
+
+	record := g.Record{
+		"name":           validator.FilterInjection(param.Name),
+		"sort":           param.Sort,
+		"action":         param.Action,
+		"conditions":     param.Conditions,
+		"remark_id":      "0",
+		"hang_up_remark": "",
+	}
+
+	// 自动挂起需要挂起原因
+	if param.Action == model.WithdrawRuleHangUp {
+		if !validator.CtypeDigit(param.RemarkID) || !validator.CheckStringLength(param.HangUpRemark, 1, 100) {
+			return nil, false
+		}
+
+		id, _ := strconv.ParseInt(param.RemarkID, 10, 64)
+		record["remark_id"] = id
+		record["hang_up_remark"] = validator.FilterInjection(param.HangUpRemark)
+	}
+
+	return record, true
+}
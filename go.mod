@@ -38,6 +38,7 @@ require (
 	github.com/pelletier/go-toml v1.9.4
 	github.com/shopspring/decimal v1.2.0
 	github.com/silenceper/pool v1.0.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spaolacci/murmur3 v1.1.0
 	github.com/tinylib/msgp v1.1.5
 	github.com/valyala/fasthttp v1.36.0
@@ -85,6 +85,19 @@ func main() {
 	mt.Fcallback = cfg.Fcallback
 	mt.IndexUrl = cfg.IndexUrl
 	mt.IsDev = cfg.IsDev
+	mt.ExportPath = cfg.ExportPath
+	mt.Minio = model.MinioConf{
+		Endpoint:        cfg.Minio.Endpoint,
+		AccessKeyID:     cfg.Minio.AccessKeyID,
+		SecretAccessKey: cfg.Minio.SecretAccessKey,
+		UseSSL:          cfg.Minio.UseSSL,
+		ImagesBucket:    cfg.Minio.ImagesBucket,
+		ExportBucket:    cfg.Minio.ExportBucket,
+	}
+	mt.Telegram = model.TelegramConf{
+		Token:  cfg.Telegram.Token,
+		ChatID: cfg.Telegram.ChatID,
+	}
 
 	mt.Finance = content
 	model.Constructor(mt, os.Args[3], cfg.Rpc)
@@ -105,7 +118,6 @@ func main() {
 			model.Create(level)
 		}
 
-		model.TransacCodeCreate()
 		model.ChannelTypeCreateCache()
 		model.BankCardUpdateCache()
 		return
@@ -123,6 +135,21 @@ func main() {
 		BuildTime:      buildTime,
 		BuildGoVersion: buildGoVersion,
 	}
+	// 已派单超时未处理的订单自动改派
+	go model.RisksReassignTask()
+	// 提款超时告警
+	go model.WithdrawSlaTask()
+	// 挂起到期的订单自动恢复
+	go model.WithdrawResumeTask()
+	// 会员限制到期自动解除
+	go model.MemberLockExpireTask()
+	// 按规则定时调整会员信用等级
+	go model.CreditLevelTask()
+	// 线下转卡收款卡释放超时占用额度和零点清零
+	go model.BankCardTask()
+	// 线下转卡释放已结束订单占用的附言码和金额
+	go model.ManualReserveTask()
+
 	app := router.SetupRouter(b)
 	srv := &fasthttp.Server{
 		Handler:            middleware.Use(app.Handler),
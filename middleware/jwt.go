@@ -28,6 +28,7 @@ var allows = map[string]bool{
 	"/finance/callback/fw":        true,
 	"/finance/callback/fd":        true,
 	"/finance/callback/fconfirm":  true,
+	"/finance/callback/banksms":   true,
 	"/finance/version":            true,
 	"/finance/pprof/":             true,
 	"/finance/pprof/block":        true,
@@ -57,6 +58,9 @@ var allows = map[string]bool{
 	"/finance/callback/vnw":       true,
 	"/finance/callback/dbd":       true,
 	"/finance/callback/dbw":       true,
+
+	// 转账截图的临时地址, 由token校验
+	"/merchant/finance/receipt/view": true,
 }
 
 // 哪些路由不用动态密码验证
@@ -79,6 +83,13 @@ var otpIgnore = map[string]bool{
 	"/merchant/finance/withdraw/historylist": true,
 	"/merchant/finance/withdraw/hanguplist":  true,
 	"/merchant/finance/credit/list":          true,
+	"/merchant/finance/export/list":          true,
+	"/merchant/finance/export/download":      true,
+	"/merchant/finance/audit/list":           true,
+	"/merchant/finance/approval/rule/list":   true,
+	"/merchant/finance/approval/list":        true,
+	"/merchant/finance/withdraw/rule/list":   true,
+	"/merchant/finance/withdraw/rule/dryrun": true,
 
 	"/merchant/finance/risks/receives":       true,
 	"/merchant/finance/risks/state":          true,
@@ -86,9 +97,37 @@ var otpIgnore = map[string]bool{
 	"/merchant/finance/withdraw/riskhistory": true,
 	"/merchant/finance/risks/number":         true,
 	"/merchant/finance/risks/list":           true,
+	"/merchant/finance/risks/dispatch/conf":  true,
+	"/merchant/finance/risks/dispatch/log":   true,
+	"/merchant/finance/withdraw/sla/list":    true,
 	"/merchant/finance/withdraw/waitreceive": true,
 	"/merchant/finance/withdraw/receive":     true,
 	"/merchant/finance/withdraw/cardrecord":  true,
+
+	"/merchant/finance/withdraw/hangup/reason/list": true,
+	"/merchant/finance/withdraw/hangup/report":      true,
+
+	"/merchant/finance/deposit/policy/list": true,
+	"/merchant/finance/deposit/throttle":    true,
+	"/merchant/finance/velocity/list":       true,
+	"/merchant/finance/velocity/quota":      true,
+	"/merchant/finance/memberlock/history":  true,
+	"/merchant/finance/membercredit/log":    true,
+	"/merchant/finance/credit/rule/list":    true,
+	"/merchant/finance/maintenance/list":    true,
+	"/merchant/finance/banktxn/list":        true,
+	"/merchant/finance/manual/code/conf":    true,
+	"/merchant/finance/promo/rule/list":     true,
+	"/merchant/finance/promo/conf":          true,
+	"/merchant/finance/promo/report":        true,
+	"/merchant/finance/promo/turnover/list": true,
+
+	"/merchant/finance/withdraw/commission/conf": true,
+	"/merchant/finance/deposit/reversal/list":    true,
+	"/merchant/finance/deposit/reversal/conf":    true,
+
+	"/merchant/finance/deposit/mismatch/conf/list": true,
+	"/merchant/finance/deposit/mismatch/list":      true,
 }
 
 func CheckTokenMiddleware(ctx *fasthttp.RequestCtx) error {
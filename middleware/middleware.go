@@ -3,6 +3,8 @@ package middleware
 import (
 	"fmt"
 	"github.com/valyala/fasthttp"
+
+	"finance/model"
 )
 
 
@@ -26,6 +28,9 @@ func Use(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 			}
 		}
 
+		// 后台修改类接口记录操作日志
+		done := model.AuditBegin(ctx)
 		next(ctx)
+		done()
 	})
 }
\ No newline at end of file
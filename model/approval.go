@@ -0,0 +1,530 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/shopspring/decimal"
+)
+
+// 需要复核的操作类型
+const (
+	ApprovalVipLimit       = "vip_limit"       // 会员等级通道存款限额
+	ApprovalBankcard       = "bankcard"        // 线下转卡银行卡
+	ApprovalUsdtRate       = "usdt_rate"       // USDT汇率
+	ApprovalWithdrawManual = "withdraw_manual" // 人工出款
+)
+
+// 复核单状态
+const (
+	ApprovalPending  = 0 // 待复核
+	ApprovalPassed   = 1 // 已通过
+	ApprovalRejected = 2 // 已拒绝
+	ApprovalExpired  = 3 // 已过期
+)
+
+// 复核单默认有效期
+const approvalDefaultExpire = 24 * 3600
+
+var approvalTitles = map[string]string{
+	ApprovalVipLimit:       "会员等级通道限额修改",
+	ApprovalBankcard:       "线下转卡银行卡修改",
+	ApprovalUsdtRate:       "USDT汇率修改",
+	ApprovalWithdrawManual: "人工出款",
+}
+
+// ApprovalRule 复核规则, 金额大于等于阈值时需要复核, 阈值为0时全部需要复核
+type ApprovalRule struct {
+	ID          string `db:"id" json:"id"`
+	Ty          string `db:"ty" json:"ty"`               // 操作类型
+	Threshold   string `db:"threshold" json:"threshold"` // 阈值
+	Expire      int64  `db:"expire" json:"expire"`       // 复核单有效期(秒)
+	State       int    `db:"state" json:"state"`         // 0 关闭 1 开启
+	UpdatedAt   int64  `db:"updated_at" json:"updated_at"`
+	UpdatedUID  string `db:"updated_uid" json:"updated_uid"`
+	UpdatedName string `db:"updated_name" json:"updated_name"`
+	Prefix      string `db:"prefix" json:"prefix"`
+}
+
+// Approval 复核单, 通过后按 payload 执行修改
+type Approval struct {
+	ID           string `db:"id" json:"id"`
+	Ty           string `db:"ty" json:"ty"`
+	EntityID     string `db:"entity_id" json:"entity_id"` // 修改对象id
+	Amount       string `db:"amount" json:"amount"`       // 和阈值比较的金额
+	Payload      string `db:"payload" json:"-"`           // 通过后执行修改用到的参数
+	Diff         string `db:"diff" json:"diff"`           // 修改前后变化的字段
+	Reason       string `db:"reason" json:"reason"`       // 申请原因
+	State        int    `db:"state" json:"state"`
+	CreatedAt    int64  `db:"created_at" json:"created_at"`
+	CreatedUID   string `db:"created_uid" json:"created_uid"`
+	CreatedName  string `db:"created_name" json:"created_name"`
+	ReviewAt     int64  `db:"review_at" json:"review_at"`
+	ReviewUID    string `db:"review_uid" json:"review_uid"`
+	ReviewName   string `db:"review_name" json:"review_name"`
+	ReviewRemark string `db:"review_remark" json:"review_remark"` // 通过/拒绝原因
+	ExpireAt     int64  `db:"expire_at" json:"expire_at"`
+	Prefix       string `db:"prefix" json:"prefix"`
+}
+
+type ApprovalData struct {
+	D []Approval `json:"d"`
+	T int64      `json:"t"`
+	S uint16     `json:"s"`
+}
+
+// ApprovalValidTy 操作类型是否支持复核
+func ApprovalValidTy(ty string) bool {
+
+	_, ok := approvalTitles[ty]
+	return ok
+}
+
+func ApprovalRuleList() ([]ApprovalRule, error) {
+
+	var data []ApprovalRule
+	query, _, _ := dialect.From("f_approval_rule").Select(colApprovalRule...).Where(g.Ex{"prefix": meta.Prefix}).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// ApprovalRuleUpdate 每种操作类型只有一条规则, 不存在时新增
+func ApprovalRuleUpdate(ty, threshold string, expire int64, state int, admin map[string]string) error {
+
+	record := g.Record{
+		"threshold":    threshold,
+		"expire":       expire,
+		"state":        state,
+		"updated_at":   time.Now().Unix(),
+		"updated_uid":  admin["id"],
+		"updated_name": admin["name"],
+	}
+
+	rule, err := approvalRuleFind(ty)
+	if err != nil {
+		return err
+	}
+
+	query := ""
+	if rule.ID == "" {
+		record["id"] = helper.GenId()
+		record["ty"] = ty
+		record["prefix"] = meta.Prefix
+		query, _, _ = dialect.Insert("f_approval_rule").Rows(record).ToSQL()
+	} else {
+		query, _, _ = dialect.Update("f_approval_rule").Set(record).Where(g.Ex{"id": rule.ID}).ToSQL()
+	}
+
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+// ApprovalSubmit 检查操作是否需要复核, 需要时生成复核单并通知审核人, 返回复核单号
+// 返回空单号时表示不需要复核, 调用方直接执行修改
+func ApprovalSubmit(ty, entityID string, amount decimal.Decimal, before, after, payload map[string]string, reason string, admin map[string]string) (string, error) {
+
+	rule, err := approvalRuleFind(ty)
+	if err != nil {
+		return "", err
+	}
+
+	if rule.ID == "" || rule.State != 1 {
+		return "", nil
+	}
+
+	threshold, _ := decimal.NewFromString(rule.Threshold)
+	if amount.Abs().LessThan(threshold) {
+		return "", nil
+	}
+
+	// 同一对象同时只能有一个待复核的修改, 检查和写入在同一个锁内, 防止并发提交都通过检查
+	lk := fmt.Sprintf("approval:%s:%s", ty, entityID)
+	err = Lock(lk)
+	if err != nil {
+		return "", err
+	}
+	defer Unlock(lk)
+
+	var n int
+	ts := time.Now().Unix()
+	ex := g.Ex{
+		"ty":        ty,
+		"entity_id": entityID,
+		"state":     ApprovalPending,
+		"expire_at": g.Op{"gt": ts},
+		"prefix":    meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_approval").Select(g.COUNT(1)).Where(ex).ToSQL()
+	err = meta.MerchantDB.Get(&n, query)
+	if err != nil {
+		return "", pushLog(err, helper.DBErr)
+	}
+
+	if n > 0 {
+		return "", errors.New(helper.OrderProcess)
+	}
+
+	expire := rule.Expire
+	if expire <= 0 {
+		expire = approvalDefaultExpire
+	}
+
+	b, err := helper.JsonMarshal(payload)
+	if err != nil {
+		return "", errors.New(helper.FormatErr)
+	}
+
+	data := Approval{
+		ID:          helper.GenId(),
+		Ty:          ty,
+		EntityID:    entityID,
+		Amount:      amount.StringFixed(4),
+		Payload:     string(b),
+		Diff:        approvalDiff(before, after),
+		Reason:      reason,
+		State:       ApprovalPending,
+		CreatedAt:   ts,
+		CreatedUID:  admin["id"],
+		CreatedName: admin["name"],
+		ExpireAt:    ts + expire,
+		Prefix:      meta.Prefix,
+	}
+	query, _, _ = dialect.Insert("f_approval").Rows(data).ToSQL()
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		return "", pushLog(err, helper.DBErr)
+	}
+
+	_ = PushMerchantNotify(approvalReviewFmt, admin["name"], approvalTitles[ty], data.Amount)
+
+	return data.ID, nil
+}
+
+func ApprovalList(ty string, state int, startTime, endTime string, page, pageSize uint16) (ApprovalData, error) {
+
+	data := ApprovalData{}
+
+	// 过期的待复核单先标记为已过期
+	ts := time.Now().Unix()
+	ex := g.Ex{
+		"state":     ApprovalPending,
+		"expire_at": g.Op{"lte": ts},
+		"prefix":    meta.Prefix,
+	}
+	query, _, _ := dialect.Update("f_approval").Set(g.Record{"state": ApprovalExpired}).Where(ex).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	ex = g.Ex{"prefix": meta.Prefix}
+	if ty != "" {
+		ex["ty"] = ty
+	}
+	if state >= 0 {
+		ex["state"] = state
+	}
+
+	if startTime != "" && endTime != "" {
+
+		startAt, err := helper.TimeToLoc(startTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		endAt, err := helper.TimeToLoc(endTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		if startAt >= endAt {
+			return data, errors.New(helper.QueryTimeRangeErr)
+		}
+
+		ex["created_at"] = g.Op{"between": exp.NewRangeVal(startAt, endAt)}
+	}
+
+	if page == 1 {
+		query, _, _ = dialect.From("f_approval").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err = meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ = dialect.From("f_approval").Select(colApproval...).Where(ex).
+		Order(g.C("created_at").Desc()).Offset(uint(offset)).Limit(uint(pageSize)).ToSQL()
+	err = meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+// ApprovalReview 复核, 发起人不能复核自己的申请, 通过时执行修改, 执行失败复核单保持待复核
+func ApprovalReview(id string, pass bool, remark string, admin map[string]string) error {
+
+	data := Approval{}
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_approval").Select(colApproval...).Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&data, query)
+	if err != nil && err != sql.ErrNoRows {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if err == sql.ErrNoRows {
+		return errors.New(helper.RecordNotExistErr)
+	}
+
+	if data.State != ApprovalPending {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	if data.CreatedUID == admin["id"] {
+		return errors.New(helper.MethodNoPermission)
+	}
+
+	ts := time.Now().Unix()
+	if data.ExpireAt <= ts {
+		query, _, _ = dialect.Update("f_approval").Set(g.Record{"state": ApprovalExpired}).Where(ex).ToSQL()
+		_, _ = meta.MerchantDB.Exec(query)
+		return errors.New(helper.OrderStateErr)
+	}
+
+	state := ApprovalRejected
+	if pass {
+		state = ApprovalPassed
+	}
+
+	// 先按待复核状态抢占记录, 防止两个复核人同时通过重复执行
+	record := g.Record{
+		"state":         state,
+		"review_at":     ts,
+		"review_uid":    admin["id"],
+		"review_name":   admin["name"],
+		"review_remark": remark,
+	}
+	ex["state"] = ApprovalPending
+	query, _, _ = dialect.Update("f_approval").Set(record).Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n != 1 {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	if !pass {
+		return nil
+	}
+
+	err = approvalApply(data)
+	if err != nil {
+		// 执行失败时恢复为待复核, 可以重新处理
+		ex["state"] = ApprovalPassed
+		ex["review_uid"] = admin["id"]
+		record = g.Record{
+			"state":         ApprovalPending,
+			"review_at":     0,
+			"review_uid":    "",
+			"review_name":   "",
+			"review_remark": "",
+		}
+		query, _, _ = dialect.Update("f_approval").Set(record).Where(ex).ToSQL()
+		_, e := meta.MerchantDB.Exec(query)
+		if e != nil {
+			_ = pushLog(e, helper.DBErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func approvalRuleFind(ty string) (ApprovalRule, error) {
+
+	rule := ApprovalRule{}
+	ex := g.Ex{
+		"ty":     ty,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_approval_rule").Select(colApprovalRule...).Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&rule, query)
+	if err != nil && err != sql.ErrNoRows {
+		return rule, pushLog(err, helper.DBErr)
+	}
+
+	return rule, nil
+}
+
+// 复核通过后按操作类型执行修改
+func approvalApply(data Approval) error {
+
+	payload := map[string]string{}
+	err := helper.JsonUnmarshal([]byte(data.Payload), &payload)
+	if err != nil {
+		return errors.New(helper.FormatErr)
+	}
+
+	switch data.Ty {
+	case ApprovalVipLimit:
+		fields := map[string]string{
+			"id":   data.EntityID,
+			"fmin": payload["fmin"],
+			"fmax": payload["fmax"],
+		}
+		err = VipUpdate(payload["payment_id"], fields)
+		if err != nil {
+			return err
+		}
+
+		Create(payload["vip"])
+		return nil
+
+	case ApprovalBankcard:
+		return approvalBankcard(data.EntityID, payload)
+
+	case ApprovalUsdtRate:
+		return UsdtUpdate("usdt_rate", payload["value"])
+
+	case ApprovalWithdrawManual:
+		return approvalWithdrawManual(data.EntityID, payload)
+	}
+
+	return errors.New(helper.ParamErr)
+}
+
+func approvalBankcard(id string, payload map[string]string) error {
+
+	switch payload["action"] {
+	case "insert":
+		bc := Bankcard_t{
+			Id:                id,
+			ChannelBankId:     "0",
+			BanklcardName:     payload["banklcard_name"],
+			BanklcardNo:       payload["banklcard_no"],
+			AccountName:       payload["account_name"],
+			State:             "0",
+			Remark:            payload["remark"],
+			DailyMaxAmount:    payload["daily_max_amount"],
+			DailyFinishAmount: "0",
+			TotalMaxAmount:    payload["total_max_amount"],
+			TotalFinishAmount: "0",
+			Flags:             payload["flags"],
+			Weight:            payload["weight"],
+			Levels:            payload["levels"],
+			Bin:               payload["bin"],
+		}
+		// 申请后卡号可能已被添加
+		_, err := BankCardByCol(bc.BanklcardNo)
+		if err == nil {
+			return errors.New(helper.BankCardExistErr)
+		}
+
+		return BankCardInsert(bc, "")
+
+	case "update":
+		record := g.Record{}
+		for _, k := range []string{"state", "remark", "total_max_amount", "daily_max_amount", "total_finish_amount", "weight", "levels", "bin"} {
+			if v, ok := payload[k]; ok {
+				record[k] = v
+			}
+		}
+		return BankCardUpdate(id, record)
+
+	case "delete":
+		return BankCardDelete(id)
+	}
+
+	return errors.New(helper.ParamErr)
+}
+
+func approvalWithdrawManual(id string, payload map[string]string) error {
+
+	err := WithdrawLock(id)
+	if err != nil {
+		return err
+	}
+	defer WithdrawUnLock(id)
+
+	withdraw, err := WithdrawFind(id)
+	if err != nil {
+		return err
+	}
+
+	// 复核期间订单可能已被其他人处理
+	if (withdraw.Automatic == 1 && withdraw.State != WithdrawAutoPayFailed) ||
+		(withdraw.Automatic != 1 && withdraw.State != WithdrawDealing) {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	record := g.Record{
+		"withdraw_remark": payload["remark"],
+		"withdraw_at":     time.Now().Unix(),
+		"withdraw_uid":    payload["withdraw_uid"],
+		"withdraw_name":   payload["withdraw_name"],
+	}
+
+	return WithdrawManualPay(withdraw, record, payload["bank_name"], payload["card_no"], payload["real_name"], payload["bank_id"])
+}
+
+// 修改前后有变化的字段, 和操作日志格式相同
+func approvalDiff(before, after map[string]string) string {
+
+	b := map[string]interface{}{}
+	for k, v := range before {
+		b[k] = v
+	}
+
+	a := map[string]interface{}{}
+	for k, v := range after {
+		a[k] = v
+	}
+
+	return auditDiff(b, a)
+}
+
+// ApprovalAmountChange 修改前后金额变化的幅度, 取变化最大的字段
+func ApprovalAmountChange(before, after map[string]string) decimal.Decimal {
+
+	amount := decimal.Zero
+	for k, v := range after {
+		n, err := decimal.NewFromString(v)
+		if err != nil {
+			continue
+		}
+
+		o, _ := decimal.NewFromString(before[k])
+		if d := n.Sub(o).Abs(); d.GreaterThan(amount) {
+			amount = d
+		}
+	}
+
+	return amount
+}
@@ -0,0 +1,359 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+)
+
+// 操作日志写入td, 和 finance_error 同库
+const auditTable = "finance_audit"
+
+type auditRoute struct {
+	Title  string // 操作名称
+	Entity string // 操作对象, 查询时按此过滤
+	Tbl    string // 对象所在表, 为空时只记录请求参数
+	Param  string // 对象主键的请求参数名
+	Col    string // 对象主键字段
+	Prefix bool   // 查询快照时是否带prefix条件
+}
+
+type AuditData struct {
+	D []systemLog `json:"d"`
+	T int64       `json:"t"`
+	S uint16      `json:"s"`
+}
+
+// 后台修改类接口的操作名称和快照配置, 未配置的后台POST接口也会记录请求参数
+var auditRoutes = map[string]auditRoute{
+	"/merchant/finance/cate/insert":                {Title: "渠道管理-新增", Entity: "category"},
+	"/merchant/finance/cate/update":                {Title: "渠道管理-修改", Entity: "category", Tbl: "f_category", Param: "id", Col: "id"},
+	"/merchant/finance/cate/update/state":          {Title: "渠道管理-启用/停用", Entity: "category", Tbl: "f_category", Param: "id", Col: "id"},
+	"/merchant/finance/channel/insert":             {Title: "通道管理-新增", Entity: "payment"},
+	"/merchant/finance/channel/update":             {Title: "通道管理-修改", Entity: "payment", Tbl: "f_payment", Param: "id", Col: "id"},
+	"/merchant/finance/channel/update/state":       {Title: "通道管理-启用/停用", Entity: "payment", Tbl: "f_payment", Param: "id", Col: "id"},
+	"/merchant/finance/vip/insert":                 {Title: "会员等级通道-新增", Entity: "vip"},
+	"/merchant/finance/vip/update":                 {Title: "会员等级通道-修改", Entity: "vip", Tbl: "f_vip", Param: "id", Col: "id"},
+	"/merchant/finance/vip/delete":                 {Title: "会员等级通道-删除", Entity: "vip", Tbl: "f_vip", Param: "id", Col: "id"},
+	"/merchant/finance/vip/update/state":           {Title: "会员等级通道-启用/停用", Entity: "vip", Tbl: "f_vip", Param: "id", Col: "id"},
+	"/merchant/finance/bank/insert":                {Title: "通道银行管理-新增", Entity: "channel_bank"},
+	"/merchant/finance/bank/update":                {Title: "通道银行管理-修改", Entity: "channel_bank", Tbl: "f_channel_banks", Param: "id", Col: "id"},
+	"/merchant/finance/bank/update/state":          {Title: "通道银行管理-启用/停用", Entity: "channel_bank", Tbl: "f_channel_banks", Param: "id", Col: "id"},
+	"/merchant/finance/credit/insert":              {Title: "会员信用等级-新增", Entity: "credit_level"},
+	"/merchant/finance/credit/update":              {Title: "会员信用等级-修改", Entity: "credit_level", Tbl: "f_credit_level", Param: "id", Col: "id"},
+	"/merchant/finance/credit/update/state":        {Title: "会员信用等级-启用/停用", Entity: "credit_level", Tbl: "f_credit_level", Param: "id", Col: "id"},
+	"/merchant/finance/membercredit/insert":        {Title: "会员信用等级-新增会员", Entity: "member_credit"},
+	"/merchant/finance/membercredit/delete":        {Title: "会员信用等级-删除会员", Entity: "member_credit"},
+	"/merchant/finance/memberlock/insert":          {Title: "会员锁定-新增", Entity: "member_lock"},
+	"/merchant/finance/memberlock/update/state":    {Title: "会员锁定-启用", Entity: "member_lock", Tbl: "f_member_lock", Param: "id", Col: "id"},
+	"/merchant/finance/tunnel/update":              {Title: "通道类型管理-修改", Entity: "channel_type", Tbl: "f_channel_type", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/reject":            {Title: "提款管理-拒绝", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/review":            {Title: "提款管理-人工出款", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/automatic/failed":  {Title: "提款管理-代付失败", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/reviewpass":        {Title: "提款审核-通过", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/reviewreject":      {Title: "提款审核-拒绝", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/hangup":            {Title: "提款审核-挂起", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/receiveupdate":     {Title: "提款审核-修改领取人", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/receive":           {Title: "提款审核-领取", Entity: "withdraw", Tbl: "tbl_withdraw", Param: "id", Col: "id"},
+	"/merchant/finance/deposit/manual":             {Title: "存款管理-存款补单", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+	"/merchant/finance/deposit/review":             {Title: "存款管理-补单审核", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+	"/merchant/finance/deposit/reduce":             {Title: "手动下分", Entity: "member_adjust"},
+	"/merchant/finance/manual/confirm":             {Title: "线下转卡-确认金额", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+	"/merchant/finance/manual/review":              {Title: "线下转卡-审核", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+	"/merchant/finance/deposit/usdt/reviewing":     {Title: "线下USDT-确认金额", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+	"/merchant/finance/deposit/usdt/review":        {Title: "线下USDT-审核", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+	"/merchant/finance/bankcard/insert":            {Title: "线下转卡-添加银行卡", Entity: "bankcard"},
+	"/merchant/finance/bankcard/update":            {Title: "线下转卡-更新银行卡", Entity: "bankcard", Tbl: "f_bankcards", Param: "id", Col: "id"},
+	"/merchant/finance/bankcard/reset":             {Title: "线下转卡-重置银行卡", Entity: "bankcard", Tbl: "f_bankcards", Param: "id", Col: "id"},
+	"/merchant/finance/bankcard/delete":            {Title: "线下转卡-删除银行卡", Entity: "bankcard", Tbl: "f_bankcards", Param: "id", Col: "id"},
+	"/merchant/finance/usdt/update":                {Title: "USDT修改配置", Entity: "config", Tbl: "f_config", Param: "field", Col: "name", Prefix: true},
+	"/merchant/finance/risks/close":                {Title: "风控配置-关闭自动派单", Entity: "risks"},
+	"/merchant/finance/risks/open":                 {Title: "风控配置-开启自动派单", Entity: "risks"},
+	"/merchant/finance/risks/setnumer":             {Title: "风控配置-设置接单数量", Entity: "risks"},
+	"/merchant/finance/risks/setregmax":            {Title: "风控配置-设置同设备号注册数量", Entity: "risks"},
+	"/merchant/finance/risks/dispatch/conf/update": {Title: "风控配置-修改派单配置", Entity: "risks"},
+	"/merchant/finance/risks/reviewer/update":      {Title: "风控配置-设置风控人员排班", Entity: "risks"},
+	"/merchant/finance/withdraw/sla/update":        {Title: "风控配置-提款超时配置", Entity: "config"},
+	"/merchant/finance/reconcile/mapping/insert":   {Title: "对账管理-账单映射-新增", Entity: "reconcile"},
+	"/merchant/finance/reconcile/mapping/update":   {Title: "对账管理-账单映射-修改", Entity: "reconcile", Tbl: "f_reconcile_mapping", Param: "id", Col: "id"},
+	"/merchant/finance/reconcile/import":           {Title: "对账管理-导入三方账单", Entity: "reconcile"},
+	"/merchant/finance/reconcile/item/repair":      {Title: "对账管理-一键补单", Entity: "reconcile", Tbl: "f_reconcile_item", Param: "id", Col: "id"},
+	"/merchant/finance/reconcile/item/ignore":      {Title: "对账管理-标记已处理", Entity: "reconcile", Tbl: "f_reconcile_item", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/rule/insert":       {Title: "自动审核规则-新增", Entity: "withdraw_rule"},
+	"/merchant/finance/withdraw/rule/update":       {Title: "自动审核规则-修改", Entity: "withdraw_rule", Tbl: "f_withdraw_rule", Param: "id", Col: "id"},
+	"/merchant/finance/withdraw/rule/update/state": {Title: "自动审核规则-启用/停用", Entity: "withdraw_rule", Tbl: "f_withdraw_rule", Param: "id", Col: "id"},
+	"/merchant/finance/approval/rule/update":       {Title: "操作复核-修改复核规则", Entity: "approval"},
+	"/merchant/finance/approval/review":            {Title: "操作复核-通过/拒绝", Entity: "approval", Tbl: "f_approval", Param: "id", Col: "id"},
+
+	"/merchant/finance/withdraw/hangup/reason/insert": {Title: "风控配置-新增挂起原因", Entity: "config"},
+	"/merchant/finance/withdraw/hangup/reason/update": {Title: "风控配置-修改挂起原因", Entity: "config", Tbl: "f_hangup_reason", Param: "id", Col: "id"},
+
+	"/merchant/finance/deposit/policy/update":  {Title: "存款防刷策略-修改", Entity: "config"},
+	"/merchant/finance/deposit/throttle/clear": {Title: "存款防刷策略-解除会员限制", Entity: "member"},
+	"/merchant/finance/velocity/update":        {Title: "存提款限额-修改", Entity: "config"},
+	"/merchant/finance/velocity/delete":        {Title: "存提款限额-删除", Entity: "config", Tbl: "f_velocity_limit", Param: "id", Col: "id"},
+	"/merchant/finance/membercredit/pin":       {Title: "会员信用等级-固定会员等级", Entity: "member_credit"},
+	"/merchant/finance/membercredit/unpin":     {Title: "会员信用等级-取消固定", Entity: "member_credit"},
+	"/merchant/finance/credit/rule/update":     {Title: "会员信用等级-设置自动分配规则", Entity: "credit_level"},
+	"/merchant/finance/credit/rule/delete":     {Title: "会员信用等级-删除自动分配规则", Entity: "credit_level", Tbl: "f_credit_rule", Param: "id", Col: "id"},
+	"/merchant/finance/maintenance/insert":     {Title: "维护计划-新增", Entity: "payment"},
+	"/merchant/finance/maintenance/cancel":     {Title: "维护计划-取消", Entity: "payment", Tbl: "f_payment_maintenance", Param: "id", Col: "id"},
+	"/merchant/finance/channel/quota/reset":    {Title: "通道管理-清零累计已收金额", Entity: "payment", Tbl: "f_payment", Param: "id", Col: "id"},
+	"/merchant/finance/banktxn/import":         {Title: "银行流水-上传流水文件", Entity: "bankcard"},
+	"/merchant/finance/banktxn/token":          {Title: "银行流水-生成短信转发token", Entity: "bankcard", Tbl: "f_bankcards", Param: "bankcard_id", Col: "id"},
+	"/merchant/finance/banktxn/match":          {Title: "银行流水-人工匹配订单入款", Entity: "deposit", Tbl: "f_bank_txn", Param: "id", Col: "id"},
+	"/merchant/finance/banktxn/ignore":         {Title: "银行流水-忽略", Entity: "deposit", Tbl: "f_bank_txn", Param: "id", Col: "id"},
+
+	"/merchant/finance/manual/code/conf/update": {Title: "线下转卡-修改附言码配置", Entity: "config"},
+	"/merchant/finance/promo/rule/update":       {Title: "存款优惠-设置首存二存规则", Entity: "config"},
+	"/merchant/finance/promo/rule/delete":       {Title: "存款优惠-删除规则", Entity: "config", Tbl: "f_deposit_promo_rule", Param: "id", Col: "id"},
+	"/merchant/finance/promo/conf/update":       {Title: "存款优惠-修改每日上限配置", Entity: "config"},
+
+	"/merchant/finance/manual/amount/tag/update": {Title: "线下转卡-设置收款卡金额尾数", Entity: "bankcard", Tbl: "f_bankcards", Param: "bankcard_id", Col: "id"},
+
+	"/merchant/finance/withdraw/commission/conf/update": {Title: "风控配置-修改佣金钱包提款配置", Entity: "config"},
+	"/merchant/finance/deposit/reverse":                 {Title: "存款管理-存款冲正", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+	"/merchant/finance/deposit/reversal/conf/update":    {Title: "存款管理-修改冲正策略", Entity: "config"},
+
+	"/merchant/finance/deposit/mismatch/conf/update": {Title: "存款管理-设置金额不一致处理方式", Entity: "config"},
+	"/merchant/finance/deposit/mismatch/conf/delete": {Title: "存款管理-删除金额不一致处理方式", Entity: "config"},
+	"/merchant/finance/deposit/mismatch/review":      {Title: "存款管理-金额不一致审核", Entity: "deposit", Tbl: "tbl_deposit", Param: "id", Col: "id"},
+}
+
+// AuditBegin 后台修改类接口执行前记录数据快照, 返回的函数在接口执行完后调用写入操作日志
+func AuditBegin(fctx *fasthttp.RequestCtx) func() {
+
+	path := string(fctx.Path())
+	route, ok := auditRoutes[path]
+	if !ok {
+		if !fctx.IsPost() || !strings.HasPrefix(path, "/merchant/") {
+			return func() {}
+		}
+
+		route = auditRoute{Title: path, Entity: "other"}
+	}
+
+	entityID := ""
+	var before map[string]interface{}
+	if route.Param != "" {
+		entityID = string(fctx.FormValue(route.Param))
+		if route.Tbl != "" && entityID != "" {
+			before = auditSnapshot(route, entityID)
+		}
+	}
+
+	return func() {
+
+		var after map[string]interface{}
+		if before != nil {
+			after = auditSnapshot(route, entityID)
+		}
+
+		auditWrite(fctx, path, route, entityID, auditDiff(before, after))
+	}
+}
+
+// SystemLogWrite 接口内补充的操作说明, 和操作日志一起写入
+func SystemLogWrite(content string, fctx *fasthttp.RequestCtx) {
+	fctx.SetUserValue("audit_content", content)
+}
+
+// TimeFormat 操作说明中的时间格式
+func TimeFormat(ts int64) string {
+
+	if ts == 0 {
+		return ""
+	}
+
+	return time.Unix(ts, 0).In(loc).Format("2006-01-02 15:04:05")
+}
+
+func AuditList(entity, entityID, name, startTime, endTime string, page, pageSize uint16) (AuditData, error) {
+
+	data := AuditData{}
+
+	startAt, err := helper.TimeToLoc(startTime, loc)
+	if err != nil {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	endAt, err := helper.TimeToLoc(endTime, loc)
+	if err != nil {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	if startAt >= endAt {
+		return data, errors.New(helper.QueryTimeRangeErr)
+	}
+
+	ex := g.Ex{
+		"prefix": meta.Prefix,
+		"ts":     g.Op{"between": exp.NewRangeVal(startAt*1000000, endAt*1000000)},
+	}
+	if entity != "" {
+		ex["entity"] = entity
+	}
+	if entityID != "" {
+		ex["entity_id"] = entityID
+	}
+	if name != "" {
+		ex["name"] = name
+	}
+
+	if page == 1 {
+		query, _, _ := dialect.From(auditTable).Select(g.COUNT(1)).Where(ex).ToSQL()
+		err = meta.MerchantLogTD.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From(auditTable).Select(colAudit...).Where(ex).
+		Order(g.C("ts").Desc()).Offset(uint(offset)).Limit(uint(pageSize)).ToSQL()
+	err = meta.MerchantLogTD.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+func auditWrite(fctx *fasthttp.RequestCtx, path string, route auditRoute, entityID, diff string) {
+
+	uid, name := "", ""
+	if b, ok := fctx.UserValue("token").([]byte); ok {
+		uid = fastjson.GetString(b, "id")
+		name = fastjson.GetString(b, "name")
+	}
+
+	content, _ := fctx.UserValue("audit_content").(string)
+	state := 0
+	if fastjson.GetBool(fctx.Response.Body(), "status") {
+		state = 1
+	}
+
+	ts := time.Now()
+	record := g.Record{
+		"ts":         ts.In(loc).UnixMicro(),
+		"id":         helper.GenId(),
+		"prefix":     meta.Prefix,
+		"title":      route.Title,
+		"entity":     route.Entity,
+		"entity_id":  entityID,
+		"route":      path,
+		"params":     auditParams(fctx),
+		"diff":       diff,
+		"content":    content,
+		"state":      state,
+		"uid":        uid,
+		"name":       name,
+		"ip":         helper.FromRequest(fctx),
+		"created_at": ts.Unix(),
+	}
+	query, _, _ := dialect.Insert(auditTable).Rows(record).ToSQL()
+	_, err := meta.MerchantLogTD.Exec(query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+	}
+}
+
+// 请求参数, 去掉动态密码
+func auditParams(fctx *fasthttp.RequestCtx) string {
+
+	params := map[string]string{}
+	fctx.QueryArgs().VisitAll(func(key, value []byte) {
+		params[string(key)] = string(value)
+	})
+	fctx.PostArgs().VisitAll(func(key, value []byte) {
+		params[string(key)] = string(value)
+	})
+	if form, err := fctx.MultipartForm(); err == nil {
+		for k, v := range form.Value {
+			if len(v) > 0 {
+				params[k] = v[0]
+			}
+		}
+		for k, v := range form.File {
+			if len(v) > 0 {
+				params[k] = v[0].Filename
+			}
+		}
+	}
+	delete(params, "code")
+
+	b, _ := helper.JsonMarshal(params)
+	return string(b)
+}
+
+func auditSnapshot(route auditRoute, id string) map[string]interface{} {
+
+	ex := g.Ex{route.Col: id}
+	if route.Prefix {
+		ex["prefix"] = meta.Prefix
+	}
+
+	query, _, _ := dialect.From(route.Tbl).Where(ex).Limit(1).ToSQL()
+	row := map[string]interface{}{}
+	err := meta.MerchantDB.QueryRowx(query).MapScan(row)
+	if err != nil {
+		// 记录不存在时按空快照处理
+		return map[string]interface{}{}
+	}
+
+	for k, v := range row {
+		switch val := v.(type) {
+		case nil:
+			row[k] = ""
+		case []byte:
+			row[k] = string(val)
+		}
+	}
+
+	return row
+}
+
+// 修改前后有变化的字段, 格式 {"字段":["修改前","修改后"]}
+func auditDiff(before, after map[string]interface{}) string {
+
+	if before == nil && after == nil {
+		return ""
+	}
+
+	diff := map[string][2]string{}
+	for k, v := range before {
+		o, n := fmt.Sprint(v), ""
+		if nv, ok := after[k]; ok {
+			n = fmt.Sprint(nv)
+		}
+		if o != n {
+			diff[k] = [2]string{o, n}
+		}
+	}
+
+	for k, v := range after {
+		if _, ok := before[k]; !ok {
+			diff[k] = [2]string{"", fmt.Sprint(v)}
+		}
+	}
+
+	if len(diff) == 0 {
+		return ""
+	}
+
+	b, _ := helper.JsonMarshal(diff)
+	return string(b)
+}
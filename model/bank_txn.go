@@ -0,0 +1,684 @@
+package model
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 银行流水来源
+const (
+	BankTxnSourceCsv = 1 // 上传流水文件
+	BankTxnSourceSms = 2 // 短信转发
+)
+
+// 银行流水处理状态
+const (
+	BankTxnPending = 0 // 待人工处理
+	BankTxnAuto    = 1 // 自动匹配入款
+	BankTxnManual  = 2 // 人工匹配入款
+	BankTxnIgnored = 3 // 已忽略
+)
+
+const (
+	// 流水金额单位是VND, 订单金额单位是KVND
+	bankTxnUnit = 1000
+	// 流水时间之前多久创建的订单参与匹配
+	bankTxnMatchBefore = 24 * 3600
+	// 银行入账时间和我方时间可能有偏差
+	bankTxnMatchAfter = 10 * 60
+)
+
+var (
+	bankTxnTimeLayouts = []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"02/01/2006 15:04:05",
+		"02/01/2006 15:04",
+		"02-01-2006 15:04:05",
+		"02-01-2006 15:04",
+		"2006/01/02 15:04:05",
+		"2006-01-02",
+		"02/01/2006",
+	}
+	// 短信中的入账金额, 如 +500,000VND / +500.000 VND
+	bankSmsAmountReg = regexp.MustCompile(`\+\s*([0-9][0-9.,]*)\s*(VND|đ|d)?`)
+	// 短信中的转账附言, 如 ND: xxx / Noi dung: xxx / ND xxx
+	bankSmsMemoReg = regexp.MustCompile(`(?i)(?:ND|Noi dung|Nội dung)\s*:?\s*(.+)$`)
+	// 附言中的6位数字附言码
+	bankMemoCodeReg = regexp.MustCompile(`\d{6}`)
+)
+
+// BankTxn 收款卡的入账流水
+type BankTxn struct {
+	ID          string  `db:"id" json:"id"`
+	BankcardID  string  `db:"bankcard_id" json:"bankcard_id"`
+	CardNo      string  `db:"card_no" json:"card_no"`
+	Amount      float64 `db:"amount" json:"amount"` // 入账金额, 单位KVND
+	Memo        string  `db:"memo" json:"memo"`     // 转账附言
+	TxnAt       int64   `db:"txn_at" json:"txn_at"` // 入账时间
+	Source      int     `db:"source" json:"source"` // 1 流水文件 2 短信
+	Raw         string  `db:"raw" json:"raw"`       // 原始内容
+	Hash        string  `db:"hash" json:"hash"`     // 去重
+	State       int     `db:"state" json:"state"`   // 0 待人工处理 1 自动入款 2 人工入款 3 已忽略
+	DepositID   string  `db:"deposit_id" json:"deposit_id"`
+	Candidates  string  `db:"candidates" json:"candidates"` // 可能匹配的订单, 逗号分开
+	CreatedAt   int64   `db:"created_at" json:"created_at"`
+	HandledAt   int64   `db:"handled_at" json:"handled_at"`
+	HandledUID  string  `db:"handled_uid" json:"handled_uid"`
+	HandledName string  `db:"handled_name" json:"handled_name"`
+	Prefix      string  `db:"prefix" json:"prefix"`
+}
+
+type BankTxnData struct {
+	D []BankTxn `json:"d"`
+	T int64     `json:"t"`
+	S uint16    `json:"s"`
+}
+
+// BankTxnResult 一次导入的结果
+type BankTxnResult struct {
+	Total     int   `json:"total"`     // 入账行数
+	Duplicate int   `json:"duplicate"` // 重复的流水
+	Auto      int   `json:"auto"`      // 自动入款
+	Pending   int   `json:"pending"`   // 待人工处理
+	Failed    []int `json:"failed"`    // 写入失败跳过的行号, 可修正后重新导入
+}
+
+type manualRemark struct {
+	Code string `json:"manual_remark"`
+}
+
+func BankTxnList(ex g.Ex, startTime, endTime string, page, pageSize uint16) (BankTxnData, error) {
+
+	data := BankTxnData{}
+	ex["prefix"] = meta.Prefix
+
+	if startTime != "" && endTime != "" {
+		startAt, err := helper.TimeToLoc(startTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		endAt, err := helper.TimeToLoc(endTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		ex["txn_at"] = g.Op{"between": g.Range(startAt, endAt)}
+	}
+
+	if page == 1 {
+		query, _, _ := dialect.From("f_bank_txn").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("f_bank_txn").Select(colBankTxn...).Where(ex).
+		Offset(uint(offset)).Limit(uint(pageSize)).Order(g.C("txn_at").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+// BankTxnImport 导入收款卡的流水文件, 只处理入账金额大于0的行
+func BankTxnImport(cardID, fileName string, content []byte, colTime, colAmount, colMemo string) (BankTxnResult, error) {
+
+	res := BankTxnResult{}
+	bc, err := BankCardByID(cardID)
+	if err != nil {
+		return res, err
+	}
+
+	rows, err := reconcileParseFile(fileName, content)
+	if err != nil {
+		return res, err
+	}
+
+	if len(rows) < 2 {
+		return res, errors.New(helper.FormatErr)
+	}
+
+	idx := map[string]int{}
+	for k, v := range rows[0] {
+		idx[strings.TrimSpace(v)] = k
+	}
+
+	cTime, ok1 := idx[colTime]
+	cAmount, ok2 := idx[colAmount]
+	cMemo, ok3 := idx[colMemo]
+	if !ok1 || !ok2 || !ok3 {
+		return res, errors.New(helper.FormatErr)
+	}
+
+	cell := func(row []string, k int) string {
+		if k >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[k])
+	}
+
+	res.Failed = []int{}
+	for k, row := range rows[1:] {
+
+		amount, ok := bankTxnAmount(cell(row, cAmount))
+		if !ok {
+			continue
+		}
+
+		txnAt, ok := bankTxnTime(cell(row, cTime))
+		if !ok {
+			continue
+		}
+
+		txn := BankTxn{
+			BankcardID: bc.Id,
+			CardNo:     bc.BanklcardNo,
+			Amount:     amount,
+			Memo:       cell(row, cMemo),
+			TxnAt:      txnAt,
+			Source:     BankTxnSourceCsv,
+			Raw:        strings.Join(row, ","),
+		}
+		// 单行失败不影响其他行, 已写入的流水重新导入时按重复跳过
+		state, err := bankTxnInsert(txn)
+		if err != nil {
+			res.Failed = append(res.Failed, k+2)
+			continue
+		}
+
+		res.Total++
+		bankTxnCount(&res, state)
+	}
+
+	return res, nil
+}
+
+// BankTxnSms 短信转发app推送的入账短信, token 对应收款卡
+func BankTxnSms(token, content string, ts int64) (BankTxnResult, error) {
+
+	res := BankTxnResult{}
+	cardID, err := meta.MerchantRedis.HGet(ctx, bankSmsTokenKey(), token).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return res, errors.New(helper.AccessTokenExpires)
+		}
+
+		return res, pushLog(err, helper.RedisErr)
+	}
+
+	bc, err := BankCardByID(cardID)
+	if err != nil {
+		return res, err
+	}
+
+	// 只处理入账短信
+	m := bankSmsAmountReg.FindStringSubmatch(content)
+	if len(m) < 2 {
+		return res, nil
+	}
+
+	amount, ok := bankTxnAmount(m[1])
+	if !ok {
+		return res, nil
+	}
+
+	memo := content
+	if mm := bankSmsMemoReg.FindStringSubmatch(content); len(mm) > 1 {
+		memo = strings.TrimSpace(mm[1])
+	}
+
+	if ts == 0 {
+		ts = time.Now().Unix()
+	}
+
+	txn := BankTxn{
+		BankcardID: bc.Id,
+		CardNo:     bc.BanklcardNo,
+		Amount:     amount,
+		Memo:       memo,
+		TxnAt:      ts,
+		Source:     BankTxnSourceSms,
+		Raw:        content,
+	}
+	state, err := bankTxnInsert(txn)
+	if err != nil {
+		return res, err
+	}
+
+	res.Total++
+	bankTxnCount(&res, state)
+	return res, nil
+}
+
+// BankSmsToken 为收款卡生成短信转发使用的token, 旧token失效
+func BankSmsToken(cardID string) (string, error) {
+
+	bc, err := BankCardByID(cardID)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", errors.New(helper.FormatErr)
+	}
+
+	token := hex.EncodeToString(b)
+	key := bankSmsTokenKey()
+	tokens, err := meta.MerchantRedis.HGetAll(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		return "", pushLog(err, helper.RedisErr)
+	}
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	for k, v := range tokens {
+		if v == bc.Id {
+			pipe.HDel(ctx, key, k)
+		}
+	}
+	pipe.HSet(ctx, key, token, bc.Id)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return "", pushLog(err, helper.RedisErr)
+	}
+
+	return token, nil
+}
+
+// BankTxnMatch 人工把待处理的流水匹配到订单并入款
+func BankTxnMatch(id, depositID string, admin map[string]string) error {
+
+	txn, err := bankTxnByID(id)
+	if err != nil {
+		return err
+	}
+
+	if txn.State != BankTxnPending {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	order, err := DepositFindOne(depositID)
+	if err != nil {
+		return err
+	}
+
+	if order.Flag != DepositFlagManual || order.BankcardID != txn.BankcardID {
+		return errors.New(helper.OrderNotExist)
+	}
+
+	return bankTxnApprove(txn, order, BankTxnManual, admin["id"], admin["name"])
+}
+
+// BankTxnIgnore 忽略与存款无关的流水
+func BankTxnIgnore(id string, admin map[string]string) error {
+
+	ex := g.Ex{
+		"id":     id,
+		"state":  BankTxnPending,
+		"prefix": meta.Prefix,
+	}
+	record := g.Record{
+		"state":        BankTxnIgnored,
+		"handled_at":   time.Now().Unix(),
+		"handled_uid":  admin["id"],
+		"handled_name": admin["name"],
+	}
+	query, _, _ := dialect.Update("f_bank_txn").Set(record).Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.RecordNotExistErr)
+	}
+
+	return nil
+}
+
+func bankSmsTokenKey() string {
+	return fmt.Sprintf("%s:bank:sms:token", meta.Prefix)
+}
+
+func bankTxnCount(res *BankTxnResult, state int) {
+
+	switch state {
+	case -1:
+		res.Duplicate++
+	case BankTxnAuto:
+		res.Auto++
+	default:
+		res.Pending++
+	}
+}
+
+// 流水金额转为订单金额, 只返回入账
+func bankTxnAmount(s string) (float64, bool) {
+
+	vnd, ok := bankTxnVND(s)
+	if !ok || vnd <= 0 {
+		return 0, false
+	}
+
+	f, _ := decimal.NewFromInt(vnd).Div(decimal.NewFromInt(bankTxnUnit)).Float64()
+	return f, true
+}
+
+// 按越南盾格式解析金额: "." 和 "," 都是千分位, 末尾1到2位的小数部分单独处理且必须为0
+// 整数部分按同一个分隔符重新格式化后必须与原文一致, 否则不识别
+func bankTxnVND(s string) (int64, bool) {
+
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "+"))
+	s = strings.TrimRight(s, ".,")
+	if s == "" {
+		return 0, false
+	}
+
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 && len(s)-i-1 <= 2 {
+		frac := s[i+1:]
+		if _, err := strconv.ParseUint(frac, 10, 64); err != nil || strings.Trim(frac, "0") != "" {
+			return 0, false
+		}
+
+		s = s[:i]
+	}
+
+	sep := ""
+	if strings.Contains(s, ".") {
+		sep = "."
+	}
+	if strings.Contains(s, ",") {
+		if sep != "" {
+			return 0, false
+		}
+		sep = ","
+	}
+
+	digits := s
+	if sep != "" {
+		digits = strings.ReplaceAll(s, sep, "")
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	str := strconv.FormatInt(n, 10)
+	if sep != "" {
+		for i := len(str) - 3; i > 0; i -= 3 {
+			str = str[:i] + sep + str[i:]
+		}
+	}
+
+	if str != s {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func bankTxnTime(s string) (int64, bool) {
+
+	for _, layout := range bankTxnTimeLayouts {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err == nil {
+			return t.Unix(), true
+		}
+	}
+
+	return 0, false
+}
+
+func bankTxnByID(id string) (BankTxn, error) {
+
+	txn := BankTxn{}
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_bank_txn").Select(colBankTxn...).Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&txn, query)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return txn, errors.New(helper.RecordNotExistErr)
+		}
+
+		return txn, pushLog(err, helper.DBErr)
+	}
+
+	return txn, nil
+}
+
+// 写入流水并尝试匹配订单, 重复的流水返回-1
+func bankTxnInsert(txn BankTxn) (int, error) {
+
+	// 原始内容里有时间和余额, 重复上传或重复推送时一致
+	sum := md5.Sum([]byte(fmt.Sprintf("%s|%d|%s", txn.CardNo, txn.Source, txn.Raw)))
+	txn.Hash = hex.EncodeToString(sum[:])
+
+	orders, err := bankTxnCandidates(txn)
+	if err != nil {
+		return 0, err
+	}
+
+	txn.ID = helper.GenId()
+	txn.State = BankTxnPending
+	txn.CreatedAt = time.Now().Unix()
+	txn.Prefix = meta.Prefix
+
+	// 带金额尾数的订单按收款卡和金额匹配, 其余附言码, 收款卡和金额都一致并且只有一笔订单时自动入款
+	var exact []Deposit
+	var ids []string
+	owner := manualAmountOwner(txn.BankcardID, txn.Amount)
+	for _, v := range orders {
+		ids = append(ids, v.ID)
+		if owner != "" {
+			if v.ID == owner {
+				exact = append(exact, v)
+			}
+			continue
+		}
+
+		if bankTxnCodeMatch(txn.Memo, v.ManualRemark) && decimal.NewFromFloat(v.Amount).Equal(decimal.NewFromFloat(txn.Amount)) {
+			exact = append(exact, v)
+		}
+	}
+	txn.Candidates = strings.Join(ids, ",")
+
+	// (prefix, hash) 唯一索引去重, 并发推送同一笔流水时只有一条写入成功
+	query, _, _ := dialect.Insert("f_bank_txn").Rows(txn).OnConflict(g.DoNothing()).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return 0, pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return -1, nil
+	}
+
+	if len(exact) != 1 {
+		return BankTxnPending, nil
+	}
+
+	err = bankTxnApprove(txn, exact[0], BankTxnAuto, "0", "系统")
+	if err != nil {
+		fmt.Println("bankTxnApprove id = ", txn.ID, ", err = ", err.Error())
+		return BankTxnPending, nil
+	}
+
+	return BankTxnAuto, nil
+}
+
+// 同一张收款卡在流水时间附近未完成的线下转卡订单
+func bankTxnCandidates(txn BankTxn) ([]Deposit, error) {
+
+	var data []Deposit
+	ex := g.Ex{
+		"prefix":      meta.Prefix,
+		"flag":        DepositFlagManual,
+		"bankcard_id": txn.BankcardID,
+		"state":       DepositConfirming, // 与 bankTxnApprove 一致, 审核中的订单已有人处理
+		"created_at":  g.Op{"between": g.Range(txn.TxnAt-bankTxnMatchBefore, txn.TxnAt+bankTxnMatchAfter)},
+	}
+	query, _, _ := dialect.From("tbl_deposit").Select(colsDeposit...).Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// 附言中是否包含订单的附言码
+func bankTxnCodeMatch(memo, remark string) bool {
+
+	mr := manualRemark{}
+	err := helper.JsonUnmarshal([]byte(remark), &mr)
+	if err != nil || mr.Code == "" {
+		return false
+	}
+
+	for _, v := range bankMemoCodeReg.FindAllString(strings.ReplaceAll(memo, " ", ""), -1) {
+		if v == mr.Code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 按流水金额确认订单并走线下转卡审核入款
+func bankTxnApprove(txn BankTxn, order Deposit, state int, adminUID, adminName string) error {
+
+	err := depositLock(order.ID)
+	if err != nil {
+		return err
+	}
+	defer depositUnLock(order.ID)
+
+	// 加锁后重新查询, 已被人工确认金额或已处理的订单不按流水入款
+	order, err = DepositFindOne(order.ID)
+	if err != nil {
+		return err
+	}
+
+	if order.State != DepositConfirming {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	// 先占用流水, 同一笔流水只能入款一次
+	record := g.Record{
+		"state":        state,
+		"deposit_id":   order.ID,
+		"handled_at":   time.Now().Unix(),
+		"handled_uid":  adminUID,
+		"handled_name": adminName,
+	}
+	ex := g.Ex{
+		"id":    txn.ID,
+		"state": BankTxnPending,
+	}
+	query, _, _ := dialect.Update("f_bank_txn").Set(record).Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	remark := fmt.Sprintf("银行流水入款 %s", txn.ID)
+	rec := g.Record{
+		"amount":        txn.Amount,
+		"review_remark": remark,
+		"state":         DepositReviewing,
+	}
+	ex = g.Ex{
+		"id":    order.ID,
+		"state": []int{DepositConfirming},
+	}
+	query, _, _ = dialect.Update("tbl_deposit").Set(rec).Where(ex).ToSQL()
+	res, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		bankTxnRevert(txn.ID, Deposit{})
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		bankTxnRevert(txn.ID, Deposit{})
+		return errors.New(helper.OrderStateErr)
+	}
+
+	amount := order.Amount
+	order.Amount = txn.Amount
+	err = manualReview(order.ID, remark, adminName, adminUID, DepositSuccess, order)
+	if err != nil {
+		order.Amount = amount
+		bankTxnRevert(txn.ID, order)
+		return err
+	}
+
+	return nil
+}
+
+// 入款失败时流水恢复为待处理, 订单已按流水改为审核中的恢复原金额和状态
+func bankTxnRevert(id string, order Deposit) {
+
+	record := g.Record{
+		"state":        BankTxnPending,
+		"deposit_id":   "",
+		"handled_at":   0,
+		"handled_uid":  "",
+		"handled_name": "",
+	}
+	query, _, _ := dialect.Update("f_bank_txn").Set(record).Where(g.Ex{"id": id}).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+	}
+
+	if order.ID == "" {
+		return
+	}
+
+	rec := g.Record{
+		"amount":        order.Amount,
+		"review_remark": order.ReviewRemark,
+		"state":         DepositConfirming,
+	}
+	query, _, _ = dialect.Update("tbl_deposit").Set(rec).Where(g.Ex{"id": order.ID, "state": DepositReviewing}).ToSQL()
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+	}
+}
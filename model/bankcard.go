@@ -25,6 +25,9 @@ type Bankcard_t struct {
 	TotalMaxAmount    string `db:"total_max_amount" json:"total_max_amount"`       // 累计最大收款限额
 	TotalFinishAmount string `db:"total_finish_amount" json:"total_finish_amount"` // 累计已收款总额
 	Flags             string `db:"flags" json:"flags"`                             // 累计已收款总额
+	Weight            string `db:"weight" json:"weight"`                           // 选卡权重
+	Levels            string `db:"levels" json:"levels"`                           // 可用的VIP等级, 逗号分开, 为空表示全部
+	Bin               string `db:"bin" json:"bin"`                                 // NAPAS BIN, 生成VietQR使用
 }
 
 // BankCardListForDeposit 银行卡信息 线下转卡 订单列表
@@ -36,19 +39,6 @@ type BankCardListForDeposit struct {
 	BankAddr string `db:"bank_addr" json:"bank_addr"`
 }
 
-func BankCardBackend() (Bankcard_t, error) {
-
-	bc := Bankcard_t{}
-	key := meta.Prefix + ":offlineBankcard"
-	res, err := meta.MerchantRedis.RPopLPush(ctx, key, key).Result()
-	if err != nil {
-		return bc, errors.New(helper.RecordNotExistErr)
-	}
-
-	helper.JsonUnmarshal([]byte(res), &bc)
-	return bc, nil
-}
-
 func BankCardUpdateCache() error {
 
 	key := meta.Prefix + ":offlineBankcard"
@@ -0,0 +1,264 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+	"lukechampine.com/frand"
+)
+
+const (
+	bankcardReserveTTL      = 30 * 60 // 未完成订单占用额度的最长时间(秒)
+	bankcardResetLock       = "bankcard:reset"
+	bankcardReserveInterval = time.Minute
+)
+
+// 每张卡被未完成订单占用的金额
+func bankcardReserveKey() string {
+	return fmt.Sprintf("%s:bankcard:reserve", meta.Prefix)
+}
+
+// 订单占用的卡和金额
+func bankcardReserveOrderKey() string {
+	return fmt.Sprintf("%s:bankcard:reserve:order", meta.Prefix)
+}
+
+// 按过期时间排序的占用订单
+func bankcardReserveExpireKey() string {
+	return fmt.Sprintf("%s:bankcard:reserve:expire", meta.Prefix)
+}
+
+// 卡是否可以用于该VIP等级, levels 为空时所有等级可用
+func bankcardLevelMatch(levels string, level int) bool {
+
+	if levels == "" {
+		return true
+	}
+
+	lv := strconv.Itoa(level)
+	for _, v := range strings.Split(levels, ",") {
+		if v == lv {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 加上占用和本次金额后是否还在限额内, 限额为0表示不限制
+func bankcardHeadroom(bc Bankcard_t, reserved, amount decimal.Decimal) bool {
+
+	pairs := [][2]string{
+		{bc.DailyMaxAmount, bc.DailyFinishAmount},
+		{bc.TotalMaxAmount, bc.TotalFinishAmount},
+	}
+	for _, v := range pairs {
+		max, _ := decimal.NewFromString(v[0])
+		if max.LessThanOrEqual(zero) {
+			continue
+		}
+
+		finish, _ := decimal.NewFromString(v[1])
+		if finish.Add(reserved).Add(amount).GreaterThan(max) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 按权重随机选择
+func bankcardWeightPick(cards []Bankcard_t) int {
+
+	total := 0
+	weights := make([]int, len(cards))
+	for i, v := range cards {
+		w, _ := strconv.Atoi(v.Weight)
+		if w < 1 {
+			w = 1
+		}
+		weights[i] = w
+		total += w
+	}
+
+	n := frand.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+
+	return len(cards) - 1
+}
+
+// BankCardSelect 线下转卡选择收款卡, 只选剩余额度足够的卡, 并为订单占用额度直到订单完成或超时
+func BankCardSelect(orderID string, level int, amount decimal.Decimal) (Bankcard_t, error) {
+
+	bc := Bankcard_t{}
+	key := meta.Prefix + ":offlineBankcard"
+	res, err := meta.MerchantRedis.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return bc, pushLog(err, helper.RedisErr)
+	}
+
+	reserved, err := meta.MerchantRedis.HGetAll(ctx, bankcardReserveKey()).Result()
+	if err != nil && err != redis.Nil {
+		return bc, pushLog(err, helper.RedisErr)
+	}
+
+	var cards []Bankcard_t
+	for _, v := range res {
+		card := Bankcard_t{}
+		err = helper.JsonUnmarshal([]byte(v), &card)
+		if err != nil || !bankcardLevelMatch(card.Levels, level) {
+			continue
+		}
+
+		r, _ := decimal.NewFromString(reserved[card.Id])
+		if bankcardHeadroom(card, r, amount) {
+			cards = append(cards, card)
+		}
+	}
+
+	val, _ := amount.Float64()
+	for len(cards) > 0 {
+		i := bankcardWeightPick(cards)
+		card := cards[i]
+
+		// 先占用再检查, 并发请求同时选中一张卡时超出限额的请求换下一张
+		total, err := meta.MerchantRedis.HIncrByFloat(ctx, bankcardReserveKey(), card.Id, val).Result()
+		if err != nil {
+			return bc, pushLog(err, helper.RedisErr)
+		}
+
+		if !bankcardHeadroom(card, decimal.NewFromFloat(total).Sub(amount), amount) {
+			meta.MerchantRedis.HIncrByFloat(ctx, bankcardReserveKey(), card.Id, -val)
+			cards = append(cards[:i], cards[i+1:]...)
+			continue
+		}
+
+		pipe := meta.MerchantRedis.TxPipeline()
+		pipe.HSet(ctx, bankcardReserveOrderKey(), orderID, fmt.Sprintf("%s|%s", card.Id, amount.String()))
+		pipe.ZAdd(ctx, bankcardReserveExpireKey(), &redis.Z{Score: float64(time.Now().Unix() + bankcardReserveTTL), Member: orderID})
+		_, err = pipe.Exec(ctx)
+		pipe.Close()
+		if err != nil {
+			return bc, pushLog(err, helper.RedisErr)
+		}
+
+		return card, nil
+	}
+
+	return bc, errors.New(helper.BankCardNotExist)
+}
+
+// 订单完成或取消后释放占用的额度
+func bankcardRelease(orderID string) {
+
+	val, err := meta.MerchantRedis.HGet(ctx, bankcardReserveOrderKey(), orderID).Result()
+	if err != nil {
+		return
+	}
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.HDel(ctx, bankcardReserveOrderKey(), orderID)
+	pipe.ZRem(ctx, bankcardReserveExpireKey(), orderID)
+	s := strings.SplitN(val, "|", 2)
+	if len(s) == 2 {
+		amount, _ := strconv.ParseFloat(s[1], 64)
+		pipe.HIncrByFloat(ctx, bankcardReserveKey(), s[0], -amount)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+}
+
+// 订单金额确定后把占用额度改为会员实际转账的金额
+func bankcardReserveUpdate(orderID string, amount decimal.Decimal) {
+
+	val, err := meta.MerchantRedis.HGet(ctx, bankcardReserveOrderKey(), orderID).Result()
+	if err != nil {
+		return
+	}
+
+	s := strings.SplitN(val, "|", 2)
+	if len(s) != 2 {
+		return
+	}
+
+	reserved, _ := decimal.NewFromString(s[1])
+	diff, _ := amount.Sub(reserved).Float64()
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.HSet(ctx, bankcardReserveOrderKey(), orderID, fmt.Sprintf("%s|%s", s[0], amount.String()))
+	pipe.HIncrByFloat(ctx, bankcardReserveKey(), s[0], diff)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+}
+
+// BankCardTask 释放超时订单占用的额度, 并在商户时区零点清零当天已收款
+func BankCardTask() {
+
+	ticker := time.NewTicker(bankcardReserveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		ids, err := meta.MerchantRedis.ZRangeByScore(ctx, bankcardReserveExpireKey(), &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(now.Unix(), 10),
+		}).Result()
+		if err == nil {
+			for _, id := range ids {
+				bankcardRelease(id)
+			}
+		}
+
+		bankcardDailyReset(now)
+	}
+}
+
+func bankcardDailyReset(now time.Time) {
+
+	day := now.In(loc).Format("20060102")
+	key := fmt.Sprintf("%s:bankcard:reset", meta.Prefix)
+	last, err := meta.MerchantRedis.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		return
+	}
+
+	if last == day {
+		return
+	}
+
+	// 多实例只需要一个执行
+	if Lock(bankcardResetLock) != nil {
+		return
+	}
+	defer Unlock(bankcardResetLock)
+
+	// 首次运行只记录日期, 避免上线当天中途清零
+	if last != "" {
+		_, err = CleanBankFinshAmount()
+		if err != nil {
+			return
+		}
+	}
+
+	meta.MerchantRedis.Set(ctx, key, day, 0)
+}
@@ -78,6 +78,9 @@ type Payment_t struct {
 	State       string `db:"state" redis:"state" json:"state"`                      //0:关闭1:开启
 	Devices     string `db:"devices" redis:"devices" json:"devices"`                //设备号
 	AmountList  string `db:"amount_list" redis:"amount_list" json:"amount_list"`    // 固定金额列表
+	Weekdays    string `db:"weekdays" redis:"weekdays" json:"weekdays"`             // 营业日 1-7, 为空表示每天
+	DayUsed     string `json:"day_used"`                                            // 当天已收金额
+	TotalUsed   string `json:"total_used"`                                          // 累计已收金额
 }
 
 func CacheRefreshPaymentBanks(id string) error {
@@ -180,6 +183,7 @@ func CacheRefreshPayment(id string) error {
 		"st":           val.St,
 		"state":        val.State,
 		"amount_list":  val.AmountList,
+		"weekdays":     val.Weekdays,
 	}
 	pkey := meta.Prefix + ":p:" + id
 	pipe.Unlink(ctx, pkey)
@@ -247,6 +251,22 @@ func Tunnel(fctx *fasthttp.RequestCtx, id string) (string, error) {
 		return "[]", nil
 	}
 
+	// 会员被限制存款时不返回渠道
+	locks, err := memberLockActive(u.UID)
+	if err != nil {
+		return "[]", err
+	}
+
+	if memberLockMatch(locks, LockScopeDeposit, "") {
+		return "[]", nil
+	}
+
+	now := fctx.Time()
+	mts, err := MaintenanceActive(now)
+	if err != nil {
+		return "[]", err
+	}
+
 	pipe := meta.MerchantRedis.TxPipeline()
 	defer pipe.Close()
 
@@ -255,21 +275,21 @@ func Tunnel(fctx *fasthttp.RequestCtx, id string) (string, error) {
 	re := make([]*redis.SliceCmd, ll)
 	bk := make([]*redis.StringCmd, ll)
 
-	exists := pipe.Exists(ctx, fmt.Sprintf("%s:DL:%s", meta.Prefix, u.UID))
 	for i, v := range paymentIds {
-		rs[i] = pipe.HMGet(ctx, meta.Prefix+":p:"+v, "id", "fmin", "fmax", "et", "st", "amount_list", "payment_name", "sort")
+		rs[i] = pipe.HMGet(ctx, meta.Prefix+":p:"+v, "id", "fmin", "fmax", "et", "st", "amount_list", "payment_name", "sort", "cate_id", "weekdays", "quota", "amount")
 		re[i] = pipe.HMGet(ctx, meta.Prefix+":pr:"+v, "fmin", "fmax")
 		bk[i] = pipe.Get(ctx, meta.Prefix+":BK:"+v)
 	}
 
 	pipe.Exec(ctx)
 
-	// 如果会员被锁定不返回渠道
-	if exists.Val() != 0 {
-		return "[]", pushLog(err, helper.RedisErr)
+	used, err := paymentQuotaUsed(paymentIds, now)
+	if err != nil {
+		return "[]", err
 	}
 
 	arr := a.NewArray()
+	n := 0
 
 	for i := 0; i < ll; i++ {
 
@@ -292,6 +312,21 @@ func Tunnel(fctx *fasthttp.RequestCtx, id string) (string, error) {
 			return "", pushLog(err, helper.RedisErr)
 		}
 
+		// 被限制的渠道不返回
+		if memberLockMatch(locks, LockScopeChannel, m.ID) {
+			continue
+		}
+
+		// 不在营业时间或维护中的通道不返回
+		if _, ok := maintenanceMatch(mts, m.CateID, m.ID); ok || !paymentWindowOpen(m.St, m.Et, m.Weekdays, now) {
+			continue
+		}
+
+		// 额度用完的通道不返回
+		if paymentQuotaExceed(m.Quota, m.Amount, used[m.ID], zero) {
+			continue
+		}
+
 		obj := fastjson.MustParse(`{"id":"0","bank":[], "fmin":"0","fmax":"0", "amount_list": "","sort":"0","payment_name":""}`)
 		obj.Set("id", fastjson.MustParse(fmt.Sprintf(`"%s"`, m.ID)))
 		obj.Set("fmin", fastjson.MustParse(fmt.Sprintf(`"%s"`, fmin)))
@@ -304,7 +339,8 @@ func Tunnel(fctx *fasthttp.RequestCtx, id string) (string, error) {
 			obj.Set("bank", fastjson.MustParse(banks))
 		}
 
-		arr.SetArrayItem(i, obj)
+		arr.SetArrayItem(n, obj)
+		n++
 		obj = nil
 	}
 	str := arr.String()
@@ -353,10 +389,19 @@ func Cate(fctx *fasthttp.RequestCtx) (string, error) {
 		return "", err
 	}
 
+	// 如果会员被限制存款不返回通道
+	locks, err := memberLockActive(m.UID)
+	if err != nil {
+		return "[]", err
+	}
+
+	if memberLockMatch(locks, LockScopeDeposit, "") {
+		return "[]", nil
+	}
+
 	key := fmt.Sprintf("%s:p:%d", meta.Prefix, m.Level)
 	//pipe := meta.MerchantRedisRead.Pipeline()
 	pipe := meta.MerchantRedis.Pipeline()
-	exists := pipe.Exists(ctx, fmt.Sprintf("%s:DL:%s", meta.Prefix, m.UID))
 
 	//sip := helper.FromRequest(fctx)
 	//if strings.Count(sip, ":") >= 2 {
@@ -370,22 +415,29 @@ func Cate(fctx *fasthttp.RequestCtx) (string, error) {
 	if err != nil {
 		return "[]", pushLog(err, helper.RedisErr)
 	}
-	// 如果会员被锁定不返回通道
-	if exists.Val() != 0 {
-		return "[]", nil
-	}
 
 	a := new(fastjson.Arena)
 	obj := a.NewArray()
 	recs := recs_temp.Val()
 
 	//fmt.Println("key = ", key)
-	//fmt.Println("exists.Val() = ", exists.Val())
 	//fmt.Println("recs = ", recs)
 
-	for i, value := range recs {
+	// 通道全部不在营业时间或维护中的渠道不返回
+	open, err := cateTunnelOpen(m.Level, recs, fctx.Time())
+	if err != nil {
+		return "[]", err
+	}
+
+	n := 0
+	for _, value := range recs {
 		val := fastjson.MustParse(value)
-		obj.SetArrayItem(i, val)
+		if !open[string(val.GetStringBytes("id"))] {
+			continue
+		}
+
+		obj.SetArrayItem(n, val)
+		n++
 	}
 
 	str := obj.String()
@@ -394,6 +446,69 @@ func Cate(fctx *fasthttp.RequestCtx) (string, error) {
 	return str, nil
 }
 
+// 检查每个渠道下是否还有可用的通道
+func cateTunnelOpen(level int, recs []string, now time.Time) (map[string]bool, error) {
+
+	open := map[string]bool{}
+	mts, err := MaintenanceActive(now)
+	if err != nil {
+		return open, err
+	}
+
+	ids := make([]string, len(recs))
+	pipe := meta.MerchantRedis.Pipeline()
+	defer pipe.Close()
+
+	ls := make([]*redis.StringSliceCmd, len(recs))
+	for i, value := range recs {
+		ids[i] = string(fastjson.MustParse(value).GetStringBytes("id"))
+		ls[i] = pipe.LRange(ctx, fmt.Sprintf("%s:p:%d:%s", meta.Prefix, level, ids[i]), 0, -1)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return open, pushLog(err, helper.RedisErr)
+	}
+
+	for i, id := range ids {
+		paymentIds := ls[i].Val()
+		rs := make([]*redis.SliceCmd, len(paymentIds))
+		for j, v := range paymentIds {
+			rs[j] = pipe.HMGet(ctx, meta.Prefix+":p:"+v, "id", "cate_id", "st", "et", "weekdays", "quota", "amount")
+		}
+		_, err = pipe.Exec(ctx)
+		if err != nil && err != redis.Nil {
+			return open, pushLog(err, helper.RedisErr)
+		}
+
+		used, err := paymentQuotaUsed(paymentIds, now)
+		if err != nil {
+			return open, err
+		}
+
+		for j := range paymentIds {
+			var p Payment_t
+			if err := rs[j].Scan(&p); err != nil {
+				continue
+			}
+
+			if _, ok := maintenanceMatch(mts, p.CateID, p.ID); ok {
+				continue
+			}
+
+			if paymentQuotaExceed(p.Quota, p.Amount, used[p.ID], zero) {
+				continue
+			}
+
+			if paymentWindowOpen(p.St, p.Et, p.Weekdays, now) {
+				open[id] = true
+				break
+			}
+		}
+	}
+
+	return open, nil
+}
+
 // CreateAutomatic 创建代付的轮询队列
 func CreateAutomatic(level string) {
 
@@ -567,6 +682,7 @@ func Create(level string) {
 			"st":           val.St,
 			"state":        val.State,
 			"amount_list":  val.AmountList,
+			"weekdays":     val.Weekdays,
 		}
 		pipe.LPush(ctx, meta.Prefix+":p:"+level+":"+val.ChannelID, val.ID)
 		pipe.HMSet(ctx, meta.Prefix+":p:"+val.ID, value)
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"strings"
+	"time"
 
 	"finance/contrib/helper"
 
@@ -88,6 +89,21 @@ func ChannelList(cateID, chanID string, device []string) ([]Payment_t, error) {
 		for i := 0; i < ll; i++ {
 			data[i].ChannelName = res[i].Val()
 		}
+
+		// 通道额度使用情况
+		ids := make([]string, ll)
+		for i, v := range data {
+			ids[i] = v.ID
+		}
+		used, err := paymentQuotaUsed(ids, time.Now())
+		if err != nil {
+			return data, err
+		}
+
+		for i := 0; i < ll; i++ {
+			data[i].DayUsed = used[data[i].ID][0].String()
+			data[i].TotalUsed = used[data[i].ID][1].String()
+		}
 	}
 
 	return data, nil
@@ -176,6 +192,9 @@ func ChannelUpdate(param map[string]string, device []string) error {
 		"comment":      param["comment"],
 		"devices":      strings.Join(device, ","),
 		"amount_list":  param["amount_list"],
+		"weekdays":     param["weekdays"],
+		"quota":        param["quota"],
+		"amount":       param["amount"],
 	}
 
 	var dr []g.Record
@@ -0,0 +1,549 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+)
+
+const (
+	creditLevelLock     = "credit:level"
+	creditLevelInterval = time.Hour
+	creditLevelBatch    = 500
+)
+
+// 信用等级变更类型
+const (
+	CreditLogAuto  = 1 // 规则自动调整
+	CreditLogPin   = 2 // 手动固定
+	CreditLogUnpin = 3 // 取消固定
+)
+
+// CreditRule 信用等级自动分配规则, 满足条件的最高等级生效, 数值为0表示不检查
+type CreditRule struct {
+	ID            string  `db:"id" json:"id"`
+	Level         int64   `db:"level" json:"level"`                   // 信用等级
+	DepositAmount float64 `db:"deposit_amount" json:"deposit_amount"` // 累计成功存款金额
+	DepositCount  int64   `db:"deposit_count" json:"deposit_count"`   // 累计成功存款次数
+	AccountDays   int64   `db:"account_days" json:"account_days"`     // 注册天数
+	CancelDays    int64   `db:"cancel_days" json:"cancel_days"`       // 统计取消和冲正订单的天数
+	MaxCancel     int64   `db:"max_cancel" json:"max_cancel"`         // 统计天数内最多允许的取消和冲正订单数
+	State         int     `db:"state" json:"state"`                   // 0 关闭 1 开启
+	UpdatedAt     int64   `db:"updated_at" json:"updated_at"`
+	UpdatedUID    string  `db:"updated_uid" json:"updated_uid"`
+	UpdatedName   string  `db:"updated_name" json:"updated_name"`
+	Prefix        string  `db:"prefix" json:"prefix"`
+}
+
+// MemberCredit 会员当前的信用等级, 固定后不参与自动调整
+type MemberCredit struct {
+	UID         string `db:"uid" json:"uid"`
+	Username    string `db:"username" json:"username"`
+	Level       int64  `db:"level" json:"level"`
+	Pinned      int    `db:"pinned" json:"pinned"`
+	UpdatedAt   int64  `db:"updated_at" json:"updated_at"`
+	UpdatedName string `db:"updated_name" json:"updated_name"`
+	Prefix      string `db:"prefix" json:"prefix"`
+}
+
+// CreditLog 信用等级变更记录
+type CreditLog struct {
+	ID          string `db:"id" json:"id"`
+	UID         string `db:"uid" json:"uid"`
+	Username    string `db:"username" json:"username"`
+	FromLevel   int64  `db:"from_level" json:"from_level"`
+	ToLevel     int64  `db:"to_level" json:"to_level"`
+	Ty          int    `db:"ty" json:"ty"`
+	Remark      string `db:"remark" json:"remark"`
+	CreatedAt   int64  `db:"created_at" json:"created_at"`
+	CreatedUID  string `db:"created_uid" json:"created_uid"`
+	CreatedName string `db:"created_name" json:"created_name"`
+	Prefix      string `db:"prefix" json:"prefix"`
+}
+
+type CreditLogData struct {
+	D []CreditLog `json:"d"`
+	T int64       `json:"t"`
+	S uint16      `json:"s"`
+}
+
+type creditStat struct {
+	Amount sql.NullFloat64 `db:"amount"`
+	Count  sql.NullInt64   `db:"t"`
+}
+
+func CreditRuleList() ([]CreditRule, error) {
+
+	var data []CreditRule
+	query, _, _ := dialect.From("f_credit_rule").Select(colCreditRule...).
+		Where(g.Ex{"prefix": meta.Prefix}).Order(g.C("level").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// CreditRuleUpdate 按信用等级新增或修改规则
+func CreditRuleUpdate(level int64, record g.Record) error {
+
+	var id string
+	ex := g.Ex{
+		"level":  level,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_credit_rule").Select("id").Where(ex).Limit(1).ToSQL()
+	_ = meta.MerchantDB.Get(&id, query)
+
+	if id == "" {
+		record["id"] = helper.GenId()
+		record["level"] = level
+		record["prefix"] = meta.Prefix
+		query, _, _ = dialect.Insert("f_credit_rule").Rows(record).ToSQL()
+	} else {
+		query, _, _ = dialect.Update("f_credit_rule").Set(record).Where(g.Ex{"id": id}).ToSQL()
+	}
+
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+func CreditRuleDelete(id string) error {
+
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Delete("f_credit_rule").Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.RecordNotExistErr)
+	}
+
+	return nil
+}
+
+// CreditLogList 信用等级变更记录
+func CreditLogList(username string, page, pageSize uint16) (CreditLogData, error) {
+
+	data := CreditLogData{}
+	ex := g.Ex{"prefix": meta.Prefix}
+	if username != "" {
+		ex["username"] = username
+	}
+
+	if page == 1 {
+		query, _, _ := dialect.From("f_member_credit_log").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("f_member_credit_log").Select(colCreditLog...).Where(ex).
+		Offset(uint(offset)).Limit(uint(pageSize)).Order(g.C("created_at").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+// CreditLevelPin 手动固定会员的信用等级, level 为0时移出所有信用等级
+func CreditLevelPin(username string, level int64, remark string, admin map[string]string) error {
+
+	mb, err := MemberByUsername(username)
+	if err != nil {
+		return err
+	}
+
+	if level > 0 {
+		var id string
+		ex := g.Ex{
+			"level":  level,
+			"prefix": meta.Prefix,
+		}
+		query, _, _ := dialect.From("f_credit_level").Select("id").Where(ex).Limit(1).ToSQL()
+		_ = meta.MerchantDB.Get(&id, query)
+		if id == "" {
+			return errors.New(helper.RecordNotExistErr)
+		}
+	}
+
+	return creditLevelMove(mb, level, 1, CreditLogPin, remark, admin["id"], admin["name"])
+}
+
+// CreditLevelUnpin 取消固定, 并立即按规则重新计算
+func CreditLevelUnpin(username, remark string, admin map[string]string) error {
+
+	mb, err := MemberByUsername(username)
+	if err != nil {
+		return err
+	}
+
+	mc, err := memberCreditFind(mb.UID)
+	if err != nil {
+		return err
+	}
+
+	if mc.Pinned == 0 {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	err = creditLevelMove(mb, mc.Level, 0, CreditLogUnpin, remark, admin["id"], admin["name"])
+	if err != nil {
+		return err
+	}
+
+	return creditLevelEvaluate(mb.UID)
+}
+
+func memberCreditFind(uid string) (MemberCredit, error) {
+
+	data := MemberCredit{}
+	ex := g.Ex{
+		"uid":    uid,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_member_credit").Select(colMemberCredit...).Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&data, query)
+	if err != nil && err != sql.ErrNoRows {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// 按规则计算会员的信用等级, 固定的会员不调整
+func creditLevelEvaluate(uid string) error {
+
+	rules, err := CreditRuleList()
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	mc, err := memberCreditFind(uid)
+	if err != nil || mc.Pinned == 1 {
+		return err
+	}
+
+	mb := Member{}
+	query, _, _ := dialect.From("tbl_members").Select(colsMember...).Where(g.Ex{"uid": uid, "prefix": meta.Prefix}).ToSQL()
+	err = meta.MerchantDB.Get(&mb, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	stat := creditStat{}
+	ex := g.Ex{
+		"uid":          uid,
+		"prefix":       meta.Prefix,
+		"state":        DepositSuccess,
+		"finance_type": g.Op{"neq": helper.TransactionFinanceDownPoint},
+	}
+	query, _, _ = dialect.From("tbl_deposit").Select(g.SUM("amount").As("amount"), g.COUNT(1).As("t")).Where(ex).ToSQL()
+	err = meta.MerchantDB.Get(&stat, query)
+	if err != nil && err != sql.ErrNoRows {
+		return pushLog(err, helper.DBErr)
+	}
+
+	now := time.Now().Unix()
+	days := (now - int64(mb.CreatedAt)) / 86400
+	cancels := map[int64]int64{}
+	target := int64(0)
+	for _, v := range rules {
+		if v.State != 1 {
+			continue
+		}
+
+		if stat.Amount.Float64 < v.DepositAmount || stat.Count.Int64 < v.DepositCount || days < v.AccountDays {
+			continue
+		}
+
+		if v.CancelDays > 0 {
+			n, ok := cancels[v.CancelDays]
+			if !ok {
+				n, err = creditCancelCount(uid, now-v.CancelDays*86400)
+				if err != nil {
+					return err
+				}
+
+				cancels[v.CancelDays] = n
+			}
+
+			if n > v.MaxCancel {
+				continue
+			}
+		}
+
+		// 规则按等级从高到低排列, 第一个满足的就是最高等级
+		target = v.Level
+		break
+	}
+
+	if target == mc.Level {
+		return nil
+	}
+
+	remark := fmt.Sprintf("存款%.4f, %d笔, 注册%d天", stat.Amount.Float64, stat.Count.Int64, days)
+	return creditLevelMove(mb, target, 0, CreditLogAuto, remark, "0", "系统")
+}
+
+// 统计时间内取消的订单数和被冲正的订单数
+func creditCancelCount(uid string, startAt int64) (int64, error) {
+
+	var n, r int64
+	ex := g.Ex{
+		"uid":        uid,
+		"prefix":     meta.Prefix,
+		"state":      DepositCancelled,
+		"created_at": g.Op{"gte": startAt},
+	}
+	query, _, _ := dialect.From("tbl_deposit").Select(g.COUNT(1)).Where(ex).ToSQL()
+	err := meta.MerchantDB.Get(&n, query)
+	if err != nil {
+		return 0, pushLog(err, helper.DBErr)
+	}
+
+	// 冲正视为拒付, 按冲正时间统计
+	ex = g.Ex{
+		"uid":        uid,
+		"prefix":     meta.Prefix,
+		"created_at": g.Op{"gte": startAt},
+	}
+	query, _, _ = dialect.From("f_deposit_reversal").Select(g.COUNT(1)).Where(ex).ToSQL()
+	err = meta.MerchantDB.Get(&r, query)
+	if err != nil {
+		return 0, pushLog(err, helper.DBErr)
+	}
+
+	return n + r, nil
+}
+
+// 调整会员的信用等级, 会员已在的其他等级渠道(包含手动加入的)全部移出, 加入新等级中还不在的渠道并记录变更
+func creditLevelMove(mb Member, level int64, pinned, ty int, remark, adminUID, adminName string) error {
+
+	mc, err := memberCreditFind(mb.UID)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	ex := g.Ex{
+		"level":  level,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_credit_level").Select("id").Where(ex).ToSQL()
+	err = meta.MerchantDB.Select(&ids, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	// 会员当前所在的渠道
+	var exists []MemberCreditLevel
+	ex = g.Ex{
+		"uid":    mb.UID,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ = dialect.From("f_member_credit_level").Select(colMemberCreditLevel...).Where(ex).ToSQL()
+	err = meta.MerchantDB.Select(&exists, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	target := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		target[id] = true
+	}
+
+	var removes []int64
+	joined := map[string]bool{}
+	for _, v := range exists {
+		if target[v.CreditLevelID] && !joined[v.CreditLevelID] {
+			joined[v.CreditLevelID] = true
+			continue
+		}
+
+		// 不属于新等级的渠道, 以及同一渠道的重复记录
+		removes = append(removes, v.ID)
+	}
+
+	from := mc.Level
+	if from == 0 && len(exists) > 0 {
+		from, err = creditLevelByMember(exists)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().Unix()
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	queries := []string{}
+	if len(removes) > 0 {
+		query, _, _ = dialect.Delete("f_member_credit_level").
+			Where(g.Ex{"id": removes, "uid": mb.UID, "prefix": meta.Prefix}).ToSQL()
+		queries = append(queries, query)
+	}
+
+	var rows []g.Record
+	for _, id := range ids {
+		if joined[id] {
+			continue
+		}
+
+		rows = append(rows, g.Record{
+			"uid":             mb.UID,
+			"username":        mb.Username,
+			"created_at":      now,
+			"credit_level_id": id,
+			"prefix":          meta.Prefix,
+		})
+	}
+
+	if len(rows) > 0 {
+		query, _, _ = dialect.Insert("f_member_credit_level").Rows(rows).ToSQL()
+		queries = append(queries, query)
+	}
+
+	record := g.Record{
+		"uid":          mb.UID,
+		"username":     mb.Username,
+		"level":        level,
+		"pinned":       pinned,
+		"updated_at":   now,
+		"updated_name": adminName,
+		"prefix":       meta.Prefix,
+	}
+	query, _, _ = dialect.Insert("f_member_credit").Rows(record).
+		OnConflict(g.DoUpdate("uid", g.Record{"level": level, "pinned": pinned, "updated_at": now, "updated_name": adminName})).ToSQL()
+	queries = append(queries, query)
+
+	log := g.Record{
+		"id":           helper.GenId(),
+		"uid":          mb.UID,
+		"username":     mb.Username,
+		"from_level":   from,
+		"to_level":     level,
+		"ty":           ty,
+		"remark":       remark,
+		"created_at":   now,
+		"created_uid":  adminUID,
+		"created_name": adminName,
+		"prefix":       meta.Prefix,
+	}
+	query, _, _ = dialect.Insert("f_member_credit_log").Rows(log).ToSQL()
+	queries = append(queries, query)
+
+	for _, v := range queries {
+		_, err = tx.Exec(v)
+		if err != nil {
+			_ = tx.Rollback()
+			return pushLog(err, helper.DBErr)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+// 没有自动分配记录时, 以会员手动加入的渠道中最高的等级作为原等级
+func creditLevelByMember(exists []MemberCreditLevel) (int64, error) {
+
+	var ids []string
+	for _, v := range exists {
+		ids = append(ids, v.CreditLevelID)
+	}
+
+	var level sql.NullInt64
+	query, _, _ := dialect.From("f_credit_level").Select(g.MAX("level")).Where(g.Ex{"id": ids, "prefix": meta.Prefix}).ToSQL()
+	err := meta.MerchantDB.Get(&level, query)
+	if err != nil {
+		return 0, pushLog(err, helper.DBErr)
+	}
+
+	return level.Int64, nil
+}
+
+// CreditLevelTask 定时按规则重新计算有成功存款的会员的信用等级
+func CreditLevelTask() {
+
+	ticker := time.NewTicker(creditLevelInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// 多实例只需要一个执行
+		if Lock(creditLevelLock) != nil {
+			continue
+		}
+
+		creditLevelCheck()
+		Unlock(creditLevelLock)
+	}
+}
+
+func creditLevelCheck() {
+
+	rules, err := CreditRuleList()
+	if err != nil || len(rules) == 0 {
+		return
+	}
+
+	last := ""
+	for {
+		var uids []string
+		ex := g.Ex{
+			"prefix": meta.Prefix,
+			"state":  DepositSuccess,
+			"uid":    g.Op{"gt": last},
+		}
+		query, _, _ := dialect.From("tbl_deposit").Select("uid").Distinct().Where(ex).
+			Order(g.C("uid").Asc()).Limit(creditLevelBatch).ToSQL()
+		err = meta.MerchantDB.Select(&uids, query)
+		if err != nil {
+			_ = pushLog(err, helper.DBErr)
+			return
+		}
+
+		for _, uid := range uids {
+			err = creditLevelEvaluate(uid)
+			if err != nil {
+				fmt.Println("creditLevelEvaluate uid = ", uid, ", err = ", err.Error())
+			}
+		}
+
+		if len(uids) < creditLevelBatch {
+			return
+		}
+
+		last = uids[len(uids)-1]
+	}
+}
@@ -54,14 +54,17 @@ type Deposit struct {
 	TopName         string  `db:"top_name" json:"top_name" redis:"top_name"`                            // 总代用户名
 	Level           int     `db:"level" json:"level" redis:"level"`                                     //会员等级
 	Discount        float64 `db:"discount" json:"discount" redis:"discount"`                            // 存款优惠/存款手续费
+	ApplyAmount     float64 `db:"apply_amount" json:"apply_amount" redis:"apply_amount"`                // 回调金额不一致时的提单金额
+	PaidAmount      float64 `db:"paid_amount" json:"paid_amount" redis:"paid_amount"`                   // 回调金额不一致时的实际支付金额
 	GroupName       string  `db:"-" json:"group_name" redis:"group_name"`                               //团队名称
 }
 
 // 存款数据
 type FDepositData struct {
-	T   int64             `json:"t"`
-	D   []Deposit         `json:"d"`
-	Agg map[string]string `json:"agg"`
+	T        int64               `json:"t"`
+	D        []Deposit           `json:"d"`
+	Agg      map[string]string   `json:"agg"`
+	Receipts map[string][]string `json:"receipts,omitempty"` // 订单id对应的转账截图临时地址
 }
 
 type depositTotal struct {
@@ -80,14 +83,78 @@ func DepositHistory(username, parentName, groupName, id, channelID, oid, state,
 
 	data := FDepositData{}
 
+	cond, ok, err := depositHistoryCond(username, parentName, groupName, id, channelID, oid, state,
+		minAmount, maxAmount, startTime, endTime, cid, timeFlag, flag, ty, dty)
+	if err != nil || !ok {
+		return data, err
+	}
+
+	if page == 1 {
+
+		var total depositTotal
+		query, _, _ := dialect.From("tbl_deposit").Select(g.COUNT(1).As("t"), g.SUM("amount").As("s")).Where(cond).ToSQL()
+		err := meta.MerchantDB.Get(&total, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if total.T.Int64 < 1 {
+			return data, nil
+		}
+
+		data.Agg = map[string]string{
+			"amount": fmt.Sprintf("%.4f", total.S.Float64),
+		}
+
+		data.T = total.T.Int64
+	}
+
+	offset := uint((page - 1) * pageSize)
+	orderField := g.L("username")
+	if sortField != "" {
+		orderField = g.L(sortField)
+	} else {
+		orderField = g.L("sum(company_net_amount)")
+	}
+
+	orderBy := orderField.Desc()
+	if isAsc == 1 {
+		orderBy = orderField.Asc()
+	}
+	query, _, _ := dialect.From("tbl_deposit").Select(colsDeposit...).
+		Where(cond).Offset(offset).Limit(uint(pageSize)).Order(orderBy).ToSQL()
+	err = meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	for i := 0; i < len(data.D); i++ {
+		if data.D[i].ReviewRemark == "undefined" {
+			data.D[i].ReviewRemark = ""
+		}
+		mb, err := GetMemberCache(data.D[i].Username)
+		if err != nil {
+			return data, pushLog(err, helper.RedisErr)
+		}
+		data.D[i].GroupName = mb.GroupName
+	}
+
+	return data, nil
+}
+
+// 存款历史记录查询条件, 列表和导出共用
+// 返回false表示没有符合条件的记录
+func depositHistoryCond(username, parentName, groupName, id, channelID, oid, state,
+	minAmount, maxAmount, startTime, endTime, cid string, timeFlag uint8, flag, ty, dty int) (exp.Expression, bool, error) {
+
 	startAt, err := helper.TimeToLoc(startTime, loc)
 	if err != nil {
-		return data, errors.New(helper.DateTimeErr)
+		return nil, false, errors.New(helper.DateTimeErr)
 	}
 
 	endAt, err := helper.TimeToLoc(endTime, loc)
 	if err != nil {
-		return data, errors.New(helper.DateTimeErr)
+		return nil, false, errors.New(helper.DateTimeErr)
 	}
 	and := g.And(g.C("prefix").Eq(meta.Prefix), g.C("tester").Eq(1))
 	if username != "" {
@@ -102,7 +169,7 @@ func DepositHistory(username, parentName, groupName, id, channelID, oid, state,
 			pushLog(err, helper.DBErr)
 		}
 		if topName == "" {
-			return data, errors.New(helper.UsernameErr)
+			return nil, false, errors.New(helper.UsernameErr)
 		}
 		and = and.Append(g.C("top_name").Eq(topName))
 	}
@@ -113,18 +180,16 @@ func DepositHistory(username, parentName, groupName, id, channelID, oid, state,
 			ex := and.Append(g.C("first_deposit_at").Between(exp.NewRangeVal(startAt, endAt)))
 			var depositAts []FirstDeposit
 			query, _, _ := dialect.From("tbl_members").Select(g.C("first_deposit_at").As("deposit_at"), g.C("uid")).Where(ex).ToSQL()
-			fmt.Println(query)
 			err := meta.MerchantDB.Select(&depositAts, query)
 			if err != nil {
 				pushLog(err, helper.DBErr)
 			}
-			fmt.Println("depositAts:", depositAts)
 			if len(depositAts) > 0 {
 				for _, v := range depositAts {
 					or = or.Append(g.And(g.C("uid").Eq(v.Uid), g.C("created_at").Eq(v.DepositAt)))
 				}
 			} else {
-				return data, nil
+				return nil, false, nil
 			}
 		}
 
@@ -133,18 +198,16 @@ func DepositHistory(username, parentName, groupName, id, channelID, oid, state,
 			ex := and.Append(g.C("second_deposit_at").Between(exp.NewRangeVal(startAt, endAt)))
 			var depositAts []FirstDeposit
 			query, _, _ := dialect.From("tbl_members").Select(g.C("second_deposit_at").As("deposit_at"), g.C("uid")).Where(ex).ToSQL()
-			fmt.Println(query)
 			err := meta.MerchantDB.Select(&depositAts, query)
 			if err != nil {
 				pushLog(err, helper.DBErr)
 			}
-			fmt.Println("depositAts:", depositAts)
 			if len(depositAts) > 0 {
 				for _, v := range depositAts {
 					or = or.Append(g.And(g.C("uid").Eq(v.Uid), g.C("created_at").Eq(v.DepositAt)))
 				}
 			} else {
-				return data, nil
+				return nil, false, nil
 			}
 		}
 
@@ -175,7 +238,7 @@ func DepositHistory(username, parentName, groupName, id, channelID, oid, state,
 	if state != "" && state != "0" {
 		and = and.Append(g.C("state").Eq(state))
 	} else {
-		and = and.Append(g.C("state").In(DepositSuccess, DepositCancelled))
+		and = and.Append(g.C("state").In(DepositSuccess, DepositCancelled, DepositReversed))
 	}
 
 	if ty != 0 {
@@ -190,70 +253,18 @@ func DepositHistory(username, parentName, groupName, id, channelID, oid, state,
 	if minAmount != "" && maxAmount != "" {
 		minF, err := strconv.ParseFloat(minAmount, 64)
 		if err != nil {
-			return data, pushLog(err, helper.AmountErr)
+			return nil, false, pushLog(err, helper.AmountErr)
 		}
 
 		maxF, err := strconv.ParseFloat(maxAmount, 64)
 		if err != nil {
-			return data, pushLog(err, helper.AmountErr)
+			return nil, false, pushLog(err, helper.AmountErr)
 		}
 
 		and = and.Append(g.C("amount").Between(exp.NewRangeVal(minF, maxF)))
 	}
 
-	if page == 1 {
-
-		var total depositTotal
-		query, _, _ := dialect.From("tbl_deposit").Select(g.COUNT(1).As("t"), g.SUM("amount").As("s")).Where(g.And(and, or)).ToSQL()
-		fmt.Println(query)
-		err := meta.MerchantDB.Get(&total, query)
-		if err != nil {
-			return data, pushLog(err, helper.DBErr)
-		}
-
-		if total.T.Int64 < 1 {
-			return data, nil
-		}
-
-		data.Agg = map[string]string{
-			"amount": fmt.Sprintf("%.4f", total.S.Float64),
-		}
-
-		data.T = total.T.Int64
-	}
-
-	offset := uint((page - 1) * pageSize)
-	orderField := g.L("username")
-	if sortField != "" {
-		orderField = g.L(sortField)
-	} else {
-		orderField = g.L("sum(company_net_amount)")
-	}
-
-	orderBy := orderField.Desc()
-	if isAsc == 1 {
-		orderBy = orderField.Asc()
-	}
-	query, _, _ := dialect.From("tbl_deposit").Select(colsDeposit...).
-		Where(g.And(and, or)).Offset(offset).Limit(uint(pageSize)).Order(orderBy).ToSQL()
-	fmt.Println(query)
-	err = meta.MerchantDB.Select(&data.D, query)
-	if err != nil {
-		return data, pushLog(err, helper.DBErr)
-	}
-
-	for i := 0; i < len(data.D); i++ {
-		if data.D[i].ReviewRemark == "undefined" {
-			data.D[i].ReviewRemark = ""
-		}
-		mb, err := GetMemberCache(data.D[i].Username)
-		if err != nil {
-			return data, pushLog(err, helper.RedisErr)
-		}
-		data.D[i].GroupName = mb.GroupName
-	}
-
-	return data, nil
+	return g.And(and, or), true, nil
 }
 
 func DepositDetail(username, state, channelID, timeFlag, startTime, endTime string, page, pageSize int) (FDepositData, error) {
@@ -423,7 +434,7 @@ func DepositReview(did, remark, state, name, uid string) error {
 
 	// 充值成功处理订单状态
 	if iState == DepositSuccess {
-		_ = CacheDepositProcessingRem(order.UID)
+		cacheDepositProcessingSuccess(order.UID, order.ID)
 	}
 
 	err = DepositUpPoint(did, uid, name, remark, iState)
@@ -436,6 +447,11 @@ func DepositReview(did, remark, state, name, uid string) error {
 
 //存款上分
 func DepositUpPoint(did, uid, name, remark string, state int) error {
+	return depositUpPoint(did, uid, name, remark, state, nil)
+}
+
+// extra 为和订单状态一起更新的字段, 包含 amount 时按该金额上分
+func depositUpPoint(did, uid, name, remark string, state int, extra g.Record) error {
 
 	// 判断状态是否合法
 	allow := map[int]bool{
@@ -467,6 +483,7 @@ func DepositUpPoint(did, uid, name, remark string, state int) error {
 	}
 
 	now := time.Now()
+	committed := false
 	record := g.Record{
 		"state":         state,
 		"confirm_at":    now.Unix(),
@@ -474,6 +491,12 @@ func DepositUpPoint(did, uid, name, remark string, state int) error {
 		"confirm_name":  name,
 		"review_remark": remark,
 	}
+	for k, v := range extra {
+		record[k] = v
+	}
+	if v, ok := extra["amount"].(string); ok {
+		order.Amount, _ = strconv.ParseFloat(v, 64)
+	}
 	query, _, _ := dialect.Update("tbl_deposit").Set(record).Where(ex).ToSQL()
 	fmt.Println(query)
 	money := decimal.NewFromFloat(order.Amount)
@@ -612,8 +635,14 @@ func DepositUpPoint(did, uid, name, remark string, state int) error {
 		pd, _ := decimal.NewFromString(promoDiscount)
 		fmt.Println("promoDiscount:", promoDiscount)
 		if pd.GreaterThan(decimal.Zero) {
-			//大于0就是优惠，给钱
-			fee = money.Mul(pd).Div(decimal.NewFromInt(100))
+			//大于0就是优惠，给钱, 超过会员每日优惠上限的部分不发
+			fee = depositPromoCap(order.UID, money.Mul(pd).Div(decimal.NewFromInt(100)), now)
+			// 上分失败时退回占用的当天额度
+			defer func(bonus decimal.Decimal) {
+				if !committed {
+					depositPromoCapRefund(order.UID, bonus, now)
+				}
+			}(fee)
 			money = money.Add(fee)
 			balanceFeeAfter = decimal.NewFromFloat(balance.Balance).Add(money.Abs())
 			feeCashType = helper.TransactionDepositBonus
@@ -703,6 +732,7 @@ func DepositUpPoint(did, uid, name, remark string, state int) error {
 	if err != nil {
 		return pushLog(err, helper.DBErr)
 	}
+	committed = true
 
 	if DepositSuccess == state {
 
@@ -720,8 +750,10 @@ func DepositUpPoint(did, uid, name, remark string, state int) error {
 		if err != nil {
 			fmt.Println("update member first_amount err:", err.Error())
 		}
+		nth := DepositPromoFirst
 		updateRows, _ := result.RowsAffected()
 		if updateRows == 0 {
+			nth = 0
 			rec = g.Record{
 				"second_deposit_at":     order.CreatedAt,
 				"second_deposit_amount": order.Amount,
@@ -732,12 +764,26 @@ func DepositUpPoint(did, uid, name, remark string, state int) error {
 			}
 			query, _, _ = dialect.Update("tbl_members").Set(rec).Where(ex).ToSQL()
 			fmt.Printf("memberSecondDeposit Update: %v\n", query)
-			_, err = meta.MerchantDB.Exec(query)
+			result, err = meta.MerchantDB.Exec(query)
 			if err != nil {
 				fmt.Println("update member second_amount err:", err.Error())
+			} else if n, _ := result.RowsAffected(); n > 0 {
+				nth = DepositPromoSecond
 			}
 		}
 
+		// 有冲正欠款时先从到账金额中扣回
+		depositReversalRecover(order.UID, order.Username)
+
+		// 记录通道优惠, 发放首存和二存优惠
+		go depositPromoApply(order, nth, fee)
+
+		// 累加通道的已收金额
+		paymentQuotaIncr(order.PID, order.Amount)
+
+		// 按存款情况重新计算信用等级
+		go creditLevelEvaluate(order.UID)
+
 		//发送站内信
 		title := "Thông Báo Nạp Tiền Thành Công"
 		content := fmt.Sprintf("Quý Khách Của P3 Thân Mến:\nBạn Đã Nạp Tiền Thành Công %s KVND,Vui Lòng KIểm Tra Ngay,Nếu Bạn Có Bất Cứ Thắc Mắc Vấn Đề Gì Vui Lòng Liên Hệ CSKH Để Biết Thêm Chi Tiết.【P3】Chúc Bạn Cược Đâu Thắng Đó !!\n",
@@ -794,25 +840,55 @@ func DepositOrderFindOne(ex g.Ex) (Deposit, error) {
 // DepositManual 手动补单
 func DepositManual(id, amount, remark, name, uid string) error {
 
+	order, err := depositManualCheck(id, amount)
+	if err != nil {
+		return err
+	}
+
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return errors.New(helper.TransErr)
+	}
+
+	err = depositManualTx(tx, order, amount, remark, name, uid)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.New(helper.TransErr)
+	}
+
+	// 发送消息通知
+	_ = PushMerchantNotify(manualReviewFmt, name, order.Username, amount)
+
+	return nil
+}
+
+// 补单前检查订单状态和金额
+func depositManualCheck(id, amount string) (Deposit, error) {
+
 	money, _ := decimal.NewFromString(amount)
 	if money.Cmp(zero) < 1 {
-		return errors.New(helper.AmountErr)
+		return Deposit{}, errors.New(helper.AmountErr)
 	}
 	// 判断订单是否存在
 	oEx := g.Ex{"id": id, "automatic": 1}
 	order, err := DepositOrderFindOne(oEx)
 	if err != nil {
-		return err
+		return order, err
 	}
 
-	err = lockMemberCheck(order.Username)
+	err = MemberLockCheck(order.UID, LockScopeDeposit, "")
 	if err != nil {
-		return err
+		return order, err
 	}
 
 	// 判断状态
 	if order.State != DepositConfirming {
-		return errors.New(helper.OrderStateErr)
+		return order, errors.New(helper.OrderStateErr)
 	}
 
 	// 判断此订单是否已经已经有一笔补单成功,如果这笔订单的手动补单有一笔成功,则不允许再补单
@@ -824,23 +900,24 @@ func DepositManual(id, amount, remark, name, uid string) error {
 	}
 	_, err = DepositOrderFindOne(existEx)
 	if err != nil && err.Error() == helper.DBErr {
-		return err
+		return order, err
 	}
 	if err == nil {
-		return errors.New(helper.OrderExist)
+		return order, errors.New(helper.OrderExist)
 	}
 
+	return order, nil
+}
+
+// 在事务中生成补单订单并取消原订单, 原订单已不是确认中时返回错误
+func depositManualTx(tx *sql.Tx, order Deposit, amount, remark, name, uid string) error {
+
 	key := meta.Prefix + ":member:" + order.Username
 	tester, err := meta.MerchantRedis.HGet(ctx, key, "tester").Result()
 	if err != nil {
 		tester = "1"
 	}
 
-	tx, err := meta.MerchantDB.Begin()
-	if err != nil {
-		return errors.New(helper.TransErr)
-	}
-
 	now := time.Now()
 	// 生成订单
 	d := g.Record{
@@ -885,50 +962,29 @@ func DepositManual(id, amount, remark, name, uid string) error {
 	_, err = tx.Exec(query)
 	if err != nil {
 		fmt.Println("deposit err = ", err)
-		_ = tx.Rollback()
 		return errors.New(helper.TransErr)
 	}
 
-	// 判断状态，如果处理中则更新状态
-	if order.State == DepositConfirming {
-		// 更新配置
-		ex := g.Ex{"id": order.ID, "prefix": meta.Prefix, "state": DepositConfirming}
-		recs := g.Record{
-			"state":         DepositCancelled,
-			"confirm_at":    now.Unix(),
-			"automatic":     "0",
-			"confirm_uid":   uid,
-			"confirm_name":  name,
-			"review_remark": remark,
-		}
-		query, _, _ = dialect.Update("tbl_deposit").Set(recs).Where(ex).ToSQL()
-		r, err := tx.Exec(query)
-		fmt.Println(r)
-		fmt.Println(err)
-		if err != nil {
-			_ = tx.Rollback()
-			return errors.New(helper.TransErr)
-		}
-		refectRows, err := r.RowsAffected()
-		if err != nil {
-			_ = tx.Rollback()
-			return errors.New(helper.TransErr)
-		}
-		fmt.Println(refectRows)
-
-		if refectRows == 0 {
-			_ = tx.Rollback()
-			return errors.New(helper.TransErr)
-		}
+	// 原订单改为已取消
+	ex := g.Ex{"id": order.ID, "prefix": meta.Prefix, "state": DepositConfirming}
+	recs := g.Record{
+		"state":         DepositCancelled,
+		"confirm_at":    now.Unix(),
+		"automatic":     "0",
+		"confirm_uid":   uid,
+		"confirm_name":  name,
+		"review_remark": remark,
 	}
-
-	err = tx.Commit()
+	query, _, _ = dialect.Update("tbl_deposit").Set(recs).Where(ex).ToSQL()
+	r, err := tx.Exec(query)
 	if err != nil {
 		return errors.New(helper.TransErr)
 	}
 
-	// 发送消息通知
-	_ = PushMerchantNotify(manualReviewFmt, name, order.Username, amount)
+	refectRows, err := r.RowsAffected()
+	if err != nil || refectRows == 0 {
+		return errors.New(helper.TransErr)
+	}
 
 	return nil
 }
@@ -1139,6 +1195,7 @@ func DepositUpPointReview(did, uid, name, remark string, state int) error {
 	}
 
 	now := time.Now()
+	committed := false
 	money := decimal.NewFromFloat(order.Amount)
 	amount := money.String()
 
@@ -1205,8 +1262,14 @@ func DepositUpPointReview(did, uid, name, remark string, state int) error {
 		}
 		pd, _ := decimal.NewFromString(promoDiscount)
 		if pd.GreaterThan(decimal.Zero) {
-			//大于0就是优惠，给钱
-			fee = money.Mul(pd).Div(decimal.NewFromInt(100))
+			//大于0就是优惠，给钱, 超过会员每日优惠上限的部分不发
+			fee = depositPromoCap(order.UID, money.Mul(pd).Div(decimal.NewFromInt(100)), now)
+			// 上分失败时退回占用的当天额度
+			defer func(bonus decimal.Decimal) {
+				if !committed {
+					depositPromoCapRefund(order.UID, bonus, now)
+				}
+			}(fee)
 			money = money.Add(fee)
 			balanceFeeAfter = decimal.NewFromFloat(balance.Balance).Add(money.Abs())
 			feeCashType = helper.TransactionDepositBonus
@@ -1309,6 +1372,7 @@ func DepositUpPointReview(did, uid, name, remark string, state int) error {
 	if err != nil {
 		return pushLog(err, helper.DBErr)
 	}
+	committed = true
 
 	_ = MemberUpdateCache(order.Username)
 
@@ -1330,8 +1394,10 @@ func DepositUpPointReview(did, uid, name, remark string, state int) error {
 			fmt.Println("update member first_amount err:", err.Error())
 		}
 
+		nth := DepositPromoFirst
 		updateRows, _ := result.RowsAffected()
 		if updateRows == 0 {
+			nth = 0
 			rec = g.Record{
 				"second_deposit_at":     order.CreatedAt,
 				"second_deposit_amount": order.Amount,
@@ -1342,12 +1408,26 @@ func DepositUpPointReview(did, uid, name, remark string, state int) error {
 			}
 			query, _, _ = dialect.Update("tbl_members").Set(rec).Where(ex).ToSQL()
 			fmt.Printf("memberSecondDeposit Update: %v\n", query)
-			_, err = meta.MerchantDB.Exec(query)
+			result, err = meta.MerchantDB.Exec(query)
 			if err != nil {
 				fmt.Println("update member second_amount err:", err.Error())
+			} else if n, _ := result.RowsAffected(); n > 0 {
+				nth = DepositPromoSecond
 			}
 		}
 
+		// 有冲正欠款时先从到账金额中扣回
+		depositReversalRecover(order.UID, order.Username)
+
+		// 记录通道优惠, 发放首存和二存优惠
+		go depositPromoApply(order, nth, fee)
+
+		// 累加通道的已收金额
+		paymentQuotaIncr(order.PID, order.Amount)
+
+		// 按存款情况重新计算信用等级
+		go creditLevelEvaluate(order.UID)
+
 		//发送站内信
 		title := "Thông Báo Nạp Tiền Thành Công"
 		content := fmt.Sprintf("Quý Khách Của P3 Thân Mến:\nBạn Đã Nạp Tiền Thành Công %s KVND,Vui Lòng KIểm Tra Ngay,Nếu Bạn Có Bất Cứ Thắc Mắc Vấn Đề Gì Vui Lòng Liên Hệ CSKH Để Biết Thêm Chi Tiết.【P3】Chúc Bạn Cược Đâu Thắng Đó !!\n",
@@ -1388,92 +1468,12 @@ func DepositUSDTReview(did, remark, name, adminUID, depositUID string, state int
 
 	// 充值成功处理订单状态
 	if state == DepositSuccess {
-		_ = CacheDepositProcessingRem(depositUID)
-	}
-
-	return nil
-}
-
-/**
-1：如果用户有连续10笔未支付订单，则限制30分钟不能存款
-（点击存款页面，确定存款按钮时候，轻提示报错：由于您有大量未支付订单，请于30分钟后再试）
-
-2：30分钟后,用户可再次发起存款，如果又有5笔未支付订单，则限制24小时内不能存款
-（点击存款页面，确定存款按钮时候，轻提示报错：由于您有大量未支付订单，请于24小时后再试）
-
-3：24小时后。用户可再次发起存款，如果又有5笔未支付订单，则限制24小时内不能存款
-（点击存款页面，确定存款按钮时候，轻提示报错：由于您有大量未支付订单，请于24小时后再试）
-
-注：有任何一笔存款成功的订单后，此限制就会重置列
-
-用户前9笔订单都是未支付，第10笔订单支付成功，，此时用户存款限制就会重置，又可发起10次未支付订单才会被限制
-*/
-
-// 限制用户存款频率
-func cacheDepositProcessing(uid string, now int64) error {
-
-	pipe := meta.MerchantRedis.TxPipeline()
-	defer pipe.Close()
-
-	// 检查是否被手动锁定
-	manual_lock_key := fmt.Sprintf("%s:finance:mlock:%s", meta.Prefix, uid)
-	automatic_lock_key := fmt.Sprintf("%s:finance:alock:%s", meta.Prefix, uid)
-
-	exists := pipe.Exists(ctx, manual_lock_key)
-
-	// 检查是否被自动锁定
-	rs := pipe.ZRevRangeWithScores(ctx, automatic_lock_key, 0, -1)
-	_, err := pipe.Exec(ctx)
-	if err != nil {
-		return pushLog(err, helper.RedisErr)
-	}
-
-	if exists.Val() != 0 {
-		return errors.New(helper.NoChannelErr)
-	}
-
-	recs, err := rs.Result()
-	if err != nil {
-		return pushLog(err, helper.RedisErr)
-	}
-
-	num := len(recs)
-	if num < 10 {
-		return nil
-	}
-
-	// 十笔 订单 锁定 5 分钟
-	if num == 20 && now < int64(recs[0].Score)+5*60 {
-		// 最后一笔订单的时间
-		return errors.New(helper.EmptyOrder30MinsBlock)
-	}
-
-	// 超出10笔 每隔五笔限制24小时
-	if num > 10 && num%5 == 0 && now < int64(recs[0].Score)+24*60*60 {
-		return errors.New(helper.EmptyOrder5HoursBlock)
+		cacheDepositProcessingSuccess(depositUID, did)
 	}
 
 	return nil
 }
 
-func cacheDepositProcessingInsert(uid, depositId string, now int64) error {
-
-	automatic_lock_key := fmt.Sprintf("%s:finance:alock:%s", meta.Prefix, uid)
-
-	z := redis.Z{
-		Score:  float64(now),
-		Member: depositId,
-	}
-	return meta.MerchantRedis.ZAdd(ctx, automatic_lock_key, &z).Err()
-}
-
-// CacheDepositProcessingRem 清除未未成功的订单计数
-func CacheDepositProcessingRem(uid string) error {
-
-	automatic_lock_key := fmt.Sprintf("%s:finance:alock:%s", meta.Prefix, uid)
-	return meta.MerchantRedis.Unlink(ctx, automatic_lock_key).Err()
-}
-
 // 存入数据库
 func deposit(record g.Record) error {
 
@@ -1508,7 +1508,8 @@ func depositFind(id string) (Deposit, error) {
 	return d, nil
 }
 
-func depositUpdate(state int, order Deposit) error {
+// extra 为和订单状态一起更新的字段, 在订单锁和上分事务内写入
+func depositUpdate(state int, order Deposit, extra g.Record) error {
 
 	// 加锁
 	err := depositLock(order.ID)
@@ -1519,10 +1520,10 @@ func depositUpdate(state int, order Deposit) error {
 
 	// 充值成功处理订单状态
 	if state == DepositSuccess {
-		_ = CacheDepositProcessingRem(order.UID)
+		cacheDepositProcessingSuccess(order.UID, order.ID)
 	}
 
-	err = DepositUpPoint(order.ID, "0", "", "", state)
+	err = depositUpPoint(order.ID, "0", "", "", state, extra)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,257 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 回调金额与订单金额不一致时的处理方式
+const (
+	DepositMismatchPolicyReject    = 0 // 拒绝回调, 订单保持确认中
+	DepositMismatchPolicyActual    = 1 // 按实际支付金额上分
+	DepositMismatchPolicyTolerance = 2 // 差额在允许范围内按实际支付金额上分, 否则拒绝
+	DepositMismatchPolicyReview    = 3 // 进入金额不一致审核列表, 人工确认
+)
+
+// DepositMismatchConf 通道的金额不一致处理配置
+type DepositMismatchConf struct {
+	ChannelID string `json:"channel_id"`
+	Policy    int    `json:"policy"`
+	Tolerance string `json:"tolerance"` // 允许的最大差额(KVND)
+}
+
+func depositMismatchKey() string {
+	return fmt.Sprintf("%s:deposit:mismatch", meta.Prefix)
+}
+
+func DepositMismatchConfList() ([]DepositMismatchConf, error) {
+
+	data := []DepositMismatchConf{}
+	res, err := meta.MerchantRedis.HGetAll(ctx, depositMismatchKey()).Result()
+	if err != nil && err != redis.Nil {
+		return data, pushLog(err, helper.RedisErr)
+	}
+
+	for _, v := range res {
+		conf := DepositMismatchConf{}
+		if helper.JsonUnmarshal([]byte(v), &conf) == nil {
+			data = append(data, conf)
+		}
+	}
+
+	return data, nil
+}
+
+func DepositMismatchConfSet(conf DepositMismatchConf) error {
+
+	_, err := ChannelTypeById(conf.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	b, err := helper.JsonMarshal(conf)
+	if err != nil {
+		return errors.New(helper.FormatErr)
+	}
+
+	err = meta.MerchantRedis.HSet(ctx, depositMismatchKey(), conf.ChannelID, string(b)).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// DepositMismatchConfDelete 删除后该通道恢复为拒绝回调
+func DepositMismatchConfDelete(channelID string) error {
+
+	err := meta.MerchantRedis.HDel(ctx, depositMismatchKey(), channelID).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// 通道未配置时拒绝回调
+func depositMismatchConf(channelID string) DepositMismatchConf {
+
+	conf := DepositMismatchConf{ChannelID: channelID, Policy: DepositMismatchPolicyReject}
+	val, err := meta.MerchantRedis.HGet(ctx, depositMismatchKey(), channelID).Result()
+	if err != nil {
+		if err != redis.Nil {
+			_ = pushLog(err, helper.RedisErr)
+		}
+		return conf
+	}
+
+	_ = helper.JsonUnmarshal([]byte(val), &conf)
+	return conf
+}
+
+// 回调金额与订单金额不一致时按通道配置处理, 返回需要和订单状态一起更新的字段
+// 需要上分时把订单金额改为实际支付金额并记录申请金额, 返回 true 表示订单需要转入人工审核, 不再自动上分
+func depositMismatch(order Deposit, state int, paid string, cent int64) (g.Record, bool, error) {
+
+	conf := depositMismatchConf(order.ChannelID)
+	if conf.Policy == DepositMismatchPolicyReject || state != DepositSuccess {
+		return nil, false, errors.New("invalid amount")
+	}
+
+	pa, err := decimal.NewFromString(paid)
+	if err != nil {
+		return nil, false, errors.New("parse amount error")
+	}
+
+	// 三方回调的金额单位转换为订单的KVND
+	actual := pa.Div(decimal.NewFromInt(cent)).Truncate(4)
+	if actual.LessThanOrEqual(zero) {
+		return nil, false, errors.New("invalid amount")
+	}
+
+	apply := decimal.NewFromFloat(order.Amount)
+	if conf.Policy == DepositMismatchPolicyTolerance {
+		tolerance, _ := decimal.NewFromString(conf.Tolerance)
+		if actual.Sub(apply).Abs().GreaterThan(tolerance) {
+			return nil, false, fmt.Errorf("amount out of tolerance: %s", tolerance.String())
+		}
+	}
+
+	record := g.Record{
+		"apply_amount": apply.String(),
+		"paid_amount":  actual.String(),
+		"amount":       actual.String(),
+	}
+	if conf.Policy != DepositMismatchPolicyReview {
+		return record, false, nil
+	}
+
+	record["state"] = DepositReviewing
+	record["review_remark"] = fmt.Sprintf("金额不一致: 申请%s, 实付%s", apply.String(), actual.String())
+	return record, true, nil
+}
+
+// 金额不一致的订单转入人工审核, 和上分使用同一个订单锁
+func depositMismatchHold(order Deposit, record g.Record) error {
+
+	err := depositLock(order.ID)
+	if err != nil {
+		return err
+	}
+	defer depositUnLock(order.ID)
+
+	ex := g.Ex{
+		"id":    order.ID,
+		"state": DepositConfirming,
+	}
+	query, _, _ := dialect.Update("tbl_deposit").Set(record).Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	return nil
+}
+
+// DepositMismatchList 金额不一致待审核的三方订单
+func DepositMismatchList(ex g.Ex, page, pageSize uint16) (FDepositData, error) {
+
+	data := FDepositData{}
+	ex["prefix"] = meta.Prefix
+	ex["state"] = DepositReviewing
+	ex["flag"] = DepositFlagThird
+	ex["paid_amount"] = g.Op{"gt": 0}
+
+	if page == 1 {
+		query, _, _ := dialect.From("tbl_deposit").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("tbl_deposit").Select(colsDeposit...).
+		Where(ex).Offset(uint(offset)).Limit(uint(pageSize)).Order(g.C("created_at").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// DepositMismatchReview 审核金额不一致的订单, 通过时按实际支付金额上分
+func DepositMismatchReview(id, remark, name, adminUID string, state int) error {
+
+	err := depositLock(id)
+	if err != nil {
+		return err
+	}
+	defer depositUnLock(id)
+
+	order, err := DepositOrderFindOne(g.Ex{"id": id, "state": DepositReviewing, "flag": DepositFlagThird})
+	if err != nil {
+		return err
+	}
+
+	if order.PaidAmount <= 0 {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	err = DepositUpPointReview(id, adminUID, name, remark, state)
+	if err != nil {
+		return err
+	}
+
+	if state == DepositSuccess {
+		cacheDepositProcessingSuccess(order.UID, order.ID)
+		depositMismatchNotice(id)
+	}
+
+	return nil
+}
+
+// 按实际支付金额上分后通知会员申请金额和到账金额
+func depositMismatchNotice(id string) {
+
+	order, err := DepositFindOne(id)
+	if err != nil || order.State != DepositSuccess || order.PaidAmount <= 0 {
+		return
+	}
+
+	apply := decimal.NewFromFloat(order.ApplyAmount).Truncate(0).String()
+	paid := decimal.NewFromFloat(order.PaidAmount).Truncate(0).String()
+
+	//发送站内信
+	title := "Thông Báo Chênh Lệch Số Tiền Nạp"
+	content := fmt.Sprintf("Quý Khách Của P3 Thân Mến:\nĐơn Nạp %s Của Bạn Đăng Ký %s KVND, Số Tiền Thực Tế Đã Thanh Toán Là %s KVND, Hệ Thống Đã Cộng %s KVND Vào Tài Khoản.Nếu Bạn Có Bất Cứ Thắc Mắc Vấn Đề Gì Vui Lòng Liên Hệ CSKH Để Biết Thêm Chi Tiết.【P3】\n",
+		order.ID, apply, paid, paid)
+	err = messageSend(order.ID, title, content, "system", meta.Prefix, 0, 0, 1, []string{order.Username})
+	if err != nil {
+		_ = pushLog(err, helper.ESErr)
+	}
+
+	//发送推送
+	msg := fmt.Sprintf(`{"ty":"1","amount": "%f", "apply_amount": "%f", "ts":"%d","status":"mismatch"}`, order.PaidAmount, order.ApplyAmount, time.Now().Unix())
+	topic := fmt.Sprintf("%s/%s/finance", meta.Prefix, order.UID)
+	err = Publish(topic, []byte(msg))
+	if err != nil {
+		fmt.Println("merchantNats.Publish finance = ", err.Error())
+	}
+}
@@ -0,0 +1,334 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+)
+
+// DepositPolicyStep 限制阶梯, 未支付订单数达到Num后限制Cooldown秒, 最后一级重复使用
+type DepositPolicyStep struct {
+	Num      int64 `json:"num"`
+	Cooldown int64 `json:"cooldown"`
+}
+
+// DepositPolicy 存款防刷策略, level 为0时对所有会员等级生效
+type DepositPolicy struct {
+	ID             string `db:"id" json:"id"`
+	Level          int    `db:"level" json:"level"`
+	Window         int64  `db:"window" json:"window"`                     // 统计未支付订单的时间窗口(秒), 0 不限
+	Steps          string `db:"steps" json:"steps"`                       // 限制阶梯json
+	ResetOnSuccess int    `db:"reset_on_success" json:"reset_on_success"` // 1 存款成功后重置
+	ResetAfter     int64  `db:"reset_after" json:"reset_after"`           // 超过多少秒没有新订单后重置, 0 不重置
+	State          int    `db:"state" json:"state"`                       // 0 关闭 1 开启
+	UpdatedAt      int64  `db:"updated_at" json:"updated_at"`
+	UpdatedUID     string `db:"updated_uid" json:"updated_uid"`
+	UpdatedName    string `db:"updated_name" json:"updated_name"`
+	Prefix         string `db:"prefix" json:"prefix"`
+}
+
+// DepositThrottle 会员当前的存款限制状态
+type DepositThrottle struct {
+	UID        string        `json:"uid"`
+	Username   string        `json:"username"`
+	Level      int           `json:"level"`
+	Policy     DepositPolicy `json:"policy"`
+	Unpaid     int64         `json:"unpaid"`      // 当前阶梯已累计的未支付订单数
+	Step       int           `json:"step"`        // 当前阶梯
+	Until      int64         `json:"until"`       // 限制结束时间
+	Last       int64         `json:"last"`        // 最后一笔订单时间
+	ManualLock bool          `json:"manual_lock"` // 是否被限制存款
+}
+
+// 未配置策略时使用的默认策略: 10笔未支付限制30分钟, 之后每5笔限制24小时, 存款成功重置
+var depositPolicyDefault = DepositPolicy{
+	Steps:          `[{"num":10,"cooldown":1800},{"num":5,"cooldown":86400}]`,
+	ResetOnSuccess: 1,
+	State:          1,
+}
+
+func DepositPolicyList() ([]DepositPolicy, error) {
+
+	var data []DepositPolicy
+	query, _, _ := dialect.From("f_deposit_policy").Select(colDepositPolicy...).
+		Where(g.Ex{"prefix": meta.Prefix}).Order(g.C("level").Asc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// DepositPolicyUpdate 按会员等级新增或修改策略
+func DepositPolicyUpdate(level int, record g.Record) error {
+
+	var id string
+	ex := g.Ex{
+		"level":  level,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_deposit_policy").Select("id").Where(ex).Limit(1).ToSQL()
+	_ = meta.MerchantDB.Get(&id, query)
+
+	if id == "" {
+		record["id"] = helper.GenId()
+		record["level"] = level
+		record["prefix"] = meta.Prefix
+		query, _, _ = dialect.Insert("f_deposit_policy").Rows(record).ToSQL()
+	} else {
+		query, _, _ = dialect.Update("f_deposit_policy").Set(record).Where(g.Ex{"id": id}).ToSQL()
+	}
+
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return DepositPolicyUpdateCache()
+}
+
+// DepositPolicyUpdateCache 策略写入redis, 存款时从redis读取
+func DepositPolicyUpdateCache() error {
+
+	data, err := DepositPolicyList()
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s:deposit:policy", meta.Prefix)
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.Unlink(ctx, key)
+	for _, v := range data {
+		b, err := helper.JsonMarshal(v)
+		if err != nil {
+			return errors.New(helper.FormatErr)
+		}
+
+		pipe.HSet(ctx, key, fmt.Sprintf("%d", v.Level), string(b))
+	}
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// DepositPolicyCheckSteps 检查限制阶梯格式
+func DepositPolicyCheckSteps(steps string) bool {
+
+	var data []DepositPolicyStep
+	err := helper.JsonUnmarshal([]byte(steps), &data)
+	if err != nil || len(data) == 0 {
+		return false
+	}
+
+	for _, v := range data {
+		if v.Num <= 0 || v.Cooldown <= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 会员等级的策略, 优先使用等级配置, 其次使用全部等级的配置, 都没有时使用默认策略
+func depositPolicyGet(level int) (DepositPolicy, []DepositPolicyStep) {
+
+	policy := depositPolicyDefault
+	key := fmt.Sprintf("%s:deposit:policy", meta.Prefix)
+	res, err := meta.MerchantRedis.HMGet(ctx, key, fmt.Sprintf("%d", level), "0").Result()
+	if err == nil {
+		for _, v := range res {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+
+			p := DepositPolicy{}
+			if helper.JsonUnmarshal([]byte(s), &p) == nil {
+				policy = p
+				break
+			}
+		}
+	}
+
+	var steps []DepositPolicyStep
+	_ = helper.JsonUnmarshal([]byte(policy.Steps), &steps)
+	return policy, steps
+}
+
+func depositThrottleKey(uid string) (string, string) {
+	return fmt.Sprintf("%s:finance:alock:%s", meta.Prefix, uid), fmt.Sprintf("%s:finance:alock:state:%s", meta.Prefix, uid)
+}
+
+// 限制用户存款频率
+func cacheDepositProcessing(user Member, now int64) error {
+
+	policy, _ := depositPolicyGet(user.Level)
+	if policy.State != 1 {
+		return nil
+	}
+
+	_, stateKey := depositThrottleKey(user.UID)
+	state, err := meta.MerchantRedis.HMGet(ctx, stateKey, "until", "blocked").Result()
+	if err != nil && err != redis.Nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	until, _ := state[0].(string)
+	ts, _ := strconv.ParseInt(until, 10, 64)
+	if now >= ts {
+		return nil
+	}
+
+	// 第一级阶梯触发的限制沿用原来的30分钟错误码, 之后的阶梯使用5小时错误码
+	blocked, _ := state[1].(string)
+	if blocked == "" || blocked == "0" {
+		return errors.New(helper.EmptyOrder30MinsBlock)
+	}
+
+	return errors.New(helper.EmptyOrder5HoursBlock)
+}
+
+// 记录存款行为, 未支付订单数达到当前阶梯时开始限制并进入下一阶梯
+func cacheDepositProcessingInsert(user Member, depositId string, now int64) error {
+
+	policy, steps := depositPolicyGet(user.Level)
+	if policy.State != 1 || len(steps) == 0 {
+		return nil
+	}
+
+	orderKey, stateKey := depositThrottleKey(user.UID)
+	state, err := meta.MerchantRedis.HGetAll(ctx, stateKey).Result()
+	if err != nil && err != redis.Nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	// 长时间没有新订单, 重置
+	last, _ := strconv.ParseInt(state["last"], 10, 64)
+	if policy.ResetAfter > 0 && last > 0 && now-last > policy.ResetAfter {
+		_ = CacheDepositProcessingRem(user.UID)
+		state = map[string]string{}
+	}
+
+	step, _ := strconv.Atoi(state["step"])
+	if step >= len(steps) {
+		step = len(steps) - 1
+	}
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.ZAdd(ctx, orderKey, &redis.Z{Score: float64(now), Member: depositId})
+	if policy.Window > 0 {
+		pipe.ZRemRangeByScore(ctx, orderKey, "-inf", fmt.Sprintf("(%d", now-policy.Window))
+	}
+	num := pipe.ZCard(ctx, orderKey)
+	pipe.HSet(ctx, stateKey, "last", now, "level", user.Level)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	if num.Val() < steps[step].Num {
+		return nil
+	}
+
+	next := step + 1
+	if next >= len(steps) {
+		next = len(steps) - 1
+	}
+
+	pipe.Unlink(ctx, orderKey)
+	pipe.HSet(ctx, stateKey, "step", next, "blocked", step, "until", now+steps[step].Cooldown)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// 存款成功的订单不再计入未支付订单, 并按策略重置, 使用下单时记录的会员等级
+func cacheDepositProcessingSuccess(uid, depositId string) {
+
+	orderKey, stateKey := depositThrottleKey(uid)
+	level, _ := meta.MerchantRedis.HGet(ctx, stateKey, "level").Int()
+	policy, _ := depositPolicyGet(level)
+	if policy.ResetOnSuccess == 1 {
+		_ = CacheDepositProcessingRem(uid)
+		return
+	}
+
+	err := meta.MerchantRedis.ZRem(ctx, orderKey, depositId).Err()
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+}
+
+// CacheDepositProcessingRem 清除未未成功的订单计数和限制状态
+func CacheDepositProcessingRem(uid string) error {
+
+	orderKey, stateKey := depositThrottleKey(uid)
+	return meta.MerchantRedis.Unlink(ctx, orderKey, stateKey).Err()
+}
+
+// DepositThrottleFind 查询会员当前的存款限制状态
+func DepositThrottleFind(username string) (DepositThrottle, error) {
+
+	data := DepositThrottle{}
+	mb, err := MemberByUsername(username)
+	if err != nil {
+		return data, err
+	}
+
+	data.UID = mb.UID
+	data.Username = mb.Username
+	data.Level = mb.Level
+	data.Policy, _ = depositPolicyGet(mb.Level)
+
+	orderKey, stateKey := depositThrottleKey(mb.UID)
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	num := pipe.ZCard(ctx, orderKey)
+	state := pipe.HGetAll(ctx, stateKey)
+	_, err = pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return data, pushLog(err, helper.RedisErr)
+	}
+
+	data.Unpaid = num.Val()
+	data.ManualLock = MemberLockCheck(mb.UID, LockScopeDeposit, "") != nil
+	data.Step, _ = strconv.Atoi(state.Val()["step"])
+	data.Until, _ = strconv.ParseInt(state.Val()["until"], 10, 64)
+	data.Last, _ = strconv.ParseInt(state.Val()["last"], 10, 64)
+	if data.Until < time.Now().Unix() {
+		data.Until = 0
+	}
+
+	return data, nil
+}
+
+// DepositThrottleClear 清除会员的存款限制
+func DepositThrottleClear(username string) error {
+
+	mb, err := MemberByUsername(username)
+	if err != nil {
+		return err
+	}
+
+	return CacheDepositProcessingRem(mb.UID)
+}
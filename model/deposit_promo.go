@@ -0,0 +1,515 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 存款优惠类型
+const (
+	DepositPromoTunnel = 0 // 通道存款优惠
+	DepositPromoFirst  = 1 // 首存优惠
+	DepositPromoSecond = 2 // 二存优惠
+)
+
+// DepositPromoRule 首存和二存优惠规则, cate_id 为0时对所有渠道生效
+type DepositPromoRule struct {
+	ID            string `db:"id" json:"id"`
+	Ty            int    `db:"ty" json:"ty"` // 1 首存 2 二存
+	CateID        string `db:"cate_id" json:"cate_id"`
+	Rate          string `db:"rate" json:"rate"`             // 优惠比例(%)
+	MaxBonus      string `db:"max_bonus" json:"max_bonus"`   // 单笔最高优惠, 0为不限制
+	MinAmount     string `db:"min_amount" json:"min_amount"` // 最低存款金额
+	TurnoverMulti int    `db:"turnover_multi" json:"turnover_multi"`
+	State         int    `db:"state" json:"state"`
+	UpdatedAt     int64  `db:"updated_at" json:"updated_at"`
+	UpdatedUID    string `db:"updated_uid" json:"updated_uid"`
+	UpdatedName   string `db:"updated_name" json:"updated_name"`
+	Prefix        string `db:"prefix" json:"prefix"`
+}
+
+// DepositPromo 发放的存款优惠, 流水要求为 (存款金额+优惠) * 流水倍数
+type DepositPromo struct {
+	ID            string  `db:"id" json:"id"`
+	DepositID     string  `db:"deposit_id" json:"deposit_id"`
+	UID           string  `db:"uid" json:"uid"`
+	Username      string  `db:"username" json:"username"`
+	Ty            int     `db:"ty" json:"ty"` // 0 通道优惠 1 首存 2 二存
+	CateID        string  `db:"cate_id" json:"cate_id"`
+	ChannelID     string  `db:"channel_id" json:"channel_id"`
+	DepositAmount float64 `db:"deposit_amount" json:"deposit_amount"`
+	Bonus         float64 `db:"bonus" json:"bonus"`
+	TurnoverMulti int     `db:"turnover_multi" json:"turnover_multi"`
+	Turnover      float64 `db:"turnover" json:"turnover"`
+	State         int     `db:"state" json:"state"` // 流水要求 0 未完成 1 已完成
+	FinishAt      int64   `db:"finish_at" json:"finish_at"`
+	FinishName    string  `db:"finish_name" json:"finish_name"`
+	CreatedAt     int64   `db:"created_at" json:"created_at"`
+	Prefix        string  `db:"prefix" json:"prefix"`
+}
+
+// DepositPromoConf 存款优惠配置
+type DepositPromoConf struct {
+	DayCap      string `json:"day_cap" redis:"day_cap"`           // 每个会员每天最多获得的优惠, 0为不限制
+	TunnelMulti int    `json:"tunnel_multi" redis:"tunnel_multi"` // 通道优惠的流水倍数
+}
+
+// DepositPromoReport 按渠道统计的优惠成本
+type DepositPromoReport struct {
+	ChannelID string  `db:"channel_id" json:"channel_id"`
+	CateID    string  `db:"cate_id" json:"cate_id"`
+	Ty        int     `db:"ty" json:"ty"`
+	T         int64   `db:"t" json:"t"`
+	Amount    float64 `db:"amount" json:"amount"` // 存款金额
+	Bonus     float64 `db:"bonus" json:"bonus"`   // 优惠金额
+}
+
+func DepositPromoConfGet() (DepositPromoConf, error) {
+
+	conf := DepositPromoConf{DayCap: "0"}
+	key := fmt.Sprintf("%s:deposit:promo:conf", meta.Prefix)
+	err := meta.MerchantRedis.HGetAll(ctx, key).Scan(&conf)
+	if err != nil && err != redis.Nil {
+		return conf, pushLog(err, helper.RedisErr)
+	}
+
+	if conf.DayCap == "" {
+		conf.DayCap = "0"
+	}
+
+	return conf, nil
+}
+
+func DepositPromoConfSet(conf DepositPromoConf) error {
+
+	key := fmt.Sprintf("%s:deposit:promo:conf", meta.Prefix)
+	err := meta.MerchantRedis.HSet(ctx, key,
+		"day_cap", conf.DayCap,
+		"tunnel_multi", conf.TunnelMulti,
+	).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+func DepositPromoRuleList() ([]DepositPromoRule, error) {
+
+	var data []DepositPromoRule
+	query, _, _ := dialect.From("f_deposit_promo_rule").Select(colDepositPromoRule...).
+		Where(g.Ex{"prefix": meta.Prefix}).Order(g.C("ty").Asc(), g.C("cate_id").Asc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// DepositPromoRuleUpdate 按优惠类型和渠道新增或修改规则
+func DepositPromoRuleUpdate(ty int, cateID string, record g.Record) error {
+
+	if cateID != "0" {
+		cate, err := CateByID(cateID)
+		if err != nil {
+			return err
+		}
+
+		if len(cate.ID) == 0 {
+			return errors.New(helper.CateNotExist)
+		}
+	}
+
+	var id string
+	ex := g.Ex{
+		"ty":      ty,
+		"cate_id": cateID,
+		"prefix":  meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_deposit_promo_rule").Select("id").Where(ex).Limit(1).ToSQL()
+	_ = meta.MerchantDB.Get(&id, query)
+
+	if id == "" {
+		record["id"] = helper.GenId()
+		record["ty"] = ty
+		record["cate_id"] = cateID
+		record["prefix"] = meta.Prefix
+		query, _, _ = dialect.Insert("f_deposit_promo_rule").Rows(record).ToSQL()
+	} else {
+		query, _, _ = dialect.Update("f_deposit_promo_rule").Set(record).Where(g.Ex{"id": id}).ToSQL()
+	}
+
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+func DepositPromoRuleDelete(id string) error {
+
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Delete("f_deposit_promo_rule").Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.RecordNotExistErr)
+	}
+
+	return nil
+}
+
+// DepositPromoReportList 按渠道和优惠类型统计优惠成本
+func DepositPromoReportList(startTime, endTime string) ([]DepositPromoReport, error) {
+
+	var data []DepositPromoReport
+	startAt, err := helper.TimeToLoc(startTime, loc)
+	if err != nil {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	endAt, err := helper.TimeToLoc(endTime, loc)
+	if err != nil || endAt < startAt {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	ex := g.Ex{
+		"prefix":     meta.Prefix,
+		"created_at": g.Op{"between": g.Range(startAt, endAt)},
+	}
+	query, _, _ := dialect.From("f_deposit_promo").
+		Select(g.C("channel_id"), g.C("cate_id"), g.C("ty"), g.COUNT(1).As("t"),
+			g.SUM("deposit_amount").As("amount"), g.SUM("bonus").As("bonus")).
+		Where(ex).GroupBy("channel_id", "cate_id", "ty").Order(g.C("bonus").Desc()).ToSQL()
+	err = meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// 会员当天已获得的优惠, 按商户时区的日期分key
+func depositPromoDayKey(now time.Time) string {
+	return fmt.Sprintf("%s:deposit:promo:day:%s", meta.Prefix, now.In(loc).Format("20060102"))
+}
+
+// 按会员每日上限扣减优惠金额, 返回实际可以发放的金额并占用当天额度
+func depositPromoCap(uid string, bonus decimal.Decimal, now time.Time) decimal.Decimal {
+
+	if bonus.LessThanOrEqual(zero) {
+		return bonus
+	}
+
+	conf, err := DepositPromoConfGet()
+	if err != nil {
+		return zero
+	}
+
+	limit, _ := decimal.NewFromString(conf.DayCap)
+	if limit.LessThanOrEqual(zero) {
+		return bonus
+	}
+
+	y, m, d := now.In(loc).Date()
+	key := depositPromoDayKey(now)
+	val, _ := bonus.Float64()
+
+	// 先占用再检查, 超出的部分退回
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	used := pipe.HIncrByFloat(ctx, key, uid, val)
+	pipe.ExpireAt(ctx, key, time.Date(y, m, d+1, 23, 59, 59, 0, loc))
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+		return zero
+	}
+
+	total := decimal.NewFromFloat(used.Val())
+	if total.LessThanOrEqual(limit) {
+		return bonus
+	}
+
+	over := decimal.Min(total.Sub(limit), bonus)
+	back, _ := over.Float64()
+	meta.MerchantRedis.HIncrByFloat(ctx, key, uid, -back)
+
+	return bonus.Sub(over)
+}
+
+// 优惠发放失败时退回占用的当天额度
+func depositPromoCapRefund(uid string, bonus decimal.Decimal, now time.Time) {
+
+	if bonus.LessThanOrEqual(zero) {
+		return
+	}
+
+	val, _ := bonus.Float64()
+	err := meta.MerchantRedis.HIncrByFloat(ctx, depositPromoDayKey(now), uid, -val).Err()
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+}
+
+// 按存款次数和渠道查找生效的规则, 指定渠道的规则优先
+func depositPromoRule(ty int, cateID string) (DepositPromoRule, bool) {
+
+	var data []DepositPromoRule
+	ex := g.Ex{
+		"ty":      ty,
+		"cate_id": []string{cateID, "0"},
+		"state":   1,
+		"prefix":  meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_deposit_promo_rule").Select(colDepositPromoRule...).Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return DepositPromoRule{}, false
+	}
+
+	for _, v := range data {
+		if v.CateID == cateID {
+			return v, true
+		}
+	}
+
+	if len(data) > 0 {
+		return data[0], true
+	}
+
+	return DepositPromoRule{}, false
+}
+
+// 写入优惠发放记录, 用于流水要求和成本统计
+func depositPromoRecord(order Deposit, ty int, bonus decimal.Decimal, multi int) {
+
+	if bonus.LessThanOrEqual(zero) {
+		return
+	}
+
+	amount := decimal.NewFromFloat(order.Amount)
+	b, _ := bonus.Float64()
+	turnover, _ := amount.Add(bonus).Mul(decimal.NewFromInt(int64(multi))).Float64()
+	rec := DepositPromo{
+		ID:            helper.GenId(),
+		DepositID:     order.ID,
+		UID:           order.UID,
+		Username:      order.Username,
+		Ty:            ty,
+		CateID:        order.CID,
+		ChannelID:     order.ChannelID,
+		DepositAmount: order.Amount,
+		Bonus:         b,
+		TurnoverMulti: multi,
+		Turnover:      turnover,
+		CreatedAt:     time.Now().Unix(),
+		Prefix:        meta.Prefix,
+	}
+	query, _, _ := dialect.Insert("f_deposit_promo").Rows(rec).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+	}
+}
+
+// 存款成功后记录通道优惠并发放首存/二存优惠, nth 为会员第几次存款成功
+func depositPromoApply(order Deposit, nth int, tunnelBonus decimal.Decimal) {
+
+	if tunnelBonus.GreaterThan(zero) {
+		conf, _ := DepositPromoConfGet()
+		depositPromoRecord(order, DepositPromoTunnel, tunnelBonus, conf.TunnelMulti)
+	}
+
+	if nth != DepositPromoFirst && nth != DepositPromoSecond {
+		return
+	}
+
+	rule, ok := depositPromoRule(nth, order.CID)
+	if !ok {
+		return
+	}
+
+	amount := decimal.NewFromFloat(order.Amount)
+	minAmount, _ := decimal.NewFromString(rule.MinAmount)
+	if amount.LessThan(minAmount) {
+		return
+	}
+
+	rate, _ := decimal.NewFromString(rule.Rate)
+	bonus := amount.Mul(rate).Div(decimal.NewFromInt(100)).Truncate(4)
+	maxBonus, _ := decimal.NewFromString(rule.MaxBonus)
+	if maxBonus.GreaterThan(zero) && bonus.GreaterThan(maxBonus) {
+		bonus = maxBonus
+	}
+
+	// 同一订单同一类型只发放一次
+	var n int64
+	query, _, _ := dialect.From("f_deposit_promo").Select(g.COUNT(1)).
+		Where(g.Ex{"deposit_id": order.ID, "ty": nth, "prefix": meta.Prefix}).ToSQL()
+	err := meta.MerchantDB.Get(&n, query)
+	if err != nil || n > 0 {
+		return
+	}
+
+	// 确认未发放后再占用当天额度, 发放失败时退回
+	now := time.Now()
+	bonus = depositPromoCap(order.UID, bonus, now)
+	if bonus.LessThanOrEqual(zero) {
+		return
+	}
+
+	err = depositPromoCredit(order, bonus)
+	if err != nil {
+		depositPromoCapRefund(order.UID, bonus, now)
+		fmt.Println("depositPromoCredit id = ", order.ID, ", err = ", err.Error())
+		return
+	}
+
+	depositPromoRecord(order, nth, bonus, rule.TurnoverMulti)
+}
+
+// 发放优惠到会员余额, 账变类型为存款优惠
+func depositPromoCredit(order Deposit, bonus decimal.Decimal) error {
+
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	// 锁定会员后读取余额, 保证账变前后余额与并发的上下分一致
+	before, err := memberBalanceLock(tx, order.UID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	ex := g.Ex{
+		"uid":    order.UID,
+		"prefix": meta.Prefix,
+	}
+	br := g.Record{
+		"balance": g.L(fmt.Sprintf("balance+%s", bonus.String())),
+	}
+	query, _, _ := dialect.Update("tbl_members").Set(br).Where(ex).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	mbTrans := memberTransaction{
+		AfterAmount:  before.Add(bonus).String(),
+		Amount:       bonus.String(),
+		BeforeAmount: before.String(),
+		BillNo:       order.ID,
+		CreatedAt:    time.Now().UnixMilli(),
+		ID:           helper.GenId(),
+		CashType:     helper.TransactionDepositBonus,
+		UID:          order.UID,
+		Username:     order.Username,
+		Prefix:       meta.Prefix,
+	}
+	query, _, _ = dialect.Insert("tbl_balance_transaction").Rows(mbTrans).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	_ = MemberUpdateCache(order.Username)
+	return nil
+}
+
+// DepositPromoTurnoverList 会员未完成流水要求的优惠记录
+func DepositPromoTurnoverList(username string) ([]DepositPromo, error) {
+
+	data := []DepositPromo{}
+	mb, err := MemberByUsername(username)
+	if err != nil {
+		return data, err
+	}
+
+	ex := g.Ex{
+		"uid":      mb.UID,
+		"state":    0,
+		"turnover": g.Op{"gt": 0},
+		"prefix":   meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_deposit_promo").Select(colDepositPromo...).Where(ex).Order(g.C("created_at").Desc()).ToSQL()
+	err = meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// 提款完成存款流水后按存款订单逐条完成之前发放的优惠流水要求, at 之后的优惠留到下一次提款
+func depositPromoFinish(uid, adminName string, at int64) {
+
+	var ids []string
+	ex := g.Ex{
+		"uid":        uid,
+		"state":      0,
+		"created_at": g.Op{"lte": at},
+		"prefix":     meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_deposit_promo").Select("deposit_id").Distinct().Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&ids, query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	for _, id := range ids {
+		err = depositPromoClose(id, adminName)
+		if err != nil {
+			fmt.Println("depositPromoClose id = ", id, ", err = ", err.Error())
+		}
+	}
+}
+
+// 按存款订单完成优惠流水要求
+func depositPromoClose(depositID, adminName string) error {
+
+	ex := g.Ex{
+		"deposit_id": depositID,
+		"state":      0,
+		"prefix":     meta.Prefix,
+	}
+	record := g.Record{
+		"state":       1,
+		"finish_at":   time.Now().Unix(),
+		"finish_name": adminName,
+	}
+	query, _, _ := dialect.Update("f_deposit_promo").Set(record).Where(ex).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	DepositReceiptMaxSize = 5 << 20 // 单张截图最大5M
+	depositReceiptMax     = 3       // 每笔订单最多上传的截图数量
+	depositReceiptExpires = 10 * time.Minute
+)
+
+// 允许上传的图片类型和扩展名
+var depositReceiptTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+}
+
+// DepositReceipt 会员上传的线下存款转账截图
+type DepositReceipt struct {
+	ID          string `db:"id" json:"id"`
+	DepositID   string `db:"deposit_id" json:"deposit_id"`
+	UID         string `db:"uid" json:"uid"`
+	Username    string `db:"username" json:"username"`
+	Storage     int    `db:"storage" json:"storage"` // 1 minio 2 本地
+	Path        string `db:"path" json:"path"`
+	ContentType string `db:"content_type" json:"content_type"`
+	Size        int64  `db:"size" json:"size"`
+	CreatedAt   int64  `db:"created_at" json:"created_at"`
+	Prefix      string `db:"prefix" json:"prefix"`
+}
+
+// DepositReceiptUpload 会员为未完成的线下转卡和线下USDT订单上传转账截图
+func DepositReceiptUpload(uid, username, depositID string, content []byte) error {
+
+	if len(content) == 0 || len(content) > DepositReceiptMaxSize {
+		return errors.New(helper.FormatErr)
+	}
+
+	// 按文件内容判断类型, 不信任扩展名
+	contentType := http.DetectContentType(content)
+	ext, ok := depositReceiptTypes[contentType]
+	if !ok {
+		return errors.New(helper.FormatErr)
+	}
+
+	ex := g.Ex{
+		"id":    depositID,
+		"uid":   uid,
+		"flag":  []int{DepositFlagManual, DepositFlagUSDT},
+		"state": []int{DepositConfirming, DepositReviewing},
+	}
+	_, err := DepositOrderFindOne(ex)
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	query, _, _ := dialect.From("f_deposit_receipt").Select(g.COUNT(1)).Where(g.Ex{"deposit_id": depositID, "prefix": meta.Prefix}).ToSQL()
+	err = meta.MerchantDB.Get(&n, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n >= depositReceiptMax {
+		return errors.New(helper.RequestBusy)
+	}
+
+	id := helper.GenId()
+	name := fmt.Sprintf("%s_%s_%s.%s", meta.Prefix, depositID, id, ext)
+	record := DepositReceipt{
+		ID:          id,
+		DepositID:   depositID,
+		UID:         uid,
+		Username:    username,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		CreatedAt:   time.Now().Unix(),
+		Prefix:      meta.Prefix,
+	}
+
+	// minio未配置或上传失败时保存到本地
+	object := fmt.Sprintf("%s/receipt/%s", meta.Prefix, name)
+	if minioEnable(meta.Minio.ImagesBucket) &&
+		minioPutObject(meta.Minio.ImagesBucket, object, bytes.NewReader(content), record.Size, contentType) == nil {
+		record.Storage = ExportStorageMinio
+		record.Path = object
+	} else {
+		dir := depositReceiptDir()
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return pushLog(err, helper.FormatErr)
+		}
+
+		record.Storage = ExportStorageLocal
+		record.Path = filepath.Join(dir, name)
+		err = os.WriteFile(record.Path, content, 0644)
+		if err != nil {
+			return pushLog(err, helper.FormatErr)
+		}
+	}
+
+	query, _, _ = dialect.Insert("f_deposit_receipt").Rows(record).ToSQL()
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+// DepositReceiptAttach 为订单列表附上转账截图的临时地址
+func DepositReceiptAttach(data *FDepositData) {
+
+	if len(data.D) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(data.D))
+	for _, v := range data.D {
+		ids = append(ids, v.ID)
+	}
+
+	var recs []DepositReceipt
+	query, _, _ := dialect.From("f_deposit_receipt").Select(colDepositReceipt...).
+		Where(g.Ex{"deposit_id": ids, "prefix": meta.Prefix}).Order(g.C("created_at").Asc()).ToSQL()
+	err := meta.MerchantDB.Select(&recs, query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	if len(recs) == 0 {
+		return
+	}
+
+	data.Receipts = map[string][]string{}
+	for _, v := range recs {
+		u, err := depositReceiptURL(v)
+		if err != nil {
+			continue
+		}
+
+		data.Receipts[v.DepositID] = append(data.Receipts[v.DepositID], u)
+	}
+}
+
+// DepositReceiptView 本地保存的截图, 通过临时token访问
+func DepositReceiptView(token string) (DepositReceipt, error) {
+
+	rec := DepositReceipt{}
+	id, err := meta.MerchantRedis.Get(ctx, depositReceiptTokenKey(token)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return rec, errors.New(helper.AccessTokenExpires)
+		}
+
+		return rec, pushLog(err, helper.RedisErr)
+	}
+
+	query, _, _ := dialect.From("f_deposit_receipt").Select(colDepositReceipt...).Where(g.Ex{"id": id, "prefix": meta.Prefix}).Limit(1).ToSQL()
+	err = meta.MerchantDB.Get(&rec, query)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return rec, errors.New(helper.RecordNotExistErr)
+		}
+
+		return rec, pushLog(err, helper.DBErr)
+	}
+
+	return rec, nil
+}
+
+func depositReceiptDir() string {
+
+	if meta.ExportPath != "" {
+		return filepath.Join(meta.ExportPath, "receipt")
+	}
+
+	return filepath.Join(os.TempDir(), "finance_receipt")
+}
+
+func depositReceiptTokenKey(token string) string {
+	return fmt.Sprintf("%s:deposit:receipt:%s", meta.Prefix, token)
+}
+
+// 存在minio的返回签名地址, 本地的生成有时效的访问token
+func depositReceiptURL(rec DepositReceipt) (string, error) {
+
+	if rec.Storage == ExportStorageMinio {
+		return minioPresignGet(meta.Minio.ImagesBucket, rec.Path, depositReceiptExpires), nil
+	}
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(b)
+	err = meta.MerchantRedis.Set(ctx, depositReceiptTokenKey(token), rec.ID, depositReceiptExpires).Err()
+	if err != nil {
+		return "", pushLog(err, helper.RedisErr)
+	}
+
+	return "/merchant/finance/receipt/view?token=" + token, nil
+}
@@ -0,0 +1,492 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 冲正原因
+const (
+	ReversalReasonPSP   = 1 // 三方渠道冲正
+	ReversalReasonBank  = 2 // 银行退回
+	ReversalReasonFraud = 3 // 欺诈转账
+	ReversalReasonOther = 9 // 其他
+)
+
+var ReversalReasons = map[int]string{
+	ReversalReasonPSP:   "三方渠道冲正",
+	ReversalReasonBank:  "银行退回",
+	ReversalReasonFraud: "欺诈转账",
+	ReversalReasonOther: "其他",
+}
+
+// DepositReversal 存款冲正记录, debit 为从余额扣除的金额, debt 为余额不足时记为欠款的金额
+type DepositReversal struct {
+	ID          string  `db:"id" json:"id"`
+	DepositID   string  `db:"deposit_id" json:"deposit_id"`
+	UID         string  `db:"uid" json:"uid"`
+	Username    string  `db:"username" json:"username"`
+	ChannelID   string  `db:"channel_id" json:"channel_id"`
+	Amount      float64 `db:"amount" json:"amount"` // 冲正金额, 包含存款优惠
+	Debit       float64 `db:"debit" json:"debit"`
+	Debt        float64 `db:"debt" json:"debt"`
+	Reason      int     `db:"reason" json:"reason"`
+	Reference   string  `db:"reference" json:"reference"` // 三方或银行的冲正单号
+	Remark      string  `db:"remark" json:"remark"`
+	Locked      int     `db:"locked" json:"locked"` // 是否已限制会员存提款
+	CreatedAt   int64   `db:"created_at" json:"created_at"`
+	CreatedUID  string  `db:"created_uid" json:"created_uid"`
+	CreatedName string  `db:"created_name" json:"created_name"`
+	Prefix      string  `db:"prefix" json:"prefix"`
+}
+
+type DepositReversalData struct {
+	D   []DepositReversal `json:"d"`
+	T   int64             `json:"t"`
+	S   uint16            `json:"s"`
+	Agg map[string]string `json:"agg"`
+}
+
+// DepositReversalConf 冲正策略
+type DepositReversalConf struct {
+	Negative int `json:"negative" redis:"negative"`   // 1 余额不足时允许扣成负数 0 不足部分记为欠款
+	AutoLock int `json:"auto_lock" redis:"auto_lock"` // 1 冲正后默认限制会员存提款
+}
+
+func DepositReversalConfGet() (DepositReversalConf, error) {
+
+	conf := DepositReversalConf{}
+	key := fmt.Sprintf("%s:deposit:reversal:conf", meta.Prefix)
+	err := meta.MerchantRedis.HGetAll(ctx, key).Scan(&conf)
+	if err != nil && err != redis.Nil {
+		return conf, pushLog(err, helper.RedisErr)
+	}
+
+	return conf, nil
+}
+
+func DepositReversalConfSet(conf DepositReversalConf) error {
+
+	key := fmt.Sprintf("%s:deposit:reversal:conf", meta.Prefix)
+	err := meta.MerchantRedis.HSet(ctx, key,
+		"negative", conf.Negative,
+		"auto_lock", conf.AutoLock,
+	).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// DepositReverse 冲正已成功的存款订单, 扣回到账金额和存款优惠, 订单状态改为已冲正
+func DepositReverse(id string, reason int, reference, remark string, lock bool, admin map[string]string) error {
+
+	if _, ok := ReversalReasons[reason]; !ok {
+		return errors.New(helper.ParamErr)
+	}
+
+	err := depositLock(id)
+	if err != nil {
+		return err
+	}
+	defer depositUnLock(id)
+
+	order, err := DepositOrderFindOne(g.Ex{"id": id})
+	if err != nil {
+		return err
+	}
+
+	if order.State != DepositSuccess || order.Amount <= 0 {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	conf, err := DepositReversalConfGet()
+	if err != nil {
+		return err
+	}
+
+	// 到账时加的通道优惠/手续费, 以及首存二存优惠一并扣回
+	var bonus sql.NullFloat64
+	query, _, _ := dialect.From("f_deposit_promo").Select(g.SUM("bonus")).
+		Where(g.Ex{"deposit_id": order.ID, "ty": []int{DepositPromoFirst, DepositPromoSecond}, "prefix": meta.Prefix}).ToSQL()
+	err = meta.MerchantDB.Get(&bonus, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	money := decimal.NewFromFloat(order.Amount).Add(decimal.NewFromFloat(order.Discount)).Add(decimal.NewFromFloat(bonus.Float64))
+	now := time.Now()
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	record := g.Record{
+		"state":         DepositReversed,
+		"review_remark": fmt.Sprintf("冲正: %s %s", ReversalReasons[reason], reference),
+	}
+	query, _, _ = dialect.Update("tbl_deposit").Set(record).Where(g.Ex{"id": order.ID, "state": DepositSuccess}).ToSQL()
+	res, err := tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		_ = tx.Rollback()
+		return errors.New(helper.OrderStateErr)
+	}
+
+	// 锁定会员后读取余额, 防止并发的上下分导致扣款金额和账变错误
+	before, err := memberBalanceLock(tx, order.UID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	debit, debt := money, zero
+	if conf.Negative == 0 && before.LessThan(money) {
+		debit = decimal.Max(before, zero)
+		debt = money.Sub(debit)
+	}
+
+	if debit.GreaterThan(zero) {
+		ex := g.Ex{
+			"uid":    order.UID,
+			"prefix": meta.Prefix,
+		}
+		br := g.Record{
+			"balance": g.L(fmt.Sprintf("balance-%s", debit.String())),
+		}
+		query, _, _ = dialect.Update("tbl_members").Set(br).Where(ex).ToSQL()
+		_, err = tx.Exec(query)
+		if err != nil {
+			_ = tx.Rollback()
+			return pushLog(err, helper.DBErr)
+		}
+
+		mbTrans := memberTransaction{
+			AfterAmount:  before.Sub(debit).String(),
+			Amount:       debit.String(),
+			BeforeAmount: before.String(),
+			BillNo:       order.ID,
+			CreatedAt:    now.UnixMilli(),
+			ID:           helper.GenId(),
+			CashType:     helper.TransactionFinanceDownPoint,
+			UID:          order.UID,
+			Username:     order.Username,
+			Prefix:       meta.Prefix,
+		}
+		query, _, _ = dialect.Insert("tbl_balance_transaction").Rows(mbTrans).ToSQL()
+		_, err = tx.Exec(query)
+		if err != nil {
+			_ = tx.Rollback()
+			return pushLog(err, helper.DBErr)
+		}
+	}
+
+	amount, _ := money.Float64()
+	debitF, _ := debit.Float64()
+	debtF, _ := debt.Float64()
+	rev := DepositReversal{
+		ID:          helper.GenId(),
+		DepositID:   order.ID,
+		UID:         order.UID,
+		Username:    order.Username,
+		ChannelID:   order.ChannelID,
+		Amount:      amount,
+		Debit:       debitF,
+		Debt:        debtF,
+		Reason:      reason,
+		Reference:   reference,
+		Remark:      remark,
+		CreatedAt:   now.Unix(),
+		CreatedUID:  admin["id"],
+		CreatedName: admin["name"],
+		Prefix:      meta.Prefix,
+	}
+	if lock || conf.AutoLock == 1 {
+		rev.Locked = 1
+	}
+	query, _, _ = dialect.Insert("f_deposit_reversal").Rows(rev).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	// 优惠已随订单扣回, 该订单的优惠流水要求一并关闭
+	ex := g.Ex{
+		"deposit_id": order.ID,
+		"state":      0,
+		"prefix":     meta.Prefix,
+	}
+	pr := g.Record{
+		"state":       1,
+		"finish_at":   now.Unix(),
+		"finish_name": admin["name"],
+	}
+	query, _, _ = dialect.Update("f_deposit_promo").Set(pr).Where(ex).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	_ = MemberUpdateCache(order.Username)
+
+	if rev.Locked == 1 {
+		depositReversalLock(order, rev, now)
+	}
+
+	// 冲正后重新计算信用等级
+	go creditLevelEvaluate(order.UID)
+
+	return nil
+}
+
+// 会员未还清的冲正欠款
+func depositReversalDebt(uid string) (decimal.Decimal, error) {
+
+	var debt sql.NullFloat64
+	ex := g.Ex{
+		"uid":    uid,
+		"debt":   g.Op{"gt": 0},
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_deposit_reversal").Select(g.SUM("debt")).Where(ex).ToSQL()
+	err := meta.MerchantDB.Get(&debt, query)
+	if err != nil {
+		return zero, pushLog(err, helper.DBErr)
+	}
+
+	return decimal.NewFromFloat(debt.Float64), nil
+}
+
+// 存款成功后用余额按冲正先后扣回欠款, 每笔冲正记一条下分账变
+func depositReversalRecover(uid, username string) {
+
+	debt, err := depositReversalDebt(uid)
+	if err != nil || debt.LessThanOrEqual(zero) {
+		return
+	}
+
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	// 先锁定会员, 冲正和扣回都在会员锁内修改欠款
+	balance, err := memberBalanceLock(tx, uid)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+
+	type reversalDebt struct {
+		id        string
+		depositID string
+		debt      decimal.Decimal
+	}
+
+	var data []reversalDebt
+	ex := g.Ex{
+		"uid":    uid,
+		"debt":   g.Op{"gt": 0},
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_deposit_reversal").Select("id", "deposit_id", "debt").
+		Where(ex).Order(g.C("created_at").Asc()).ToSQL()
+	rows, err := tx.Query(query)
+	if err != nil {
+		_ = tx.Rollback()
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	for rows.Next() {
+		var (
+			v  reversalDebt
+			dt string
+		)
+		err = rows.Scan(&v.id, &v.depositID, &dt)
+		if err != nil {
+			break
+		}
+
+		v.debt, _ = decimal.NewFromString(dt)
+		data = append(data, v)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	_ = rows.Close()
+	if err != nil {
+		_ = tx.Rollback()
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	now := time.Now()
+	total := zero
+	for _, v := range data {
+		before := balance.Sub(total)
+		take := decimal.Min(before, v.debt)
+		if take.LessThanOrEqual(zero) {
+			break
+		}
+
+		record := g.Record{
+			"debt":  g.L(fmt.Sprintf("debt-%s", take.String())),
+			"debit": g.L(fmt.Sprintf("debit+%s", take.String())),
+		}
+		query, _, _ = dialect.Update("f_deposit_reversal").Set(record).Where(g.Ex{"id": v.id}).ToSQL()
+		_, err = tx.Exec(query)
+		if err != nil {
+			_ = tx.Rollback()
+			_ = pushLog(err, helper.DBErr)
+			return
+		}
+
+		mbTrans := memberTransaction{
+			AfterAmount:  before.Sub(take).String(),
+			Amount:       take.String(),
+			BeforeAmount: before.String(),
+			BillNo:       v.depositID,
+			CreatedAt:    now.UnixMilli(),
+			ID:           helper.GenId(),
+			CashType:     helper.TransactionFinanceDownPoint,
+			UID:          uid,
+			Username:     username,
+			Prefix:       meta.Prefix,
+		}
+		query, _, _ = dialect.Insert("tbl_balance_transaction").Rows(mbTrans).ToSQL()
+		_, err = tx.Exec(query)
+		if err != nil {
+			_ = tx.Rollback()
+			_ = pushLog(err, helper.DBErr)
+			return
+		}
+
+		total = total.Add(take)
+	}
+
+	if total.LessThanOrEqual(zero) {
+		_ = tx.Rollback()
+		return
+	}
+
+	ex = g.Ex{
+		"uid":    uid,
+		"prefix": meta.Prefix,
+	}
+	br := g.Record{
+		"balance": g.L(fmt.Sprintf("balance-%s", total.String())),
+	}
+	query, _, _ = dialect.Update("tbl_members").Set(br).Where(ex).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	_ = MemberUpdateCache(username)
+}
+
+// 冲正后限制会员存款和提款, 已有生效中的限制时跳过
+func depositReversalLock(order Deposit, rev DepositReversal, now time.Time) {
+
+	for _, scope := range []int{LockScopeDeposit, LockScopeWithdraw} {
+		record := g.Record{
+			"id":           helper.GenId(),
+			"username":     order.Username,
+			"reason":       LockReasonChargeback,
+			"start_at":     now.Unix(),
+			"end_at":       0,
+			"comment":      fmt.Sprintf("存款订单%s冲正", order.ID),
+			"created_uid":  rev.CreatedUID,
+			"created_name": rev.CreatedName,
+			"created_at":   now.Unix(),
+		}
+		err := LockInsert(order.UID, scope, "", record)
+		if err != nil {
+			fmt.Println("depositReversalLock uid = ", order.UID, ", err = ", err.Error())
+		}
+	}
+}
+
+// DepositReversalList 冲正记录, 附带冲正总额和欠款总额
+func DepositReversalList(ex g.Ex, startTime, endTime string, page, pageSize uint16) (DepositReversalData, error) {
+
+	data := DepositReversalData{}
+	ex["prefix"] = meta.Prefix
+	if startTime != "" && endTime != "" {
+		startAt, err := helper.TimeToLoc(startTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		endAt, err := helper.TimeToLoc(endTime, loc)
+		if err != nil || endAt < startAt {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		ex["created_at"] = g.Op{"between": g.Range(startAt, endAt)}
+	}
+
+	if page == 1 {
+		agg := struct {
+			T      int64           `db:"t"`
+			Amount sql.NullFloat64 `db:"amount"`
+			Debt   sql.NullFloat64 `db:"debt"`
+		}{}
+		query, _, _ := dialect.From("f_deposit_reversal").
+			Select(g.COUNT(1).As("t"), g.SUM("amount").As("amount"), g.SUM("debt").As("debt")).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&agg, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		data.T = agg.T
+		data.Agg = map[string]string{
+			"amount": decimal.NewFromFloat(agg.Amount.Float64).StringFixed(4),
+			"debt":   decimal.NewFromFloat(agg.Debt.Float64).StringFixed(4),
+		}
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("f_deposit_reversal").Select(colDepositReversal...).
+		Where(ex).Offset(uint(offset)).Limit(uint(pageSize)).Order(g.C("created_at").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
@@ -0,0 +1,515 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/hprose/hprose-golang/v3/rpc/core"
+)
+
+// 导出类型
+const (
+	ExportDeposit  = 1 // 存款历史记录
+	ExportWithdraw = 2 // 提款历史记录
+)
+
+// 导出状态
+const (
+	ExportProcessing = 1 // 生成中
+	ExportSuccess    = 2 // 已完成
+	ExportFailed     = 3 // 失败
+)
+
+// 文件存储位置
+const (
+	ExportStorageMinio = 1
+	ExportStorageLocal = 2
+)
+
+// 拥有该权限的管理员导出完整卡号, 否则卡号打码
+const exportCardNoPriv = "/merchant/finance/export/cardno"
+
+// Export 导出任务
+type Export struct {
+	ID         string `db:"id" json:"id"`
+	Ty         int    `db:"ty" json:"ty"`               // 1 存款 2 提款
+	FileType   string `db:"file_type" json:"file_type"` // csv xlsx
+	FileName   string `db:"file_name" json:"file_name"`
+	Filter     string `db:"filter" json:"filter"` // 查询条件
+	State      int    `db:"state" json:"state"`   // 1 生成中 2 已完成 3 失败
+	Total      int64  `db:"total" json:"total"`   // 导出行数
+	Storage    int    `db:"storage" json:"-"`     // 1 minio 2 本地
+	Path       string `db:"path" json:"-"`        // minio object 或本地文件路径
+	ErrMsg     string `db:"err_msg" json:"err_msg"`
+	AdminID    string `db:"admin_id" json:"admin_id"`
+	AdminName  string `db:"admin_name" json:"admin_name"`
+	CreatedAt  int64  `db:"created_at" json:"created_at"`
+	FinishedAt int64  `db:"finished_at" json:"finished_at"`
+	Prefix     string `db:"prefix" json:"-"`
+}
+
+type ExportData struct {
+	D []Export `json:"d"`
+	T int64    `json:"t"`
+	S uint16   `json:"s"`
+}
+
+var (
+	exportDepositHeader = []string{"订单号", "三方单号", "会员账号", "上级代理", "渠道ID", "通道ID", "订单金额", "优惠金额",
+		"状态", "收款卡号", "创建时间", "完成时间", "操作人", "备注"}
+	exportWithdrawHeader = []string{"订单号", "三方单号", "会员账号", "上级代理", "钱包", "提款金额", "状态",
+		"会员卡号", "开户名", "创建时间", "出款时间", "审核人", "出款人", "审核备注", "出款备注",
+		"状态耗时(秒)", "超时时间(秒)", "是否超时"}
+	exportStateName = map[int]string{
+		DepositConfirming:     "确认中",
+		DepositSuccess:        "存款成功",
+		DepositCancelled:      "已取消",
+		DepositReviewing:      "审核中",
+		DepositReversed:       "已冲正",
+		WithdrawReviewing:     "审核中",
+		WithdrawReviewReject:  "审核拒绝",
+		WithdrawDealing:       "出款中",
+		WithdrawSuccess:       "提款成功",
+		WithdrawFailed:        "出款失败",
+		WithdrawAbnormal:      "异常订单",
+		WithdrawAutoPayFailed: "代付失败",
+		WithdrawHangup:        "挂起",
+		WithdrawDispatched:    "已派单",
+	}
+)
+
+// ExportDepositInsert 按存款历史记录的查询条件创建导出任务
+func ExportDepositInsert(admin map[string]string, fileType, filter, username, parentName, groupName, id, channelID, oid, state,
+	minAmount, maxAmount, startTime, endTime, cid string, timeFlag uint8, flag, ty, dty int) (string, error) {
+
+	cond, ok, err := depositHistoryCond(username, parentName, groupName, id, channelID, oid, state,
+		minAmount, maxAmount, startTime, endTime, cid, timeFlag, flag, ty, dty)
+	if err != nil {
+		return "", err
+	}
+
+	// 没有符合条件的记录, 依然生成只有表头的文件
+	if !ok {
+		cond = g.C("id").Eq("0")
+	}
+
+	query, _, _ := dialect.From("tbl_deposit").Select(colsDeposit...).Where(cond).Order(g.C("created_at").Desc()).ToSQL()
+	return exportInsert(ExportDeposit, admin, fileType, filter, func(w exportWriter, full bool) (int64, error) {
+		return exportDeposit(w, query, full)
+	})
+}
+
+// ExportWithdrawInsert 按提款历史记录的查询条件创建导出任务
+func ExportWithdrawInsert(admin map[string]string, fileType, filter string, ex g.Ex, ty, startTime, endTime string) (string, error) {
+
+	ex, err := withdrawHistoryEx(ex, ty, startTime, endTime)
+	if err != nil {
+		return "", err
+	}
+
+	return exportInsert(ExportWithdraw, admin, fileType, filter, func(w exportWriter, full bool) (int64, error) {
+		return exportWithdraw(w, ex, full)
+	})
+}
+
+func ExportList(adminID string, page, pageSize uint16) (ExportData, error) {
+
+	data := ExportData{}
+	ex := g.Ex{
+		"admin_id": adminID,
+		"prefix":   meta.Prefix,
+	}
+
+	if page == 1 {
+		query, _, _ := dialect.From("f_export").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("f_export").Select(colExport...).Where(ex).
+		Order(g.C("created_at").Desc()).Offset(uint(offset)).Limit(uint(pageSize)).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+// ExportDownload 获取导出文件, 存在minio的返回临时下载地址, 本地的返回文件路径
+func ExportDownload(id, adminID string) (Export, string, error) {
+
+	data := Export{}
+	ex := g.Ex{
+		"id":       id,
+		"admin_id": adminID,
+		"prefix":   meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_export").Select(colExport...).Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&data, query)
+	if err == sql.ErrNoRows {
+		return data, "", errors.New(helper.RecordNotExistErr)
+	}
+
+	if err != nil {
+		return data, "", pushLog(err, helper.DBErr)
+	}
+
+	if data.State != ExportSuccess {
+		return data, "", errors.New(helper.OrderProcess)
+	}
+
+	if data.Storage == ExportStorageMinio {
+		return data, minioPresignGet(meta.Minio.ExportBucket, data.Path, 10*time.Minute), nil
+	}
+
+	return data, data.Path, nil
+}
+
+func exportInsert(ty int, admin map[string]string, fileType, filter string, fn func(w exportWriter, full bool) (int64, error)) (string, error) {
+
+	// 同一个管理员同时只能有一个导出任务, 超过一小时未完成的视为已中断
+	var n int64
+	ex := g.Ex{
+		"admin_id":   admin["id"],
+		"state":      ExportProcessing,
+		"created_at": g.Op{"gt": time.Now().Add(-time.Hour).Unix()},
+		"prefix":     meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_export").Select(g.COUNT(1)).Where(ex).ToSQL()
+	err := meta.MerchantDB.Get(&n, query)
+	if err != nil {
+		return "", pushLog(err, helper.DBErr)
+	}
+
+	if n > 0 {
+		return "", errors.New(helper.RequestBusy)
+	}
+
+	now := time.Now()
+	data := Export{
+		ID:        helper.GenId(),
+		Ty:        ty,
+		FileType:  fileType,
+		Filter:    filter,
+		State:     ExportProcessing,
+		AdminID:   admin["id"],
+		AdminName: admin["name"],
+		CreatedAt: now.Unix(),
+		Prefix:    meta.Prefix,
+	}
+
+	name := "deposit"
+	if ty == ExportWithdraw {
+		name = "withdraw"
+	}
+	data.FileName = fmt.Sprintf("%s_%s.%s", name, now.In(loc).Format("20060102150405"), fileType)
+
+	query, _, _ = dialect.Insert("f_export").Rows(data).ToSQL()
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		return "", pushLog(err, helper.DBErr)
+	}
+
+	full := PrivCheck(exportCardNoPriv, admin["group_id"]) == nil
+	go exportRun(data, full, fn)
+
+	return data.ID, nil
+}
+
+func exportRun(data Export, full bool, fn func(w exportWriter, full bool) (int64, error)) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			exportFinish(data.ID, g.Record{"state": ExportFailed, "err_msg": fmt.Sprintf("%v", r)})
+		}
+	}()
+
+	dir := meta.ExportPath
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "finance_export")
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		exportFinish(data.ID, g.Record{"state": ExportFailed, "err_msg": err.Error()})
+		return
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.%s", meta.Prefix, data.ID, data.FileType))
+	total, err := exportWriteFile(path, data.FileType, full, fn)
+	if err != nil {
+		_ = os.Remove(path)
+		_ = pushLog(err, helper.DBErr)
+		exportFinish(data.ID, g.Record{"state": ExportFailed, "err_msg": err.Error()})
+		return
+	}
+
+	record := g.Record{
+		"state":   ExportSuccess,
+		"total":   total,
+		"storage": ExportStorageLocal,
+		"path":    path,
+	}
+
+	// 上传minio失败时保留本地文件
+	if minioEnable(meta.Minio.ExportBucket) {
+		object := fmt.Sprintf("%s/export/%s", meta.Prefix, filepath.Base(path))
+		err = exportUpload(path, object, data.FileType)
+		if err == nil {
+			record["storage"] = ExportStorageMinio
+			record["path"] = object
+			_ = os.Remove(path)
+		} else {
+			_ = pushLog(err, helper.FormatErr)
+		}
+	}
+
+	exportFinish(data.ID, record)
+}
+
+func exportWriteFile(path, fileType string, full bool, fn func(w exportWriter, full bool) (int64, error)) (int64, error) {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	w, err := newExportWriter(fileType, f)
+	if err != nil {
+		return 0, err
+	}
+
+	total, err := fn(w, full)
+	if err != nil {
+		return 0, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func exportUpload(path, object, fileType string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	contentType := "text/csv"
+	if fileType == "xlsx" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+
+	return minioPutObject(meta.Minio.ExportBucket, object, f, st.Size(), contentType)
+}
+
+func exportFinish(id string, record g.Record) {
+
+	record["finished_at"] = time.Now().Unix()
+	query, _, _ := dialect.Update("f_export").Set(record).Where(g.Ex{"id": id}).Limit(1).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+	}
+}
+
+func exportDeposit(w exportWriter, query string, full bool) (int64, error) {
+
+	err := w.Write(exportDepositHeader)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := meta.MerchantDB.Queryx(query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var total int64
+	for rows.Next() {
+
+		d := Deposit{}
+		err = rows.StructScan(&d)
+		if err != nil {
+			return total, err
+		}
+
+		bankNo := d.BankNo
+		if !full {
+			bankNo = exportMaskCardNo(bankNo)
+		}
+
+		err = w.Write([]string{
+			d.ID, d.OID, d.Username, d.ParentName, d.CID, d.ChannelID,
+			strconv.FormatFloat(d.Amount, 'f', 4, 64), strconv.FormatFloat(d.Discount, 'f', 4, 64),
+			exportStateName[d.State], bankNo, exportTime(d.CreatedAt), exportTime(d.ConfirmAt), d.ConfirmName, d.ReviewRemark,
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total++
+	}
+
+	return total, rows.Err()
+}
+
+// 会员卡号需要rpc解密, 按批读取
+func exportWithdraw(w exportWriter, ex g.Ex, full bool) (int64, error) {
+
+	err := w.Write(exportWithdrawHeader)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		total  int64
+		lastID = ""
+		size   = 500
+	)
+	slaConf, _ := withdrawSlaConf()
+	now := time.Now().Unix()
+	for {
+
+		cond := g.And(ex)
+		if lastID != "" {
+			cond = cond.Append(g.C("id").Lt(lastID))
+		}
+
+		var data []Withdraw
+		query, _, _ := dialect.From("tbl_withdraw").Select(colsWithdraw...).Where(cond).Order(g.C("id").Desc()).Limit(uint(size)).ToSQL()
+		err = meta.MerchantDB.Select(&data, query)
+		if err != nil {
+			return total, err
+		}
+
+		if len(data) == 0 {
+			break
+		}
+
+		var uids []string
+		fields := []string{"realname"}
+		for _, v := range data {
+			uids = append(uids, v.UID)
+			fields = append(fields, "bankcard"+v.BID)
+		}
+
+		recs, err := exportDecrypt(uids, !full, fields)
+		if err != nil {
+			return total, err
+		}
+
+		for _, v := range data {
+
+			wallet := "中心钱包"
+			if v.WalletFlag == AgencyWallet {
+				wallet = "佣金钱包"
+			}
+
+			// 与列表一致, 只有计时状态展示超时信息
+			sla := withdrawCols{Withdraw: v}
+			withdrawSlaFill(&sla, slaConf, now)
+			stateSeconds, slaSeconds, breach := "", "", ""
+			if sla.StateAt > 0 {
+				stateSeconds = strconv.FormatInt(sla.StateSeconds, 10)
+				slaSeconds = strconv.FormatInt(sla.SlaSeconds, 10)
+				breach = "否"
+				if sla.SlaBreach {
+					breach = "是"
+				}
+			}
+
+			err = w.Write([]string{
+				v.ID, v.OID, v.Username, v.ParentName, wallet, strconv.FormatFloat(v.Amount, 'f', 4, 64), exportStateName[v.State],
+				recs[v.UID]["bankcard"+v.BID], recs[v.UID]["realname"], exportTime(v.CreatedAt), exportTime(v.WithdrawAt),
+				v.ConfirmName, v.WithdrawName, v.ReviewRemark, v.WithdrawRemark,
+				stateSeconds, slaSeconds, breach,
+			})
+			if err != nil {
+				return total, err
+			}
+
+			total++
+		}
+
+		if len(data) < size {
+			break
+		}
+
+		lastID = data[len(data)-1].ID
+	}
+
+	return total, nil
+}
+
+func exportDecrypt(uids []string, hide bool, fields []string) (map[string]map[string]string, error) {
+
+	clientContext := core.NewClientContext()
+	header := make(http.Header)
+	header.Set("X-Func-Name", "kms")
+	clientContext.Items().Set("httpRequestHeaders", header)
+	rctx := core.WithContext(context.Background(), clientContext)
+
+	recs, err := grpc_t.DecryptAll(rctx, uids, hide, fields)
+	if err != nil {
+		return nil, errors.New(helper.GetRPCErr)
+	}
+
+	return recs, nil
+}
+
+// 卡号只保留前4位和后4位
+func exportMaskCardNo(no string) string {
+
+	// 短卡号最多保留后4位, 至少遮盖一半
+	if len(no) <= 8 {
+		keep := len(no) / 2
+		if keep > 4 {
+			keep = 4
+		}
+		return strings.Repeat("*", len(no)-keep) + no[len(no)-keep:]
+	}
+
+	return no[:4] + strings.Repeat("*", len(no)-8) + no[len(no)-4:]
+}
+
+func exportTime(ts int64) string {
+
+	if ts == 0 {
+		return ""
+	}
+
+	return time.Unix(ts, 0).In(loc).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+)
+
+// 导出文件按行写入, 不在内存中保留整个文件
+type exportWriter interface {
+	Write(row []string) error
+	Close() error
+}
+
+func newExportWriter(fileType string, w io.Writer) (exportWriter, error) {
+
+	if fileType == "xlsx" {
+		return newXlsxWriter(w)
+	}
+
+	bw := bufio.NewWriter(w)
+	// 带BOM, excel打开csv时中文不乱码
+	_, err := bw.WriteString("\xef\xbb\xbf")
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvWriter{bw: bw, cw: csv.NewWriter(bw)}, nil
+}
+
+type csvWriter struct {
+	bw *bufio.Writer
+	cw *csv.Writer
+}
+
+func (w *csvWriter) Write(row []string) error {
+	return w.cw.Write(row)
+}
+
+func (w *csvWriter) Close() error {
+
+	w.cw.Flush()
+	if err := w.cw.Error(); err != nil {
+		return err
+	}
+
+	return w.bw.Flush()
+}
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxSheetHead = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetTail = `</sheetData></worksheet>`
+)
+
+// 只写一个sheet, 单元格全部使用内联字符串, sheet放在zip最后一个文件边写边压缩
+type xlsxWriter struct {
+	zw *zip.Writer
+	bw *bufio.Writer
+}
+
+func newXlsxWriter(w io.Writer) (exportWriter, error) {
+
+	zw := zip.NewWriter(w)
+	parts := [][2]string{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, v := range parts {
+		f, err := zw.Create(v[0])
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.WriteString(f, v[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriter(f)
+	_, err = bw.WriteString(xlsxSheetHead)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xlsxWriter{zw: zw, bw: bw}, nil
+}
+
+func (w *xlsxWriter) Write(row []string) error {
+
+	_, err := w.bw.WriteString("<row>")
+	if err != nil {
+		return err
+	}
+
+	for _, v := range row {
+		_, err = w.bw.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		if err != nil {
+			return err
+		}
+
+		err = xml.EscapeText(w.bw, []byte(v))
+		if err != nil {
+			return err
+		}
+
+		_, err = w.bw.WriteString("</t></is></c>")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.bw.WriteString("</row>")
+	return err
+}
+
+func (w *xlsxWriter) Close() error {
+
+	_, err := w.bw.WriteString(xlsxSheetTail)
+	if err != nil {
+		return err
+	}
+
+	err = w.bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return w.zw.Close()
+}
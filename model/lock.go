@@ -5,16 +5,69 @@ import (
 	"errors"
 	"finance/contrib/helper"
 	"fmt"
+	"time"
 
 	g "github.com/doug-martin/goqu/v9"
 	"github.com/doug-martin/goqu/v9/exp"
 )
 
+// 会员限制范围
+const (
+	LockScopeDeposit  = 1 // 全部存款
+	LockScopeWithdraw = 2 // 提款
+	LockScopeChannel  = 3 // 指定存款渠道, target 为渠道id
+	LockScopeManual   = 4 // 线下转卡
+)
+
+// 会员限制原因
+const (
+	LockReasonRisk       = 1 // 风控审核
+	LockReasonChargeback = 2 // 存款冲正/拒付
+	LockReasonSpam       = 3 // 恶意刷单
+	LockReasonAbnormal   = 4 // 账户异常
+	LockReasonRequest    = 5 // 会员申请
+	LockReasonOther      = 9 // 其他
+)
+
+// 限制状态
+const (
+	LockStateReleased = "0" // 已解除
+	LockStateActive   = "1" // 生效中
+	LockStateExpired  = "2" // 已到期
+)
+
+const (
+	memberLockExpireLock     = "memberlock:expire"
+	memberLockExpireInterval = 60 * time.Second
+	memberLockExpireOperator = "到期自动解除"
+)
+
+var LockScopes = map[int]string{
+	LockScopeDeposit:  "存款",
+	LockScopeWithdraw: "提款",
+	LockScopeChannel:  "指定渠道",
+	LockScopeManual:   "线下转卡",
+}
+
+var LockReasons = map[int]string{
+	LockReasonRisk:       "风控审核",
+	LockReasonChargeback: "存款冲正/拒付",
+	LockReasonSpam:       "恶意刷单",
+	LockReasonAbnormal:   "账户异常",
+	LockReasonRequest:    "会员申请",
+	LockReasonOther:      "其他",
+}
+
 type MemberLock struct {
 	ID          string `db:"id" json:"id"`
 	UID         string `db:"uid" json:"uid"`
 	Username    string `db:"username" json:"username"`
 	State       string `db:"state" json:"state"`
+	Scope       int    `db:"scope" json:"scope"`       // 限制范围
+	Target      string `db:"target" json:"target"`     // 限制的渠道id
+	Reason      int    `db:"reason" json:"reason"`     // 限制原因
+	StartAt     int64  `db:"start_at" json:"start_at"` // 开始时间
+	EndAt       int64  `db:"end_at" json:"end_at"`     // 结束时间, 0 永久
 	CreatedAt   int64  `db:"created_at" json:"created_at"`
 	Comment     string `db:"comment" json:"comment"`
 	UpdatedAt   int64  `db:"updated_at" json:"updated_at"`
@@ -31,11 +84,11 @@ type MemberLockData struct {
 	S uint16       `json:"s"`
 }
 
-func LockList(username, lockName, start, end string, page, pageSize uint16) (MemberLockData, error) {
+func LockList(username, lockName, start, end string, scope int, page, pageSize uint16) (MemberLockData, error) {
 
 	data := MemberLockData{}
 
-	ex := g.Ex{"state": "1", "prefix": meta.Prefix}
+	ex := g.Ex{"state": LockStateActive, "prefix": meta.Prefix}
 
 	if username != "" {
 		ex["username"] = username
@@ -45,6 +98,10 @@ func LockList(username, lockName, start, end string, page, pageSize uint16) (Mem
 		ex["created_name"] = lockName
 	}
 
+	if scope > 0 {
+		ex["scope"] = lockScopes(scope)
+	}
+
 	if start != "" && end != "" {
 
 		startAt, err := helper.TimeToLoc(start, loc)
@@ -92,6 +149,7 @@ func LockList(username, lockName, start, end string, page, pageSize uint16) (Mem
 
 	levels, _ := MemberLevelByUID(uids)
 	for k := range data.D {
+		memberLockLegacy(&data.D[k])
 		level, ok := levels[data.D[k].UID]
 		if ok {
 			data.D[k].Level = fmt.Sprintf("VIP%d", level-1)
@@ -102,42 +160,54 @@ func LockList(username, lockName, start, end string, page, pageSize uint16) (Mem
 	return data, nil
 }
 
-func LockInsert(param map[string]string) error {
+// LockHistory 会员全部的限制记录, 包含已解除和已到期的
+func LockHistory(username string) ([]MemberLock, error) {
 
-	err := lockMemberCheck(param["username"])
+	var data []MemberLock
+	ex := g.Ex{
+		"username": username,
+		"prefix":   meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_member_lock").Select(colMemberLock...).
+		Where(ex).Order(g.C("created_at").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
 	if err != nil {
-		return err
+		return data, pushLog(err, helper.DBErr)
 	}
 
-	record := g.Record{
-		"id":           param["id"],
-		"uid":          param["uid"],
-		"username":     param["username"],
-		"state":        param["state"],
-		"comment":      param["comment"],
-		"created_at":   param["created_at"],
-		"created_uid":  param["created_uid"],
-		"created_name": param["created_name"],
-		"updated_at":   "0",
-		"updated_uid":  "0",
-		"updated_name": "",
-		"prefix":       meta.Prefix,
+	for k := range data {
+		memberLockLegacy(&data[k])
 	}
-	query, _, _ := dialect.Insert("f_member_lock").Rows(record).ToSQL()
-	_, err = meta.MerchantDB.Exec(query)
+
+	return data, nil
+}
+
+func LockInsert(uid string, scope int, target string, record g.Record) error {
+
+	// 同一范围同时只能有一条生效的限制
+	err := lockMemberCheck(uid, scope, target)
 	if err != nil {
-		return pushLog(err, helper.DBErr)
+		return err
 	}
 
-	key := fmt.Sprintf("%s:DL:%s", meta.Prefix, param["uid"])
-	err = meta.MerchantRedis.Set(ctx, key, "1", 0).Err()
+	record["uid"] = uid
+	record["scope"] = scope
+	record["target"] = target
+	record["state"] = LockStateActive
+	record["updated_at"] = 0
+	record["updated_uid"] = "0"
+	record["updated_name"] = ""
+	record["prefix"] = meta.Prefix
+	query, _, _ := dialect.Insert("f_member_lock").Rows(record).ToSQL()
+	_, err = meta.MerchantDB.Exec(query)
 	if err != nil {
-		_ = pushLog(err, helper.RedisErr)
+		return pushLog(err, helper.DBErr)
 	}
 
-	return nil
+	return memberLockUpdateCache(uid)
 }
 
+// LockUpdateState 解除限制
 func LockUpdateState(uid string, param map[string]string) error {
 
 	record := g.Record{
@@ -148,7 +218,7 @@ func LockUpdateState(uid string, param map[string]string) error {
 	}
 	ex := g.Ex{
 		"id":    param["id"],
-		"state": "1",
+		"state": LockStateActive,
 	}
 	query, _, _ := dialect.Update("f_member_lock").Set(record).Where(ex).Limit(1).ToSQL()
 	_, err := meta.MerchantDB.Exec(query)
@@ -156,22 +226,47 @@ func LockUpdateState(uid string, param map[string]string) error {
 		return pushLog(err, helper.DBErr)
 	}
 
-	key := fmt.Sprintf("%s:DL:%s", meta.Prefix, uid)
-	err = meta.MerchantRedis.Unlink(ctx, key).Err()
-	if err != nil {
-		_ = pushLog(err, helper.RedisErr)
+	// 旧版本锁定时写入的key
+	_ = meta.MerchantRedis.Unlink(ctx, fmt.Sprintf("%s:DL:%s", meta.Prefix, uid), fmt.Sprintf("%s:finance:mlock:%s", meta.Prefix, uid)).Err()
+
+	return memberLockUpdateCache(uid)
+}
+
+// LockTimeRange 限制的开始和结束时间, 开始时间为空时立即生效, 结束时间为空时永久
+func LockTimeRange(startTime, endTime string, now int64) (int64, int64, error) {
+
+	startAt, endAt := now, int64(0)
+	if startTime != "" {
+		at, err := helper.TimeToLoc(startTime, loc)
+		if err != nil {
+			return 0, 0, errors.New(helper.DateTimeErr)
+		}
+
+		startAt = at
 	}
 
-	return nil
+	if endTime != "" {
+		at, err := helper.TimeToLoc(endTime, loc)
+		if err != nil || at <= startAt || at <= now {
+			return 0, 0, errors.New(helper.DateTimeErr)
+		}
+
+		endAt = at
+	}
+
+	return startAt, endAt, nil
 }
 
-func lockMemberCheck(username string) error {
+// 是否已有相同范围生效中的限制
+func lockMemberCheck(uid string, scope int, target string) error {
 
 	var id string
 	ex := g.Ex{
-		"username": username,
-		"state":    "1",
-		"prefix":   meta.Prefix,
+		"uid":    uid,
+		"scope":  lockScopes(scope),
+		"target": target,
+		"state":  LockStateActive,
+		"prefix": meta.Prefix,
 	}
 	query, _, _ := dialect.From("f_member_lock").Select("id").Where(ex).Limit(1).ToSQL()
 	err := meta.MerchantDB.Get(&id, query)
@@ -186,15 +281,149 @@ func lockMemberCheck(username string) error {
 	return errors.New(helper.MemberLockAlready)
 }
 
-// 根据给定会员的uid查询会员是否被lock了
+// 会员生效中的限制写入redis, 存提款时从redis读取
+func memberLockUpdateCache(uid string) error {
+
+	var data []MemberLock
+	ex := g.Ex{
+		"uid":    uid,
+		"state":  LockStateActive,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_member_lock").Select(colMemberLock...).Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	key := fmt.Sprintf("%s:member:lock:%s", meta.Prefix, uid)
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.Unlink(ctx, key)
+	for _, v := range data {
+		memberLockLegacy(&v)
+		b, err := helper.JsonMarshal(v)
+		if err != nil {
+			return errors.New(helper.FormatErr)
+		}
+
+		pipe.HSet(ctx, key, v.ID, string(b))
+	}
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// 会员当前生效的限制, 未开始和已到期的不返回
+func memberLockActive(uid string) ([]MemberLock, error) {
+
+	var data []MemberLock
+	key := fmt.Sprintf("%s:member:lock:%s", meta.Prefix, uid)
+
+	pipe := meta.MerchantRedis.Pipeline()
+	defer pipe.Close()
+
+	rs := pipe.HGetAll(ctx, key)
+	// 旧版本的锁定key, 存在时视为永久的全部存款限制
+	legacy := pipe.Exists(ctx, fmt.Sprintf("%s:DL:%s", meta.Prefix, uid), fmt.Sprintf("%s:finance:mlock:%s", meta.Prefix, uid))
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return data, pushLog(err, helper.RedisErr)
+	}
+
+	if legacy.Val() > 0 {
+		data = append(data, MemberLock{UID: uid, State: LockStateActive, Scope: LockScopeDeposit})
+	}
+
+	now := time.Now().Unix()
+	for _, v := range rs.Val() {
+		ml := MemberLock{}
+		if helper.JsonUnmarshal([]byte(v), &ml) != nil {
+			continue
+		}
+
+		memberLockLegacy(&ml)
+		if ml.StartAt > now || (ml.EndAt > 0 && ml.EndAt <= now) {
+			continue
+		}
+
+		data = append(data, ml)
+	}
+
+	return data, nil
+}
+
+// 旧版本的限制没有范围, 视为全部存款限制
+func memberLockLegacy(ml *MemberLock) {
+
+	if ml.Scope == 0 {
+		ml.Scope = LockScopeDeposit
+	}
+}
+
+// 按范围查询时包含旧版本没有范围的限制
+func lockScopes(scope int) []int {
+
+	if scope == LockScopeDeposit {
+		return []int{0, LockScopeDeposit}
+	}
+
+	return []int{scope}
+}
+
+// 限制是否覆盖本次操作, 全部存款的限制同样覆盖指定渠道和线下转卡
+func memberLockMatch(locks []MemberLock, scope int, target string) bool {
+
+	for _, v := range locks {
+		if v.Scope == scope && (scope != LockScopeChannel || v.Target == target) {
+			return true
+		}
+
+		if v.Scope == LockScopeDeposit && (scope == LockScopeChannel || scope == LockScopeManual) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MemberLockCheck 存提款入口统一检查会员是否被限制
+func MemberLockCheck(uid string, scope int, target string) error {
+
+	locks, err := memberLockActive(uid)
+	if err != nil {
+		return err
+	}
+
+	if !memberLockMatch(locks, scope, target) {
+		return nil
+	}
+
+	if scope == LockScopeWithdraw {
+		return errors.New(helper.WithdrawBan)
+	}
+
+	return errors.New(helper.NoChannelErr)
+}
+
+// 根据给定会员的uid查询会员是否被限制存款
 func lockMapByUids(uids []string) (map[string]bool, error) {
 
 	var lms []string
+	now := time.Now().Unix()
 	ex := g.Ex{
-		"uid":   uids,
-		"state": "1",
+		"uid":      uids,
+		"state":    LockStateActive,
+		"scope":    lockScopes(LockScopeDeposit),
+		"start_at": g.Op{"lte": now},
 	}
-	query, _, _ := dialect.From("f_member_lock").Select("uid").Where(ex).ToSQL()
+	query, _, _ := dialect.From("f_member_lock").Select("uid").
+		Where(ex, g.Or(g.C("end_at").Eq(0), g.C("end_at").Gt(now))).ToSQL()
 	err := meta.MerchantDB.Select(&lms, query)
 	if err != nil {
 		return nil, pushLog(err, helper.DBErr)
@@ -226,3 +455,85 @@ func LockById(id string) (MemberLock, error) {
 
 	return data, nil
 }
+
+// MemberLockExpireTask 定时将到期的限制改为已到期
+func MemberLockExpireTask() {
+
+	// 启动时同步一次生效中的限制到redis
+	if Lock(memberLockExpireLock) == nil {
+		memberLockLoad()
+		Unlock(memberLockExpireLock)
+	}
+
+	ticker := time.NewTicker(memberLockExpireInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// 多实例只需要一个执行
+		if Lock(memberLockExpireLock) != nil {
+			continue
+		}
+
+		memberLockExpire()
+		Unlock(memberLockExpireLock)
+	}
+}
+
+func memberLockLoad() {
+
+	var uids []string
+	ex := g.Ex{
+		"prefix": meta.Prefix,
+		"state":  LockStateActive,
+	}
+	query, _, _ := dialect.From("f_member_lock").Select("uid").Distinct().Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&uids, query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	for _, uid := range uids {
+		_ = memberLockUpdateCache(uid)
+	}
+}
+
+func memberLockExpire() {
+
+	var data []MemberLock
+	now := time.Now().Unix()
+	ex := g.Ex{
+		"prefix": meta.Prefix,
+		"state":  LockStateActive,
+		"end_at": g.Op{"between": exp.NewRangeVal(1, now)},
+	}
+	query, _, _ := dialect.From("f_member_lock").Select(colMemberLock...).Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	uids := map[string]bool{}
+	for _, v := range data {
+		record := g.Record{
+			"state":        LockStateExpired,
+			"updated_at":   now,
+			"updated_uid":  "0",
+			"updated_name": memberLockExpireOperator,
+		}
+		query, _, _ = dialect.Update("f_member_lock").Set(record).
+			Where(g.Ex{"id": v.ID, "state": LockStateActive}).ToSQL()
+		_, err = meta.MerchantDB.Exec(query)
+		if err != nil {
+			_ = pushLog(err, helper.DBErr)
+			continue
+		}
+
+		uids[v.UID] = true
+	}
+
+	for uid := range uids {
+		_ = memberLockUpdateCache(uid)
+	}
+}
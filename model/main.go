@@ -43,6 +43,23 @@ type MetaTable struct {
 	EsPrefix      string
 	MerchantInfo  map[string]string
 	Finance       map[string]map[string]interface{}
+	Minio         MinioConf
+	ExportPath    string
+	Telegram      TelegramConf
+}
+
+type TelegramConf struct {
+	Token  string
+	ChatID int64
+}
+
+type MinioConf struct {
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	ImagesBucket    string
+	ExportBucket    string
 }
 
 var grpc_t struct {
@@ -78,6 +95,28 @@ var (
 	colsMember           = helper.EnumFields(Member{})
 	colsMemberBankcard   = helper.EnumFields(MemberBankCard{})
 	colsMemberInfo       = helper.EnumFields(MemberInfo{})
+	colReconcileMapping  = helper.EnumFields(ReconcileMapping{})
+	colReconcileBatch    = helper.EnumFields(ReconcileBatch{})
+	colReconcileItem     = helper.EnumFields(ReconcileItem{})
+	colAudit             = helper.EnumFields(systemLog{})
+	colApprovalRule      = helper.EnumFields(ApprovalRule{})
+	colApproval          = helper.EnumFields(Approval{})
+	colWithdrawRule      = helper.EnumFields(WithdrawRule{})
+	colExport            = helper.EnumFields(Export{})
+	colRiskReviewer      = helper.EnumFields(RiskReviewer{})
+	colRisksDispatchLog  = helper.EnumFields(RisksDispatchLog{})
+	colHangUpReason      = helper.EnumFields(HangUpReason{})
+	colDepositPolicy     = helper.EnumFields(DepositPolicy{})
+	colVelocityLimit     = helper.EnumFields(VelocityLimit{})
+	colCreditRule        = helper.EnumFields(CreditRule{})
+	colMemberCredit      = helper.EnumFields(MemberCredit{})
+	colCreditLog         = helper.EnumFields(CreditLog{})
+	colMaintenance       = helper.EnumFields(PaymentMaintenance{})
+	colBankTxn           = helper.EnumFields(BankTxn{})
+	colDepositReceipt    = helper.EnumFields(DepositReceipt{})
+	colDepositPromo      = helper.EnumFields(DepositPromo{})
+	colDepositPromoRule  = helper.EnumFields(DepositPromoRule{})
+	colDepositReversal   = helper.EnumFields(DepositReversal{})
 )
 
 var (
@@ -95,13 +134,6 @@ const (
 	AgencyWallet = 2 //代理的佣金钱包
 )
 
-var defaultLevelWithdrawLimit = map[string]string{
-	"count_remain":   "7",
-	"max_remain":     "700000",
-	"withdraw_count": "7",
-	"withdraw_max":   "700000",
-}
-
 func Constructor(mt *MetaTable, socks5, c string) {
 
 	meta = mt
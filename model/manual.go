@@ -50,36 +50,89 @@ func ManualPay(fctx *fasthttp.RequestCtx, paymentID, amount string) (string, err
 		return "", errors.New(helper.ChannelNotExist)
 	}
 
+	// 检查通道的营业时间和维护计划
+	err = PaymentOpenCheck(p, fctx.Time())
+	if err != nil {
+		return "", err
+	}
+
 	// 检查存款金额是否符合范围
 	a, ok := validator.CheckFloatScope(amount, p.Fmin, p.Fmax)
 	if !ok {
 		return "", errors.New(helper.AmountOutRange)
 	}
 
-	/*
-		// 检查用户的存款行为是否过于频繁
-		err = cacheDepositProcessing(user.UID, ts)
-		if err != nil {
-			return res, err
-		}
-	*/
-	amount = a.Truncate(0).String()
-	bc, err := BankCardBackend()
+	// 检查通道剩余的每日和累计额度
+	err = PaymentQuotaCheck(p, a, fctx.Time())
 	if err != nil {
-		fmt.Println("BankCardBackend err = ", err.Error())
-		return "", errors.New(helper.BankCardNotExist)
+		return "", err
+	}
+
+	// 检查用户的存款行为是否过于频繁
+	err = cacheDepositProcessing(user, ts)
+	if err != nil {
+		return "", err
+	}
+
+	// 检查会员是否被限制线下转卡
+	err = MemberLockCheck(user.UID, LockScopeManual, "")
+	if err != nil {
+		return "", err
+	}
+
+	// 存款次数和额度限制, 加锁到订单写入完成, 防止并发请求绕过限制
+	err = velocityLock(VelocityDeposit, user.UID)
+	if err != nil {
+		return "", err
 	}
+	defer velocityUnlock(VelocityDeposit, user.UID)
 
-	// 获取附言码
-	code, err := TransacCodeGet()
+	err = velocityCheck(VelocityDeposit, user, a, fctx.Time())
 	if err != nil {
-		return "", errors.New(helper.ChannelBusyTryOthers)
+		return "", err
 	}
 
-	fmt.Println("TransacCodeGet code = ", code)
+	amount = a.Truncate(0).String()
+
+	// 开启金额尾数时, 同一张收款卡上未完成订单的金额不重复, 会员按带尾数的金额转账
+	conf, err := ManualCodeConfGet()
+	if err != nil {
+		return "", err
+	}
 
 	// 生成我方存款订单号
 	orderId := helper.GenId()
+
+	// 选择剩余额度足够的收款卡并占用额度, 按带最大尾数的金额检查
+	money := a.Truncate(0)
+	reserve := money.Add(decimal.NewFromInt(int64(conf.amountTagMax())).Div(decimal.NewFromInt(bankTxnUnit)))
+	bc, err := BankCardSelect(orderId, user.Level, reserve)
+	if err != nil {
+		fmt.Println("BankCardSelect err = ", err.Error())
+		return "", errors.New(helper.BankCardNotExist)
+	}
+
+	// 获取附言码, 同一张收款卡一段时间内不重复
+	code, err := DepositManualRemark(orderId, bc.Id)
+	if err != nil {
+		bankcardRelease(orderId)
+		return "", err
+	}
+
+	if tag := conf.amountTag(bc.Id); tag > 0 {
+		money, err = manualAmountTag(orderId, bc.Id, money, tag)
+		if err != nil {
+			bankcardRelease(orderId)
+			manualCodeRelease(orderId)
+			return "", err
+		}
+
+		amount = money.String()
+	}
+
+	// 占用的额度改为会员实际转账的金额
+	bankcardReserveUpdate(orderId, money)
+
 	d := g.Record{
 		"id":            orderId,
 		"prefix":        meta.Prefix,
@@ -117,11 +170,14 @@ func ManualPay(fctx *fasthttp.RequestCtx, paymentID, amount string) (string, err
 	err = deposit(d)
 	if err != nil {
 		fmt.Println("Manual deposit err = ", err)
+		bankcardRelease(orderId)
+		manualCodeRelease(orderId)
+		manualAmountRelease(orderId)
 		return "", pushLog(err, helper.DBErr)
 	}
 
 	// 记录存款行为
-	_ = cacheDepositProcessingInsert(user.UID, orderId, ts)
+	_ = cacheDepositProcessingInsert(user, orderId, ts)
 
 	res = map[string]string{
 		"id":           orderId,
@@ -130,9 +186,17 @@ func ManualPay(fctx *fasthttp.RequestCtx, paymentID, amount string) (string, err
 		"realname":     bc.AccountName,
 		"bankAddr":     bc.BankcardAddr,
 		"manualRemark": code,
+		"amount":       amount,
 		"ts":           fmt.Sprintf("%d", ts),
 	}
 
+	// 银行不支持VietQR时只返回文字信息
+	payload, img, err := manualQR(bc, money, code)
+	if err == nil {
+		res["qrPayload"] = payload
+		res["qrImage"] = img
+	}
+
 	bytes, _ := helper.JsonMarshal(res)
 	if user.Tester == "1" {
 		_, err = meta.MerchantRedis.Set(ctx, key, string(bytes), 30*time.Minute).Result()
@@ -145,7 +209,10 @@ func ManualPay(fctx *fasthttp.RequestCtx, paymentID, amount string) (string, err
 
 	if user.Tester == "0" {
 		DepositUpPointReview(orderId, user.UID, "系统", "自动", DepositSuccess)
-		CacheDepositProcessingRem(user.UID)
+		cacheDepositProcessingSuccess(user.UID, orderId)
+		bankcardRelease(orderId)
+		manualCodeRelease(orderId)
+		manualAmountRelease(orderId)
 	}
 	return string(bytes), nil
 }
@@ -212,12 +279,23 @@ func ManualReview(did, remark, name, uid string, state int, record Deposit) erro
 	}
 	defer depositUnLock(did)
 
-	err = DepositUpPointReview(did, uid, name, remark, state)
+	return manualReview(did, remark, name, uid, state, record)
+}
+
+// 调用方需要持有订单锁
+func manualReview(did, remark, name, uid string, state int, record Deposit) error {
+
+	err := DepositUpPointReview(did, uid, name, remark, state)
 	if err == nil {
 
+		// 释放订单占用的收款卡额度, 附言码和金额
+		bankcardRelease(did)
+		manualCodeRelease(did)
+		manualAmountRelease(did)
+
 		if state == DepositSuccess {
 			// 清除未未成功的订单计数
-			CacheDepositProcessingRem(record.UID)
+			cacheDepositProcessingSuccess(record.UID, did)
 			amount := decimal.NewFromFloat(record.Amount)
 
 			vals := g.Record{
@@ -235,10 +313,9 @@ func ManualReview(did, remark, name, uid string, state int, record Deposit) erro
 				return err
 			}
 
+			// 当天额度用完的卡选卡时会跳过, 零点清零后自动恢复, 只有累计额度用完才关闭
 			totalFinishAmount, _ := decimal.NewFromString(bc.TotalFinishAmount)
-			dailyFinishAmount, _ := decimal.NewFromString(bc.DailyFinishAmount)
 			totalMaxAmount, _ := decimal.NewFromString(bc.TotalMaxAmount)
-			dailyMaxAmount, _ := decimal.NewFromString(bc.DailyMaxAmount)
 
 			if totalFinishAmount.Cmp(totalMaxAmount) >= 0 {
 
@@ -247,14 +324,6 @@ func ManualReview(did, remark, name, uid string, state int, record Deposit) erro
 				}
 				BankCardUpdate(record.BankcardID, vals)
 			}
-
-			if dailyFinishAmount.Cmp(dailyMaxAmount) >= 0 {
-
-				vals = g.Record{
-					"state": "0",
-				}
-				BankCardUpdate(record.BankcardID, vals)
-			}
 		}
 
 		key := fmt.Sprintf("%s:finance:manual:%s", meta.Prefix, record.Username)
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+	"lukechampine.com/frand"
+)
+
+// 收款卡上未完成订单占用的金额(VND)
+func manualAmountKey(cardID string, vnd int64) string {
+	return fmt.Sprintf("%s:manual:amount:%s:%d", meta.Prefix, cardID, vnd)
+}
+
+// 订单占用的收款卡和金额
+func manualAmountOrderKey(orderID string) string {
+	return fmt.Sprintf("%s:manual:amount:order:%s", meta.Prefix, orderID)
+}
+
+// 收款卡单独配置的金额尾数最大值
+func manualAmountTagKey() string {
+	return fmt.Sprintf("%s:manual:amount:tag", meta.Prefix)
+}
+
+func manualAmountTagCards() (map[string]int, error) {
+
+	cards := map[string]int{}
+	res, err := meta.MerchantRedis.HGetAll(ctx, manualAmountTagKey()).Result()
+	if err != nil && err != redis.Nil {
+		return cards, pushLog(err, helper.RedisErr)
+	}
+
+	for k, v := range res {
+		n, err := strconv.Atoi(v)
+		if err == nil {
+			cards[k] = n
+		}
+	}
+
+	return cards, nil
+}
+
+// ManualAmountTagSet 设置收款卡的金额尾数最大值, inherit 为true时删除单独配置, 使用全局配置
+func ManualAmountTagSet(cardID string, max int, inherit bool) error {
+
+	_, err := BankCardByID(cardID)
+	if err != nil {
+		return err
+	}
+
+	if inherit {
+		err = meta.MerchantRedis.HDel(ctx, manualAmountTagKey(), cardID).Err()
+	} else {
+		err = meta.MerchantRedis.HSet(ctx, manualAmountTagKey(), cardID, max).Err()
+	}
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// 收款卡使用的金额尾数最大值
+func (conf ManualCodeConf) amountTag(cardID string) int {
+
+	if n, ok := conf.Cards[cardID]; ok {
+		return n
+	}
+
+	return conf.AmountTag
+}
+
+// 所有收款卡中最大的金额尾数, 选卡时按此占用额度
+func (conf ManualCodeConf) amountTagMax() int {
+
+	max := conf.AmountTag
+	for _, v := range conf.Cards {
+		if v > max {
+			max = v
+		}
+	}
+
+	return max
+}
+
+// 线下转卡金额加上1到max VND的尾数, 同一张收款卡上未完成的订单金额不重复, 返回金额单位为KVND
+func manualAmountTag(orderID, cardID string, amount decimal.Decimal, max int) (decimal.Decimal, error) {
+
+	// 订单未完成前不过期, 订单完成或取消后释放
+	base := amount.Mul(decimal.NewFromInt(bankTxnUnit)).IntPart()
+
+	// 从随机位置开始找, 减少并发请求互相冲突
+	start := frand.Intn(max)
+	for i := 0; i < max; i++ {
+		vnd := base + int64((start+i)%max+1)
+		ok, err := meta.MerchantRedis.SetNX(ctx, manualAmountKey(cardID, vnd), orderID, 0).Result()
+		if err != nil {
+			return amount, pushLog(err, helper.RedisErr)
+		}
+
+		if !ok {
+			continue
+		}
+
+		pipe := meta.MerchantRedis.TxPipeline()
+		pipe.Set(ctx, manualAmountOrderKey(orderID), fmt.Sprintf("%s|%d", cardID, vnd), 0)
+		pipe.ZAdd(ctx, manualReserveKey(), &redis.Z{Score: float64(time.Now().Unix()), Member: orderID})
+		_, err = pipe.Exec(ctx)
+		pipe.Close()
+		if err != nil {
+			meta.MerchantRedis.Unlink(ctx, manualAmountKey(cardID, vnd))
+			return amount, pushLog(err, helper.RedisErr)
+		}
+
+		return decimal.NewFromInt(vnd).Div(decimal.NewFromInt(bankTxnUnit)), nil
+	}
+
+	// 该卡上相同金额的未完成订单已经用完所有尾数
+	return amount, errors.New(helper.ChannelBusyTryOthers)
+}
+
+// 订单完成或取消后释放占用的金额
+func manualAmountRelease(orderID string) {
+
+	meta.MerchantRedis.ZRem(ctx, manualReserveKey(), orderID)
+	val, err := meta.MerchantRedis.Get(ctx, manualAmountOrderKey(orderID)).Result()
+	if err != nil {
+		return
+	}
+
+	s := strings.Split(val, "|")
+	if len(s) != 2 {
+		return
+	}
+
+	vnd, _ := strconv.ParseInt(s[1], 10, 64)
+	key := manualAmountKey(s[0], vnd)
+
+	// 只删除自己占用的
+	owner, err := meta.MerchantRedis.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		_ = pushLog(err, helper.RedisErr)
+		return
+	}
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.Unlink(ctx, manualAmountOrderKey(orderID))
+	if owner == orderID {
+		pipe.Unlink(ctx, key)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+}
+
+// 按收款卡和入账金额查找占用该金额的未完成订单
+func manualAmountOwner(cardID string, amount float64) string {
+
+	vnd := decimal.NewFromFloat(amount).Mul(decimal.NewFromInt(bankTxnUnit))
+	if !vnd.Equal(vnd.Truncate(0)) {
+		return ""
+	}
+
+	id, err := meta.MerchantRedis.Get(ctx, manualAmountKey(cardID, vnd.IntPart())).Result()
+	if err != nil {
+		return ""
+	}
+
+	return id
+}
@@ -0,0 +1,258 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	"github.com/go-redis/redis/v8"
+	"lukechampine.com/frand"
+)
+
+const (
+	manualCodeMin      = 100000
+	manualCodePool     = 900000        // 6位附言码的总数
+	manualCodeRetry    = 20            // 随机到已占用的附言码时的重试次数
+	manualCodeAlertTTL = 1 * time.Hour // 同一张卡的告警间隔
+	manualCodeWindow   = 3 * 24 * 3600 // 默认三天内不重复
+	manualCodeAlert    = 80            // 默认已用超过80%时告警
+
+	manualReserveLock     = "manual:reserve"
+	manualReserveInterval = 5 * time.Minute
+	manualReserveCheck    = 30 * 60 // 订单生成超过该时间(秒)后开始检查订单状态
+)
+
+// ManualCodeConf 线下转卡附言码和金额尾数配置
+type ManualCodeConf struct {
+	Window    int64          `json:"window" redis:"window"`         // 同一张收款卡附言码不重复的时间(秒)
+	Alert     int            `json:"alert" redis:"alert"`           // 已用占比超过多少(%)时告警
+	AmountTag int            `json:"amount_tag" redis:"amount_tag"` // 金额尾数的最大值(VND), 0为不开启
+	Cards     map[string]int `json:"cards" redis:"-"`               // 收款卡单独配置的金额尾数最大值, 未配置的卡使用 amount_tag
+}
+
+func ManualCodeConfGet() (ManualCodeConf, error) {
+
+	conf := ManualCodeConf{}
+	key := fmt.Sprintf("%s:manual:code:conf", meta.Prefix)
+	err := meta.MerchantRedis.HGetAll(ctx, key).Scan(&conf)
+	if err != nil && err != redis.Nil {
+		return conf, pushLog(err, helper.RedisErr)
+	}
+
+	if conf.Window == 0 {
+		conf.Window = manualCodeWindow
+	}
+	if conf.Alert == 0 {
+		conf.Alert = manualCodeAlert
+	}
+
+	conf.Cards, err = manualAmountTagCards()
+	if err != nil {
+		return conf, err
+	}
+
+	return conf, nil
+}
+
+func ManualCodeConfSet(conf ManualCodeConf) error {
+
+	key := fmt.Sprintf("%s:manual:code:conf", meta.Prefix)
+	err := meta.MerchantRedis.HSet(ctx, key,
+		"window", conf.Window,
+		"alert", conf.Alert,
+		"amount_tag", conf.AmountTag,
+	).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// 收款卡上被占用的附言码
+func manualCodeKey(cardID, code string) string {
+	return fmt.Sprintf("%s:manual:code:%s:%s", meta.Prefix, cardID, code)
+}
+
+// 收款卡上被占用的附言码, 按可以重新使用的时间排序, 用来统计剩余数量
+func manualCodeUsedKey(cardID string) string {
+	return fmt.Sprintf("%s:manual:code:used:%s", meta.Prefix, cardID)
+}
+
+// 订单使用的收款卡, 附言码和生成时间
+func manualCodeOrderKey(orderID string) string {
+	return fmt.Sprintf("%s:manual:code:order:%s", meta.Prefix, orderID)
+}
+
+// 占用附言码或金额的订单, 按生成时间排序, 由 ManualReserveTask 检查订单状态
+func manualReserveKey() string {
+	return fmt.Sprintf("%s:manual:reserve", meta.Prefix)
+}
+
+// DepositManualRemark 为订单生成附言码, 同一张收款卡在配置的时间内不重复, 订单未完成前一直占用
+func DepositManualRemark(orderID, cardID string) (string, error) {
+
+	conf, err := ManualCodeConfGet()
+	if err != nil {
+		return "", err
+	}
+
+	// 订单未完成前不过期, 订单完成或取消后从生成时间起算不重复的时间
+	now := time.Now().Unix()
+	for i := 0; i < manualCodeRetry; i++ {
+		code := strconv.Itoa(frand.Intn(manualCodePool) + manualCodeMin)
+		ok, err := meta.MerchantRedis.SetNX(ctx, manualCodeKey(cardID, code), orderID, 0).Result()
+		if err != nil {
+			return "", pushLog(err, helper.RedisErr)
+		}
+
+		if !ok {
+			continue
+		}
+
+		pipe := meta.MerchantRedis.TxPipeline()
+		pipe.ZAdd(ctx, manualCodeUsedKey(cardID), &redis.Z{Score: math.MaxInt64, Member: code})
+		pipe.Set(ctx, manualCodeOrderKey(orderID), fmt.Sprintf("%s|%s|%d", cardID, code, now), 0)
+		pipe.ZAdd(ctx, manualReserveKey(), &redis.Z{Score: float64(now), Member: orderID})
+		_, err = pipe.Exec(ctx)
+		pipe.Close()
+		if err != nil {
+			return "", pushLog(err, helper.RedisErr)
+		}
+
+		manualCodeUsage(cardID, conf, now)
+		return code, nil
+	}
+
+	// 多次随机都已被占用, 说明可用的附言码已经很少
+	manualCodeAlertSend(cardID, -1)
+	return "", errors.New(helper.ChannelBusyTryOthers)
+}
+
+// 订单完成或取消后释放附言码, 生成后配置的时间内仍不能被其他订单使用
+func manualCodeRelease(orderID string) {
+
+	meta.MerchantRedis.ZRem(ctx, manualReserveKey(), orderID)
+	val, err := meta.MerchantRedis.Get(ctx, manualCodeOrderKey(orderID)).Result()
+	if err != nil {
+		return
+	}
+
+	s := strings.Split(val, "|")
+	if len(s) != 3 {
+		return
+	}
+
+	conf, err := ManualCodeConfGet()
+	if err != nil {
+		return
+	}
+
+	cardID, code := s[0], s[1]
+	createdAt, _ := strconv.ParseInt(s[2], 10, 64)
+	freeAt := createdAt + conf.Window
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.Unlink(ctx, manualCodeOrderKey(orderID))
+	if freeAt <= time.Now().Unix() {
+		pipe.Unlink(ctx, manualCodeKey(cardID, code))
+		pipe.ZRem(ctx, manualCodeUsedKey(cardID), code)
+	} else {
+		pipe.ExpireAt(ctx, manualCodeKey(cardID, code), time.Unix(freeAt, 0))
+		pipe.ZAdd(ctx, manualCodeUsedKey(cardID), &redis.Z{Score: float64(freeAt), Member: code})
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+}
+
+// 统计收款卡已占用的附言码, 超过配置的占比时告警
+func manualCodeUsage(cardID string, conf ManualCodeConf, now int64) {
+
+	key := manualCodeUsedKey(cardID)
+	pipe := meta.MerchantRedis.Pipeline()
+	defer pipe.Close()
+
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now, 10))
+	used := pipe.ZCard(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+		return
+	}
+
+	if used.Val()*100 >= int64(manualCodePool*conf.Alert) {
+		manualCodeAlertSend(cardID, used.Val())
+	}
+}
+
+func manualCodeAlertSend(cardID string, used int64) {
+
+	key := fmt.Sprintf("%s:manual:code:alert:%s", meta.Prefix, cardID)
+	ok, err := meta.MerchantRedis.SetNX(ctx, key, "1", manualCodeAlertTTL).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	text := fmt.Sprintf("⚠️附言码不足⚠️\r\n收款卡ID: %s\r\n已占用: %d/%d\r\n站点: %s", cardID, used, manualCodePool, meta.Prefix)
+	if used < 0 {
+		text = fmt.Sprintf("⚠️附言码不足⚠️\r\n收款卡ID: %s\r\n连续%d次生成的附言码都已被占用\r\n站点: %s", cardID, manualCodeRetry, meta.Prefix)
+	}
+
+	err = telegramSend(text)
+	if err != nil {
+		fmt.Println("manualCodeAlertSend telegram = ", err.Error())
+	}
+}
+
+// ManualReserveTask 定时检查占用附言码和金额的订单, 释放已完成, 已取消或未写入的订单的占用
+// 未完成的订单一直占用, 不会因为超时被其他订单使用
+func ManualReserveTask() {
+
+	ticker := time.NewTicker(manualReserveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// 多实例只需要一个执行
+		if Lock(manualReserveLock) != nil {
+			continue
+		}
+
+		manualReserveSweep(time.Now().Unix())
+		Unlock(manualReserveLock)
+	}
+}
+
+func manualReserveSweep(now int64) {
+
+	ids, err := meta.MerchantRedis.ZRangeByScore(ctx, manualReserveKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now-manualReserveCheck, 10),
+	}).Result()
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+		return
+	}
+
+	for _, id := range ids {
+		order, err := DepositFindOne(id)
+		if err != nil && err.Error() != helper.OrderNotExist {
+			continue
+		}
+
+		if err == nil && (order.State == DepositConfirming || order.State == DepositReviewing) {
+			continue
+		}
+
+		manualCodeRelease(id)
+		manualAmountRelease(id)
+	}
+}
@@ -1,11 +1,13 @@
 package model
 
 import (
+	"database/sql"
 	"errors"
 	"finance/contrib/helper"
 	"fmt"
 
 	g "github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/shopspring/decimal"
 	"github.com/valyala/fasthttp"
 )
@@ -247,6 +249,23 @@ func GetBalanceDB(uid string) (MBBalance, error) {
 	return balance, nil
 }
 
+// 事务内锁定会员并读取余额, 提交或回滚前其他事务无法修改该会员余额
+func memberBalanceLock(tx *sql.Tx, uid string) (decimal.Decimal, error) {
+
+	var balance string
+	ex := g.Ex{
+		"uid":    uid,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("tbl_members").Select("balance").Where(ex).Limit(1).ForUpdate(exp.Wait).ToSQL()
+	err := tx.QueryRow(query).Scan(&balance)
+	if err != nil {
+		return zero, pushLog(err, helper.DBErr)
+	}
+
+	return decimal.NewFromString(balance)
+}
+
 //获取用户 首存 和 二存
 func GetUserDeposit(uid string) (MBBalance, error) {
 
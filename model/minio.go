@@ -0,0 +1,154 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// minio 只用到上传和临时下载地址, 直接按 S3 V4 签名发请求, 不引入sdk
+const (
+	minioRegion      = "us-east-1"
+	minioAlgorithm   = "AWS4-HMAC-SHA256"
+	minioUnsignedSum = "UNSIGNED-PAYLOAD"
+)
+
+var minioClient = &http.Client{Timeout: 5 * time.Minute}
+
+// minioEnable minio是否已配置
+func minioEnable(bucket string) bool {
+	return meta.Minio.Endpoint != "" && meta.Minio.AccessKeyID != "" && bucket != ""
+}
+
+// minioPutObject 上传文件, body按流读取不会整体载入内存
+func minioPutObject(bucket, object string, body io.Reader, size int64, contentType string) error {
+
+	if !minioEnable(bucket) {
+		return errors.New("minio not configured")
+	}
+
+	u := minioURL(bucket, object)
+	req, err := http.NewRequest(http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", minioUnsignedSum)
+
+	headers := map[string]string{
+		"content-type":         contentType,
+		"host":                 u.Host,
+		"x-amz-content-sha256": minioUnsignedSum,
+		"x-amz-date":           now.Format("20060102T150405Z"),
+	}
+	signed, canonical := minioCanonicalHeaders(headers)
+	creq := strings.Join([]string{http.MethodPut, u.EscapedPath(), "", canonical, signed, minioUnsignedSum}, "\n")
+
+	scope := minioScope(now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		minioAlgorithm, meta.Minio.AccessKeyID, scope, signed, minioSign(now, scope, creq)))
+
+	resp, err := minioClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("minio put %s status %d: %s", object, resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+// minioPresignGet 生成有时效的下载地址
+func minioPresignGet(bucket, object string, expires time.Duration) string {
+
+	return minioPresign(minioURL(bucket, object), time.Now().UTC(), expires)
+}
+
+func minioPresign(u *url.URL, now time.Time, expires time.Duration) string {
+
+	scope := minioScope(now)
+
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", minioAlgorithm)
+	q.Set("X-Amz-Credential", meta.Minio.AccessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	// url.Values.Encode 按key排序, 空格编码为+, S3要求%20
+	query := strings.ReplaceAll(q.Encode(), "+", "%20")
+
+	creq := strings.Join([]string{http.MethodGet, u.EscapedPath(), query, "host:" + u.Host + "\n", "host", minioUnsignedSum}, "\n")
+	u.RawQuery = query + "&X-Amz-Signature=" + minioSign(now, scope, creq)
+
+	return u.String()
+}
+
+func minioURL(bucket, object string) *url.URL {
+
+	scheme := "http"
+	if meta.Minio.UseSSL {
+		scheme = "https"
+	}
+
+	return &url.URL{
+		Scheme: scheme,
+		Host:   meta.Minio.Endpoint,
+		Path:   "/" + bucket + "/" + object,
+	}
+}
+
+func minioScope(t time.Time) string {
+	return fmt.Sprintf("%s/%s/s3/aws4_request", t.Format("20060102"), minioRegion)
+}
+
+func minioCanonicalHeaders(headers map[string]string) (string, string) {
+
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+
+	return strings.Join(keys, ";"), b.String()
+}
+
+func minioSign(t time.Time, scope, canonicalRequest string) string {
+
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	toSign := strings.Join([]string{minioAlgorithm, t.Format("20060102T150405Z"), scope, hex.EncodeToString(sum[:])}, "\n")
+
+	key := minioHmac([]byte("AWS4"+meta.Minio.SecretAccessKey), t.Format("20060102"))
+	key = minioHmac(key, minioRegion)
+	key = minioHmac(key, "s3")
+	key = minioHmac(key, "aws4_request")
+
+	return hex.EncodeToString(minioHmac(key, toSign))
+}
+
+func minioHmac(key []byte, data string) []byte {
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	g "github.com/doug-martin/goqu/v9"
+	"github.com/shopspring/decimal"
 	"github.com/valyala/fasthttp"
 	"github.com/wI2L/jettison"
 )
@@ -27,7 +28,42 @@ func NewestPay(fctx *fasthttp.RequestCtx, pid, amount, bid string) (map[string]s
 		return res, errors.New(helper.ChannelNotExist)
 	}
 
+	// 检查通道的营业时间和维护计划
+	err = PaymentOpenCheck(p, fctx.Time())
+	if err != nil {
+		return res, err
+	}
+
 	fmt.Println("NewestPay p:", p)
+	dm, err := decimal.NewFromString(amount)
+	if err != nil {
+		return res, errors.New(helper.AmountErr)
+	}
+
+	// 检查通道剩余的每日和累计额度
+	err = PaymentQuotaCheck(p, dm, fctx.Time())
+	if err != nil {
+		return res, err
+	}
+
+	// 检查会员是否被限制存款
+	err = MemberLockCheck(user.UID, LockScopeChannel, p.ID)
+	if err != nil {
+		return res, err
+	}
+
+	// 存款次数和额度限制, 加锁到订单写入完成, 防止并发请求绕过限制
+	err = velocityLock(VelocityDeposit, user.UID)
+	if err != nil {
+		return res, err
+	}
+	defer velocityUnlock(VelocityDeposit, user.UID)
+
+	err = velocityCheck(VelocityDeposit, user, dm, fctx.Time())
+	if err != nil {
+		return res, err
+	}
+
 	data, err = Pay(user, p, amount, bid)
 	if err != nil {
 		/*
@@ -96,7 +132,7 @@ func NewestPay(fctx *fasthttp.RequestCtx, pid, amount, bid string) (map[string]s
 	}
 
 	// 记录存款行为
-	_ = cacheDepositProcessingInsert(user.UID, data.OrderID, ts)
+	_ = cacheDepositProcessingInsert(user, data.OrderID, ts)
 
 	res["id"] = data.OrderID
 	res["url"] = data.Addr
@@ -174,7 +210,7 @@ func CoinPay(ctx *fasthttp.RequestCtx, pid, amount string, user Member) {
 	pLog.OrderID = helper.GenId()
 
 	// 检查用户的存款行为是否过于频繁
-	err = cacheDepositProcessing(user.UID, time.Now().Unix())
+	err = cacheDepositProcessing(user, time.Now().Unix())
 	if err != nil {
 		helper.Print(ctx, false, err.Error())
 		return
@@ -240,7 +276,7 @@ func CoinPay(ctx *fasthttp.RequestCtx, pid, amount string, user Member) {
 	}
 
 	// 记录存款行为
-	_ = cacheDepositProcessingInsert(user.UID, pLog.OrderID, ctx.Time().Unix())
+	_ = cacheDepositProcessingInsert(user, pLog.OrderID, ctx.Time().Unix())
 
 	res := coinPayCommRes{
 		ID:           pLog.OrderID,
@@ -325,6 +361,9 @@ func DepositCallBack(fctx *fasthttp.RequestCtx, payment_id string) {
 		return
 	}
 
+	// 回调金额与订单金额不一致时和订单状态一起更新的字段
+	var mismatch g.Record
+
 	// usdt 验证usdt金额
 	if order.PID == "101003754213878523" {
 
@@ -346,18 +385,43 @@ func DepositCallBack(fctx *fasthttp.RequestCtx, payment_id string) {
 		orderAmount := fmt.Sprintf("%.4f", order.Amount)
 		err = compareAmount(data.Amount, orderAmount, data.Cent)
 		if err != nil {
-			err = fmt.Errorf("compare amount error: [err: %v, req: %s, origin: %s]", err, data.Amount, orderAmount)
-			fctx.SetBody([]byte(`failed`))
-			return
+			// 金额不一致时按通道配置拒绝, 按实际金额上分或转人工审核
+			record, review, e := depositMismatch(order, data.State, data.Amount, data.Cent)
+			if e != nil {
+				err = fmt.Errorf("compare amount error: [err: %v, mismatch: %v, req: %s, origin: %s]", err, e, data.Amount, orderAmount)
+				fctx.SetBody([]byte(`failed`))
+				return
+			}
+
+			pLog.Error = fmt.Sprintf("amount mismatch: [req: %s, origin: %s, review: %t]", data.Amount, orderAmount, review)
+			err = nil
+			mismatch = record
+			// 转入金额不一致审核的订单由人工确认后上分
+			if review {
+				data.State = DepositReviewing
+				err = depositMismatchHold(order, record)
+				if err != nil {
+					err = fmt.Errorf("set order state error: [%v], old state=%d, new state=%d", err, order.State, data.State)
+					fctx.SetBody([]byte(`failed`))
+					return
+				}
+			}
 		}
 	}
 
 	// 修改订单状态
-	err = depositUpdate(data.State, order)
-	if err != nil {
-		err = fmt.Errorf("set order state error: [%v], old state=%d, new state=%d", err, order.State, data.State)
-		fctx.SetBody([]byte(`failed`))
-		return
+	if data.State != DepositReviewing {
+		err = depositUpdate(data.State, order, mismatch)
+		if err != nil {
+			err = fmt.Errorf("set order state error: [%v], old state=%d, new state=%d", err, order.State, data.State)
+			fctx.SetBody([]byte(`failed`))
+			return
+		}
+
+		// 按实际支付金额上分的订单通知会员
+		if mismatch != nil {
+			depositMismatchNotice(order.ID)
+		}
 	}
 
 	if data.Resp != nil {
@@ -117,13 +117,12 @@ func Pay(user Member, p FPay, amount, bid string) (paymentDepositResp, error) {
 
 	fmt.Println("Pay orderId = ", orderId)
 
-	/*
-		// 检查用户的存款行为是否过于频繁
-		err = cacheDepositProcessing(user.UID, time.Now().Unix())
-		if err != nil {
-			return data, err
-		}
-	*/
+	// 检查用户的存款行为是否过于频繁
+	err = cacheDepositProcessing(user, time.Now().Unix())
+	if err != nil {
+		return data, err
+	}
+
 	// 向渠道方发送存款订单请求
 	data, err = payment.Pay(orderId, p.ChannelID, amount, bid)
 	fmt.Println("Pay  payment.Pay err = ", err)
@@ -306,14 +305,6 @@ func BankCards(channelBankID string) (Bankcard_t, error) {
 	return card, nil
 }
 
-// DepositManualRemark 生成不重复的附言码 三天内不重复
-func DepositManualRemark(cardID string) (string, error) {
-
-	code := 0
-
-	return strconv.Itoa(code), nil
-}
-
 func PushWithdrawSuccess(uid string, amount float64) error {
 	msg := fmt.Sprintf(`{"amount": %.4f, "flags":"withdraw"}`, amount)
 
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 通道当天的已收金额, 按商户时区的日期分key, 过期后自动清零
+func paymentQuotaDayKey(now time.Time) string {
+	return fmt.Sprintf("%s:payment:quota:%s", meta.Prefix, now.In(loc).Format("20060102"))
+}
+
+// 通道累计的已收金额
+func paymentQuotaTotalKey() string {
+	return fmt.Sprintf("%s:payment:quota:total", meta.Prefix)
+}
+
+// 批量获取通道当天和累计的已收金额
+func paymentQuotaUsed(ids []string, now time.Time) (map[string][2]decimal.Decimal, error) {
+
+	used := map[string][2]decimal.Decimal{}
+	if len(ids) == 0 {
+		return used, nil
+	}
+
+	pipe := meta.MerchantRedis.Pipeline()
+	defer pipe.Close()
+
+	day := pipe.HMGet(ctx, paymentQuotaDayKey(now), ids...)
+	total := pipe.HMGet(ctx, paymentQuotaTotalKey(), ids...)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return used, pushLog(err, helper.RedisErr)
+	}
+
+	dv, tv := day.Val(), total.Val()
+	for i, id := range ids {
+		var u [2]decimal.Decimal
+		ds, dok := dv[i].(string)
+		ts, tok := tv[i].(string)
+
+		// redis中没有记录时按成功的存款订单重新统计
+		if !dok || !tok {
+			u, err = paymentQuotaRebuild(id, now)
+			if err != nil {
+				return used, err
+			}
+
+			used[id] = u
+			continue
+		}
+
+		u[0], _ = decimal.NewFromString(ds)
+		u[1], _ = decimal.NewFromString(ts)
+		used[id] = u
+	}
+
+	return used, nil
+}
+
+// 从成功的存款订单统计通道当天和清零后的已收金额并写回redis
+func paymentQuotaRebuild(pid string, now time.Time) ([2]decimal.Decimal, error) {
+
+	var u [2]decimal.Decimal
+
+	var resetAt int64
+	query, _, _ := dialect.From("f_payment").Select("quota_reset_at").Where(g.Ex{"id": pid}).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&resetAt, query)
+	if err != nil && err != sql.ErrNoRows {
+		return u, pushLog(err, helper.DBErr)
+	}
+
+	y, m, d := now.In(loc).Date()
+	dayAt := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	for i, startAt := range []int64{dayAt.Unix(), resetAt} {
+		var amount sql.NullFloat64
+		ex := g.Ex{
+			"pid":        pid,
+			"prefix":     meta.Prefix,
+			"state":      DepositSuccess,
+			"confirm_at": g.Op{"gte": startAt},
+		}
+		query, _, _ = dialect.From("tbl_deposit").Select(g.SUM("amount")).Where(ex).ToSQL()
+		err = meta.MerchantDB.Get(&amount, query)
+		if err != nil {
+			return u, pushLog(err, helper.DBErr)
+		}
+
+		u[i] = decimal.NewFromFloat(amount.Float64)
+	}
+
+	// 已有累加时以redis为准
+	key := paymentQuotaDayKey(now)
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.HSetNX(ctx, key, pid, u[0].String())
+	pipe.ExpireAt(ctx, key, time.Date(y, m, d+1, 23, 59, 59, 0, loc))
+	pipe.HSetNX(ctx, paymentQuotaTotalKey(), pid, u[1].String())
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+
+	return u, nil
+}
+
+// 加上本次金额后是否超过每日限额或总限额, 限额为0表示不限制
+func paymentQuotaExceed(quota, amount string, used [2]decimal.Decimal, add decimal.Decimal) bool {
+
+	limits := [2]string{quota, amount}
+	for i, v := range limits {
+		limit, err := decimal.NewFromString(v)
+		if err != nil || limit.LessThanOrEqual(zero) {
+			continue
+		}
+
+		if add.IsZero() && used[i].GreaterThanOrEqual(limit) {
+			return true
+		}
+
+		if used[i].Add(add).GreaterThan(limit) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PaymentQuotaCheck 发起存款前检查通道剩余额度
+func PaymentQuotaCheck(p FPay, amount decimal.Decimal, now time.Time) error {
+
+	used, err := paymentQuotaUsed([]string{p.ID}, now)
+	if err != nil {
+		return err
+	}
+
+	if paymentQuotaExceed(p.Quota, p.Amount, used[p.ID], amount) {
+		return errors.New(helper.ChannelBusyTryOthers)
+	}
+
+	return nil
+}
+
+// 存款成功后累加通道的已收金额
+func paymentQuotaIncr(pid string, amount float64) {
+
+	if pid == "" || amount <= 0 {
+		return
+	}
+
+	now := time.Now().In(loc)
+	y, m, d := now.Date()
+	key := paymentQuotaDayKey(now)
+
+	// 先补齐redis中缺失的统计, 避免只累加本次金额
+	_, _ = paymentQuotaUsed([]string{pid}, now)
+
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.HIncrByFloat(ctx, key, pid, amount)
+	pipe.ExpireAt(ctx, key, time.Date(y, m, d+1, 23, 59, 59, 0, loc))
+	pipe.HIncrByFloat(ctx, paymentQuotaTotalKey(), pid, amount)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+}
+
+// PaymentQuotaReset 清零通道的累计已收金额, 记录清零时间用于重新统计
+func PaymentQuotaReset(id string) error {
+
+	record := g.Record{"quota_reset_at": time.Now().Unix()}
+	query, _, _ := dialect.Update("f_payment").Set(record).Where(g.Ex{"id": id}).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	err = meta.MerchantRedis.HSet(ctx, paymentQuotaTotalKey(), id, "0").Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+)
+
+// PaymentMaintenance 渠道或通道的计划维护, payment_id 为0时整个渠道维护
+type PaymentMaintenance struct {
+	ID          string `db:"id" json:"id"`
+	CateID      string `db:"cate_id" json:"cate_id"`
+	PaymentID   string `db:"payment_id" json:"payment_id"`
+	StartAt     int64  `db:"start_at" json:"start_at"`
+	EndAt       int64  `db:"end_at" json:"end_at"`
+	Message     string `db:"message" json:"message"` // 展示给会员的维护提示
+	State       int    `db:"state" json:"state"`     // 0 已取消 1 正常
+	CreatedAt   int64  `db:"created_at" json:"created_at"`
+	CreatedUID  string `db:"created_uid" json:"created_uid"`
+	CreatedName string `db:"created_name" json:"created_name"`
+	Prefix      string `db:"prefix" json:"prefix"`
+}
+
+type PaymentMaintenanceData struct {
+	D []PaymentMaintenance `json:"d"`
+	T int64                `json:"t"`
+	S uint16               `json:"s"`
+}
+
+func MaintenanceList(ex g.Ex, page, pageSize uint16) (PaymentMaintenanceData, error) {
+
+	data := PaymentMaintenanceData{}
+	ex["prefix"] = meta.Prefix
+
+	if page == 1 {
+		query, _, _ := dialect.From("f_payment_maintenance").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("f_payment_maintenance").Select(colMaintenance...).Where(ex).
+		Offset(uint(offset)).Limit(uint(pageSize)).Order(g.C("start_at").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+func MaintenanceInsert(cateID, paymentID, startTime, endTime string, record g.Record) error {
+
+	startAt, err := helper.TimeToLoc(startTime, loc)
+	if err != nil {
+		return errors.New(helper.DateTimeErr)
+	}
+
+	endAt, err := helper.TimeToLoc(endTime, loc)
+	if err != nil || endAt <= startAt || endAt <= time.Now().Unix() {
+		return errors.New(helper.DateTimeErr)
+	}
+
+	if paymentID != "0" {
+		p, err := ChanByID(paymentID)
+		if err != nil {
+			return err
+		}
+
+		if p.ID == "" || p.CateID != cateID {
+			return errors.New(helper.ChannelIDErr)
+		}
+	} else {
+		var id string
+		query, _, _ := dialect.From("f_category").Select("id").Where(g.Ex{"id": cateID, "prefix": meta.Prefix}).ToSQL()
+		_ = meta.MerchantDB.Get(&id, query)
+		if id == "" {
+			return errors.New(helper.CateNotExist)
+		}
+	}
+
+	record["id"] = helper.GenId()
+	record["cate_id"] = cateID
+	record["payment_id"] = paymentID
+	record["start_at"] = startAt
+	record["end_at"] = endAt
+	record["state"] = 1
+	record["prefix"] = meta.Prefix
+	query, _, _ := dialect.Insert("f_payment_maintenance").Rows(record).ToSQL()
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return MaintenanceUpdateCache()
+}
+
+// MaintenanceCancel 取消或提前结束维护
+func MaintenanceCancel(id string) error {
+
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Update("f_payment_maintenance").Set(g.Record{"state": 0}).Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.RecordNotExistErr)
+	}
+
+	return MaintenanceUpdateCache()
+}
+
+// MaintenanceUpdateCache 缓存未结束的维护计划
+func MaintenanceUpdateCache() error {
+
+	var data []PaymentMaintenance
+	ex := g.Ex{
+		"prefix": meta.Prefix,
+		"state":  1,
+		"end_at": g.Op{"gt": time.Now().Unix()},
+	}
+	query, _, _ := dialect.From("f_payment_maintenance").Select(colMaintenance...).Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	key := fmt.Sprintf("%s:payment:maintenance", meta.Prefix)
+	if len(data) == 0 {
+		_, err = meta.MerchantRedis.Unlink(ctx, key).Result()
+		if err != nil {
+			return pushLog(err, helper.RedisErr)
+		}
+
+		return nil
+	}
+
+	b, err := helper.JsonMarshal(data)
+	if err != nil {
+		return errors.New(helper.FormatErr)
+	}
+
+	_, err = meta.MerchantRedis.Set(ctx, key, string(b), 0).Result()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// MaintenanceActive 当前正在维护的渠道和通道, 前台用来展示维护提示
+func MaintenanceActive(now time.Time) ([]PaymentMaintenance, error) {
+
+	var (
+		data   []PaymentMaintenance
+		active []PaymentMaintenance
+	)
+
+	key := fmt.Sprintf("%s:payment:maintenance", meta.Prefix)
+	b, err := meta.MerchantRedis.Get(ctx, key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return active, nil
+		}
+
+		return active, pushLog(err, helper.RedisErr)
+	}
+
+	err = helper.JsonUnmarshal(b, &data)
+	if err != nil {
+		return active, errors.New(helper.FormatErr)
+	}
+
+	ts := now.Unix()
+	for _, v := range data {
+		if v.StartAt <= ts && v.EndAt > ts {
+			active = append(active, v)
+		}
+	}
+
+	return active, nil
+}
+
+func maintenanceMatch(mts []PaymentMaintenance, cateID, paymentID string) (PaymentMaintenance, bool) {
+
+	for _, v := range mts {
+		if v.CateID != cateID {
+			continue
+		}
+
+		if v.PaymentID == "0" || v.PaymentID == paymentID {
+			return v, true
+		}
+	}
+
+	return PaymentMaintenance{}, false
+}
+
+// PaymentCheckWeekdays 营业日格式 1,2,3 (周一到周日为1-7), 为空表示每天
+func PaymentCheckWeekdays(weekdays string) bool {
+
+	if weekdays == "" {
+		return true
+	}
+
+	for _, v := range strings.Split(weekdays, ",") {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 || d > 7 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func paymentClock(s string) int {
+
+	t, err := time.Parse("15:04:05", s)
+	if err != nil {
+		return 0
+	}
+
+	return t.Hour()*3600 + t.Minute()*60 + t.Second()
+}
+
+func paymentWeekday(t time.Time) string {
+
+	d := int(t.Weekday())
+	if d == 0 {
+		d = 7
+	}
+
+	return strconv.Itoa(d)
+}
+
+// 检查通道是否在营业时间内, st 等于 et 时全天营业, st 大于 et 时跨天营业
+func paymentWindowOpen(st, et, weekdays string, now time.Time) bool {
+
+	now = now.In(loc)
+	start := paymentClock(st)
+	end := paymentClock(et)
+	clock := now.Hour()*3600 + now.Minute()*60 + now.Second()
+
+	// 跨天营业时, 凌晨的时间段属于前一天的营业时间
+	day := now
+	open := true
+	if start < end {
+		open = clock >= start && clock < end
+	} else if start > end {
+		open = clock >= start || clock < end
+		if clock < end {
+			day = now.AddDate(0, 0, -1)
+		}
+	}
+
+	if !open {
+		return false
+	}
+
+	if weekdays == "" {
+		return true
+	}
+
+	wd := paymentWeekday(day)
+	for _, v := range strings.Split(weekdays, ",") {
+		if v == wd {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PaymentOpenCheck 发起存款前检查通道的营业时间和维护计划
+func PaymentOpenCheck(p FPay, now time.Time) error {
+
+	mts, err := MaintenanceActive(now)
+	if err != nil {
+		return err
+	}
+
+	if m, ok := maintenanceMatch(mts, p.CateID, p.ID); ok {
+		if m.Message != "" {
+			return errors.New(m.Message)
+		}
+
+		return errors.New(helper.ChannelBusyTryOthers)
+	}
+
+	if !paymentWindowOpen(p.St, p.Et, p.Weekdays, now) {
+		return errors.New(helper.ChannelBusyTryOthers)
+	}
+
+	return nil
+}
+
+// 代付轮询时检查通道是否可用
+func paymentOpenByID(id string, now time.Time) bool {
+
+	p, err := ChanByID(id)
+	if err != nil || p.ID == "" {
+		return false
+	}
+
+	mts, err := MaintenanceActive(now)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := maintenanceMatch(mts, p.CateID, p.ID); ok {
+		return false
+	}
+
+	return paymentWindowOpen(p.St, p.Et, p.Weekdays, now)
+}
@@ -72,5 +72,41 @@ const (
     "content": "Người dùng %s, Phát hạ điểm thủ công, %s hạ điểm %s KVND, vui lòng nhanh chóng xét duyệt.",
     "url": "/fin/ManualUpAndDown?name=review_list"
   }
+}`
+	// 高风险操作复核
+	approvalReviewFmt = `{
+  "cn": {
+    "title": "操作复核",
+    "content": "用户 %s，发起%s，金额 %s，请尽快复核。",
+    "url": "/fin/approval"
+  },
+  "en": {
+    "title": "Operation approval",
+    "content": "User %s submitted %s, amount %s, please approve as soon as possible.",
+    "url": "/fin/approval"
+  },
+  "vn": {
+    "title": "Duyệt thao tác",
+    "content": "Người dùng %s, đã gửi %s, số tiền %s, vui lòng nhanh chóng xét duyệt.",
+    "url": "/fin/approval"
+  }
+}`
+	// 提款超时
+	withdrawSlaFmt = `{
+  "cn": {
+    "title": "提款超时",
+    "content": "提款单 %s，会员 %s，当前状态已持续 %s，请尽快处理。",
+    "url": "/risk/withdrawalReview"
+  },
+  "en": {
+    "title": "Withdrawal overdue",
+    "content": "Withdrawal %s, member %s, has been in the current state for %s, please handle it as soon as possible.",
+    "url": "/risk/withdrawalReview"
+  },
+  "vn": {
+    "title": "Rút tiền quá hạn",
+    "content": "Đơn rút tiền %s, thành viên %s, đã ở trạng thái hiện tại %s, vui lòng nhanh chóng xử lý.",
+    "url": "/risk/withdrawalReview"
+  }
 }`
 )
@@ -0,0 +1,865 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/shopspring/decimal"
+)
+
+// 对账类型
+const (
+	ReconcileDeposit  = 1 // 代收
+	ReconcileWithdraw = 2 // 代付
+)
+
+// 对账结果
+const (
+	ReconcileMatched     = 1 // 一致
+	ReconcileMissingOurs = 2 // 我方缺单
+	ReconcileMissingPSP  = 3 // 三方缺单
+	ReconcileAmountDiff  = 4 // 金额不一致
+	ReconcileStateDiff   = 5 // 状态不一致
+)
+
+// 差异处理状态
+const (
+	ReconcileItemPending  = 0 // 待处理
+	ReconcileItemRepaired = 1 // 已补单
+	ReconcileItemIgnored  = 2 // 已忽略
+)
+
+// ReconcileMapping 三方账单字段映射, 每个渠道的代收/代付各一条
+type ReconcileMapping struct {
+	ID           string `db:"id" json:"id"`
+	CateID       string `db:"cate_id" json:"cate_id"`
+	CateName     string `db:"-" json:"cate_name"`
+	Ty           int    `db:"ty" json:"ty"`                       // 1 代收 2 代付
+	HeaderRow    int    `db:"header_row" json:"header_row"`       // 表头所在行, 从1开始
+	ColID        string `db:"col_id" json:"col_id"`               // 我方订单号列名
+	ColOID       string `db:"col_oid" json:"col_oid"`             // 三方订单号列名
+	ColAmount    string `db:"col_amount" json:"col_amount"`       // 金额列名
+	ColState     string `db:"col_state" json:"col_state"`         // 状态列名, 为空表示账单只包含成功订单
+	SuccessValue string `db:"success_value" json:"success_value"` // 状态列中表示成功的值, 多个逗号分开
+	Cent         int64  `db:"cent" json:"cent"`                   // 账单金额单位, 账单金额除以该值为KVND, 越南盾账单为1000
+	CreatedAt    int64  `db:"created_at" json:"created_at"`
+	UpdatedAt    int64  `db:"updated_at" json:"updated_at"`
+	UpdatedUID   string `db:"updated_uid" json:"updated_uid"`
+	UpdatedName  string `db:"updated_name" json:"updated_name"`
+	Prefix       string `db:"prefix" json:"prefix"`
+}
+
+// ReconcileBatch 一次账单导入对账的汇总
+type ReconcileBatch struct {
+	ID          string `db:"id" json:"id"`
+	MappingID   string `db:"mapping_id" json:"mapping_id"`
+	CateID      string `db:"cate_id" json:"cate_id"`
+	Ty          int    `db:"ty" json:"ty"`
+	FileName    string `db:"file_name" json:"file_name"`
+	StartAt     int64  `db:"start_at" json:"start_at"`
+	EndAt       int64  `db:"end_at" json:"end_at"`
+	Total       int    `db:"total" json:"total"`               // 账单行数
+	Matched     int    `db:"matched" json:"matched"`           // 一致
+	MissingOurs int    `db:"missing_ours" json:"missing_ours"` // 我方缺单
+	MissingPSP  int    `db:"missing_psp" json:"missing_psp"`   // 三方缺单
+	AmountDiff  int    `db:"amount_diff" json:"amount_diff"`   // 金额不一致
+	StateDiff   int    `db:"state_diff" json:"state_diff"`     // 状态不一致
+	CreatedAt   int64  `db:"created_at" json:"created_at"`
+	CreatedUID  string `db:"created_uid" json:"created_uid"`
+	CreatedName string `db:"created_name" json:"created_name"`
+	Prefix      string `db:"prefix" json:"prefix"`
+}
+
+// ReconcileItem 对账明细, 只保存差异行
+type ReconcileItem struct {
+	ID           string `db:"id" json:"id"`
+	BatchID      string `db:"batch_id" json:"batch_id"`
+	Ty           int    `db:"ty" json:"ty"`
+	Result       int    `db:"result" json:"result"`
+	OrderID      string `db:"order_id" json:"order_id"`           // 我方订单号
+	OID          string `db:"oid" json:"oid"`                     // 三方订单号
+	Username     string `db:"username" json:"username"`           //
+	PspAmount    string `db:"psp_amount" json:"psp_amount"`       // 账单金额
+	OurAmount    string `db:"our_amount" json:"our_amount"`       // 我方金额
+	PspState     string `db:"psp_state" json:"psp_state"`         // 账单原始状态
+	PspSuccess   int    `db:"psp_success" json:"psp_success"`     // 账单是否成功 1 成功 0 失败
+	OurState     int    `db:"our_state" json:"our_state"`         // 我方订单状态
+	State        int    `db:"state" json:"state"`                 // 0 待处理 1 已补单 2 已忽略
+	HandleRemark string `db:"handle_remark" json:"handle_remark"` //
+	HandledAt    int64  `db:"handled_at" json:"handled_at"`
+	HandledUID   string `db:"handled_uid" json:"handled_uid"`
+	HandledName  string `db:"handled_name" json:"handled_name"`
+	Prefix       string `db:"prefix" json:"prefix"`
+	Repairable   bool   `db:"-" json:"repairable"` // 是否可以一键补单
+}
+
+type ReconcileBatchData struct {
+	D []ReconcileBatch `json:"d"`
+	T int64            `json:"t"`
+	S uint16           `json:"s"`
+}
+
+type ReconcileItemData struct {
+	D []ReconcileItem `json:"d"`
+	T int64           `json:"t"`
+	S uint16          `json:"s"`
+}
+
+// 账单中的一行
+type reconcileLine struct {
+	ID      string
+	OID     string
+	Amount  decimal.Decimal
+	State   string
+	Success bool
+}
+
+// 参与对账的我方订单
+type reconcileOrder struct {
+	ID        string  `db:"id"`
+	OID       string  `db:"oid"`
+	Username  string  `db:"username"`
+	Amount    float64 `db:"amount"`
+	State     int     `db:"state"`
+	CreatedAt int64   `db:"created_at"`
+}
+
+func ReconcileMappingList(cateID string, ty int) ([]ReconcileMapping, error) {
+
+	var data []ReconcileMapping
+
+	ex := g.Ex{"prefix": meta.Prefix}
+	if cateID != "" {
+		ex["cate_id"] = cateID
+	}
+	if ty > 0 {
+		ex["ty"] = ty
+	}
+
+	query, _, _ := dialect.From("f_reconcile_mapping").Select(colReconcileMapping...).Where(ex).Order(g.C("created_at").Desc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	var cids []string
+	for _, v := range data {
+		cids = append(cids, v.CateID)
+	}
+
+	if len(cids) > 0 {
+		cm, _ := cateByIDS(cids)
+		for k := range data {
+			data[k].CateName = cm[data[k].CateID]
+		}
+	}
+
+	return data, nil
+}
+
+func ReconcileMappingInsert(param map[string]string) error {
+
+	cate, err := CateByID(param["cate_id"])
+	if err != nil {
+		return err
+	}
+
+	if len(cate.ID) == 0 {
+		return errors.New(helper.CateNotExist)
+	}
+
+	var id string
+	ex := g.Ex{
+		"cate_id": param["cate_id"],
+		"ty":      param["ty"],
+		"prefix":  meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_reconcile_mapping").Select("id").Where(ex).Limit(1).ToSQL()
+	err = meta.MerchantDB.Get(&id, query)
+	if err != nil && err != sql.ErrNoRows {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if len(id) != 0 {
+		return errors.New(helper.RecordExistErr)
+	}
+
+	record := g.Record{
+		"id":            param["id"],
+		"cate_id":       param["cate_id"],
+		"ty":            param["ty"],
+		"header_row":    param["header_row"],
+		"col_id":        param["col_id"],
+		"col_oid":       param["col_oid"],
+		"col_amount":    param["col_amount"],
+		"col_state":     param["col_state"],
+		"success_value": param["success_value"],
+		"cent":          param["cent"],
+		"created_at":    param["created_at"],
+		"updated_at":    param["created_at"],
+		"updated_uid":   param["updated_uid"],
+		"updated_name":  param["updated_name"],
+		"prefix":        meta.Prefix,
+	}
+	query, _, _ = dialect.Insert("f_reconcile_mapping").Rows(record).ToSQL()
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+func ReconcileMappingUpdate(id string, param map[string]string) error {
+
+	record := g.Record{
+		"header_row":    param["header_row"],
+		"col_id":        param["col_id"],
+		"col_oid":       param["col_oid"],
+		"col_amount":    param["col_amount"],
+		"col_state":     param["col_state"],
+		"success_value": param["success_value"],
+		"cent":          param["cent"],
+		"updated_at":    param["updated_at"],
+		"updated_uid":   param["updated_uid"],
+		"updated_name":  param["updated_name"],
+	}
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Update("f_reconcile_mapping").Set(record).Where(ex).Limit(1).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+func reconcileMappingByID(id string) (ReconcileMapping, error) {
+
+	data := ReconcileMapping{}
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_reconcile_mapping").Select(colReconcileMapping...).Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&data, query)
+	if err == sql.ErrNoRows {
+		return data, errors.New(helper.RecordNotExistErr)
+	}
+
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// ReconcileImport 导入三方账单并与我方订单对账
+// 账单按 我方订单号 > 三方订单号 的顺序匹配我方订单, 匹配上的再比较金额和状态
+// 对账区间内我方成功但账单中不存在的订单记为三方缺单
+func ReconcileImport(mappingID, fileName string, content []byte, startTime, endTime string, admin map[string]string) (ReconcileBatch, error) {
+
+	batch := ReconcileBatch{}
+
+	startAt, err := helper.TimeToLoc(startTime, loc)
+	if err != nil {
+		return batch, errors.New(helper.DateTimeErr)
+	}
+
+	endAt, err := helper.TimeToLoc(endTime, loc)
+	if err != nil {
+		return batch, errors.New(helper.DateTimeErr)
+	}
+
+	if startAt >= endAt {
+		return batch, errors.New(helper.QueryTimeRangeErr)
+	}
+
+	mp, err := reconcileMappingByID(mappingID)
+	if err != nil {
+		return batch, err
+	}
+
+	rows, err := reconcileParseFile(fileName, content)
+	if err != nil {
+		return batch, err
+	}
+
+	lines, err := reconcileLines(mp, rows)
+	if err != nil {
+		return batch, err
+	}
+
+	orders, err := reconcileOrders(mp, g.C("created_at").Between(exp.NewRangeVal(startAt, endAt)))
+	if err != nil {
+		return batch, err
+	}
+
+	byID := map[string]reconcileOrder{}
+	byOID := map[string]reconcileOrder{}
+	reconcileIndex(mp.Ty, orders, byID, byOID)
+
+	// 账单中的订单可能在对账区间外创建, 按单号再查一次
+	var ids, oids []string
+	for _, v := range lines {
+		if _, ok := byID[v.ID]; ok && v.ID != "" {
+			continue
+		}
+		if _, ok := byOID[v.OID]; ok && v.OID != "" {
+			continue
+		}
+		if v.ID != "" {
+			ids = append(ids, v.ID)
+		}
+		if v.OID != "" {
+			oids = append(oids, v.OID)
+		}
+	}
+
+	if len(ids) > 0 || len(oids) > 0 {
+		or := g.Or()
+		if len(ids) > 0 {
+			or = or.Append(g.C("id").In(ids))
+		}
+		if len(oids) > 0 {
+			or = or.Append(g.C("oid").In(oids))
+		}
+		extra, err := reconcileOrders(mp, or)
+		if err != nil {
+			return batch, err
+		}
+
+		reconcileIndex(mp.Ty, extra, byID, byOID)
+	}
+
+	now := time.Now().Unix()
+	batch = ReconcileBatch{
+		ID:          helper.GenId(),
+		MappingID:   mp.ID,
+		CateID:      mp.CateID,
+		Ty:          mp.Ty,
+		FileName:    fileName,
+		StartAt:     startAt,
+		EndAt:       endAt,
+		Total:       len(lines),
+		CreatedAt:   now,
+		CreatedUID:  admin["id"],
+		CreatedName: admin["name"],
+		Prefix:      meta.Prefix,
+	}
+
+	var items []g.Record
+	used := map[string]bool{}
+	for _, v := range lines {
+
+		order, ok := byID[v.ID]
+		if !ok || v.ID == "" {
+			order, ok = byOID[v.OID]
+			if v.OID == "" {
+				ok = false
+			}
+		}
+		// 账单重复的行不能再次匹配已对过的订单
+		if ok && used[order.ID] {
+			ok = false
+		}
+
+		item := g.Record{
+			"id":            helper.GenId(),
+			"batch_id":      batch.ID,
+			"ty":            mp.Ty,
+			"order_id":      v.ID,
+			"oid":           v.OID,
+			"username":      "",
+			"psp_amount":    v.Amount.String(),
+			"our_amount":    "0",
+			"psp_state":     v.State,
+			"psp_success":   0,
+			"our_state":     0,
+			"state":         ReconcileItemPending,
+			"handle_remark": "",
+			"handled_at":    0,
+			"handled_uid":   "0",
+			"handled_name":  "",
+			"prefix":        meta.Prefix,
+		}
+		if v.Success {
+			item["psp_success"] = 1
+		}
+
+		if !ok {
+			batch.MissingOurs++
+			item["result"] = ReconcileMissingOurs
+			items = append(items, item)
+			continue
+		}
+
+		used[order.ID] = true
+		item["order_id"] = order.ID
+		item["oid"] = order.OID
+		item["username"] = order.Username
+		item["our_amount"] = decimal.NewFromFloat(order.Amount).String()
+		item["our_state"] = order.State
+
+		if !decimal.NewFromFloat(order.Amount).Equal(v.Amount) {
+			batch.AmountDiff++
+			item["result"] = ReconcileAmountDiff
+			items = append(items, item)
+			continue
+		}
+
+		if v.Success != reconcileSuccess(mp.Ty, order.State) {
+			batch.StateDiff++
+			item["result"] = ReconcileStateDiff
+			items = append(items, item)
+			continue
+		}
+
+		batch.Matched++
+	}
+
+	for _, v := range orders {
+		if used[v.ID] || !reconcileSuccess(mp.Ty, v.State) {
+			continue
+		}
+
+		batch.MissingPSP++
+		items = append(items, g.Record{
+			"id":            helper.GenId(),
+			"batch_id":      batch.ID,
+			"ty":            mp.Ty,
+			"result":        ReconcileMissingPSP,
+			"order_id":      v.ID,
+			"oid":           v.OID,
+			"username":      v.Username,
+			"psp_amount":    "0",
+			"our_amount":    decimal.NewFromFloat(v.Amount).String(),
+			"psp_state":     "",
+			"psp_success":   0,
+			"our_state":     v.State,
+			"state":         ReconcileItemPending,
+			"handle_remark": "",
+			"handled_at":    0,
+			"handled_uid":   "0",
+			"handled_name":  "",
+			"prefix":        meta.Prefix,
+		})
+	}
+
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return batch, pushLog(err, helper.DBErr)
+	}
+
+	query, _, _ := dialect.Insert("f_reconcile_batch").Rows(batch).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return batch, pushLog(err, helper.DBErr)
+	}
+
+	// 分批写入, 避免单条sql过大
+	for i := 0; i < len(items); i += 500 {
+		j := i + 500
+		if j > len(items) {
+			j = len(items)
+		}
+
+		query, _, _ = dialect.Insert("f_reconcile_item").Rows(items[i:j]).ToSQL()
+		_, err = tx.Exec(query)
+		if err != nil {
+			_ = tx.Rollback()
+			return batch, pushLog(err, helper.DBErr)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return batch, pushLog(err, helper.DBErr)
+	}
+
+	return batch, nil
+}
+
+func ReconcileBatchList(cateID string, ty int, startTime, endTime string, page, pageSize uint16) (ReconcileBatchData, error) {
+
+	data := ReconcileBatchData{}
+
+	ex := g.Ex{"prefix": meta.Prefix}
+	if cateID != "" {
+		ex["cate_id"] = cateID
+	}
+	if ty > 0 {
+		ex["ty"] = ty
+	}
+
+	if startTime != "" && endTime != "" {
+
+		startAt, err := helper.TimeToLoc(startTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		endAt, err := helper.TimeToLoc(endTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		if startAt >= endAt {
+			return data, errors.New(helper.QueryTimeRangeErr)
+		}
+
+		ex["created_at"] = g.Op{"between": exp.NewRangeVal(startAt, endAt)}
+	}
+
+	if page == 1 {
+		query, _, _ := dialect.From("f_reconcile_batch").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("f_reconcile_batch").Select(colReconcileBatch...).Where(ex).
+		Order(g.C("created_at").Desc()).Offset(uint(offset)).Limit(uint(pageSize)).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+func ReconcileItemList(batchID string, result, state int, page, pageSize uint16) (ReconcileItemData, error) {
+
+	data := ReconcileItemData{}
+
+	ex := g.Ex{
+		"batch_id": batchID,
+		"prefix":   meta.Prefix,
+	}
+	if result > 0 {
+		ex["result"] = result
+	}
+	if state >= 0 {
+		ex["state"] = state
+	}
+
+	if page == 1 {
+		query, _, _ := dialect.From("f_reconcile_item").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From("f_reconcile_item").Select(colReconcileItem...).Where(ex).
+		Order(g.C("result").Asc()).Offset(uint(offset)).Limit(uint(pageSize)).ToSQL()
+	err := meta.MerchantDB.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	for k := range data.D {
+		data.D[k].Repairable = reconcileRepairable(data.D[k])
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+// ReconcileRepair 对账差异一键补单
+// 只处理三方成功而我方仍在确认中的存款订单, 按账单金额走补单流程
+// 差异记录的状态和补单在同一个事务中修改, 防止重复补单
+func ReconcileRepair(id, remark, adminName, adminUID string) error {
+
+	item, err := reconcileItemByID(id)
+	if err != nil {
+		return err
+	}
+
+	if item.State != ReconcileItemPending {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	if !reconcileRepairable(item) {
+		return errors.New(helper.OrderStateErr)
+	}
+
+	if remark == "" {
+		remark = fmt.Sprintf("对账补单 %s", item.BatchID)
+	}
+
+	order, err := depositManualCheck(item.OrderID, item.PspAmount)
+	if err != nil {
+		return err
+	}
+
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	res, err := tx.Exec(reconcileItemHandleSQL(id, ReconcileItemRepaired, remark, adminName, adminUID))
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		_ = tx.Rollback()
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	err = depositManualTx(tx, order, item.PspAmount, remark, adminName, adminUID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	_ = PushMerchantNotify(manualReviewFmt, adminName, order.Username, item.PspAmount)
+
+	return nil
+}
+
+// ReconcileIgnore 对账差异标记为已处理, 不做账务变动
+func ReconcileIgnore(id, remark, adminName, adminUID string) error {
+
+	item, err := reconcileItemByID(id)
+	if err != nil {
+		return err
+	}
+
+	if item.State != ReconcileItemPending {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	res, err := meta.MerchantDB.Exec(reconcileItemHandleSQL(id, ReconcileItemIgnored, remark, adminName, adminUID))
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	return nil
+}
+
+// 只更新待处理的差异记录
+func reconcileItemHandleSQL(id string, state int, remark, adminName, adminUID string) string {
+
+	record := g.Record{
+		"state":         state,
+		"handle_remark": remark,
+		"handled_at":    time.Now().Unix(),
+		"handled_uid":   adminUID,
+		"handled_name":  adminName,
+	}
+	ex := g.Ex{
+		"id":     id,
+		"state":  ReconcileItemPending,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Update("f_reconcile_item").Set(record).Where(ex).Limit(1).ToSQL()
+	return query
+}
+
+func reconcileItemByID(id string) (ReconcileItem, error) {
+
+	data := ReconcileItem{}
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_reconcile_item").Select(colReconcileItem...).Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&data, query)
+	if err == sql.ErrNoRows {
+		return data, errors.New(helper.RecordNotExistErr)
+	}
+
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+func reconcileRepairable(item ReconcileItem) bool {
+
+	if item.Ty != ReconcileDeposit || item.State != ReconcileItemPending || item.PspSuccess != 1 {
+		return false
+	}
+
+	if item.Result != ReconcileStateDiff && item.Result != ReconcileAmountDiff {
+		return false
+	}
+
+	return item.OurState == DepositConfirming
+}
+
+func reconcileSuccess(ty, state int) bool {
+
+	if ty == ReconcileDeposit {
+		return state == DepositSuccess
+	}
+
+	return state == WithdrawSuccess
+}
+
+// 建立我方订单索引, 同一个三方单号存在多笔订单时(补单)优先取成功的那笔
+func reconcileIndex(ty int, orders []reconcileOrder, byID, byOID map[string]reconcileOrder) {
+
+	for _, v := range orders {
+		byID[v.ID] = v
+		if v.OID == "" {
+			continue
+		}
+
+		if o, ok := byOID[v.OID]; ok && reconcileSuccess(ty, o.State) {
+			continue
+		}
+
+		byOID[v.OID] = v
+	}
+}
+
+func reconcileOrders(mp ReconcileMapping, cond exp.Expression) ([]reconcileOrder, error) {
+
+	var data []reconcileOrder
+
+	cols := []interface{}{"id", "oid", "username", "amount", "state", "created_at"}
+	and := g.And(g.C("prefix").Eq(meta.Prefix), cond)
+
+	tbl := "tbl_deposit"
+	if mp.Ty == ReconcileDeposit {
+		and = and.Append(g.C("cid").Eq(mp.CateID))
+	} else {
+		tbl = "tbl_withdraw"
+
+		var pids []string
+		query, _, _ := dialect.From("f_payment").Select("id").Where(g.Ex{"cate_id": mp.CateID, "prefix": meta.Prefix}).ToSQL()
+		err := meta.MerchantDB.Select(&pids, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if len(pids) == 0 {
+			return data, nil
+		}
+
+		and = and.Append(g.C("pid").In(pids))
+	}
+
+	query, _, _ := dialect.From(tbl).Select(cols...).Where(and).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// 按映射配置把账单内容转换为对账行
+func reconcileLines(mp ReconcileMapping, rows [][]string) ([]reconcileLine, error) {
+
+	var lines []reconcileLine
+
+	header := mp.HeaderRow
+	if header < 1 {
+		header = 1
+	}
+
+	if len(rows) < header {
+		return lines, errors.New(helper.FormatErr)
+	}
+
+	idx := map[string]int{}
+	for k, v := range rows[header-1] {
+		idx[strings.TrimSpace(v)] = k
+	}
+
+	col := func(name string) int {
+		if name == "" {
+			return -1
+		}
+		if k, ok := idx[name]; ok {
+			return k
+		}
+		return -2
+	}
+
+	cID, cOID, cAmount, cState := col(mp.ColID), col(mp.ColOID), col(mp.ColAmount), col(mp.ColState)
+	// 金额必须有, 订单号至少有一个
+	if cAmount < 0 || cID == -2 || cOID == -2 || cState == -2 || (cID < 0 && cOID < 0) {
+		return lines, errors.New(helper.FormatErr)
+	}
+
+	// 账单金额换算为订单的KVND, 与回调的 compareAmount 一致
+	cent := decimal.NewFromInt(mp.Cent)
+	if mp.Cent <= 0 {
+		cent = decimal.NewFromInt(1)
+	}
+
+	success := map[string]bool{}
+	for _, v := range strings.Split(mp.SuccessValue, ",") {
+		success[strings.TrimSpace(v)] = true
+	}
+
+	cell := func(row []string, k int) string {
+		if k < 0 || k >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[k])
+	}
+
+	for _, row := range rows[header:] {
+
+		line := reconcileLine{
+			ID:  cell(row, cID),
+			OID: cell(row, cOID),
+		}
+		if line.ID == "" && line.OID == "" {
+			continue
+		}
+
+		amount := strings.NewReplacer(",", "", " ", "").Replace(cell(row, cAmount))
+		money, err := decimal.NewFromString(amount)
+		if err != nil {
+			return lines, errors.New(helper.AmountErr)
+		}
+
+		line.Amount = money.Div(cent)
+		line.State = cell(row, cState)
+		line.Success = cState < 0 || success[line.State]
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,192 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"finance/contrib/helper"
+)
+
+type xlsxSharedStrings struct {
+	SI []struct {
+		T string `xml:"t"`
+		R []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		R int `xml:"r,attr"`
+		C []struct {
+			R  string `xml:"r,attr"`
+			T  string `xml:"t,attr"`
+			V  string `xml:"v"`
+			IS struct {
+				T string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// 解析三方账单, 支持csv和xlsx(只读取第一个sheet)
+func reconcileParseFile(fileName string, content []byte) ([][]string, error) {
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return reconcileParseCsv(content)
+	case ".xlsx":
+		return reconcileParseXlsx(content)
+	}
+
+	return nil, errors.New(helper.FormatErr)
+}
+
+func reconcileParseCsv(content []byte) ([][]string, error) {
+
+	// 去掉excel导出时带的BOM
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, errors.New(helper.FormatErr)
+	}
+
+	return rows, nil
+}
+
+func reconcileParseXlsx(content []byte) ([][]string, error) {
+
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, errors.New(helper.FormatErr)
+	}
+
+	var (
+		sheets []string
+		files  = map[string]*zip.File{}
+	)
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if strings.HasPrefix(f.Name, "xl/worksheets/sheet") && strings.HasSuffix(f.Name, ".xml") {
+			sheets = append(sheets, f.Name)
+		}
+	}
+
+	if len(sheets) == 0 {
+		return nil, errors.New(helper.FormatErr)
+	}
+
+	sort.Strings(sheets)
+	sheet := "xl/worksheets/sheet1.xml"
+	if _, ok := files[sheet]; !ok {
+		sheet = sheets[0]
+	}
+
+	var ss []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		shared := xlsxSharedStrings{}
+		err = xlsxDecode(f, &shared)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range shared.SI {
+			s := v.T
+			for _, r := range v.R {
+				s += r.T
+			}
+			ss = append(ss, s)
+		}
+	}
+
+	data := xlsxSheet{}
+	err = xlsxDecode(files[sheet], &data)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, 0, len(data.Rows))
+	for _, row := range data.Rows {
+
+		// 空行在xml中不存在, 按行号补齐
+		for row.R > 0 && len(rows) < row.R-1 {
+			rows = append(rows, []string{})
+		}
+
+		var cells []string
+		for i, c := range row.C {
+			k := xlsxColumn(c.R)
+			if k < 0 {
+				k = i
+			}
+			for len(cells) <= k {
+				cells = append(cells, "")
+			}
+
+			switch c.T {
+			case "s":
+				n, err := strconv.Atoi(c.V)
+				if err == nil && n >= 0 && n < len(ss) {
+					cells[k] = ss[n]
+				}
+			case "inlineStr":
+				cells[k] = c.IS.T
+			default:
+				cells[k] = c.V
+			}
+		}
+
+		rows = append(rows, cells)
+	}
+
+	return rows, nil
+}
+
+func xlsxDecode(f *zip.File, v interface{}) error {
+
+	rc, err := f.Open()
+	if err != nil {
+		return errors.New(helper.FormatErr)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return errors.New(helper.FormatErr)
+	}
+
+	err = xml.Unmarshal(b, v)
+	if err != nil {
+		return errors.New(helper.FormatErr)
+	}
+
+	return nil
+}
+
+// 单元格坐标转换为列下标, 例如 A1 => 0, AB12 => 27
+func xlsxColumn(ref string) int {
+
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		n = n*26 + int(c-'A'+1)
+	}
+
+	return n - 1
+}
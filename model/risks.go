@@ -5,64 +5,20 @@ import (
 	"finance/contrib/helper"
 	"fmt"
 	"strconv"
+	"time"
 
 	g "github.com/doug-martin/goqu/v9"
 	"github.com/go-redis/redis/v8"
 )
 
 type Receive struct {
-	ID   string `db:"id" json:"id" rule:"none"`      // 主键ID
-	Name string `db:"name" json:"name" rule:"aname"` // 用户名
-}
-
-//返水风控审核人员的UID
-func GetRisksUID() (string, error) {
-
-	maxKey := fmt.Sprintf("%s:risk:maxreceivenum", meta.Prefix)
-	// 查询最大接单数量
-	max, err := meta.MerchantRedis.Get(ctx, maxKey).Uint64()
-	if err != nil && err != redis.Nil {
-		return "0", pushLog(err, helper.RedisErr)
-	}
-
-	// 如果最大接单数量小于等于0则直接返回
-	if max <= 0 {
-		return "0", errors.New("max acceptable order quality less or equal to 0")
-	}
-
-	risksKey := fmt.Sprintf("%s:risk:receive", meta.Prefix)
-	// 查询在自动派单列表中的总人数
-	c, err := meta.MerchantRedis.LLen(ctx, risksKey).Result()
-	if err != nil {
-		return "0", pushLog(err, helper.RedisErr)
-	}
-
-	for i := int64(0); i < c; i++ {
-		uid, err := meta.MerchantRedis.RPopLPush(ctx, risksKey, risksKey).Result()
-		if err != nil && err != redis.Nil {
-			return "0", pushLog(err, helper.RedisErr)
-		}
-
-		// 查询结果可能是redis.Nil
-		if uid == "" {
-			continue
-		}
-
-		key := fmt.Sprintf("%s:risk:mb:%s", meta.Prefix, uid)
-		// 查询当前未处理的订单
-		current, err := meta.MerchantRedis.LLen(ctx, key).Result()
-		if err != nil {
-			return "0", pushLog(err, helper.RedisErr)
-		}
-
-		// 如果当前未处理的订单小于最大接单数量 则派单给改风控人员
-		if current < int64(max) {
-			return uid, nil
-		}
-	}
-
-	// 从头循环到尾,没有找到合适风控用户
-	return "0", errors.New(helper.RequestBusy)
+	ID       string             `db:"id" json:"id" rule:"none"`      // 主键ID
+	Name     string             `db:"name" json:"name" rule:"aname"` // 用户名
+	OnDuty   bool               `db:"-" json:"on_duty"`              // 是否开启自动接单
+	InShift  bool               `db:"-" json:"in_shift"`             // 当前是否在班次内
+	Current  int64              `db:"-" json:"current"`              // 未处理的订单数
+	Reviewer RiskReviewer       `db:"-" json:"reviewer"`             // 排班和派单设置
+	Dispatch []RisksDispatchLog `db:"-" json:"dispatch"`             // 最近的派单记录
 }
 
 // RisksCloseAuto 风控人员关闭自己接单或是是关闭风控配置的自动派单
@@ -247,6 +203,33 @@ func RisksReceives() ([]Receive, error) {
 		return data, pushLog(err, helper.DBErr)
 	}
 
+	uids, _ := RisksList()
+	reviewers, err := RisksReviewerList(nil)
+	if err != nil {
+		return data, err
+	}
+
+	now := time.Now()
+	for k, v := range data {
+		for _, uid := range uids {
+			if uid == v.ID {
+				data[k].OnDuty = true
+				break
+			}
+		}
+
+		rv, ok := reviewers[v.ID]
+		if !ok {
+			rv = RiskReviewer{UID: v.ID, Name: v.Name, Weight: 1}
+		}
+		data[k].Reviewer = rv
+		data[k].InShift = risksInShift(rv, now)
+
+		key := fmt.Sprintf("%s:risk:mb:%s", meta.Prefix, v.ID)
+		data[k].Current, _ = meta.MerchantRedis.LLen(ctx, key).Result()
+		data[k].Dispatch = risksDispatchRecent(v.ID)
+	}
+
 	return data, nil
 }
 
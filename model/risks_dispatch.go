@@ -0,0 +1,515 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 派单方式
+const (
+	RisksModeRound  = 1 // 轮询
+	RisksModeLeast  = 2 // 最少未处理订单
+	RisksModeWeight = 3 // 按权重分配(未处理订单数/权重 最小)
+)
+
+// 派单记录类型
+const (
+	RisksDispatchNew      = 1 // 新订单派单
+	RisksDispatchReassign = 2 // 超时未处理改派
+)
+
+const (
+	risksDispatchLogTable  = "risk_dispatch_log"
+	risksReassignLock      = "risk:reassign"
+	risksReassignInterval  = 60 * time.Second
+	risksDispatchRecentNum = 10 // 风控人员列表展示最近的派单记录数
+)
+
+// RisksDispatchConf 派单配置
+type RisksDispatchConf struct {
+	Mode           int    `json:"mode" redis:"mode"`                       // 派单方式 1 轮询 2 最少未处理 3 权重
+	Timeout        int64  `json:"timeout" redis:"timeout"`                 // 已派单未处理超过秒数自动改派, 0 不改派
+	PriorityVip    int    `json:"priority_vip" redis:"priority_vip"`       // 会员等级大于等于该值为优先订单, 0 不限
+	PriorityAmount string `json:"priority_amount" redis:"priority_amount"` // 提款金额大于等于该值为优先订单, 0 不限
+}
+
+// RiskReviewer 风控人员的排班和派单设置
+type RiskReviewer struct {
+	UID         string `db:"uid" json:"uid"`
+	Name        string `db:"name" json:"name"`
+	Weight      int    `db:"weight" json:"weight"`           // 权重
+	Priority    int    `db:"priority" json:"priority"`       // 1 优先接收高等级/大额订单
+	ShiftStart  string `db:"shift_start" json:"shift_start"` // 班次开始 HH:MM, 为空全天
+	ShiftEnd    string `db:"shift_end" json:"shift_end"`     // 班次结束 HH:MM, 小于开始时间表示跨天
+	UpdatedAt   int64  `db:"updated_at" json:"updated_at"`
+	UpdatedUID  string `db:"updated_uid" json:"updated_uid"`
+	UpdatedName string `db:"updated_name" json:"updated_name"`
+	Prefix      string `db:"prefix" json:"prefix"`
+}
+
+// RisksDispatchLog 派单记录
+type RisksDispatchLog struct {
+	ID        string `db:"id" json:"id"`
+	OrderID   string `db:"order_id" json:"order_id"`
+	Ty        int    `db:"ty" json:"ty"` // 1 派单 2 超时改派
+	UID       string `db:"uid" json:"uid"`
+	Name      string `db:"name" json:"name"`
+	FromUID   string `db:"from_uid" json:"from_uid"`
+	FromName  string `db:"from_name" json:"from_name"`
+	Mode      int    `db:"mode" json:"mode"`
+	Priority  int    `db:"priority" json:"priority"`
+	Current   int64  `db:"current" json:"current"` // 派单时该风控人员未处理的订单数
+	Reason    string `db:"reason" json:"reason"`
+	CreatedAt int64  `db:"created_at" json:"created_at"`
+}
+
+type RisksDispatchLogData struct {
+	D []RisksDispatchLog `json:"d"`
+	T int64              `json:"t"`
+	S uint16             `json:"s"`
+}
+
+// 派单候选人
+type risksCandidate struct {
+	UID      string
+	Weight   int
+	Priority bool
+	Current  int64
+}
+
+// 派单结果
+type risksDecision struct {
+	UID      string
+	Mode     int
+	Priority bool
+	Current  int64
+	Reason   string
+}
+
+func RisksDispatchConfGet() (RisksDispatchConf, error) {
+
+	conf := RisksDispatchConf{Mode: RisksModeRound, PriorityAmount: "0"}
+	key := fmt.Sprintf("%s:risk:dispatch", meta.Prefix)
+	err := meta.MerchantRedis.HGetAll(ctx, key).Scan(&conf)
+	if err != nil && err != redis.Nil {
+		return conf, pushLog(err, helper.RedisErr)
+	}
+
+	if conf.Mode == 0 {
+		conf.Mode = RisksModeRound
+	}
+
+	return conf, nil
+}
+
+func RisksDispatchConfSet(conf RisksDispatchConf) error {
+
+	key := fmt.Sprintf("%s:risk:dispatch", meta.Prefix)
+	err := meta.MerchantRedis.HSet(ctx, key,
+		"mode", conf.Mode,
+		"timeout", conf.Timeout,
+		"priority_vip", conf.PriorityVip,
+		"priority_amount", conf.PriorityAmount,
+	).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+func RisksReviewerList(uids []string) (map[string]RiskReviewer, error) {
+
+	var data []RiskReviewer
+	ex := g.Ex{"prefix": meta.Prefix}
+	if len(uids) > 0 {
+		ex["uid"] = uids
+	}
+	query, _, _ := dialect.From("f_risk_reviewer").Select(colRiskReviewer...).Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, pushLog(err, helper.DBErr)
+	}
+
+	res := make(map[string]RiskReviewer, len(data))
+	for _, v := range data {
+		res[v.UID] = v
+	}
+
+	return res, nil
+}
+
+// RisksReviewerUpdate 设置风控人员的排班和派单权重
+func RisksReviewerUpdate(uid string, record g.Record) error {
+
+	reviewers, err := RisksReviewerList([]string{uid})
+	if err != nil {
+		return err
+	}
+
+	query := ""
+	if _, ok := reviewers[uid]; !ok {
+		record["uid"] = uid
+		record["prefix"] = meta.Prefix
+		query, _, _ = dialect.Insert("f_risk_reviewer").Rows(record).ToSQL()
+	} else {
+		query, _, _ = dialect.Update("f_risk_reviewer").Set(record).Where(g.Ex{"uid": uid, "prefix": meta.Prefix}).ToSQL()
+	}
+
+	_, err = meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+// 班次检查, HH:MM 格式
+func risksInShift(rv RiskReviewer, t time.Time) bool {
+
+	if rv.ShiftStart == "" || rv.ShiftEnd == "" {
+		return true
+	}
+
+	now := t.In(loc).Format("15:04")
+	if rv.ShiftStart <= rv.ShiftEnd {
+		return now >= rv.ShiftStart && now < rv.ShiftEnd
+	}
+
+	// 跨天班次
+	return now >= rv.ShiftStart || now < rv.ShiftEnd
+}
+
+// 是否优先订单
+func risksPriority(conf RisksDispatchConf, level int, amount decimal.Decimal) bool {
+
+	if conf.PriorityVip > 0 && level >= conf.PriorityVip {
+		return true
+	}
+
+	pa, err := decimal.NewFromString(conf.PriorityAmount)
+	if err == nil && pa.IsPositive() && amount.GreaterThanOrEqual(pa) {
+		return true
+	}
+
+	return false
+}
+
+// 选择风控人员, exclude 为改派时排除的原领取人
+func risksSelect(level int, amount decimal.Decimal, exclude string) (risksDecision, error) {
+
+	res := risksDecision{UID: "0"}
+	maxKey := fmt.Sprintf("%s:risk:maxreceivenum", meta.Prefix)
+	// 查询最大接单数量
+	max, err := meta.MerchantRedis.Get(ctx, maxKey).Int64()
+	if err != nil && err != redis.Nil {
+		return res, pushLog(err, helper.RedisErr)
+	}
+
+	// 如果最大接单数量小于等于0则直接返回
+	if max <= 0 {
+		return res, errors.New("max acceptable order quality less or equal to 0")
+	}
+
+	conf, err := RisksDispatchConfGet()
+	if err != nil {
+		return res, err
+	}
+
+	res.Mode = conf.Mode
+	res.Priority = risksPriority(conf, level, amount)
+
+	risksKey := fmt.Sprintf("%s:risk:receive", meta.Prefix)
+	// 轮询时每次派单移动一次队列
+	_, err = meta.MerchantRedis.RPopLPush(ctx, risksKey, risksKey).Result()
+	if err != nil && err != redis.Nil {
+		return res, pushLog(err, helper.RedisErr)
+	}
+
+	uids, err := meta.MerchantRedis.LRange(ctx, risksKey, 0, -1).Result()
+	if err != nil {
+		return res, pushLog(err, helper.RedisErr)
+	}
+
+	reviewers, err := RisksReviewerList(uids)
+	if err != nil {
+		return res, err
+	}
+
+	now := time.Now()
+	var all, senior []risksCandidate
+	for _, uid := range uids {
+		if uid == "" || uid == exclude {
+			continue
+		}
+
+		c := risksCandidate{UID: uid, Weight: 1}
+		rv, ok := reviewers[uid]
+		if ok {
+			// 不在班次内不派单
+			if !risksInShift(rv, now) {
+				continue
+			}
+
+			if rv.Weight > 0 {
+				c.Weight = rv.Weight
+			}
+			c.Priority = rv.Priority == 1
+		}
+
+		key := fmt.Sprintf("%s:risk:mb:%s", meta.Prefix, uid)
+		// 查询当前未处理的订单
+		c.Current, err = meta.MerchantRedis.LLen(ctx, key).Result()
+		if err != nil {
+			return res, pushLog(err, helper.RedisErr)
+		}
+
+		if c.Current >= max {
+			continue
+		}
+
+		all = append(all, c)
+		if c.Priority {
+			senior = append(senior, c)
+		}
+	}
+
+	candidates := all
+	res.Reason = "普通订单"
+	if res.Priority {
+		res.Reason = "优先订单, 无优先接单人员"
+		if len(senior) > 0 {
+			candidates = senior
+			res.Reason = "优先订单"
+		}
+	}
+
+	if len(candidates) == 0 {
+		// 没有找到合适风控用户
+		return res, errors.New(helper.RequestBusy)
+	}
+
+	pick := candidates[0]
+	switch conf.Mode {
+	case RisksModeLeast:
+		for _, v := range candidates[1:] {
+			if v.Current < pick.Current {
+				pick = v
+			}
+		}
+		res.Reason += ", 最少未处理订单"
+	case RisksModeWeight:
+		for _, v := range candidates[1:] {
+			if v.Current*int64(pick.Weight) < pick.Current*int64(v.Weight) {
+				pick = v
+			}
+		}
+		res.Reason += fmt.Sprintf(", 权重%d", pick.Weight)
+	default:
+		res.Reason += ", 轮询"
+	}
+
+	res.UID = pick.UID
+	res.Current = pick.Current
+	return res, nil
+}
+
+// 写入派单记录
+func risksDispatchLogWrite(orderID string, ty int, d risksDecision, name, fromUID, fromName string) {
+
+	ts := time.Now()
+	priority := 0
+	if d.Priority {
+		priority = 1
+	}
+	record := g.Record{
+		"ts":         ts.In(loc).UnixMicro(),
+		"id":         helper.GenId(),
+		"prefix":     meta.Prefix,
+		"order_id":   orderID,
+		"ty":         ty,
+		"uid":        d.UID,
+		"name":       name,
+		"from_uid":   fromUID,
+		"from_name":  fromName,
+		"mode":       d.Mode,
+		"priority":   priority,
+		"current":    d.Current,
+		"reason":     d.Reason,
+		"created_at": ts.Unix(),
+	}
+	query, _, _ := dialect.Insert(risksDispatchLogTable).Rows(record).ToSQL()
+	_, err := meta.MerchantLogTD.Exec(query)
+	if err != nil {
+		fmt.Println("insert risk_dispatch_log = ", err.Error(), query)
+	}
+}
+
+func RisksDispatchLogList(uid, orderID, startTime, endTime string, page, pageSize uint16) (RisksDispatchLogData, error) {
+
+	data := RisksDispatchLogData{}
+	ex := g.Ex{"prefix": meta.Prefix}
+	if uid != "" {
+		ex["uid"] = uid
+	}
+	if orderID != "" {
+		ex["order_id"] = orderID
+	}
+
+	if startTime != "" && endTime != "" {
+
+		startAt, err := helper.TimeToLoc(startTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		endAt, err := helper.TimeToLoc(endTime, loc)
+		if err != nil {
+			return data, errors.New(helper.DateTimeErr)
+		}
+
+		if startAt >= endAt {
+			return data, errors.New(helper.QueryTimeRangeErr)
+		}
+
+		ex["ts"] = g.Op{"between": exp.NewRangeVal(startAt*1000000, endAt*1000000)}
+	}
+
+	if page == 1 {
+		query, _, _ := dialect.From(risksDispatchLogTable).Select(g.COUNT(1)).Where(ex).ToSQL()
+		err := meta.MerchantLogTD.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	offset := (page - 1) * pageSize
+	query, _, _ := dialect.From(risksDispatchLogTable).Select(colRisksDispatchLog...).Where(ex).
+		Order(g.C("ts").Desc()).Offset(uint(offset)).Limit(uint(pageSize)).ToSQL()
+	err := meta.MerchantLogTD.Select(&data.D, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+// RisksReassignTask 定时改派超时未处理的已派单订单
+func RisksReassignTask() {
+
+	ticker := time.NewTicker(risksReassignInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// 多实例只需要一个执行
+		if Lock(risksReassignLock) != nil {
+			continue
+		}
+
+		risksReassign()
+		Unlock(risksReassignLock)
+	}
+}
+
+func risksReassign() {
+
+	conf, err := RisksDispatchConfGet()
+	if err != nil || conf.Timeout <= 0 {
+		return
+	}
+
+	var data []Withdraw
+	ex := g.Ex{
+		"prefix":     meta.Prefix,
+		"state":      WithdrawDispatched,
+		"receive_at": g.Op{"lt": time.Now().Unix() - conf.Timeout},
+	}
+	query, _, _ := dialect.From("tbl_withdraw").Select(colWithdraw...).Where(ex).Order(g.C("receive_at").Asc()).Limit(100).ToSQL()
+	err = meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	for _, v := range data {
+		_ = risksReassignOne(v, conf)
+	}
+}
+
+func risksReassignOne(order Withdraw, conf RisksDispatchConf) error {
+
+	err := WithdrawLock(order.ID)
+	if err != nil {
+		return err
+	}
+	defer WithdrawUnLock(order.ID)
+
+	// 加锁后重新查询, 防止已被处理
+	order, err = WithdrawFind(order.ID)
+	if err != nil {
+		return err
+	}
+
+	if order.State != WithdrawDispatched || order.ReceiveAt >= time.Now().Unix()-conf.Timeout {
+		return nil
+	}
+
+	d, err := risksSelect(order.Level, decimal.NewFromFloat(order.Amount), order.ConfirmUID)
+	if err != nil {
+		return err
+	}
+
+	name, err := AdminGetName(d.UID)
+	if err != nil || name == "" {
+		return err
+	}
+
+	record := g.Record{
+		"confirm_uid":  d.UID,
+		"confirm_name": name,
+		"receive_at":   time.Now().Unix(),
+	}
+	err = WithdrawUpdateInfo(order.ID, record)
+	if err != nil {
+		return err
+	}
+
+	_ = SetRisksOrder(order.ConfirmUID, order.ID, -1)
+	_ = SetRisksOrder(d.UID, order.ID, 1)
+
+	d.Reason = fmt.Sprintf("超过%d秒未处理, %s", conf.Timeout, d.Reason)
+	risksDispatchLogWrite(order.ID, RisksDispatchReassign, d, name, order.ConfirmUID, order.ConfirmName)
+	return nil
+}
+
+// 风控人员最近的派单记录
+func risksDispatchRecent(uid string) []RisksDispatchLog {
+
+	var data []RisksDispatchLog
+	ex := g.Ex{
+		"prefix": meta.Prefix,
+		"uid":    uid,
+	}
+	query, _, _ := dialect.From(risksDispatchLogTable).Select(colRisksDispatchLog...).Where(ex).
+		Order(g.C("ts").Desc()).Limit(risksDispatchRecentNum).ToSQL()
+	err := meta.MerchantLogTD.Select(&data, query)
+	if err != nil {
+		fmt.Println("risksDispatchRecent = ", err.Error())
+	}
+
+	return data
+}
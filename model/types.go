@@ -17,6 +17,7 @@ const (
 	DepositSuccess    = 362 //存款成功
 	DepositCancelled  = 363 //存款已取消
 	DepositReviewing  = 364 //存款审核中
+	DepositReversed   = 365 //存款已冲正
 )
 
 // 取款状态
@@ -112,13 +113,21 @@ var channels = map[string]paymentChannel{
 }
 */
 
+// 后台操作日志
 type systemLog struct {
-	Title     string `msg:"title"`
-	UID       string `msg:"uid"`
-	Name      string `msg:"name"`
-	Content   string `msg:"content"`
-	IP        string `msg:"ip"`
-	CreatedAt int64  `msg:"created_at"`
+	ID        string `db:"id" json:"id"`
+	Title     string `db:"title" json:"title"`
+	Entity    string `db:"entity" json:"entity"`       // 操作对象
+	EntityID  string `db:"entity_id" json:"entity_id"` // 操作对象id
+	Route     string `db:"route" json:"route"`
+	Params    string `db:"params" json:"params"`   // 请求参数
+	Diff      string `db:"diff" json:"diff"`       // 修改前后变化的字段
+	Content   string `db:"content" json:"content"` // 操作说明
+	State     int    `db:"state" json:"state"`     // 1 成功 0 失败
+	UID       string `db:"uid" json:"uid"`
+	Name      string `db:"name" json:"name"`
+	IP        string `db:"ip" json:"ip"`
+	CreatedAt int64  `db:"created_at" json:"created_at"`
 }
 
 // 发起支付返回结果结构
@@ -186,6 +195,7 @@ type FPay struct {
 	St        string `db:"st" redis:"st" json:"st"`                         //开始时间
 	State     string `db:"state" redis:"state" json:"state"`                //0:关闭1:开启
 	Devices   string `db:"devices" redis:"devices" json:"devices"`          //设备号
+	Weekdays  string `db:"weekdays" redis:"weekdays" json:"weekdays"`       //营业日
 }
 
 type WithdrawAutoParam struct {
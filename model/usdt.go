@@ -80,6 +80,12 @@ func UsdtPay(fctx *fasthttp.RequestCtx, pid, amount, addr, protocolType, hashID
 		return "", errors.New(helper.ChannelNotExist)
 	}
 
+	// 检查通道的营业时间和维护计划
+	err = PaymentOpenCheck(p, fctx.Time())
+	if err != nil {
+		return "", err
+	}
+
 	//ch := paymentChannelMatch(p.ChannelID)
 	/*
 		ch, err := ChannelTypeById(p.ChannelID)
@@ -105,6 +111,12 @@ func UsdtPay(fctx *fasthttp.RequestCtx, pid, amount, addr, protocolType, hashID
 		return "", errors.New(helper.AmountErr)
 	}
 
+	// 检查通道剩余的每日和累计额度
+	err = PaymentQuotaCheck(p, dm, fctx.Time())
+	if err != nil {
+		return "", err
+	}
+
 	// 发起的usdt金额
 	usdtAmount := dm.Mul(decimal.NewFromInt(1000)).DivRound(usdt_rate, 3).String()
 
@@ -112,7 +124,25 @@ func UsdtPay(fctx *fasthttp.RequestCtx, pid, amount, addr, protocolType, hashID
 	orderID := helper.GenId()
 
 	// 检查用户的存款行为是否过于频繁
-	err = cacheDepositProcessing(user.UID, time.Now().Unix())
+	err = cacheDepositProcessing(user, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+
+	// 检查会员是否被限制存款
+	err = MemberLockCheck(user.UID, LockScopeChannel, p.ID)
+	if err != nil {
+		return "", err
+	}
+
+	// 存款次数和额度限制, 加锁到订单写入完成, 防止并发请求绕过限制
+	err = velocityLock(VelocityDeposit, user.UID)
+	if err != nil {
+		return "", err
+	}
+	defer velocityUnlock(VelocityDeposit, user.UID)
+
+	err = velocityCheck(VelocityDeposit, user, dm, fctx.Time())
 	if err != nil {
 		return "", err
 	}
@@ -158,7 +188,7 @@ func UsdtPay(fctx *fasthttp.RequestCtx, pid, amount, addr, protocolType, hashID
 	}
 
 	// 记录存款行为
-	_ = cacheDepositProcessingInsert(user.UID, orderID, fctx.Time().In(loc).Unix())
+	_ = cacheDepositProcessingInsert(user, orderID, fctx.Time().In(loc).Unix())
 
 	return orderID, nil
 }
@@ -0,0 +1,428 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	VelocityDeposit  = 1 // 存款
+	VelocityWithdraw = 2 // 提款
+)
+
+// VelocityLimit 存提款频率和额度限制, uid 为0时是会员等级的配置, 否则是单个会员的配置
+// level 为0时对所有会员等级生效, 数值为0表示不限制
+type VelocityLimit struct {
+	ID          string  `db:"id" json:"id"`
+	Ty          int     `db:"ty" json:"ty"`                     // 1 存款 2 提款
+	Level       int     `db:"level" json:"level"`               // 会员等级
+	UID         string  `db:"uid" json:"uid"`                   // 会员uid
+	Username    string  `db:"username" json:"username"`         // 会员名
+	SingleMax   float64 `db:"single_max" json:"single_max"`     // 单笔最大金额
+	HourCount   int64   `db:"hour_count" json:"hour_count"`     // 每小时次数
+	HourAmount  float64 `db:"hour_amount" json:"hour_amount"`   // 每小时金额
+	DayCount    int64   `db:"day_count" json:"day_count"`       // 每日次数
+	DayAmount   float64 `db:"day_amount" json:"day_amount"`     // 每日金额
+	WeekCount   int64   `db:"week_count" json:"week_count"`     // 每周次数
+	WeekAmount  float64 `db:"week_amount" json:"week_amount"`   // 每周金额
+	MonthCount  int64   `db:"month_count" json:"month_count"`   // 每月次数
+	MonthAmount float64 `db:"month_amount" json:"month_amount"` // 每月金额
+	UpdatedAt   int64   `db:"updated_at" json:"updated_at"`
+	UpdatedUID  string  `db:"updated_uid" json:"updated_uid"`
+	UpdatedName string  `db:"updated_name" json:"updated_name"`
+	Prefix      string  `db:"prefix" json:"prefix"`
+}
+
+// VelocityWindow 单个时间窗口的额度使用情况
+type VelocityWindow struct {
+	Window       string `json:"window"` // hour day week month
+	StartAt      int64  `json:"start_at"`
+	Count        int64  `json:"count"`
+	CountUsed    int64  `json:"count_used"`
+	CountRemain  int64  `json:"count_remain"`
+	Amount       string `json:"amount"`
+	AmountUsed   string `json:"amount_used"`
+	AmountRemain string `json:"amount_remain"`
+}
+
+// VelocityQuota 会员的限制配置和各时间窗口剩余额度
+type VelocityQuota struct {
+	Ty        int              `json:"ty"`
+	SingleMax string           `json:"single_max"`
+	Windows   []VelocityWindow `json:"windows"`
+}
+
+type velocityUsed struct {
+	HourCount   sql.NullInt64   `db:"hour_count"`
+	HourAmount  sql.NullFloat64 `db:"hour_amount"`
+	DayCount    sql.NullInt64   `db:"day_count"`
+	DayAmount   sql.NullFloat64 `db:"day_amount"`
+	WeekCount   sql.NullInt64   `db:"week_count"`
+	WeekAmount  sql.NullFloat64 `db:"week_amount"`
+	MonthCount  sql.NullInt64   `db:"month_count"`
+	MonthAmount sql.NullFloat64 `db:"month_amount"`
+}
+
+func VelocityList(ex g.Ex) ([]VelocityLimit, error) {
+
+	var data []VelocityLimit
+	ex["prefix"] = meta.Prefix
+	query, _, _ := dialect.From("f_velocity_limit").Select(colVelocityLimit...).
+		Where(ex).Order(g.C("ty").Asc(), g.C("level").Asc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// VelocityUpdate 新增或修改限制, 会员等级按 ty+level 唯一, 单个会员按 ty+uid 唯一
+func VelocityUpdate(ty, level int, username string, record g.Record) error {
+
+	uid := "0"
+	if username != "" {
+		mb, err := MemberByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		uid = mb.UID
+		level = 0
+	}
+
+	var id string
+	ex := g.Ex{
+		"ty":     ty,
+		"level":  level,
+		"uid":    uid,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_velocity_limit").Select("id").Where(ex).Limit(1).ToSQL()
+	_ = meta.MerchantDB.Get(&id, query)
+
+	if id == "" {
+		record["id"] = helper.GenId()
+		record["ty"] = ty
+		record["level"] = level
+		record["uid"] = uid
+		record["username"] = username
+		record["prefix"] = meta.Prefix
+		query, _, _ = dialect.Insert("f_velocity_limit").Rows(record).ToSQL()
+	} else {
+		query, _, _ = dialect.Update("f_velocity_limit").Set(record).Where(g.Ex{"id": id}).ToSQL()
+	}
+
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return VelocityUpdateCache()
+}
+
+func VelocityDelete(id string) error {
+
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Delete("f_velocity_limit").Where(ex).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.RecordNotExistErr)
+	}
+
+	return VelocityUpdateCache()
+}
+
+// VelocityUpdateCache 限制配置写入redis, 存提款时从redis读取
+func VelocityUpdateCache() error {
+
+	data, err := VelocityList(g.Ex{})
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s:velocity:limit", meta.Prefix)
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.Unlink(ctx, key)
+	for _, v := range data {
+		b, err := helper.JsonMarshal(v)
+		if err != nil {
+			return errors.New(helper.FormatErr)
+		}
+
+		pipe.HSet(ctx, key, velocityField(v.Ty, v.Level, v.UID), string(b))
+	}
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+func velocityField(ty, level int, uid string) string {
+
+	if uid != "" && uid != "0" {
+		return fmt.Sprintf("%d:u:%s", ty, uid)
+	}
+
+	return fmt.Sprintf("%d:l:%d", ty, level)
+}
+
+// 会员生效的限制, 优先使用会员配置, 其次是等级配置, 再次是全部等级的配置
+func velocityGet(ty int, mb Member) (VelocityLimit, bool) {
+
+	data := VelocityLimit{}
+	key := fmt.Sprintf("%s:velocity:limit", meta.Prefix)
+	fields := []string{velocityField(ty, 0, mb.UID), velocityField(ty, mb.Level, ""), velocityField(ty, 0, "")}
+	res, err := meta.MerchantRedis.HMGet(ctx, key, fields...).Result()
+	if err == nil {
+		for _, v := range res {
+			s, ok := v.(string)
+			if ok && helper.JsonUnmarshal([]byte(s), &data) == nil {
+				return data, true
+			}
+		}
+	}
+
+	if ty == VelocityWithdraw {
+		return velocityWithdrawLegacy(mb.Level)
+	}
+
+	return data, false
+}
+
+// 未配置提款限制时兼容原来vip每日提款次数和金额的配置
+func velocityWithdrawLegacy(level int) (VelocityLimit, bool) {
+
+	data := VelocityLimit{Ty: VelocityWithdraw, Level: level}
+	pipe := meta.MerchantRedis.Pipeline()
+	defer pipe.Close()
+
+	times := pipe.HGet(ctx, fmt.Sprintf("%s:vip:withdraw:maxtimes", meta.Prefix), fmt.Sprintf(`%d`, level))
+	amount := pipe.HGet(ctx, fmt.Sprintf("%s:vip:withdraw:maxamount", meta.Prefix), fmt.Sprintf(`%d`, level))
+	_, _ = pipe.Exec(ctx)
+
+	data.DayCount, _ = times.Int64()
+	data.DayAmount, _ = amount.Float64()
+	data.SingleMax = data.DayAmount
+
+	return data, data.DayCount > 0 || data.DayAmount > 0
+}
+
+// 各时间窗口的开始时间
+func velocityStarts(now time.Time) map[string]int64 {
+
+	t := now.In(loc)
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	week := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+
+	return map[string]int64{
+		"hour":  time.Date(y, m, d, t.Hour(), 0, 0, 0, loc).Unix(),
+		"day":   day.Unix(),
+		"week":  week.Unix(),
+		"month": time.Date(y, m, 1, 0, 0, 0, 0, loc).Unix(),
+	}
+}
+
+// 各时间窗口已使用的次数和金额, 按下单计算, 审核中和出款中等未完成的订单也占用额度
+// 提款不含已拒绝和出款失败的订单, 存款不含已取消的订单
+func velocityUsedGet(ty int, uid string, starts map[string]int64) (velocityUsed, error) {
+
+	data := velocityUsed{}
+	tbl := "tbl_deposit"
+	amount := "IF(amount > 0, amount, usdt_apply_amount * rate / 1000)"
+	ex := g.Ex{
+		"prefix": meta.Prefix,
+		"uid":    uid,
+		"state":  g.Op{"neq": DepositCancelled},
+	}
+	if ty == VelocityWithdraw {
+		tbl = "tbl_withdraw"
+		amount = "amount"
+		ex["state"] = g.Op{"notIn": []int{WithdrawReviewReject, WithdrawFailed}}
+	}
+
+	min := starts["month"]
+	if starts["week"] < min {
+		min = starts["week"]
+	}
+	ex["created_at"] = g.Op{"gte": min}
+
+	cols := []interface{}{}
+	for _, w := range []string{"hour", "day", "week", "month"} {
+		cols = append(cols,
+			g.L("SUM(IF(created_at >= ?, 1, 0))", starts[w]).As(w+"_count"),
+			g.L(fmt.Sprintf("SUM(IF(created_at >= ?, %s, 0))", amount), starts[w]).As(w+"_amount"),
+		)
+	}
+
+	query, _, _ := dialect.From(tbl).Select(cols...).Where(ex).ToSQL()
+	err := meta.MerchantDB.Get(&data, query)
+	if err != nil && err != sql.ErrNoRows {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+// 会员的限制配置和各时间窗口的使用情况
+func velocityQuota(ty int, mb Member, now time.Time) (VelocityQuota, error) {
+
+	data := VelocityQuota{Ty: ty, SingleMax: "0"}
+	limit, ok := velocityGet(ty, mb)
+	if !ok {
+		return data, nil
+	}
+
+	starts := velocityStarts(now)
+	used, err := velocityUsedGet(ty, mb.UID, starts)
+	if err != nil {
+		return data, err
+	}
+
+	data.SingleMax = decimal.NewFromFloat(limit.SingleMax).String()
+	windows := []struct {
+		name       string
+		count      int64
+		amount     float64
+		countUsed  sql.NullInt64
+		amountUsed sql.NullFloat64
+	}{
+		{"hour", limit.HourCount, limit.HourAmount, used.HourCount, used.HourAmount},
+		{"day", limit.DayCount, limit.DayAmount, used.DayCount, used.DayAmount},
+		{"week", limit.WeekCount, limit.WeekAmount, used.WeekCount, used.WeekAmount},
+		{"month", limit.MonthCount, limit.MonthAmount, used.MonthCount, used.MonthAmount},
+	}
+	for _, v := range windows {
+		amount := decimal.NewFromFloat(v.amount)
+		amountUsed := decimal.NewFromFloat(v.amountUsed.Float64)
+		w := VelocityWindow{
+			Window:       v.name,
+			StartAt:      starts[v.name],
+			Count:        v.count,
+			CountUsed:    v.countUsed.Int64,
+			CountRemain:  -1,
+			Amount:       amount.String(),
+			AmountUsed:   amountUsed.String(),
+			AmountRemain: "-1",
+		}
+		if v.count > 0 {
+			w.CountRemain = v.count - v.countUsed.Int64
+			if w.CountRemain < 0 {
+				w.CountRemain = 0
+			}
+		}
+		if v.amount > 0 {
+			w.AmountRemain = decimal.Max(amount.Sub(amountUsed), decimal.Zero).String()
+		}
+		data.Windows = append(data.Windows, w)
+	}
+
+	return data, nil
+}
+
+// 检查本次存提款是否超出限制, 需要在 velocityLock 之后调用, 到订单写入完成后再解锁
+func velocityCheck(ty int, mb Member, amount decimal.Decimal, now time.Time) error {
+
+	limit, ok := velocityGet(ty, mb)
+	if !ok {
+		return nil
+	}
+
+	if limit.SingleMax > 0 && amount.GreaterThan(decimal.NewFromFloat(limit.SingleMax)) {
+		if ty == VelocityWithdraw {
+			return errors.New(helper.MaxDrawLimitParamErr)
+		}
+
+		return errors.New(helper.AmountOutRange)
+	}
+
+	data, err := velocityQuota(ty, mb, now)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range data.Windows {
+		if w.CountRemain == 0 {
+			return errors.New(helper.DailyTimesLimitErr)
+		}
+
+		remain, _ := decimal.NewFromString(w.AmountRemain)
+		if w.AmountRemain != "-1" && amount.GreaterThan(remain) {
+			return errors.New(helper.DailyAmountLimitErr)
+		}
+	}
+
+	return nil
+}
+
+// 同一会员同一方向的请求串行处理, 避免并发请求同时通过检查
+func velocityLock(ty int, uid string) error {
+	return Lock(fmt.Sprintf("velocity:%d:%s", ty, uid))
+}
+
+func velocityUnlock(ty int, uid string) {
+	Unlock(fmt.Sprintf("velocity:%d:%s", ty, uid))
+}
+
+// VelocityQuotaFind 后台查询会员的限制和剩余额度
+func VelocityQuotaFind(username string) ([]VelocityQuota, error) {
+
+	var data []VelocityQuota
+	mb, err := MemberByUsername(username)
+	if err != nil {
+		return data, err
+	}
+
+	now := time.Now()
+	for _, ty := range []int{VelocityDeposit, VelocityWithdraw} {
+		q, err := velocityQuota(ty, mb, now)
+		if err != nil {
+			return data, err
+		}
+
+		data = append(data, q)
+	}
+
+	return data, nil
+}
+
+// 保留旧接口的字段, 没有配置时不限制
+func velocityLegacyFields(q VelocityQuota) map[string]string {
+
+	res := map[string]string{
+		"count_remain":   "-1",
+		"max_remain":     "-1",
+		"withdraw_count": "0",
+		"withdraw_max":   "0",
+	}
+	for _, w := range q.Windows {
+		if w.Window == "day" {
+			res["count_remain"] = fmt.Sprintf("%d", w.CountRemain)
+			res["max_remain"] = w.AmountRemain
+			res["withdraw_count"] = fmt.Sprintf("%d", w.Count)
+			res["withdraw_max"] = w.Amount
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,206 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/shopspring/decimal"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	vietQRGUID        = "A000000727" // NAPAS
+	vietQRServiceCard = "QRIBFTTA"   // 转账到银行账户
+	vietQRCurrency    = "704"        // VND
+	vietQRCountry     = "VN"
+	vietQRImageSize   = 320
+)
+
+// 常用银行的NAPAS BIN, key为去掉空格后的大写银行简称或名称
+var vietQRBins = map[string]string{
+	"VCB":              "970436",
+	"VIETCOMBANK":      "970436",
+	"TCB":              "970407",
+	"TECHCOMBANK":      "970407",
+	"ACB":              "970416",
+	"BIDV":             "970418",
+	"ICB":              "970415",
+	"CTG":              "970415",
+	"VIETINBANK":       "970415",
+	"VBA":              "970405",
+	"AGRIBANK":         "970405",
+	"MB":               "970422",
+	"MBBANK":           "970422",
+	"TPB":              "970423",
+	"TPBANK":           "970423",
+	"VPB":              "970432",
+	"VPBANK":           "970432",
+	"STB":              "970403",
+	"SACOMBANK":        "970403",
+	"HDB":              "970437",
+	"HDBANK":           "970437",
+	"VIB":              "970441",
+	"SHB":              "970443",
+	"EIB":              "970431",
+	"EXIMBANK":         "970431",
+	"MSB":              "970426",
+	"OCB":              "970448",
+	"SCB":              "970429",
+	"SEAB":             "970440",
+	"SEABANK":          "970440",
+	"LPB":              "970449",
+	"LIENVIETPOSTBANK": "970449",
+	"ABB":              "970425",
+	"ABBANK":           "970425",
+	"NAB":              "970428",
+	"NAMABANK":         "970428",
+	"VAB":              "970427",
+	"PGB":              "970430",
+	"KLB":              "970452",
+	"NCB":              "970419",
+	"BVB":              "970438",
+	"GPB":              "970408",
+	"DOB":              "970406",
+	"PVCB":             "970412",
+	"CIMB":             "422589",
+	"UOB":              "970458",
+	"WOORI":            "970457",
+	"SHBVN":            "970424",
+}
+
+// VietQRBin 根据银行名称查找NAPAS BIN, 找不到返回空
+func VietQRBin(bankName string) string {
+
+	name := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(bankName), " ", ""))
+	return vietQRBins[name]
+}
+
+// EMVCo 的 ID + 长度 + 值
+func vietQRField(id, val string) string {
+	return fmt.Sprintf("%s%02d%s", id, len(val), val)
+}
+
+// CRC-16/CCITT-FALSE
+func vietQRCrc(s string) string {
+
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%04X", crc)
+}
+
+// VietQRPayload 生成转账到银行账户的VietQR内容, amount 为VND, 为0时不带金额
+func VietQRPayload(bin, account string, amount int64, memo string) (string, error) {
+
+	if len(bin) != 6 || !helper.CtypeDigit(bin) || account == "" || len(account) > 19 || len(memo) > 25 {
+		return "", errors.New(helper.ParamErr)
+	}
+
+	beneficiary := vietQRField("00", bin) + vietQRField("01", account)
+	merchant := vietQRField("00", vietQRGUID) + vietQRField("01", beneficiary) + vietQRField("02", vietQRServiceCard)
+
+	// 带金额的为动态码, 只能使用一次
+	initiation := "11"
+	if amount > 0 {
+		initiation = "12"
+	}
+
+	s := vietQRField("00", "01") +
+		vietQRField("01", initiation) +
+		vietQRField("38", merchant) +
+		vietQRField("53", vietQRCurrency)
+	if amount > 0 {
+		s += vietQRField("54", fmt.Sprintf("%d", amount))
+	}
+	s += vietQRField("58", vietQRCountry)
+	if memo != "" {
+		s += vietQRField("62", vietQRField("08", memo))
+	}
+
+	s += "6304"
+	return s + vietQRCrc(s), nil
+}
+
+// VietQRImage 生成二维码图片, 返回 data:image/png;base64
+func VietQRImage(payload string) (string, error) {
+
+	png, err := qrcode.Encode(payload, qrcode.Medium, vietQRImageSize)
+	if err != nil {
+		return "", errors.New(helper.FormatErr)
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// 线下转卡订单的二维码, 订单金额单位为KVND
+func manualQR(bc Bankcard_t, amount decimal.Decimal, code string) (string, string, error) {
+
+	bin := bc.Bin
+	if bin == "" {
+		bin = VietQRBin(bc.BanklcardName)
+	}
+
+	payload, err := VietQRPayload(bin, bc.BanklcardNo, amount.Mul(decimal.NewFromInt(1000)).IntPart(), code)
+	if err != nil {
+		return "", "", err
+	}
+
+	img, err := VietQRImage(payload)
+	if err != nil {
+		return "", "", err
+	}
+
+	return payload, img, nil
+}
+
+// ManualQRDetail 线下转卡订单的收款信息和二维码, 只能查询自己的订单
+func ManualQRDetail(uid, orderID string) (map[string]string, error) {
+
+	order, err := DepositOrderFindOne(g.Ex{"id": orderID, "uid": uid, "flag": DepositFlagManual})
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := BankCardByID(order.BankcardID)
+	if err != nil {
+		return nil, err
+	}
+
+	mr := manualRemark{}
+	_ = helper.JsonUnmarshal([]byte(order.ManualRemark), &mr)
+
+	res := map[string]string{
+		"id":           order.ID,
+		"name":         bc.BanklcardName,
+		"cardNo":       bc.BanklcardNo,
+		"realname":     bc.AccountName,
+		"bankAddr":     bc.BankcardAddr,
+		"manualRemark": mr.Code,
+		"amount":       decimal.NewFromFloat(order.Amount).String(),
+		"state":        fmt.Sprintf("%d", order.State),
+		"ts":           fmt.Sprintf("%d", order.CreatedAt),
+	}
+
+	// 银行不支持VietQR时只返回文字信息
+	payload, img, err := manualQR(bc, decimal.NewFromFloat(order.Amount), mr.Code)
+	if err == nil {
+		res["qrPayload"] = payload
+		res["qrImage"] = img
+	}
+
+	return res, nil
+}
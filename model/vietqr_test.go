@@ -0,0 +1,82 @@
+package model
+
+import (
+	"testing"
+)
+
+// CRC-16/CCITT-FALSE 的标准校验值
+func TestVietQRCrc(t *testing.T) {
+
+	if crc := vietQRCrc("123456789"); crc != "29B1" {
+		t.Fatalf("crc = %s", crc)
+	}
+}
+
+func TestVietQRPayload(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		bin     string
+		account string
+		amount  int64
+		memo    string
+		payload string
+	}{
+		{
+			// NAPAS VietQR 规范中的静态码示例
+			name:    "napas static",
+			bin:     "970403",
+			account: "0011012345678",
+			payload: "00020101021138570010A00000072701270006970403011300110123456780208QRIBFTTA53037045802VN63049E6F",
+		},
+		{
+			name:    "amount and memo",
+			bin:     "970436",
+			account: "1017595600",
+			amount:  500000,
+			memo:    "NAP 123456",
+			payload: "00020101021238540010A00000072701240006970436011010175956000208QRIBFTTA530370454065000005802VN62140810NAP 1234566304AB4B",
+		},
+		{
+			name:    "amount and memo 2",
+			bin:     "970415",
+			account: "113366668888",
+			amount:  79000,
+			memo:    "Thanh toan",
+			payload: "00020101021238560010A0000007270126000697041501121133666688880208QRIBFTTA53037045405790005802VN62140810Thanh toan6304AACE",
+		},
+	}
+
+	for _, v := range cases {
+		payload, err := VietQRPayload(v.bin, v.account, v.amount, v.memo)
+		if err != nil {
+			t.Fatalf("%s: %s", v.name, err.Error())
+		}
+
+		if payload != v.payload {
+			t.Fatalf("%s: payload = %s", v.name, payload)
+		}
+
+		// 末尾4位为 6304 之前全部内容的CRC
+		n := len(payload) - 4
+		if crc := vietQRCrc(payload[:n]); crc != payload[n:] {
+			t.Fatalf("%s: crc = %s", v.name, crc)
+		}
+	}
+}
+
+func TestVietQRPayloadInvalid(t *testing.T) {
+
+	cases := [][2]string{
+		{"97040", "0011012345678"},
+		{"97040A", "0011012345678"},
+		{"970403", ""},
+		{"970403", "01234567890123456789"},
+	}
+
+	for _, v := range cases {
+		if _, err := VietQRPayload(v[0], v[1], 0, ""); err == nil {
+			t.Fatalf("bin = %s, account = %s", v[0], v[1])
+		}
+	}
+}
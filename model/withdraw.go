@@ -10,7 +10,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/hprose/hprose-golang/v3/rpc/core"
 
 	"finance/contrib/helper"
@@ -63,6 +62,9 @@ type Withdraw struct {
 	TopName           string  `db:"top_name"            json:"top_name"           redis:"top_name"`             // 总代用户名
 	Level             int     `db:"level"               json:"level"              redis:"level"`
 	Balance           string  `db:"balance"               json:"balance"              redis:"balance"`
+	RiskScore         int     `db:"risk_score"          json:"risk_score"         redis:"risk_score"`   // 风险评分
+	RiskReasons       string  `db:"risk_reasons"        json:"risk_reasons"       redis:"risk_reasons"` // 风险评分命中原因json
+	ResumeAt          int64   `db:"resume_at"           json:"resume_at"          redis:"resume_at"`    // 挂起自动恢复时间
 }
 
 // FWithdrawData 取款数据
@@ -96,6 +98,10 @@ type withdrawCols struct {
 	MemberTags         string  `json:"member_tags"`
 	Balance            string  `db:"balance"     json:"balance"     redis:"balance"    ` //余额
 	LockAmount         float64 `db:"lock_amount" json:"lock_amount" redis:"lock_amount"` //锁定额度
+	StateAt            int64   `json:"state_at"`                                         // 进入当前状态的时间
+	StateSeconds       int64   `json:"state_seconds"`                                    // 当前状态已持续秒数
+	SlaSeconds         int64   `json:"sla_seconds"`                                      // 当前状态超时秒数, 0 未配置
+	SlaBreach          bool    `json:"sla_breach"`                                       // 是否已超时
 }
 
 type withdrawTotal struct {
@@ -206,42 +212,32 @@ func WithdrawUserInsert(amount, bid, sid, ts, verifyCode string, fCtx *fasthttp.
 		return "", errors.New(helper.WaterFlowUnreached)
 	}
 
-	//查询今日提款总计
-	count, totalAmount, err := withdrawDailyData(mb.Username)
-
-	// 所属vip提现次数限制
-	timesKey := fmt.Sprintf("%s:vip:withdraw:maxtimes", meta.Prefix)
-	times, err := meta.MerchantRedis.HGet(ctx, timesKey, fmt.Sprintf(`%d`, mb.Level)).Result()
-	if err != nil {
-		return "", pushLog(err, helper.RedisErr)
-	}
-	num, err := strconv.ParseInt(times, 10, 64)
+	// 冲正欠款未还清不能提款
+	debt, err := depositReversalDebt(mb.UID)
 	if err != nil {
-		return "", pushLog(err, helper.FormatErr)
+		return "", err
 	}
-	//今日提款次数大于等于所属vip提现次数限制
-	if count >= num {
-		return "", errors.New(helper.DailyTimesLimitErr)
+
+	if debt.GreaterThan(zero) {
+		return "", errors.New(helper.WithdrawBan)
 	}
 
-	// 所属vip提现金额限制
-	amountKey := fmt.Sprintf("%s:vip:withdraw:maxamount", meta.Prefix)
-	maxAmount, err := meta.MerchantRedis.HGet(ctx, amountKey, fmt.Sprintf(`%d`, mb.Level)).Result()
+	// 检查会员是否被限制提款
+	err = MemberLockCheck(mb.UID, LockScopeWithdraw, "")
 	if err != nil {
-		return "", pushLog(err, helper.RedisErr)
+		return "", err
 	}
-	max, err := decimal.NewFromString(maxAmount)
+
+	// 提款次数和额度限制, 加锁到订单写入完成, 防止并发提款绕过限制
+	err = velocityLock(VelocityWithdraw, mb.UID)
 	if err != nil {
-		return "", pushLog(err, helper.FormatErr)
+		return "", err
 	}
+	defer velocityUnlock(VelocityWithdraw, mb.UID)
 
-	//当前提现金额 大于 所属等级每日提现金额限制
-	if withdrawAmount.Cmp(max) > 0 {
-		return "", errors.New(helper.MaxDrawLimitParamErr)
-	}
-	// 今日已经申请的提现金额大于所属等级每日提现金额限制 或者 今日已经申请的提现金额加上当前提现金额大于所属等级每日提现金额限制
-	if totalAmount.Cmp(max) > 0 || totalAmount.Add(withdrawAmount).Cmp(max) > 0 {
-		return "", errors.New(helper.DailyAmountLimitErr)
+	err = velocityCheck(VelocityWithdraw, mb, withdrawAmount, fCtx.Time())
+	if err != nil {
+		return "", err
 	}
 
 	var (
@@ -249,13 +245,23 @@ func WithdrawUserInsert(amount, bid, sid, ts, verifyCode string, fCtx *fasthttp.
 		withdrawId = helper.GenLongId()
 		state      = WithdrawReviewing
 		adminName  string
+		extra      g.Record
+		decision   risksDecision
+		uid        = "0"
 	)
 
-	// 获取风控UID
-	uid, err := GetRisksUID()
-	if err != nil {
-		fmt.Println("风控人员未找到: 订单id=", withdrawId, "err:", err)
-		uid = "0"
+	// 自动审核规则, 命中自动通过或自动挂起时不派单给风控
+	rule, hit := withdrawRuleCheck(mb, bid, withdrawAmount, fCtx.Time().Unix())
+	if hit && rule.Action != WithdrawRuleReview {
+		state, extra = withdrawRuleRecord(rule, fCtx.Time())
+	} else {
+		// 获取风控UID
+		decision, err = risksSelect(mb.Level, withdrawAmount, "")
+		uid = decision.UID
+		if err != nil {
+			_ = pushLog(fmt.Errorf("风控人员未找到: 订单id=%s, err: %s", withdrawId, err.Error()), helper.RedisErr)
+			uid = "0"
+		}
 	}
 
 	if uid != "0" {
@@ -266,7 +272,7 @@ func WithdrawUserInsert(amount, bid, sid, ts, verifyCode string, fCtx *fasthttp.
 		}
 
 		if adminName == "" {
-			fmt.Println("风控人员未找到: 订单id=", withdrawId, "uid:", uid, "err:", err)
+			_ = pushLog(fmt.Errorf("风控人员未找到: 订单id=%s, uid=%s", withdrawId, uid), helper.DBErr)
 			uid = "0"
 		}
 
@@ -278,14 +284,33 @@ func WithdrawUserInsert(amount, bid, sid, ts, verifyCode string, fCtx *fasthttp.
 	if mb.Tester == "0" {
 		state = WithdrawSuccess
 	}
+
+	// 风险评分, 计算失败不影响提款
+	score, reasons, err := withdrawRiskScore(mb, bid, bankcardHash, fCtx.Time().Unix())
+	if err == nil {
+		if extra == nil {
+			extra = g.Record{}
+		}
+		extra["risk_score"] = score
+		extra["risk_reasons"] = "[]"
+		if len(reasons) > 0 {
+			b, _ := helper.JsonMarshal(reasons)
+			extra["risk_reasons"] = string(b)
+		}
+	}
+
 	// 记录提款单
-	err = WithdrawInsert(amount, bid, withdrawId, uid, adminName, receiveAt, state, fCtx.Time(), mb)
+	err = WithdrawInsert(amount, bid, withdrawId, uid, adminName, receiveAt, state, MemberWallet, fCtx.Time(), mb, extra)
 	if err != nil {
 		return "", err
 	}
 
 	if uid != "0" {
 		_ = SetRisksOrder(uid, withdrawId, 1)
+		risksDispatchLogWrite(withdrawId, RisksDispatchNew, decision, adminName, "", "")
+	} else if state == WithdrawHangup {
+		resumeAt, _ := extra["resume_at"].(int64)
+		WithdrawHangUpLog(withdrawId, rule.RemarkID, "0", withdrawRuleOperator, rule.HangUpRemark, resumeAt, fCtx.Time().Unix())
 	} else {
 		/*
 			// 自动派单模式
@@ -299,7 +324,7 @@ func WithdrawUserInsert(amount, bid, sid, ts, verifyCode string, fCtx *fasthttp.
 			}
 		*/
 	}
-	if mb.Tester == "1" {
+	if mb.Tester == "1" && state != WithdrawDealing && state != WithdrawHangup {
 
 		// 发送消息通知
 		_ = PushWithdrawNotify(withdrawReviewFmt, mb.Username, amount)
@@ -319,7 +344,8 @@ func WithdrawUserInsert(amount, bid, sid, ts, verifyCode string, fCtx *fasthttp.
 	return withdrawId, nil
 }
 
-func WithdrawInsert(amount, bid, withdrawID, confirmUid, confirmName string, receiveAt int64, state int, ts time.Time, member Member) error {
+// WithdrawInsert 写入提款单, wallet 为提款的钱包类型, 从对应钱包扣除并锁定提款金额
+func WithdrawInsert(amount, bid, withdrawID, confirmUid, confirmName string, receiveAt int64, state, wallet int, ts time.Time, member Member, extra g.Record) error {
 
 	// lock and defer unlock
 	lk := fmt.Sprintf("w:%s", member.Username)
@@ -358,7 +384,12 @@ func WithdrawInsert(amount, bid, withdrawID, confirmUid, confirmName string, rec
 	}
 
 	// check balance
-	userAmount, err := BalanceIsEnough(member.UID, withdrawAmount)
+	var userAmount decimal.Decimal
+	if wallet == AgencyWallet {
+		userAmount, err = commissionIsEnough(member.UID, withdrawAmount)
+	} else {
+		userAmount, err = BalanceIsEnough(member.UID, withdrawAmount)
+	}
 	if err != nil {
 		return err
 	}
@@ -406,11 +437,14 @@ func WithdrawInsert(amount, bid, withdrawID, confirmUid, confirmName string, rec
 		"receive_at":          receiveAt,
 		"confirm_uid":         confirmUid,
 		"confirm_name":        confirmName,
-		"wallet_flag":         MemberWallet,
+		"wallet_flag":         wallet,
 		"level":               member.Level,
 		"tester":              member.Tester,
 		"balance":             userAmount.Sub(withdrawAmount).String(),
 	}
+	for k, v := range extra {
+		record[k] = v
+	}
 
 	// 开启事务 写账变 更新redis  查询提款
 	tx, err := meta.MerchantDB.Begin()
@@ -425,7 +459,13 @@ func WithdrawInsert(amount, bid, withdrawID, confirmUid, confirmName string, rec
 		return pushLog(err, helper.DBErr)
 	}
 
-	if member.Tester == "1" {
+	if member.Tester == "1" && wallet == AgencyWallet {
+		err = withdrawCommissionLock(tx, member, withdrawID, withdrawAmount, userAmount, ts)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	} else if member.Tester == "1" {
 		// 更新余额
 		ex = g.Ex{
 			"uid":    member.UID,
@@ -490,26 +530,6 @@ func withdrawOrderExists(ex g.Ex) error {
 	return nil
 }
 
-// 今日提款成功次数和金额
-func withdrawDailyData(username string) (int64, decimal.Decimal, error) {
-
-	data := withdrawTotal{}
-	ex := g.Ex{
-		"prefix":     meta.Prefix,
-		"username":   username,
-		"state":      WithdrawSuccess,
-		"created_at": g.Op{"between": exp.NewRangeVal(helper.DayTST(0, loc).Unix(), helper.DayTET(0, loc).Unix())},
-	}
-	query, _, _ := dialect.From("tbl_withdraw").Select(g.COUNT("id").As("t"), g.SUM("amount").As("agg")).Where(ex).ToSQL()
-	err := meta.MerchantDB.Get(&data, query)
-
-	if err != nil && err != sql.ErrNoRows {
-		return 0, decimal.Zero, pushLog(err, helper.DBErr)
-	}
-
-	return data.T.Int64, decimal.NewFromFloat(data.Agg.Float64), nil
-}
-
 func BankCardExist(ex g.Ex) bool {
 
 	var id string
@@ -524,7 +544,7 @@ func BankCardExist(ex g.Ex) bool {
 }
 
 // WithdrawList 提款记录
-func WithdrawList(ex g.Ex, ty uint8, startTime, endTime string, page, pageSize uint) (FWithdrawData, error) {
+func WithdrawList(ex g.Ex, ty uint8, startTime, endTime string, page, pageSize uint, orders ...exp.OrderedExpression) (FWithdrawData, error) {
 
 	ex["prefix"] = meta.Prefix
 
@@ -572,9 +592,11 @@ func WithdrawList(ex g.Ex, ty uint8, startTime, endTime string, page, pageSize u
 		}
 	}
 
+	// 默认按申请时间倒序
+	orders = append(orders, g.C("created_at").Desc())
 	offset := (page - 1) * pageSize
 	query, _, _ := dialect.From("tbl_withdraw").
-		Select(colWithdraw...).Where(ex).Order(g.C("created_at").Desc()).Offset(offset).Limit(pageSize).ToSQL()
+		Select(colWithdraw...).Where(ex).Order(orders...).Offset(offset).Limit(pageSize).ToSQL()
 	//fmt.Println(query)
 	err := meta.MerchantDB.Select(&data.D, query)
 	if err != nil {
@@ -587,35 +609,9 @@ func WithdrawList(ex g.Ex, ty uint8, startTime, endTime string, page, pageSize u
 // 提款历史记录
 func WithdrawHistoryList(ex g.Ex, ty, startTime, endTime string, page, pageSize uint) (FWithdrawData, error) {
 
-	ex["prefix"] = meta.Prefix
-	ex["tester"] = "1"
-	fmt.Println("startTime:", startTime)
-	fmt.Println("endtime:", endTime)
-	if startTime != "" && endTime != "" {
-
-		startAt, err := helper.TimeToLoc(startTime, loc)
-		if err != nil {
-			return FWithdrawData{}, errors.New(helper.DateTimeErr)
-		}
-
-		endAt, err := helper.TimeToLoc(endTime, loc)
-		if err != nil {
-			return FWithdrawData{}, errors.New(helper.DateTimeErr)
-		}
-
-		if startAt >= endAt {
-			return FWithdrawData{}, errors.New(helper.QueryTimeRangeErr)
-		}
-
-		if ty == "1" {
-			ex["created_at"] = g.Op{"between": exp.NewRangeVal(startAt, endAt)}
-		} else {
-			ex["withdraw_at"] = g.Op{"between": exp.NewRangeVal(startAt, endAt)}
-		}
-	}
-
-	if realName, ok := ex["real_name_hash"]; ok {
-		ex["real_name_hash"] = MurmurHash(realName.(string), 0)
+	ex, err := withdrawHistoryEx(ex, ty, startTime, endTime)
+	if err != nil {
+		return FWithdrawData{}, err
 	}
 
 	data := FWithdrawData{}
@@ -642,7 +638,7 @@ func WithdrawHistoryList(ex g.Ex, ty, startTime, endTime string, page, pageSize
 	query, _, _ := dialect.From("tbl_withdraw").Select(colsWithdraw...).Where(ex).
 		Offset(offset).Limit(pageSize).Order(g.C("created_at").Desc()).ToSQL()
 	fmt.Println(query)
-	err := meta.MerchantDB.Select(&data.D, query)
+	err = meta.MerchantDB.Select(&data.D, query)
 	if err != nil {
 		return data, pushLog(err, helper.DBErr)
 	}
@@ -650,6 +646,41 @@ func WithdrawHistoryList(ex g.Ex, ty, startTime, endTime string, page, pageSize
 	return data, nil
 }
 
+// 提款历史记录查询条件, 列表和导出共用
+func withdrawHistoryEx(ex g.Ex, ty, startTime, endTime string) (g.Ex, error) {
+
+	ex["prefix"] = meta.Prefix
+	ex["tester"] = "1"
+	if startTime != "" && endTime != "" {
+
+		startAt, err := helper.TimeToLoc(startTime, loc)
+		if err != nil {
+			return ex, errors.New(helper.DateTimeErr)
+		}
+
+		endAt, err := helper.TimeToLoc(endTime, loc)
+		if err != nil {
+			return ex, errors.New(helper.DateTimeErr)
+		}
+
+		if startAt >= endAt {
+			return ex, errors.New(helper.QueryTimeRangeErr)
+		}
+
+		if ty == "1" {
+			ex["created_at"] = g.Op{"between": exp.NewRangeVal(startAt, endAt)}
+		} else {
+			ex["withdraw_at"] = g.Op{"between": exp.NewRangeVal(startAt, endAt)}
+		}
+	}
+
+	if realName, ok := ex["real_name_hash"]; ok {
+		ex["real_name_hash"] = MurmurHash(realName.(string), 0)
+	}
+
+	return ex, nil
+}
+
 // 财务拒绝提款订单
 func WithdrawReject(id string, d g.Record) error {
 	return WithdrawDownPoint(id, "", WithdrawFailed, d)
@@ -666,6 +697,58 @@ func WithdrawHandSuccess(id, uid, bid string, record g.Record) error {
 	return WithdrawDownPoint(id, bankcard, WithdrawSuccess, record)
 }
 
+// WithdrawManualPay 人工出款, 选择了出款卡时累加出款卡的出款金额, 超过限额时关闭出款卡
+func WithdrawManualPay(withdraw Withdraw, record g.Record, bankName, cardNo, realName, bankID string) error {
+
+	record["pid"] = "0"
+	record["automatic"] = "0"
+	record["state"] = WithdrawSuccess
+	record["bank_name"] = bankName
+	record["card_no"] = cardNo
+	record["real_name"] = realName
+
+	if bankID == "" || bankID == "undefined" {
+		return WithdrawHandSuccess(withdraw.ID, withdraw.UID, withdraw.BID, record)
+	}
+
+	bk, err := BankCardByCol(cardNo)
+	if err != nil {
+		return err
+	}
+
+	//提款超过当日最大提款限额
+	amount := decimal.NewFromFloat(withdraw.Amount)
+	fishAmount, _ := decimal.NewFromString(bk.DailyFinishAmount)
+	maxAmount, _ := decimal.NewFromString(bk.DailyMaxAmount)
+	totalAmount, _ := decimal.NewFromString(bk.TotalFinishAmount)
+	totalMaxAmount, _ := decimal.NewFromString(bk.TotalMaxAmount)
+
+	if fishAmount.Cmp(maxAmount) >= 0 || fishAmount.Add(amount).GreaterThan(maxAmount) ||
+		totalAmount.Add(amount).GreaterThan(totalMaxAmount) {
+		return errors.New(helper.DailyAmountLimitErr)
+	}
+
+	err = WithdrawHandSuccess(withdraw.ID, withdraw.UID, withdraw.BID, record)
+	if err != nil {
+		return err
+	}
+
+	rec := g.Record{
+		"daily_finish_amount": fishAmount.Add(amount).StringFixed(4),
+		"total_finish_amount": totalAmount.Add(amount).StringFixed(4),
+	}
+	if fishAmount.Add(amount).Cmp(maxAmount) >= 0 {
+		rec["state"] = 0
+	}
+	if totalAmount.Add(amount).Cmp(totalMaxAmount) >= 0 {
+		rec["state"] = 0
+	}
+
+	// 订单已出款成功, 更新出款卡失败不影响结果
+	_ = BankCardUpdate(bk.Id, rec)
+	return nil
+}
+
 func WithdrawRiskReview(id string, state int, record g.Record, withdraw Withdraw) error {
 
 	err := WithdrawDownPoint(id, "", state, record)
@@ -852,51 +935,34 @@ func WithdrawFind(id string) (Withdraw, error) {
 	return w, nil
 }
 
-// 每日剩余提款次数和总额
-func WithdrawLimit(ctx *fasthttp.RequestCtx) (map[string]string, error) {
+// WithdrawLimit 剩余提款次数和额度, 保留原来的每日字段, windows 为各时间窗口的剩余额度
+func WithdrawLimit(ctx *fasthttp.RequestCtx) (map[string]interface{}, error) {
 
 	member, err := MemberCache(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	date := ctx.Time().Format("20060102")
-	return WithDrawDailyLimit(date, member.Username)
-}
-
-// WithDrawDailyLimit 获取每日提现限制
-func WithDrawDailyLimit(date, username string) (map[string]string, error) {
-
-	limitKey := fmt.Sprintf("%s:%s:w:%s", meta.Prefix, username, date)
-
-	// 获取会员当日提现限制，先取缓存 没有就设置一下
-	num, err := meta.MerchantRedis.Exists(ctx, limitKey).Result()
+	withdraw, err := velocityQuota(VelocityWithdraw, member, ctx.Time())
 	if err != nil {
-		return defaultLevelWithdrawLimit, pushLog(err, helper.RedisErr)
+		return nil, err
 	}
 
-	if num > 0 {
-		rs, err := meta.MerchantRedis.Get(ctx, limitKey).Result()
-		if err != nil {
-			return defaultLevelWithdrawLimit, pushLog(err, helper.RedisErr)
-		}
-
-		data := make(map[string]string)
-		err = helper.JsonUnmarshal([]byte(rs), &data)
-		if err != nil {
-			return defaultLevelWithdrawLimit, errors.New(helper.FormatErr)
-		}
-
-		return data, nil
+	deposit, err := velocityQuota(VelocityDeposit, member, ctx.Time())
+	if err != nil {
+		return nil, err
 	}
 
-	b, _ := helper.JsonMarshal(defaultLevelWithdrawLimit)
-	err = meta.MerchantRedis.Set(ctx, limitKey, b, 24*60*60*time.Second).Err()
-	if err != nil {
-		return defaultLevelWithdrawLimit, pushLog(err, helper.RedisErr)
+	data := map[string]interface{}{
+		"single_max": withdraw.SingleMax,
+		"windows":    withdraw.Windows,
+		"deposit":    deposit,
+	}
+	for k, v := range velocityLegacyFields(withdraw) {
+		data[k] = v
 	}
 
-	return defaultLevelWithdrawLimit, nil
+	return data, nil
 }
 
 func WithdrawInProcessing(ctx *fasthttp.RequestCtx) (map[string]interface{}, error) {
@@ -931,6 +997,12 @@ func WithdrawInProcessing(ctx *fasthttp.RequestCtx) (map[string]interface{}, err
 		"created_at": order.CreatedAt,
 	}
 
+	// 挂起时展示挂起原因和预计恢复时间
+	if order.State == WithdrawHangup {
+		data["message"] = hangUpReasonContent(fmt.Sprintf("%d", order.RemarkID))
+		data["resume_at"] = order.ResumeAt
+	}
+
 	return data, nil
 }
 
@@ -1020,6 +1092,8 @@ func WithdrawDealListData(data FWithdrawData) (WithdrawListData, error) {
 	}
 
 	cids, _ := channelCateMap(pids)
+	slaConf, _ := withdrawSlaConf()
+	now := time.Now().Unix()
 
 	// 处理返回前端的数据
 	for _, v := range data.D {
@@ -1049,6 +1123,8 @@ func WithdrawDealListData(data FWithdrawData) (WithdrawListData, error) {
 			w.CateName = cate.Name
 		}
 
+		withdrawSlaFill(&w, slaConf, now)
+
 		result.D = append(result.D, w)
 	}
 
@@ -1112,6 +1188,11 @@ func WithdrawAuto(param WithdrawAutoParam, level int) error {
 			continue
 		}
 
+		// skip the payment channel which is out of its operating window or under maintenance
+		if !paymentOpenByID(info.PaymentID, time.Now()) {
+			continue
+		}
+
 		pay, err := WithdrawGetPayment(info.CateID)
 		if err != nil {
 			continue
@@ -1413,6 +1494,11 @@ func LockBalanceIsEnough(uid string, amount decimal.Decimal) (decimal.Decimal, e
 // 提款成功
 func withdrawOrderSuccess(query, bankcard string, order Withdraw) error {
 
+	// 佣金钱包的提款不涉及存款流水和中心钱包
+	if order.WalletFlag == AgencyWallet {
+		return withdrawCommissionSuccess(query, order)
+	}
+
 	money := decimal.NewFromFloat(order.Amount)
 
 	// 判断锁定余额是否充足
@@ -1432,6 +1518,9 @@ func withdrawOrderSuccess(query, bankcard string, order Withdraw) error {
 	fmt.Println("FinshDepositFlow:recs:", recs)
 	if !recs {
 		fmt.Println("FinshDepositFlow is false")
+	} else {
+		// 存款流水已完成, 之前发放的存款优惠流水要求随之完成
+		depositPromoFinish(order.UID, order.ConfirmName, order.CreatedAt)
 	}
 	//开启事务
 	tx, err := meta.MerchantDB.Begin()
@@ -1469,17 +1558,18 @@ func withdrawOrderSuccess(query, bankcard string, order Withdraw) error {
 
 	MemberUpdateCache(order.Username)
 
-	// 修改会员提款限制
-	date := time.Unix(order.CreatedAt, 0).Format("20060102")
-	_ = withDrawDailyLimitUpdate(money, date, order.Username)
-
 	// 发送通知 提款成功
 	//_ = PushWithdrawSuccess(order.UID, order.Amount)
+	return withdrawSuccessNotify(order)
+}
+
+// 提款成功的站内信和推送
+func withdrawSuccessNotify(order Withdraw) error {
 
 	title := "Thông Báo Rút Tiền Thành Công "
 	content := fmt.Sprintf("Quý Khách Của P3 Thân Mến:\nBạn Đã Rút Tiền Thành Công %s KVND,Vui Lòng Kiểm Tra Tiền Rút Của Bạn Đã Thành Công Về Tài Khoản Chưa .Nếu Bạn Có Bất Cứ Thắc Mắc Vấn Đề Gì Vui Lòng Liên Hệ CSKH Để Biết Thêm Chi Tiết.!!【P3】Rút Tiền Nhanh Chóng & An Toàn !",
 		decimal.NewFromFloat(order.Amount).Truncate(0).String())
-	err = messageSend(order.ID, title, content, "system", meta.Prefix, 0, 0, 1, []string{order.Username})
+	err := messageSend(order.ID, title, content, "system", meta.Prefix, 0, 0, 1, []string{order.Username})
 	if err != nil {
 		_ = pushLog(err, helper.ESErr)
 	}
@@ -1504,51 +1594,13 @@ func withdrawOrderSuccess(query, bankcard string, order Withdraw) error {
 	return nil
 }
 
-// 更新每日提款次数限制和金额限制
-func withDrawDailyLimitUpdate(amount decimal.Decimal, date, username string) error {
-
-	limitKey := fmt.Sprintf("%s:%s:w:%s", meta.Prefix, username, date)
-
-	var wl = map[string]string{
-		"withdraw_count": "0",
-		"count_remain":   "0",
-		"withdraw_max":   "0.0000",
-		"max_remain":     "0.0000",
-	}
-
-	// 如果订单生成日的提款限制缓存没有命中 从redis中get key的时 返回的err不会是nil
-	// 所以直接返回就可以了
-	// 否则需要刷新一下缓存
-	rs, err := meta.MerchantRedis.Get(ctx, limitKey).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil
-		}
-		return pushLog(err, helper.RedisErr)
-	}
-
-	err = helper.JsonUnmarshal([]byte(rs), &wl)
-	if err != nil {
-		return errors.New(helper.FormatErr)
-	}
-
-	count, _ := strconv.ParseInt(wl["count_remain"], 10, 64)
-	wl["count_remain"] = strconv.FormatInt(count-1, 10)
-
-	prev, _ := decimal.NewFromString(wl["max_remain"])
-	wl["max_remain"] = prev.Sub(amount).String()
+func withdrawOrderFailed(query string, order Withdraw) error {
 
-	b, _ := helper.JsonMarshal(wl)
-	err = meta.MerchantRedis.Set(ctx, limitKey, b, 24*60*60*time.Second).Err()
-	if err != nil {
-		return pushLog(err, helper.RedisErr)
+	// 佣金钱包的提款退回佣金钱包
+	if order.WalletFlag == AgencyWallet {
+		return withdrawCommissionFailed(query, order)
 	}
 
-	return nil
-}
-
-func withdrawOrderFailed(query string, order Withdraw) error {
-
 	money := decimal.NewFromFloat(order.Amount)
 
 	//4、查询用户额度
@@ -1614,10 +1666,15 @@ func withdrawOrderFailed(query string, order Withdraw) error {
 	}
 
 	MemberUpdateCache(order.Username)
+	return withdrawFailedNotify(order)
+}
+
+// 提款失败的站内信和推送
+func withdrawFailedNotify(order Withdraw) error {
 
 	title := "Thông Báo Rút Tiền Thất Bại :"
 	content := fmt.Sprintf("Quý Khách Của P3 Thân Mến :\n Đơn Rút Tiền Của Quý Khách Xử Lý Thất Bại, Nguyên Nhân Do : %s. Nếu Có Bất Cứ Vấn Đề Thắc Mắc Vui Lòng Liên Hệ CSKH  Để Biết Thêm Chi Tiết. [P3] Cung Cấp Dịch Vụ Chăm Sóc 1:1 Mọi Lúc Cho Khách Hàng ! \n", order.WithdrawRemark)
-	err = messageSend(order.ID, title, content, "system", meta.Prefix, 0, 0, 1, []string{order.Username})
+	err := messageSend(order.ID, title, content, "system", meta.Prefix, 0, 0, 1, []string{order.Username})
 	if err != nil {
 		_ = pushLog(err, helper.ESErr)
 	}
@@ -0,0 +1,426 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+	"github.com/valyala/fasthttp"
+)
+
+// CommissionWithdrawConf 佣金钱包提款配置, 金额为0时不限制
+type CommissionWithdrawConf struct {
+	Fmin       string `json:"fmin" redis:"fmin"`               // 单笔最低金额
+	Fmax       string `json:"fmax" redis:"fmax"`               // 单笔最高金额
+	DailyTimes int    `json:"daily_times" redis:"daily_times"` // 每日提款次数
+	DailyMax   string `json:"daily_max" redis:"daily_max"`     // 每日提款总额
+	Dispatch   int    `json:"dispatch" redis:"dispatch"`       // 1 按风控派单规则派单 0 进入待领取列表由风控手动领取
+}
+
+// 代理类型, 只有代理可以从佣金钱包提款
+const (
+	AgencyTypeTeam   = 391 // 团队代理
+	AgencyTypeNormal = 393 // 普通代理
+)
+
+// 代理佣金余额
+type commissionBalance struct {
+	Commission     float64 `db:"commission"`
+	CommissionLock float64 `db:"commission_lock"`
+}
+
+func CommissionWithdrawConfGet() (CommissionWithdrawConf, error) {
+
+	conf := CommissionWithdrawConf{}
+	key := fmt.Sprintf("%s:withdraw:commission:conf", meta.Prefix)
+	err := meta.MerchantRedis.HGetAll(ctx, key).Scan(&conf)
+	if err != nil && err != redis.Nil {
+		return conf, pushLog(err, helper.RedisErr)
+	}
+
+	if conf.Fmin == "" {
+		conf.Fmin = "0"
+	}
+	if conf.Fmax == "" {
+		conf.Fmax = "0"
+	}
+	if conf.DailyMax == "" {
+		conf.DailyMax = "0"
+	}
+
+	return conf, nil
+}
+
+func CommissionWithdrawConfSet(conf CommissionWithdrawConf) error {
+
+	key := fmt.Sprintf("%s:withdraw:commission:conf", meta.Prefix)
+	err := meta.MerchantRedis.HSet(ctx, key,
+		"fmin", conf.Fmin,
+		"fmax", conf.Fmax,
+		"daily_times", conf.DailyTimes,
+		"daily_max", conf.DailyMax,
+		"dispatch", conf.Dispatch,
+	).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// WithdrawCommissionInsert 代理申请从佣金钱包提款
+func WithdrawCommissionInsert(amount, bid string, fCtx *fasthttp.RequestCtx) (string, error) {
+
+	mb, err := MemberCache(fCtx)
+	if err != nil {
+		return "", errors.New(helper.AccessTokenExpires)
+	}
+
+	if mb.AgencyType != AgencyTypeTeam && mb.AgencyType != AgencyTypeNormal {
+		return "", errors.New(helper.MethodNoPermission)
+	}
+
+	var bankcardHash uint64
+	query, _, _ := dialect.From("tbl_member_bankcard").Select("bank_card_hash").Where(g.Ex{"id": bid, "state": 1}).ToSQL()
+	err = meta.MerchantDB.Get(&bankcardHash, query)
+	if err != nil && err != sql.ErrNoRows {
+		return "", pushLog(err, helper.DBErr)
+	}
+
+	// 记录不存在
+	if bankcardHash == 0 {
+		return "", errors.New(helper.RecordNotExistErr)
+	}
+
+	withdrawAmount, err := decimal.NewFromString(amount)
+	if err != nil || withdrawAmount.LessThanOrEqual(zero) {
+		return "", errors.New(helper.AmountErr)
+	}
+
+	conf, err := CommissionWithdrawConfGet()
+	if err != nil {
+		return "", err
+	}
+
+	fmin, _ := decimal.NewFromString(conf.Fmin)
+	fmax, _ := decimal.NewFromString(conf.Fmax)
+	if withdrawAmount.LessThan(fmin) || (fmax.GreaterThan(zero) && withdrawAmount.GreaterThan(fmax)) {
+		return "", errors.New(helper.AmountErr)
+	}
+
+	// 冲正欠款未还清不能提款
+	debt, err := depositReversalDebt(mb.UID)
+	if err != nil {
+		return "", err
+	}
+
+	if debt.GreaterThan(zero) {
+		return "", errors.New(helper.WithdrawBan)
+	}
+
+	// 检查会员是否被限制提款
+	err = MemberLockCheck(mb.UID, LockScopeWithdraw, "")
+	if err != nil {
+		return "", err
+	}
+
+	// 每日次数和总额限制, 加锁到订单写入完成, 防止并发提款绕过限制
+	lk := fmt.Sprintf("w:commission:%s", mb.UID)
+	err = Lock(lk)
+	if err != nil {
+		return "", err
+	}
+	defer Unlock(lk)
+
+	err = withdrawCommissionDailyCheck(mb.UID, withdrawAmount, conf, fCtx.Time())
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		receiveAt  int64
+		withdrawId = helper.GenLongId()
+		state      = WithdrawReviewing
+		adminName  string
+		extra      = g.Record{}
+		decision   risksDecision
+		uid        = "0"
+	)
+
+	// 按配置派单给风控, 否则进入风控待领取列表
+	if conf.Dispatch == 1 {
+		decision, err = risksSelect(mb.Level, withdrawAmount, "")
+		uid = decision.UID
+		if err != nil {
+			_ = pushLog(fmt.Errorf("风控人员未找到: 订单id=%s, err: %s", withdrawId, err.Error()), helper.RedisErr)
+			uid = "0"
+		}
+	}
+
+	if uid != "0" {
+		adminName, err = AdminGetName(uid)
+		if err != nil {
+			return "", err
+		}
+
+		if adminName == "" {
+			uid = "0"
+		} else {
+			state = WithdrawDispatched
+			receiveAt = fCtx.Time().Unix()
+		}
+	}
+	if mb.Tester == "0" {
+		state = WithdrawSuccess
+	}
+
+	// 风险评分, 计算失败不影响提款
+	score, reasons, err := withdrawRiskScore(mb, bid, bankcardHash, fCtx.Time().Unix())
+	if err == nil {
+		extra["risk_score"] = score
+		extra["risk_reasons"] = "[]"
+		if len(reasons) > 0 {
+			b, _ := helper.JsonMarshal(reasons)
+			extra["risk_reasons"] = string(b)
+		}
+	}
+
+	err = WithdrawInsert(amount, bid, withdrawId, uid, adminName, receiveAt, state, AgencyWallet, fCtx.Time(), mb, extra)
+	if err != nil {
+		return "", err
+	}
+
+	if uid != "0" {
+		_ = SetRisksOrder(uid, withdrawId, 1)
+		risksDispatchLogWrite(withdrawId, RisksDispatchNew, decision, adminName, "", "")
+	}
+
+	if mb.Tester == "1" {
+		_ = PushWithdrawNotify(withdrawReviewFmt, mb.Username, amount)
+	}
+
+	return withdrawId, nil
+}
+
+// 当天佣金钱包提款次数和总额, 拒绝和失败的订单不计算在内
+func withdrawCommissionDailyCheck(uid string, amount decimal.Decimal, conf CommissionWithdrawConf, now time.Time) error {
+
+	dailyMax, _ := decimal.NewFromString(conf.DailyMax)
+	if conf.DailyTimes == 0 && dailyMax.LessThanOrEqual(zero) {
+		return nil
+	}
+
+	y, m, d := now.In(loc).Date()
+	ex := g.Ex{
+		"uid":         uid,
+		"wallet_flag": AgencyWallet,
+		"created_at":  g.Op{"gte": time.Date(y, m, d, 0, 0, 0, 0, loc).Unix()},
+		"state":       g.Op{"notIn": []int{WithdrawReviewReject, WithdrawFailed}},
+	}
+	data := struct {
+		T      int64           `db:"t"`
+		Amount sql.NullFloat64 `db:"amount"`
+	}{}
+	query, _, _ := dialect.From("tbl_withdraw").Select(g.COUNT(1).As("t"), g.SUM("amount").As("amount")).Where(ex).ToSQL()
+	err := meta.MerchantDB.Get(&data, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if conf.DailyTimes > 0 && data.T >= int64(conf.DailyTimes) {
+		return errors.New(helper.AmountErr)
+	}
+
+	if dailyMax.GreaterThan(zero) && decimal.NewFromFloat(data.Amount.Float64).Add(amount).GreaterThan(dailyMax) {
+		return errors.New(helper.AmountErr)
+	}
+
+	return nil
+}
+
+func commissionBalanceDB(uid string) (commissionBalance, error) {
+
+	cb := commissionBalance{}
+	ex := g.Ex{
+		"uid":    uid,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("tbl_members").Select("commission", "commission_lock").Where(ex).Limit(1).ToSQL()
+	err := meta.MerchantDB.Get(&cb, query)
+	if err != nil {
+		return cb, pushLog(err, helper.DBErr)
+	}
+
+	return cb, nil
+}
+
+// 检查佣金余额是否充足
+func commissionIsEnough(uid string, amount decimal.Decimal) (decimal.Decimal, error) {
+
+	cb, err := commissionBalanceDB(uid)
+	if err != nil {
+		return decimal.NewFromFloat(cb.Commission), err
+	}
+
+	if decimal.NewFromFloat(cb.Commission).Sub(amount).IsNegative() {
+		return decimal.NewFromFloat(cb.Commission), errors.New(helper.LackOfBalance)
+	}
+
+	return decimal.NewFromFloat(cb.Commission), nil
+}
+
+// 佣金钱包提款下单, 扣除佣金并锁定到订单完成
+func withdrawCommissionLock(tx *sql.Tx, member Member, withdrawID string, amount, before decimal.Decimal, ts time.Time) error {
+
+	ex := g.Ex{
+		"uid":    member.UID,
+		"prefix": meta.Prefix,
+	}
+	record := g.Record{
+		"commission":      g.L(fmt.Sprintf("commission-%s", amount.String())),
+		"commission_lock": g.L(fmt.Sprintf("commission_lock+%s", amount.String())),
+	}
+	query, _, _ := dialect.Update("tbl_members").Set(record).Where(ex).ToSQL()
+	_, err := tx.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	trans := memberTransaction{
+		AfterAmount:  before.Sub(amount).String(),
+		Amount:       amount.String(),
+		BeforeAmount: before.String(),
+		BillNo:       withdrawID,
+		CreatedAt:    ts.UnixMilli(),
+		ID:           helper.GenId(),
+		CashType:     helper.TransactionWithDraw,
+		UID:          member.UID,
+		Username:     member.Username,
+		Prefix:       meta.Prefix,
+	}
+	query, _, _ = dialect.Insert("tbl_commission_transaction").Rows(trans).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return nil
+}
+
+// 佣金钱包提款成功, 扣除锁定的佣金
+func withdrawCommissionSuccess(query string, order Withdraw) error {
+
+	money := decimal.NewFromFloat(order.Amount)
+	cb, err := commissionBalanceDB(order.UID)
+	if err != nil {
+		return err
+	}
+
+	if decimal.NewFromFloat(cb.CommissionLock).Sub(money).IsNegative() {
+		return errors.New(helper.LackOfBalance)
+	}
+
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	ex := g.Ex{
+		"uid":    order.UID,
+		"prefix": meta.Prefix,
+	}
+	record := g.Record{
+		"last_withdraw_at": time.Now().Unix(),
+		"commission_lock":  g.L(fmt.Sprintf("commission_lock-%s", money.String())),
+	}
+	query, _, _ = dialect.Update("tbl_members").Set(record).Where(ex).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	_ = MemberUpdateCache(order.Username)
+	return withdrawSuccessNotify(order)
+}
+
+// 佣金钱包提款失败, 锁定的佣金退回佣金钱包
+func withdrawCommissionFailed(query string, order Withdraw) error {
+
+	money := decimal.NewFromFloat(order.Amount)
+	cb, err := commissionBalanceDB(order.UID)
+	if err != nil {
+		return err
+	}
+
+	before := decimal.NewFromFloat(cb.Commission)
+	tx, err := meta.MerchantDB.Begin()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	ex := g.Ex{
+		"uid":    order.UID,
+		"prefix": meta.Prefix,
+	}
+	record := g.Record{
+		"commission":      g.L(fmt.Sprintf("commission+%s", money.String())),
+		"commission_lock": g.L(fmt.Sprintf("commission_lock-%s", money.String())),
+	}
+	query, _, _ = dialect.Update("tbl_members").Set(record).Where(ex).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	trans := memberTransaction{
+		AfterAmount:  before.Add(money).String(),
+		Amount:       money.String(),
+		BeforeAmount: before.String(),
+		BillNo:       order.ID,
+		CreatedAt:    time.Now().UnixMilli(),
+		ID:           helper.GenId(),
+		CashType:     helper.TransactionWithDrawFail,
+		UID:          order.UID,
+		Username:     order.Username,
+		Prefix:       meta.Prefix,
+	}
+	query, _, _ = dialect.Insert("tbl_commission_transaction").Rows(trans).ToSQL()
+	_, err = tx.Exec(query)
+	if err != nil {
+		_ = tx.Rollback()
+		return pushLog(err, helper.DBErr)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	_ = MemberUpdateCache(order.Username)
+	return withdrawFailedNotify(order)
+}
@@ -0,0 +1,348 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	withdrawResumeLock     = "withdraw:resume"
+	withdrawResumeInterval = 60 * time.Second
+	withdrawResumeOperator = "挂起到期恢复"
+)
+
+// HangUpReason 挂起原因, 会员看到的提示按站点语言展示
+type HangUpReason struct {
+	ID            string `db:"id" json:"id"`
+	Title         string `db:"title" json:"title"`                   // 后台展示的原因
+	ContentCn     string `db:"content_cn" json:"content_cn"`         // 会员提示-中文
+	ContentEn     string `db:"content_en" json:"content_en"`         // 会员提示-英文
+	ContentVn     string `db:"content_vn" json:"content_vn"`         // 会员提示-越南语
+	ResumeSeconds int64  `db:"resume_seconds" json:"resume_seconds"` // 默认挂起多少秒后自动恢复, 0 不自动恢复
+	Sort          int    `db:"sort" json:"sort"`
+	State         int    `db:"state" json:"state"` // 0 关闭 1 开启
+	UpdatedAt     int64  `db:"updated_at" json:"updated_at"`
+	UpdatedUID    string `db:"updated_uid" json:"updated_uid"`
+	UpdatedName   string `db:"updated_name" json:"updated_name"`
+	Prefix        string `db:"prefix" json:"prefix"`
+}
+
+// HangUpReportItem 挂起统计
+type HangUpReportItem struct {
+	RemarkID string `db:"remark_id" json:"remark_id"`
+	Title    string `db:"-" json:"title"`
+	UID      string `db:"uid" json:"uid"`
+	Name     string `db:"name" json:"name"`
+	T        int64  `db:"t" json:"t"`
+}
+
+func HangUpReasonList() ([]HangUpReason, error) {
+
+	var data []HangUpReason
+	query, _, _ := dialect.From("f_hangup_reason").Select(colHangUpReason...).
+		Where(g.Ex{"prefix": meta.Prefix}).Order(g.C("sort").Asc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+func HangUpReasonInsert(record g.Record) error {
+
+	record["prefix"] = meta.Prefix
+	query, _, _ := dialect.Insert("f_hangup_reason").Rows(record).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return HangUpReasonUpdateCache()
+}
+
+func HangUpReasonUpdate(id string, record g.Record) error {
+
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Update("f_hangup_reason").Set(record).Where(ex).Limit(1).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	return HangUpReasonUpdateCache()
+}
+
+// HangUpReasonUpdateCache 挂起原因写入redis, 关闭的原因也保留, 已挂起的订单仍需展示
+func HangUpReasonUpdateCache() error {
+
+	data, err := HangUpReasonList()
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s:withdraw:hangup:reason", meta.Prefix)
+	pipe := meta.MerchantRedis.TxPipeline()
+	defer pipe.Close()
+
+	pipe.Unlink(ctx, key)
+	for _, v := range data {
+		b, err := helper.JsonMarshal(v)
+		if err != nil {
+			return errors.New(helper.FormatErr)
+		}
+
+		pipe.HSet(ctx, key, v.ID, string(b))
+	}
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// HangUpReasonFind 从缓存读取挂起原因
+func HangUpReasonFind(id string) (HangUpReason, error) {
+
+	data := HangUpReason{}
+	key := fmt.Sprintf("%s:withdraw:hangup:reason", meta.Prefix)
+	res, err := meta.MerchantRedis.HGet(ctx, key, id).Result()
+	if err == redis.Nil {
+		return data, errors.New(helper.RecordNotExistErr)
+	}
+
+	if err != nil {
+		return data, pushLog(err, helper.RedisErr)
+	}
+
+	err = helper.JsonUnmarshal([]byte(res), &data)
+	if err != nil {
+		return data, errors.New(helper.FormatErr)
+	}
+
+	return data, nil
+}
+
+// 会员看到的挂起提示
+func hangUpReasonContent(id string) string {
+
+	rs, err := HangUpReasonFind(id)
+	if err != nil {
+		return ""
+	}
+
+	switch meta.Lang {
+	case "cn":
+		return rs.ContentCn
+	case "vn":
+		return rs.ContentVn
+	}
+
+	return rs.ContentEn
+}
+
+// HangUpResumeAt 挂起的自动恢复时间, 未指定时使用挂起原因的默认时长
+func HangUpResumeAt(reason HangUpReason, resumeAt string, now int64) (int64, error) {
+
+	if resumeAt == "" {
+		if reason.ResumeSeconds > 0 {
+			return now + reason.ResumeSeconds, nil
+		}
+
+		return 0, nil
+	}
+
+	at, err := helper.TimeToLoc(resumeAt, loc)
+	if err != nil || at <= now {
+		return 0, errors.New(helper.DateTimeErr)
+	}
+
+	return at, nil
+}
+
+// 挂起原因的默认恢复时间
+func hangUpResumeAt(id string, ts int64) int64 {
+
+	rs, err := HangUpReasonFind(id)
+	if err != nil || rs.ResumeSeconds <= 0 {
+		return 0
+	}
+
+	return ts + rs.ResumeSeconds
+}
+
+// WithdrawHangUpLog 记录每次挂起, 用于按原因和风控人员统计
+func WithdrawHangUpLog(withdrawID, remarkID, uid, name, remark string, resumeAt, ts int64) {
+
+	record := g.Record{
+		"id":          helper.GenId(),
+		"withdraw_id": withdrawID,
+		"remark_id":   remarkID,
+		"uid":         uid,
+		"name":        name,
+		"remark":      remark,
+		"resume_at":   resumeAt,
+		"created_at":  ts,
+		"prefix":      meta.Prefix,
+	}
+	query, _, _ := dialect.Insert("f_withdraw_hangup_log").Rows(record).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+	}
+}
+
+// WithdrawHangUpReport 按挂起原因和风控人员统计挂起次数
+func WithdrawHangUpReport(startTime, endTime string) ([]HangUpReportItem, error) {
+
+	var data []HangUpReportItem
+	startAt, err := helper.TimeToLoc(startTime, loc)
+	if err != nil {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	endAt, err := helper.TimeToLoc(endTime, loc)
+	if err != nil {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	if startAt >= endAt {
+		return data, errors.New(helper.QueryTimeRangeErr)
+	}
+
+	ex := g.Ex{
+		"prefix":     meta.Prefix,
+		"created_at": g.Op{"between": exp.NewRangeVal(startAt, endAt)},
+	}
+	query, _, _ := dialect.From("f_withdraw_hangup_log").
+		Select(g.C("remark_id"), g.C("uid"), g.C("name"), g.COUNT(1).As("t")).
+		Where(ex).GroupBy("remark_id", "uid", "name").Order(g.C("t").Desc()).ToSQL()
+	err = meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	for k, v := range data {
+		rs, err := HangUpReasonFind(v.RemarkID)
+		if err == nil {
+			data[k].Title = rs.Title
+		}
+	}
+
+	return data, nil
+}
+
+// WithdrawResumeTask 定时将到期的挂起订单恢复到待审核
+func WithdrawResumeTask() {
+
+	ticker := time.NewTicker(withdrawResumeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// 多实例只需要一个执行
+		if Lock(withdrawResumeLock) != nil {
+			continue
+		}
+
+		withdrawResume()
+		Unlock(withdrawResumeLock)
+	}
+}
+
+func withdrawResume() {
+
+	var ids []string
+	ex := g.Ex{
+		"prefix":    meta.Prefix,
+		"state":     WithdrawHangup,
+		"resume_at": g.Op{"between": exp.NewRangeVal(1, time.Now().Unix())},
+	}
+	query, _, _ := dialect.From("tbl_withdraw").Select("id").Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&ids, query)
+	if err != nil {
+		_ = pushLog(err, helper.DBErr)
+		return
+	}
+
+	for _, id := range ids {
+		err = withdrawResumeOne(id)
+		if err != nil {
+			fmt.Println("withdrawResume id = ", id, ", err = ", err.Error())
+		}
+	}
+}
+
+func withdrawResumeOne(id string) error {
+
+	err := WithdrawLock(id)
+	if err != nil {
+		return err
+	}
+	defer WithdrawUnLock(id)
+
+	order, err := WithdrawFind(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if order.State != WithdrawHangup || order.ResumeAt == 0 || order.ResumeAt > now {
+		return nil
+	}
+
+	record := g.Record{
+		"state":        WithdrawReviewing,
+		"confirm_uid":  "0",
+		"confirm_name": "",
+		"receive_at":   0,
+		"resume_at":    0,
+	}
+
+	// 有可接单的风控人员时直接派单
+	d, err := risksSelect(order.Level, decimal.NewFromFloat(order.Amount), "")
+	name := ""
+	if err == nil {
+		name, _ = AdminGetName(d.UID)
+	}
+
+	if name != "" {
+		record["state"] = WithdrawDispatched
+		record["confirm_uid"] = d.UID
+		record["confirm_name"] = name
+		record["receive_at"] = now
+	}
+
+	ex := g.Ex{
+		"id":    id,
+		"state": WithdrawHangup,
+	}
+	err = withdrawUpdateInfo(ex, record)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if name != "" {
+		_ = SetRisksOrder(d.UID, id, 1)
+		d.Reason = fmt.Sprintf("%s, %s", withdrawResumeOperator, d.Reason)
+		risksDispatchLogWrite(id, RisksDispatchNew, d, name, "", "")
+	}
+
+	return nil
+}
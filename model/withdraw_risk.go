@@ -0,0 +1,252 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+)
+
+// 提款风险评分的命中项
+const (
+	WithdrawRiskSharedCard     = "shared_card"      // 银行卡被其他账号绑定
+	WithdrawRiskRealName       = "real_name"        // 银行卡的绑定人实名与会员实名不一致
+	WithdrawRiskSameDevice     = "same_device"      // 注册设备号与其他会员相同
+	WithdrawRiskSameIP         = "same_ip"          // 注册IP与其他会员相同
+	WithdrawRiskDepositGap     = "deposit_gap"      // 存款后短时间内提款
+	WithdrawRiskNoDeposit      = "no_deposit"       // 没有成功存款记录
+	WithdrawRiskCardRecent     = "card_recent"      // 提款卡绑定时间过短
+	WithdrawRiskCardChangeMany = "card_change_many" // 近期频繁绑卡
+	WithdrawRiskPwdChange      = "pwd_change"       // 近期修改过登录或取款密码
+)
+
+// 各命中项的分值, 总分最高100
+var withdrawRiskWeight = map[string]int{
+	WithdrawRiskSharedCard:     30,
+	WithdrawRiskRealName:       25,
+	WithdrawRiskSameDevice:     15,
+	WithdrawRiskSameIP:         10,
+	WithdrawRiskDepositGap:     15,
+	WithdrawRiskNoDeposit:      20,
+	WithdrawRiskCardRecent:     15,
+	WithdrawRiskCardChangeMany: 10,
+	WithdrawRiskPwdChange:      15,
+}
+
+const (
+	withdrawRiskScoreMax   = 100
+	withdrawRiskDepositGap = 1800   // 存款后30分钟内提款
+	withdrawRiskCardRecent = 86400  // 提款卡绑定不足24小时
+	withdrawRiskCardPeriod = 604800 // 近7天绑定的银行卡数
+	withdrawRiskCardMany   = 3
+	withdrawRiskPwdPeriod  = 604800 // 近7天修改过密码
+)
+
+// WithdrawRiskReason 风险评分的命中原因
+type WithdrawRiskReason struct {
+	Code   string `json:"code"`
+	Score  int    `json:"score"`
+	Detail string `json:"detail"`
+}
+
+// 提款时计算风险评分, 返回总分和命中原因
+func withdrawRiskScore(mb Member, bid string, bankcardHash uint64, ts int64) (int, []WithdrawRiskReason, error) {
+
+	var reasons []WithdrawRiskReason
+	add := func(code, detail string) {
+		reasons = append(reasons, WithdrawRiskReason{
+			Code:   code,
+			Score:  withdrawRiskWeight[code],
+			Detail: detail,
+		})
+	}
+
+	// 同一张银行卡被多个账号绑定
+	var uids []string
+	ex := g.Ex{
+		"bank_card_hash": bankcardHash,
+		"prefix":         meta.Prefix,
+		"uid":            g.Op{"neq": mb.UID},
+	}
+	query, _, _ := dialect.From("tbl_member_bankcard").Select("uid").Distinct().Where(ex).ToSQL()
+	err := meta.MerchantDB.Select(&uids, query)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, nil, pushLog(err, helper.DBErr)
+	}
+
+	if len(uids) > 0 {
+		add(WithdrawRiskSharedCard, fmt.Sprintf("银行卡被其他%d个账号绑定", len(uids)))
+	}
+
+	// 会员实名与该卡历史出款时记录的实名不一致, 说明绑卡后修改过实名
+	if mb.RealnameHash == "" || mb.RealnameHash == "0" {
+		add(WithdrawRiskRealName, "会员未填写实名")
+	} else {
+		var n int
+		ex = g.Ex{
+			"uid":            mb.UID,
+			"bid":            bid,
+			"prefix":         meta.Prefix,
+			"state":          WithdrawSuccess,
+			"real_name_hash": g.Op{"neq": mb.RealnameHash},
+		}
+		query, _, _ = dialect.From("tbl_withdraw").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err = meta.MerchantDB.Get(&n, query)
+		if err != nil {
+			return 0, nil, pushLog(err, helper.DBErr)
+		}
+
+		if n > 0 {
+			add(WithdrawRiskRealName, fmt.Sprintf("会员实名与该卡%d笔历史出款的实名不一致", n))
+		} else if len(uids) > 0 {
+			// 其他绑定人的实名与当前会员不一致
+			ex = g.Ex{
+				"uid":           uids,
+				"realname_hash": g.Op{"neq": mb.RealnameHash},
+			}
+			query, _, _ = dialect.From("tbl_members").Select(g.COUNT(1)).Where(ex).ToSQL()
+			err = meta.MerchantDB.Get(&n, query)
+			if err != nil {
+				return 0, nil, pushLog(err, helper.DBErr)
+			}
+
+			if n > 0 {
+				add(WithdrawRiskRealName, fmt.Sprintf("银行卡的%d个其他绑定人实名与会员不一致", n))
+			}
+		}
+	}
+
+	// 注册设备号/注册IP与其他会员相同
+	if mb.RegDevice != "" {
+		n, err := withdrawRiskMemberCount(g.Ex{"reg_device": mb.RegDevice, "uid": g.Op{"neq": mb.UID}})
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if n > 0 {
+			add(WithdrawRiskSameDevice, fmt.Sprintf("注册设备号与其他%d个会员相同", n))
+		}
+	}
+
+	if mb.Regip != "" {
+		n, err := withdrawRiskMemberCount(g.Ex{"regip": mb.Regip, "uid": g.Op{"neq": mb.UID}})
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if n > 0 {
+			add(WithdrawRiskSameIP, fmt.Sprintf("注册IP与其他%d个会员相同", n))
+		}
+	}
+
+	// 最近一次成功存款到本次提款的时间间隔
+	var depositAt int64
+	ex = g.Ex{
+		"uid":        mb.UID,
+		"state":      DepositSuccess,
+		"created_at": g.Op{"lt": ts},
+	}
+	query, _, _ = dialect.From("tbl_deposit").Select("created_at").Where(ex).Order(g.C("created_at").Desc()).Limit(1).ToSQL()
+	err = meta.MerchantDB.Get(&depositAt, query)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, nil, pushLog(err, helper.DBErr)
+	}
+
+	if depositAt == 0 {
+		add(WithdrawRiskNoDeposit, "没有成功存款记录")
+	} else if ts-depositAt < withdrawRiskDepositGap {
+		add(WithdrawRiskDepositGap, fmt.Sprintf("存款后%d分钟内提款", (ts-depositAt)/60))
+	}
+
+	// 提款卡绑定时间和近期绑卡/换卡次数
+	cards, err := MemberBankcardList(g.Ex{
+		"uid":        mb.UID,
+		"created_at": g.Op{"gte": ts - withdrawRiskCardPeriod},
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, v := range cards {
+		if v.ID == bid && ts-int64(v.CreatedAt) < withdrawRiskCardRecent {
+			add(WithdrawRiskCardRecent, fmt.Sprintf("提款卡绑定%d小时", (ts-int64(v.CreatedAt))/3600))
+		}
+	}
+
+	if len(cards) >= withdrawRiskCardMany {
+		add(WithdrawRiskCardChangeMany, fmt.Sprintf("近7天绑定%d张银行卡", len(cards)))
+	}
+
+	if at := withdrawRiskPwdChanged(mb, ts); at > 0 && ts-at < withdrawRiskPwdPeriod {
+		add(WithdrawRiskPwdChange, fmt.Sprintf("%d小时前发现登录或取款密码已修改", (ts-at)/3600))
+	}
+
+	score := 0
+	for _, v := range reasons {
+		score += v.Score
+	}
+
+	if score > withdrawRiskScoreMax {
+		score = withdrawRiskScoreMax
+	}
+
+	return score, reasons, nil
+}
+
+func withdrawRiskMemberCount(ex g.Ex) (int, error) {
+
+	var n int
+	ex["prefix"] = meta.Prefix
+	query, _, _ := dialect.From("tbl_members").Select(g.COUNT(1)).Where(ex).ToSQL()
+	err := meta.MerchantDB.Get(&n, query)
+	if err != nil {
+		return 0, pushLog(err, helper.DBErr)
+	}
+
+	return n, nil
+}
+
+// 会员密码的指纹记录
+type withdrawRiskPwd struct {
+	Hash uint64 `json:"h"`
+	At   int64  `json:"at"` // 发现密码变化的时间, 0 为首次记录
+}
+
+// 每次提款时记录登录密码和取款密码的指纹, 与上次不同说明期间修改过密码
+// 返回发现密码变化的时间, 没有变化记录时返回0
+func withdrawRiskPwdChanged(mb Member, ts int64) int64 {
+
+	key := fmt.Sprintf("%s:withdraw:risk:pwd", meta.Prefix)
+	h := MurmurHash(fmt.Sprintf("%s:%d", mb.Password, mb.WithdrawPwd), 0)
+
+	last := withdrawRiskPwd{}
+	val, err := meta.MerchantRedis.HGet(ctx, key, mb.UID).Result()
+	if err != nil && err != redis.Nil {
+		_ = pushLog(err, helper.RedisErr)
+		return 0
+	}
+
+	if err == nil {
+		_ = helper.JsonUnmarshal([]byte(val), &last)
+		if last.Hash == h {
+			return last.At
+		}
+	}
+
+	cur := withdrawRiskPwd{Hash: h}
+	// 首次记录时无法判断是否修改过
+	if err == nil {
+		cur.At = ts
+	}
+
+	b, _ := helper.JsonMarshal(cur)
+	err = meta.MerchantRedis.HSet(ctx, key, mb.UID, string(b)).Err()
+	if err != nil {
+		_ = pushLog(err, helper.RedisErr)
+	}
+
+	return cur.At
+}
@@ -0,0 +1,545 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 提款自动审核规则命中后的处理方式
+const (
+	WithdrawRulePass   = 1 // 自动通过
+	WithdrawRuleHangUp = 2 // 自动挂起
+	WithdrawRuleReview = 3 // 人工审核
+)
+
+// 规则条件可用的字段
+const (
+	WithdrawRuleAmount      = "amount"       // 提款金额
+	WithdrawRuleVip         = "vip"          // 会员等级
+	WithdrawRuleTags        = "tags"         // 会员标签
+	WithdrawRuleCardDays    = "card_days"    // 银行卡绑定天数
+	WithdrawRuleCardSuccess = "card_success" // 银行卡提款成功次数
+	WithdrawRuleCardFail    = "card_fail"    // 银行卡提款失败次数
+	WithdrawRuleLastDeposit = "last_deposit" // 最近一次存款金额
+	WithdrawRuleRatio       = "ratio"        // (历史成功提款+本次提款)/历史成功存款
+)
+
+// 自动审核的操作人
+const withdrawRuleOperator = "自动审核"
+
+// 试运行单次最多计算的历史订单数
+const withdrawRuleDryRunMax = 200
+
+// WithdrawRuleCond 规则条件, 标签字段的操作符为 in(包含任一标签) nin(不包含任一标签), 其他字段为数值比较
+type WithdrawRuleCond struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// WithdrawRule 提款自动审核规则, 按sort从小到大匹配, 条件全部满足时命中, 都未命中走人工审核
+type WithdrawRule struct {
+	ID           string `db:"id" json:"id"`
+	Name         string `db:"name" json:"name"`
+	Sort         int    `db:"sort" json:"sort"`
+	Action       int    `db:"action" json:"action"`                 // 1 自动通过 2 自动挂起 3 人工审核
+	Conditions   string `db:"conditions" json:"conditions"`         // 条件列表json
+	RemarkID     string `db:"remark_id" json:"remark_id"`           // 挂起原因ID
+	HangUpRemark string `db:"hang_up_remark" json:"hang_up_remark"` // 挂起备注
+	State        int    `db:"state" json:"state"`                   // 0 关闭 1 开启
+	UpdatedAt    int64  `db:"updated_at" json:"updated_at"`
+	UpdatedUID   string `db:"updated_uid" json:"updated_uid"`
+	UpdatedName  string `db:"updated_name" json:"updated_name"`
+	Prefix       string `db:"prefix" json:"prefix"`
+}
+
+// WithdrawRuleDryRunItem 试运行时每笔历史订单的计算结果
+type WithdrawRuleDryRunItem struct {
+	ID        string  `json:"id"`
+	Username  string  `json:"username"`
+	Amount    float64 `json:"amount"`
+	State     int     `json:"state"` // 订单实际状态
+	CreatedAt int64   `json:"created_at"`
+	RuleID    string  `json:"rule_id"`
+	RuleName  string  `json:"rule_name"`
+	Action    int     `json:"action"` // 规则计算的处理方式
+}
+
+type WithdrawRuleDryRunData struct {
+	D      []WithdrawRuleDryRunItem `json:"d"`
+	T      int64                    `json:"t"`
+	S      uint16                   `json:"s"`
+	Pass   int                      `json:"pass"`   // 本页自动通过笔数
+	HangUp int                      `json:"hangup"` // 本页自动挂起笔数
+	Review int                      `json:"review"` // 本页人工审核笔数
+}
+
+// 规则计算用到的订单数据
+type withdrawRuleFacts struct {
+	Amount      decimal.Decimal
+	Vip         int
+	Tags        []string
+	CardDays    int64
+	CardSuccess int
+	CardFail    int
+	LastDeposit decimal.Decimal
+	Ratio       decimal.Decimal
+	NoDeposit   bool // 没有成功存款时比例视为无穷大
+}
+
+func WithdrawRuleList() ([]WithdrawRule, error) {
+
+	var data []WithdrawRule
+	query, _, _ := dialect.From("f_withdraw_rule").Select(colWithdrawRule...).
+		Where(g.Ex{"prefix": meta.Prefix}).Order(g.C("sort").Asc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	return data, nil
+}
+
+func WithdrawRuleInsert(record g.Record) error {
+
+	record["prefix"] = meta.Prefix
+	query, _, _ := dialect.Insert("f_withdraw_rule").Rows(record).ToSQL()
+	_, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	return WithdrawRuleUpdateCache()
+}
+
+func WithdrawRuleUpdate(id string, record g.Record) error {
+
+	ex := g.Ex{
+		"id":     id,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.Update("f_withdraw_rule").Set(record).Where(ex).Limit(1).ToSQL()
+	res, err := meta.MerchantDB.Exec(query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(helper.NoDataUpdate)
+	}
+
+	return WithdrawRuleUpdateCache()
+}
+
+// WithdrawRuleUpdateCache 开启的规则写入redis, 提款时从redis读取
+func WithdrawRuleUpdateCache() error {
+
+	var data []WithdrawRule
+	ex := g.Ex{
+		"state":  1,
+		"prefix": meta.Prefix,
+	}
+	query, _, _ := dialect.From("f_withdraw_rule").Select(colWithdrawRule...).Where(ex).Order(g.C("sort").Asc()).ToSQL()
+	err := meta.MerchantDB.Select(&data, query)
+	if err != nil {
+		return pushLog(err, helper.DBErr)
+	}
+
+	b, err := helper.JsonMarshal(data)
+	if err != nil {
+		return errors.New(helper.FormatErr)
+	}
+
+	err = meta.MerchantRedis.Set(ctx, meta.Prefix+":withdraw:rules", string(b), 0).Err()
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// WithdrawRuleCheckConditions 检查规则条件格式
+func WithdrawRuleCheckConditions(conditions string) bool {
+
+	var conds []WithdrawRuleCond
+	err := helper.JsonUnmarshal([]byte(conditions), &conds)
+	if err != nil || len(conds) == 0 || len(conds) > 20 {
+		return false
+	}
+
+	for _, v := range conds {
+		switch v.Field {
+		case WithdrawRuleTags:
+			if (v.Op != "in" && v.Op != "nin") || v.Value == "" {
+				return false
+			}
+		case WithdrawRuleAmount, WithdrawRuleVip, WithdrawRuleCardDays, WithdrawRuleCardSuccess,
+			WithdrawRuleCardFail, WithdrawRuleLastDeposit, WithdrawRuleRatio:
+			if _, ok := withdrawRuleOps[v.Op]; !ok {
+				return false
+			}
+			if _, err := decimal.NewFromString(v.Value); err != nil {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// WithdrawRuleDryRun 用规则计算历史订单, 不修改订单, ids为空时使用所有开启的规则
+func WithdrawRuleDryRun(ids []string, startTime, endTime string, page, pageSize uint16) (WithdrawRuleDryRunData, error) {
+
+	data := WithdrawRuleDryRunData{}
+
+	startAt, err := helper.TimeToLoc(startTime, loc)
+	if err != nil {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	endAt, err := helper.TimeToLoc(endTime, loc)
+	if err != nil {
+		return data, errors.New(helper.DateTimeErr)
+	}
+
+	if startAt >= endAt {
+		return data, errors.New(helper.QueryTimeRangeErr)
+	}
+
+	ex := g.Ex{"prefix": meta.Prefix}
+	if len(ids) > 0 {
+		ex["id"] = ids
+	} else {
+		ex["state"] = 1
+	}
+
+	var rules []WithdrawRule
+	query, _, _ := dialect.From("f_withdraw_rule").Select(colWithdrawRule...).Where(ex).Order(g.C("sort").Asc()).ToSQL()
+	err = meta.MerchantDB.Select(&rules, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	ex = g.Ex{
+		"prefix":      meta.Prefix,
+		"wallet_flag": MemberWallet,
+		"created_at":  g.Op{"between": exp.NewRangeVal(startAt, endAt)},
+	}
+	if page == 1 {
+		query, _, _ = dialect.From("tbl_withdraw").Select(g.COUNT(1)).Where(ex).ToSQL()
+		err = meta.MerchantDB.Get(&data.T, query)
+		if err != nil {
+			return data, pushLog(err, helper.DBErr)
+		}
+
+		if data.T == 0 {
+			return data, nil
+		}
+	}
+
+	if pageSize > withdrawRuleDryRunMax {
+		pageSize = withdrawRuleDryRunMax
+	}
+
+	var orders []Withdraw
+	offset := (page - 1) * pageSize
+	query, _, _ = dialect.From("tbl_withdraw").Select(colsWithdraw...).Where(ex).
+		Order(g.C("created_at").Desc()).Offset(uint(offset)).Limit(uint(pageSize)).ToSQL()
+	err = meta.MerchantDB.Select(&orders, query)
+	if err != nil {
+		return data, pushLog(err, helper.DBErr)
+	}
+
+	for _, v := range orders {
+		item := WithdrawRuleDryRunItem{
+			ID:        v.ID,
+			Username:  v.Username,
+			Amount:    v.Amount,
+			State:     v.State,
+			CreatedAt: v.CreatedAt,
+			Action:    WithdrawRuleReview,
+		}
+
+		// 按下单时的数据计算
+		facts, err := withdrawRuleFactsGet(v.UID, v.BID, v.Level, decimal.NewFromFloat(v.Amount), v.CreatedAt)
+		if err == nil {
+			if rule, ok := withdrawRuleMatch(rules, facts); ok {
+				item.RuleID = rule.ID
+				item.RuleName = rule.Name
+				item.Action = rule.Action
+			}
+		}
+
+		switch item.Action {
+		case WithdrawRulePass:
+			data.Pass++
+		case WithdrawRuleHangUp:
+			data.HangUp++
+		default:
+			data.Review++
+		}
+
+		data.D = append(data.D, item)
+	}
+
+	data.S = pageSize
+	return data, nil
+}
+
+// 会员提款时匹配规则, 规则读取或计算出错时走人工审核
+func withdrawRuleCheck(mb Member, bid string, amount decimal.Decimal, ts int64) (WithdrawRule, bool) {
+
+	var rules []WithdrawRule
+	b, err := meta.MerchantRedis.Get(ctx, meta.Prefix+":withdraw:rules").Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			fmt.Println("withdraw rules redis = ", err.Error())
+		}
+		return WithdrawRule{}, false
+	}
+
+	err = helper.JsonUnmarshal(b, &rules)
+	if err != nil || len(rules) == 0 {
+		return WithdrawRule{}, false
+	}
+
+	facts, err := withdrawRuleFactsGet(mb.UID, bid, mb.Level, amount, ts)
+	if err != nil {
+		fmt.Println("withdraw rule facts = ", err.Error())
+		return WithdrawRule{}, false
+	}
+
+	return withdrawRuleMatch(rules, facts)
+}
+
+func withdrawRuleMatch(rules []WithdrawRule, facts withdrawRuleFacts) (WithdrawRule, bool) {
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Sort < rules[j].Sort
+	})
+
+	for _, rule := range rules {
+		var conds []WithdrawRuleCond
+		err := helper.JsonUnmarshal([]byte(rule.Conditions), &conds)
+		if err != nil || len(conds) == 0 {
+			continue
+		}
+
+		hit := true
+		for _, c := range conds {
+			if !withdrawRuleCondMatch(c, facts) {
+				hit = false
+				break
+			}
+		}
+
+		if hit {
+			return rule, true
+		}
+	}
+
+	return WithdrawRule{}, false
+}
+
+var withdrawRuleOps = map[string]bool{">": true, ">=": true, "<": true, "<=": true, "=": true, "!=": true}
+
+func withdrawRuleCondMatch(c WithdrawRuleCond, facts withdrawRuleFacts) bool {
+
+	if c.Field == WithdrawRuleTags {
+		hit := false
+		for _, v := range strings.Split(c.Value, ",") {
+			v = strings.TrimSpace(v)
+			for _, t := range facts.Tags {
+				if v != "" && v == t {
+					hit = true
+				}
+			}
+		}
+
+		if c.Op == "nin" {
+			return !hit
+		}
+
+		return hit
+	}
+
+	val, err := decimal.NewFromString(c.Value)
+	if err != nil {
+		return false
+	}
+
+	var fact decimal.Decimal
+	switch c.Field {
+	case WithdrawRuleAmount:
+		fact = facts.Amount
+	case WithdrawRuleVip:
+		fact = decimal.NewFromInt(int64(facts.Vip))
+	case WithdrawRuleCardDays:
+		fact = decimal.NewFromInt(facts.CardDays)
+	case WithdrawRuleCardSuccess:
+		fact = decimal.NewFromInt(int64(facts.CardSuccess))
+	case WithdrawRuleCardFail:
+		fact = decimal.NewFromInt(int64(facts.CardFail))
+	case WithdrawRuleLastDeposit:
+		fact = facts.LastDeposit
+	case WithdrawRuleRatio:
+		if facts.NoDeposit {
+			return c.Op == ">" || c.Op == ">=" || c.Op == "!="
+		}
+		fact = facts.Ratio
+	default:
+		return false
+	}
+
+	n := fact.Cmp(val)
+	switch c.Op {
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case "=":
+		return n == 0
+	case "!=":
+		return n != 0
+	}
+
+	return false
+}
+
+// 计算规则用到的数据, 只统计ts之前的记录, 试运行时和下单时结果一致
+func withdrawRuleFactsGet(uid, bid string, level int, amount decimal.Decimal, ts int64) (withdrawRuleFacts, error) {
+
+	facts := withdrawRuleFacts{
+		Amount: amount,
+		Vip:    level,
+	}
+
+	tags, err := MemberTagsList(uid)
+	if err != nil {
+		return facts, err
+	}
+
+	for _, v := range strings.Split(tags, ",") {
+		if v != "" {
+			facts.Tags = append(facts.Tags, v)
+		}
+	}
+
+	var bindAt int64
+	query, _, _ := dialect.From("tbl_member_bankcard").Select("created_at").Where(g.Ex{"id": bid}).Limit(1).ToSQL()
+	err = meta.MerchantDB.Get(&bindAt, query)
+	if err != nil && err != sql.ErrNoRows {
+		return facts, pushLog(err, helper.DBErr)
+	}
+
+	if bindAt > 0 && ts > bindAt {
+		facts.CardDays = (ts - bindAt) / 86400
+	}
+
+	var nums []StateNum
+	ex := g.Ex{
+		"bid":        bid,
+		"prefix":     meta.Prefix,
+		"created_at": g.Op{"lt": ts},
+	}
+	query, _, _ = dialect.From("tbl_withdraw").Select(g.COUNT("id").As("t"), g.C("state").As("state")).
+		Where(ex).GroupBy("state").ToSQL()
+	err = meta.MerchantDB.Select(&nums, query)
+	if err != nil && err != sql.ErrNoRows {
+		return facts, pushLog(err, helper.DBErr)
+	}
+
+	for _, v := range nums {
+		if v.State == WithdrawSuccess {
+			facts.CardSuccess += v.T
+		}
+		if v.State == WithdrawReviewReject || v.State == WithdrawAbnormal || v.State == WithdrawFailed {
+			facts.CardFail += v.T
+		}
+	}
+
+	var lastDeposit float64
+	ex = g.Ex{
+		"uid":        uid,
+		"prefix":     meta.Prefix,
+		"state":      DepositSuccess,
+		"amount":     g.Op{"gt": 0},
+		"created_at": g.Op{"lt": ts},
+	}
+	query, _, _ = dialect.From("tbl_deposit").Select("amount").Where(ex).Order(g.C("created_at").Desc()).Limit(1).ToSQL()
+	err = meta.MerchantDB.Get(&lastDeposit, query)
+	if err != nil && err != sql.ErrNoRows {
+		return facts, pushLog(err, helper.DBErr)
+	}
+
+	facts.LastDeposit = decimal.NewFromFloat(lastDeposit)
+
+	var depositTotal, withdrawTotal float64
+	query, _, _ = dialect.From("tbl_deposit").Select(g.COALESCE(g.SUM("amount"), 0)).Where(ex).ToSQL()
+	err = meta.MerchantDB.Get(&depositTotal, query)
+	if err != nil {
+		return facts, pushLog(err, helper.DBErr)
+	}
+
+	ex = g.Ex{
+		"uid":        uid,
+		"prefix":     meta.Prefix,
+		"state":      WithdrawSuccess,
+		"created_at": g.Op{"lt": ts},
+	}
+	query, _, _ = dialect.From("tbl_withdraw").Select(g.COALESCE(g.SUM("amount"), 0)).Where(ex).ToSQL()
+	err = meta.MerchantDB.Get(&withdrawTotal, query)
+	if err != nil {
+		return facts, pushLog(err, helper.DBErr)
+	}
+
+	if depositTotal <= 0 {
+		facts.NoDeposit = true
+	} else {
+		facts.Ratio = decimal.NewFromFloat(withdrawTotal).Add(amount).Div(decimal.NewFromFloat(depositTotal))
+	}
+
+	return facts, nil
+}
+
+// 规则命中后写入提款单的字段
+func withdrawRuleRecord(rule WithdrawRule, ts time.Time) (int, g.Record) {
+
+	remark := fmt.Sprintf("%s: %s", withdrawRuleOperator, rule.Name)
+	switch rule.Action {
+	case WithdrawRulePass:
+		return WithdrawDealing, g.Record{
+			"confirm_at":    ts.Unix(),
+			"confirm_name":  withdrawRuleOperator,
+			"review_remark": remark,
+		}
+	case WithdrawRuleHangUp:
+		return WithdrawHangup, g.Record{
+			"hang_up_name":   withdrawRuleOperator,
+			"hang_up_remark": rule.HangUpRemark,
+			"remark_id":      rule.RemarkID,
+			"hang_up_at":     ts.Unix(),
+			"review_remark":  remark,
+			"resume_at":      hangUpResumeAt(rule.RemarkID, ts.Unix()),
+		}
+	}
+
+	return WithdrawReviewing, nil
+}
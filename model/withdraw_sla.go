@@ -0,0 +1,248 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"finance/contrib/helper"
+
+	g "github.com/doug-martin/goqu/v9"
+	"github.com/go-redis/redis/v8"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	withdrawSlaLock     = "withdraw:sla"
+	withdrawSlaInterval = 60 * time.Second
+	withdrawSlaAlertTTL = 24 * time.Hour
+)
+
+// 需要计时的提款状态
+var withdrawSlaStates = map[int]string{
+	WithdrawReviewing:     "审核中",
+	WithdrawDispatched:    "已派单",
+	WithdrawHangup:        "挂起",
+	WithdrawDealing:       "出款中",
+	WithdrawAutoPayFailed: "代付失败",
+}
+
+// WithdrawSla 提款状态超时配置, level 为0时对所有会员等级生效
+type WithdrawSla struct {
+	State   int   `json:"state"`
+	Level   int   `json:"level"`
+	Seconds int64 `json:"seconds"`
+}
+
+// WithdrawSlaValidState 是否计时的状态
+func WithdrawSlaValidState(state int) bool {
+
+	_, ok := withdrawSlaStates[state]
+	return ok
+}
+
+func WithdrawSlaList() ([]WithdrawSla, error) {
+
+	var data []WithdrawSla
+	conf, err := withdrawSlaConf()
+	if err != nil {
+		return data, err
+	}
+
+	for k, v := range conf {
+		s := strings.Split(k, ":")
+		state, _ := strconv.Atoi(s[0])
+		level, _ := strconv.Atoi(s[1])
+		data = append(data, WithdrawSla{State: state, Level: level, Seconds: v})
+	}
+
+	return data, nil
+}
+
+// WithdrawSlaUpdate 设置超时秒数, 0 为删除
+func WithdrawSlaUpdate(state, level int, seconds int64) error {
+
+	key := fmt.Sprintf("%s:withdraw:sla", meta.Prefix)
+	field := fmt.Sprintf("%d:%d", state, level)
+
+	var err error
+	if seconds == 0 {
+		err = meta.MerchantRedis.HDel(ctx, key, field).Err()
+	} else {
+		err = meta.MerchantRedis.HSet(ctx, key, field, seconds).Err()
+	}
+	if err != nil {
+		return pushLog(err, helper.RedisErr)
+	}
+
+	return nil
+}
+
+// 超时配置 state:level => 秒数
+func withdrawSlaConf() (map[string]int64, error) {
+
+	key := fmt.Sprintf("%s:withdraw:sla", meta.Prefix)
+	res, err := meta.MerchantRedis.HGetAll(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		return nil, pushLog(err, helper.RedisErr)
+	}
+
+	conf := make(map[string]int64, len(res))
+	for k, v := range res {
+		if len(strings.Split(k, ":")) != 2 {
+			continue
+		}
+
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && n > 0 {
+			conf[k] = n
+		}
+	}
+
+	return conf, nil
+}
+
+// 订单等级的超时秒数, 优先使用等级配置, 其次使用全部等级的配置
+func withdrawSlaSeconds(conf map[string]int64, state, level int) int64 {
+
+	if n, ok := conf[fmt.Sprintf("%d:%d", state, level)]; ok {
+		return n
+	}
+
+	return conf[fmt.Sprintf("%d:0", state)]
+}
+
+// 订单进入当前状态的时间
+func withdrawStateAt(w Withdraw) int64 {
+
+	at := int64(0)
+	switch w.State {
+	case WithdrawDispatched:
+		at = w.ReceiveAt
+	case WithdrawHangup:
+		at = w.HangUpAt
+	case WithdrawDealing, WithdrawAutoPayFailed:
+		at = w.ConfirmAt
+	}
+
+	if at < w.CreatedAt {
+		at = w.CreatedAt
+	}
+
+	return at
+}
+
+// 列表展示的状态计时
+func withdrawSlaFill(w *withdrawCols, conf map[string]int64, now int64) {
+
+	if !WithdrawSlaValidState(w.State) {
+		return
+	}
+
+	w.StateAt = withdrawStateAt(w.Withdraw)
+	w.StateSeconds = now - w.StateAt
+	w.SlaSeconds = withdrawSlaSeconds(conf, w.State, w.Level)
+	w.SlaBreach = w.SlaSeconds > 0 && w.StateSeconds > w.SlaSeconds
+}
+
+// WithdrawSlaTask 定时检查超时的提款订单并告警
+func WithdrawSlaTask() {
+
+	ticker := time.NewTicker(withdrawSlaInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// 多实例只需要一个执行
+		if Lock(withdrawSlaLock) != nil {
+			continue
+		}
+
+		withdrawSlaCheck()
+		Unlock(withdrawSlaLock)
+	}
+}
+
+func withdrawSlaCheck() {
+
+	conf, err := withdrawSlaConf()
+	if err != nil || len(conf) == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	for state := range withdrawSlaStates {
+
+		// 该状态最短的超时时间
+		min := int64(0)
+		for k, v := range conf {
+			if strings.HasPrefix(k, fmt.Sprintf("%d:", state)) && (min == 0 || v < min) {
+				min = v
+			}
+		}
+
+		if min == 0 {
+			continue
+		}
+
+		var data []Withdraw
+		ex := g.Ex{
+			"prefix":     meta.Prefix,
+			"state":      state,
+			"created_at": g.Op{"lt": now - min},
+		}
+		query, _, _ := dialect.From("tbl_withdraw").Select(colWithdraw...).Where(ex).Order(g.C("created_at").Asc()).ToSQL()
+		err = meta.MerchantDB.Select(&data, query)
+		if err != nil {
+			_ = pushLog(err, helper.DBErr)
+			continue
+		}
+
+		for _, v := range data {
+			seconds := withdrawSlaSeconds(conf, v.State, v.Level)
+			stateAt := withdrawStateAt(v)
+			if seconds == 0 || now-stateAt <= seconds {
+				continue
+			}
+
+			// 同一订单同一状态只告警一次
+			key := fmt.Sprintf("%s:withdraw:sla:alert:%s:%d:%d", meta.Prefix, v.ID, v.State, stateAt)
+			ok, err := meta.MerchantRedis.SetNX(ctx, key, "1", withdrawSlaAlertTTL).Result()
+			if err != nil || !ok {
+				continue
+			}
+
+			withdrawSlaAlert(v, now-stateAt)
+		}
+	}
+}
+
+func withdrawSlaAlert(w Withdraw, elapsed int64) {
+
+	duration := (time.Duration(elapsed) * time.Second).String()
+	_ = PushMerchantNotify(withdrawSlaFmt, w.ID, w.Username, duration)
+
+	text := fmt.Sprintf("⚠️提款超时⚠️\r\n订单号: %s\r\n会员: %s\r\nVIP%d\r\n金额: %.4f\r\n状态: %s\r\n领取人: %s\r\n已耗时: %s\r\n站点: %s",
+		w.ID, w.Username, w.Level-1, w.Amount, withdrawSlaStates[w.State], w.ConfirmName, duration, meta.Prefix)
+	err := telegramSend(text)
+	if err != nil {
+		fmt.Println("withdrawSlaAlert telegram = ", err.Error())
+	}
+}
+
+// 发送小飞机消息, 未配置时不发送
+func telegramSend(text string) error {
+
+	if meta.Telegram.Token == "" || meta.Telegram.ChatID == 0 {
+		return nil
+	}
+
+	bot, err := tgbotapi.NewBotAPI(meta.Telegram.Token)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(meta.Telegram.ChatID, text)
+	_, err = bot.Send(msg)
+	return err
+}
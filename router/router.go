@@ -68,11 +68,24 @@ func SetupRouter(b BuildInfo) *router.Router {
 	tunnelCtl := new(controller.TunnelController)
 
 	risksCtl := new(controller.RisksController)
+	depositPolicyCtl := new(controller.DepositPolicyController)
+	velocityCtl := new(controller.VelocityController)
+	maintenanceCtl := new(controller.MaintenanceController)
+	hangUpReasonCtl := new(controller.HangUpReasonController)
 	creditCtl := new(controller.CreditLevelController)
 	lockCtl := new(controller.LockController)
 	usdtCtl := new(controller.UsdtController)
 	bankCardCtl := new(controller.BankCardController)
 	manualCtl := new(controller.ManualController)
+	reconcileCtl := new(controller.ReconcileController)
+	bankTxnCtl := new(controller.BankTxnController)
+	depositPromoCtl := new(controller.DepositPromoController)
+	reversalCtl := new(controller.DepositReversalController)
+	mismatchCtl := new(controller.DepositMismatchController)
+	auditCtl := new(controller.AuditController)
+	approvalCtl := new(controller.ApprovalController)
+	withdrawRuleCtl := new(controller.WithdrawRuleController)
+	exportCtl := new(controller.ExportController)
 
 	route_callback_group := route.Group("/finance/callback")
 	route_merchant_group := route.Group("/merchant/finance")
@@ -99,6 +112,8 @@ func SetupRouter(b BuildInfo) *router.Router {
 	post(route_callback_group, "/quickd", cbCtl.QuickD)
 	// [callback] quick pay 代付回调
 	post(route_callback_group, "/quickw", cbCtl.QuickW)
+	// [callback] 短信转发app推送收款卡入账短信
+	post(route_callback_group, "/banksms", bankTxnCtl.Sms)
 	// [callback] USDT 代收回调
 	get(route_callback_group, "/usdtd", cbCtl.UsdtD)
 	// [callback] 越南支付代收回调
@@ -126,6 +141,8 @@ func SetupRouter(b BuildInfo) *router.Router {
 	get(nil, "/finance/cate", payCtl.Cate)
 	// [前台] 存款通道
 	get(nil, "/finance/tunnel", payCtl.Tunnel)
+	// [前台] 存款渠道维护提示
+	get(nil, "/finance/maintenance", payCtl.Maintenance)
 	// [前台] 发起存款
 	post(nil, "/finance/pay", payCtl.Pay)
 	// [前台] 扫码支付订单支付界面
@@ -136,12 +153,18 @@ func SetupRouter(b BuildInfo) *router.Router {
 	get(nil, "/finance/withdraw/limit", wdCtl.Limit)
 	// [前台] 获取正在处理中的提现订单
 	get(nil, "/finance/withdraw/processing", wdCtl.Processing)
+	// [前台] 代理申请佣金钱包提现
+	post(nil, "/finance/withdraw/commission", wdCtl.Commission)
 	// [前台] 渠道列表数据缓存
 	get(nil, "/finance/cate/cache", cateCtl.Cache)
 	// [前台] 通道列表数据缓存
 	//get(nil, "/finance/channel/cache", channelCtl.Cache)
 	// [前台] 线下转卡-发起存款
 	post(nil, "/finance/manual/pay", manualCtl.Pay)
+	// [前台] 线下转卡订单的收款信息和二维码
+	post(nil, "/finance/manual/qrDetail", manualCtl.QrDetail)
+	// [前台] 线下转卡和线下USDT订单上传转账截图
+	post(nil, "/finance/deposit/receipt", manualCtl.Receipt)
 	// [前台] 线下USDT-发起存款
 	post(nil, "/finance/usdt/pay", usdtCtl.Pay)
 
@@ -184,6 +207,8 @@ func SetupRouter(b BuildInfo) *router.Router {
 	//get(route_merchant_group, "/channel/cache", channelCtl.Cache)
 	// [商户后台] 财务管理-渠道管理-通道管理-启用/停用
 	post(route_merchant_group, "/channel/update/state", channelCtl.UpdateState)
+	// [商户后台] 财务管理-渠道管理-通道管理-清零累计已收金额
+	post(route_merchant_group, "/channel/quota/reset", channelCtl.QuotaReset)
 
 	// [商户后台] 财务管理-渠道管理-会员等级通道-新增
 	post(route_merchant_group, "/vip/insert", vipCtl.Insert)
@@ -222,6 +247,18 @@ func SetupRouter(b BuildInfo) *router.Router {
 	post(route_merchant_group, "/membercredit/list", creditCtl.MemberList)
 	// [商户后台] 财务管理-渠道管理-会员信用等级-删除会员
 	post(route_merchant_group, "/membercredit/delete", creditCtl.MemberDelete)
+	// [商户后台] 财务管理-渠道管理-会员信用等级-手动固定会员等级
+	post(route_merchant_group, "/membercredit/pin", creditCtl.MemberPin)
+	// [商户后台] 财务管理-渠道管理-会员信用等级-取消固定
+	post(route_merchant_group, "/membercredit/unpin", creditCtl.MemberUnpin)
+	// [商户后台] 财务管理-渠道管理-会员信用等级-等级变更记录
+	get(route_merchant_group, "/membercredit/log", creditCtl.MemberLog)
+	// [商户后台] 财务管理-渠道管理-会员信用等级-自动分配规则列表
+	get(route_merchant_group, "/credit/rule/list", creditCtl.RuleList)
+	// [商户后台] 财务管理-渠道管理-会员信用等级-设置自动分配规则
+	post(route_merchant_group, "/credit/rule/update", creditCtl.RuleUpdate)
+	// [商户后台] 财务管理-渠道管理-会员信用等级-删除自动分配规则
+	post(route_merchant_group, "/credit/rule/delete", creditCtl.RuleDelete)
 
 	// [商户后台] 财务管理-渠道管理-会员锁定-新增
 	post(route_merchant_group, "/memberlock/insert", lockCtl.MemberInsert)
@@ -229,6 +266,8 @@ func SetupRouter(b BuildInfo) *router.Router {
 	post(route_merchant_group, "/memberlock/list", lockCtl.MemberList)
 	// [商户后台] 财务管理-渠道管理-会员锁定-启用
 	post(route_merchant_group, "/memberlock/update/state", lockCtl.UpdateState)
+	// [商户后台] 财务管理-渠道管理-会员锁定-会员的全部限制记录
+	get(route_merchant_group, "/memberlock/history", lockCtl.History)
 
 	// [商户后台] 财务管理-渠道管理-通道类型管理-列表
 	get(route_merchant_group, "/tunnel/list", tunnelCtl.List)
@@ -240,6 +279,8 @@ func SetupRouter(b BuildInfo) *router.Router {
 	post(route_merchant_group, "/withdraw/financelist", wdCtl.FinanceReviewList)
 	// [商户后台] 财务管理-提款管理-提款历史记录
 	post(route_merchant_group, "/withdraw/historylist", wdCtl.HistoryList)
+	// [商户后台] 财务管理-提款管理-提款历史记录-导出
+	post(route_merchant_group, "/withdraw/historylist/export", wdCtl.HistoryExport)
 	// [商户后台] 财务管理-提款管理-拒绝
 	post(route_merchant_group, "/withdraw/reject", wdCtl.ReviewReject)
 	// [商户后台] 财务管理-提款管理-人工出款（手动代付， 手动出款）
@@ -265,6 +306,16 @@ func SetupRouter(b BuildInfo) *router.Router {
 	post(route_merchant_group, "/withdraw/receiveupdate", wdCtl.ConfirmNameUpdate)
 	// [商户后台] 风控管理-提款审核-挂起列表-领取
 	post(route_merchant_group, "/withdraw/receive", wdCtl.ConfirmName)
+	// [商户后台] 风控管理-自动审核规则-列表
+	get(route_merchant_group, "/withdraw/rule/list", withdrawRuleCtl.List)
+	// [商户后台] 风控管理-自动审核规则-新增
+	post(route_merchant_group, "/withdraw/rule/insert", withdrawRuleCtl.Insert)
+	// [商户后台] 风控管理-自动审核规则-修改
+	post(route_merchant_group, "/withdraw/rule/update", withdrawRuleCtl.Update)
+	// [商户后台] 风控管理-自动审核规则-启用/停用
+	post(route_merchant_group, "/withdraw/rule/update/state", withdrawRuleCtl.UpdateState)
+	// [商户后台] 风控管理-自动审核规则-试运行
+	post(route_merchant_group, "/withdraw/rule/dryrun", withdrawRuleCtl.DryRun)
 	// [商户后台] 风控管理-提款审核-历史记录列表
 	post(route_merchant_group, "/withdraw/riskhistory", wdCtl.RiskHistory)
 
@@ -274,12 +325,32 @@ func SetupRouter(b BuildInfo) *router.Router {
 	get(route_merchant_group, "/deposit/list", depositCtl.List)
 	// [商户后台] 财务管理-存款管理-历史记录
 	get(route_merchant_group, "/deposit/history", depositCtl.History)
+	// [商户后台] 财务管理-存款管理-历史记录-导出
+	get(route_merchant_group, "/deposit/history/export", depositCtl.Export)
 	// [商户后台] 财务管理-存款管理-存款补单
 	post(route_merchant_group, "/deposit/manual", depositCtl.Manual)
 	// [商户后台] 财务管理-存款管理-补单审核
 	post(route_merchant_group, "/deposit/review", depositCtl.Review)
 	// [商户后台] 财务管理-手动下分
 	post(route_merchant_group, "/deposit/reduce", depositCtl.Reduce)
+	// [商户后台] 财务管理-存款管理-历史记录-冲正
+	post(route_merchant_group, "/deposit/reverse", reversalCtl.Reverse)
+	// [商户后台] 财务管理-存款管理-冲正记录
+	get(route_merchant_group, "/deposit/reversal/list", reversalCtl.List)
+	// [商户后台] 财务管理-存款管理-冲正策略
+	get(route_merchant_group, "/deposit/reversal/conf", reversalCtl.Conf)
+	// [商户后台] 财务管理-存款管理-修改冲正策略
+	post(route_merchant_group, "/deposit/reversal/conf/update", reversalCtl.ConfUpdate)
+	// [商户后台] 财务管理-存款管理-金额不一致处理配置
+	get(route_merchant_group, "/deposit/mismatch/conf/list", mismatchCtl.ConfList)
+	// [商户后台] 财务管理-存款管理-设置通道金额不一致处理方式
+	post(route_merchant_group, "/deposit/mismatch/conf/update", mismatchCtl.ConfUpdate)
+	// [商户后台] 财务管理-存款管理-删除通道金额不一致处理方式
+	post(route_merchant_group, "/deposit/mismatch/conf/delete", mismatchCtl.ConfDelete)
+	// [商户后台] 财务管理-存款管理-金额不一致审核列表
+	get(route_merchant_group, "/deposit/mismatch/list", mismatchCtl.List)
+	// [商户后台] 财务管理-存款管理-金额不一致审核
+	post(route_merchant_group, "/deposit/mismatch/review", mismatchCtl.Review)
 	// [商户后台] 财务管理-存款管理-USDT存款
 	post(route_merchant_group, "/deposit/usdt/list", depositCtl.USDTList)
 	// [商户后台] 财务管理-存款管理-线下转卡-入款订单
@@ -289,12 +360,103 @@ func SetupRouter(b BuildInfo) *router.Router {
 	post(route_merchant_group, "/manual/confirm", manualCtl.Confirm)
 	// [商户后台] 财务管理-存款管理-线下转卡-审核
 	post(route_merchant_group, "/manual/review", manualCtl.Review)
+	// [商户后台] 财务管理-存款管理-查看转账截图
+	get(route_merchant_group, "/receipt/view", manualCtl.ReceiptView)
+	// [商户后台] 财务管理-存款管理-线下转卡-附言码配置
+	get(route_merchant_group, "/manual/code/conf", manualCtl.CodeConf)
+	// [商户后台] 财务管理-存款管理-线下转卡-修改附言码配置
+	post(route_merchant_group, "/manual/code/conf/update", manualCtl.CodeConfUpdate)
+	// [商户后台] 财务管理-存款管理-线下转卡-设置收款卡的金额尾数
+	post(route_merchant_group, "/manual/amount/tag/update", manualCtl.AmountTagUpdate)
 
 	// [商户后台] 财务管理-存款管理-线下USDT-确认金额待审核
 	post(route_merchant_group, "/deposit/usdt/reviewing", depositCtl.OfflineUSDT)
 	// [商户后台] 财务管理-存款管理-线下USDT-审核
 	post(route_merchant_group, "/deposit/usdt/review", depositCtl.OfflineUSDTReview)
 
+	// [商户后台] 财务管理-存款防刷策略-列表
+	get(route_merchant_group, "/deposit/policy/list", depositPolicyCtl.List)
+	// [商户后台] 财务管理-存款防刷策略-按会员等级设置
+	post(route_merchant_group, "/deposit/policy/update", depositPolicyCtl.Update)
+	// [商户后台] 财务管理-存款防刷策略-会员限制状态
+	get(route_merchant_group, "/deposit/throttle", depositPolicyCtl.Throttle)
+	// [商户后台] 财务管理-存款防刷策略-解除会员限制
+	post(route_merchant_group, "/deposit/throttle/clear", depositPolicyCtl.ThrottleClear)
+
+	// [商户后台] 财务管理-存提款限额-列表
+	get(route_merchant_group, "/velocity/list", velocityCtl.List)
+	// [商户后台] 财务管理-存提款限额-按会员等级或单个会员设置
+	post(route_merchant_group, "/velocity/update", velocityCtl.Update)
+	// [商户后台] 财务管理-存提款限额-删除
+	post(route_merchant_group, "/velocity/delete", velocityCtl.Delete)
+	// [商户后台] 财务管理-存提款限额-会员剩余额度
+	get(route_merchant_group, "/velocity/quota", velocityCtl.Quota)
+
+	// [商户后台] 财务管理-渠道管理-维护计划-列表
+	get(route_merchant_group, "/maintenance/list", maintenanceCtl.List)
+	// [商户后台] 财务管理-渠道管理-维护计划-新增
+	post(route_merchant_group, "/maintenance/insert", maintenanceCtl.Insert)
+	// [商户后台] 财务管理-渠道管理-维护计划-取消
+	post(route_merchant_group, "/maintenance/cancel", maintenanceCtl.Cancel)
+
+	// [商户后台] 财务管理-线下转卡-银行流水-列表
+	get(route_merchant_group, "/banktxn/list", bankTxnCtl.List)
+	// [商户后台] 财务管理-线下转卡-银行流水-上传流水文件
+	post(route_merchant_group, "/banktxn/import", bankTxnCtl.Import)
+	// [商户后台] 财务管理-线下转卡-银行流水-生成短信转发token
+	post(route_merchant_group, "/banktxn/token", bankTxnCtl.SmsToken)
+	// [商户后台] 财务管理-线下转卡-银行流水-人工匹配订单入款
+	post(route_merchant_group, "/banktxn/match", bankTxnCtl.Match)
+	// [商户后台] 财务管理-线下转卡-银行流水-忽略
+	post(route_merchant_group, "/banktxn/ignore", bankTxnCtl.Ignore)
+
+	// [商户后台] 财务管理-存款优惠-首存二存规则列表
+	get(route_merchant_group, "/promo/rule/list", depositPromoCtl.RuleList)
+	// [商户后台] 财务管理-存款优惠-设置首存二存规则
+	post(route_merchant_group, "/promo/rule/update", depositPromoCtl.RuleUpdate)
+	// [商户后台] 财务管理-存款优惠-删除规则
+	post(route_merchant_group, "/promo/rule/delete", depositPromoCtl.RuleDelete)
+	// [商户后台] 财务管理-存款优惠-每日上限配置
+	get(route_merchant_group, "/promo/conf", depositPromoCtl.Conf)
+	// [商户后台] 财务管理-存款优惠-修改每日上限配置
+	post(route_merchant_group, "/promo/conf/update", depositPromoCtl.ConfUpdate)
+	// [商户后台] 财务管理-存款优惠-按渠道统计优惠成本
+	get(route_merchant_group, "/promo/report", depositPromoCtl.Report)
+	// [商户后台] 财务管理-存款优惠-会员未完成流水要求的优惠
+	get(route_merchant_group, "/promo/turnover/list", depositPromoCtl.TurnoverList)
+
+	// [商户后台] 财务管理-导出记录-列表
+	get(route_merchant_group, "/export/list", exportCtl.List)
+	// [商户后台] 财务管理-导出记录-下载
+	get(route_merchant_group, "/export/download", exportCtl.Download)
+
+	// [商户后台] 财务管理-对账管理-账单映射-列表
+	get(route_merchant_group, "/reconcile/mapping/list", reconcileCtl.MappingList)
+	// [商户后台] 财务管理-对账管理-账单映射-新增
+	post(route_merchant_group, "/reconcile/mapping/insert", reconcileCtl.MappingInsert)
+	// [商户后台] 财务管理-对账管理-账单映射-修改
+	post(route_merchant_group, "/reconcile/mapping/update", reconcileCtl.MappingUpdate)
+	// [商户后台] 财务管理-对账管理-导入三方账单并对账
+	post(route_merchant_group, "/reconcile/import", reconcileCtl.Import)
+	// [商户后台] 财务管理-对账管理-对账记录
+	post(route_merchant_group, "/reconcile/list", reconcileCtl.BatchList)
+	// [商户后台] 财务管理-对账管理-对账明细
+	post(route_merchant_group, "/reconcile/item/list", reconcileCtl.ItemList)
+	// [商户后台] 财务管理-对账管理-对账明细-一键补单
+	post(route_merchant_group, "/reconcile/item/repair", reconcileCtl.Repair)
+	// [商户后台] 财务管理-对账管理-对账明细-标记已处理
+	post(route_merchant_group, "/reconcile/item/ignore", reconcileCtl.Ignore)
+
+	// [商户后台] 财务管理-操作日志-列表
+	get(route_merchant_group, "/audit/list", auditCtl.List)
+	// [商户后台] 财务管理-操作复核-复核规则-列表
+	get(route_merchant_group, "/approval/rule/list", approvalCtl.RuleList)
+	// [商户后台] 财务管理-操作复核-复核规则-修改
+	post(route_merchant_group, "/approval/rule/update", approvalCtl.RuleUpdate)
+	// [商户后台] 财务管理-操作复核-复核列表
+	post(route_merchant_group, "/approval/list", approvalCtl.List)
+	// [商户后台] 财务管理-操作复核-通过/拒绝
+	post(route_merchant_group, "/approval/review", approvalCtl.Review)
 	// [商户后台] 财务管理-存款管理-获取出款卡列表
 	get(route_merchant_group, "/bankcard/remit", bankCardCtl.Remit)
 
@@ -330,6 +492,30 @@ func SetupRouter(b BuildInfo) *router.Router {
 	post(route_merchant_group, "/risks/setregmax", risksCtl.SetRegMax)
 	// [商户后台] 风控管理-风控配置-获取同设备号注册数量
 	get(route_merchant_group, "/risks/regmax", risksCtl.RegMax)
+	// [商户后台] 风控管理-风控配置-派单配置
+	get(route_merchant_group, "/risks/dispatch/conf", risksCtl.DispatchConf)
+	// [商户后台] 风控管理-风控配置-修改派单配置
+	post(route_merchant_group, "/risks/dispatch/conf/update", risksCtl.DispatchConfUpdate)
+	// [商户后台] 风控管理-风控配置-设置风控人员排班和权重
+	post(route_merchant_group, "/risks/reviewer/update", risksCtl.ReviewerUpdate)
+	// [商户后台] 风控管理-风控配置-派单记录
+	get(route_merchant_group, "/risks/dispatch/log", risksCtl.DispatchLog)
+	// [商户后台] 风控管理-提款超时配置-列表
+	get(route_merchant_group, "/withdraw/sla/list", wdCtl.SlaList)
+	// [商户后台] 风控管理-提款超时配置-修改
+	post(route_merchant_group, "/withdraw/sla/update", wdCtl.SlaUpdate)
+	// [商户后台] 风控管理-佣金钱包提款配置
+	get(route_merchant_group, "/withdraw/commission/conf", wdCtl.CommissionConf)
+	// [商户后台] 风控管理-修改佣金钱包提款配置
+	post(route_merchant_group, "/withdraw/commission/conf/update", wdCtl.CommissionConfUpdate)
+	// [商户后台] 风控管理-挂起原因-列表
+	get(route_merchant_group, "/withdraw/hangup/reason/list", hangUpReasonCtl.List)
+	// [商户后台] 风控管理-挂起原因-新增
+	post(route_merchant_group, "/withdraw/hangup/reason/insert", hangUpReasonCtl.Insert)
+	// [商户后台] 风控管理-挂起原因-修改
+	post(route_merchant_group, "/withdraw/hangup/reason/update", hangUpReasonCtl.Update)
+	// [商户后台] 风控管理-挂起原因-挂起统计
+	get(route_merchant_group, "/withdraw/hangup/report", hangUpReasonCtl.Report)
 
 	return route
 }